@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	cfgrtn "github.com/eugenshima/myapp/internal/config"
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+	"github.com/eugenshima/myapp/internal/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+// subcommands run instead of the server
+const (
+	// purgeCacheKeysCommand is the subcommand removing cache keys of the old key schema
+	purgeCacheKeysCommand = "purge-legacy-cache-keys"
+	// inviteCommand is the subcommand creating an invitation, it bootstraps the first admin
+	inviteCommand = "invite"
+)
+
+// command returns the subcommand with the given name or nil if there is none
+func command(name string) func(cfg *cfgrtn.Config, args []string) error {
+	switch name {
+	case migrateCommand:
+		return runMigrate
+	case purgeCacheKeysCommand:
+		return runPurgeCacheKeys
+	case inviteCommand:
+		return runInvite
+	}
+	return nil
+}
+
+// runPurgeCacheKeys scans Redis for cache keys written before the namespaced key schema
+// and deletes them unless -dry-run is given
+func runPurgeCacheKeys(cfg *cfgrtn.Config, args []string) error {
+	flags := flag.NewFlagSet(purgeCacheKeysCommand, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the legacy keys")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	rdb, err := (&storage{cfg: cfg}).redis()
+	if err != nil {
+		return err
+	}
+	keys := repository.NewLegacyCacheKeys(rdb)
+	ctx := context.Background()
+	if *dryRun {
+		found, err := keys.Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("Scan(): %w", err)
+		}
+		for _, key := range found {
+			fmt.Println(key)
+		}
+		logrus.Infof("found %d legacy cache keys", len(found))
+		return nil
+	}
+	deleted, err := keys.Purge(ctx)
+	if err != nil {
+		return fmt.Errorf("Purge(): %w", err)
+	}
+	logrus.Infof("deleted %d legacy cache keys", deleted)
+	return nil
+}
+
+// runInvite creates an invitation granting -role and prints its code, signing up with the code grants the role
+func runInvite(cfg *cfgrtn.Config, args []string) error {
+	flags := flag.NewFlagSet(inviteCommand, flag.ContinueOnError)
+	role := flags.String("role", model.RoleAdmin, "role granted by the invitation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	stores := &storage{cfg: cfg}
+	urps, err := stores.userRepository()
+	if err != nil {
+		return err
+	}
+	roleStore, err := stores.roleRepository()
+	if err != nil {
+		return err
+	}
+	invitations, err := stores.invitationRepository()
+	if err != nil {
+		return err
+	}
+	requests, err := stores.roleRequestRepository()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	roles := service.NewRoleService(roleStore, urps, repository.NewUserMemoryCacheConnection())
+	if err = roles.EnsureDefaults(ctx); err != nil {
+		return fmt.Errorf("EnsureDefaults(): %w", err)
+	}
+	invitation, err := service.NewEnrollmentService(invitations, requests, roles, cfg.InvitationTTL).CreateInvitation(ctx, *role)
+	if err != nil {
+		return fmt.Errorf("CreateInvitation(): %w", err)
+	}
+	fmt.Println(invitation.Code)
+	logrus.Infof("invitation for role %q expires at %s", invitation.Role, invitation.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -3,59 +3,51 @@ package consumer
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 )
 
+// readTimeout is how long a single read waits for new messages
+const readTimeout = 5 * time.Second
+
 // RedisConsumer is a struct for Redis Stream Consumer
 type RedisConsumer struct {
-	rdb *redis.Client
+	rdb    *redis.Client
+	stream string
 }
 
-// NewConsumer creates a new Redis Stream Consumer
-func NewConsumer(rdb *redis.Client) *RedisConsumer {
-	return &RedisConsumer{rdb: rdb}
+// NewConsumer creates a new Redis Stream Consumer of the given stream
+func NewConsumer(rdb *redis.Client, stream string) *RedisConsumer {
+	return &RedisConsumer{rdb: rdb, stream: stream}
 }
 
-// RedisConsumer reading streams from redis
+// RedisConsumer logs the messages added to the stream after it started until ctx is done
 func (rdbClient *RedisConsumer) RedisConsumer(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Goroutine Closed...")
-			return
-		case <-time.After(5 * time.Second):
-			fmt.Println("Goroutine Closed...")
-			return
-		default:
-			streams, err := rdbClient.rdb.XRead(context.Background(), &redis.XReadArgs{
-				Streams: []string{"testStream", "0"},
-				Count:   1,
-				Block:   0,
-			}).Result()
-			if err != nil {
-				fmt.Println("Error reading messages from Redis Stream:", err)
+	lastID := "$"
+	for ctx.Err() == nil {
+		streams, err := rdbClient.rdb.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{rdbClient.stream, lastID},
+			Count:   100,
+			Block:   readTimeout,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.WithFields(logrus.Fields{"stream": rdbClient.stream}).Errorf("XRead: %v", err)
+				time.Sleep(readTimeout)
 			}
-			for _, stream := range streams {
-				streamName := stream.Stream
-				messages := stream.Messages
-
-				for _, msg := range messages {
-					messageID := msg.ID
-					messageData := msg.Values
-
-					fmt.Println("Received message from Redis Stream:", messageID, messageData)
-
-					_, err := rdbClient.rdb.XDel(context.Background(), streamName, messageID).Result()
-					if err != nil {
-						fmt.Println("Error deleting message from Redis Stream:", err)
-					}
-				}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				lastID = msg.ID
+				logrus.WithFields(logrus.Fields{"stream": stream.Stream, "id": msg.ID}).Infof("Received message: %v", msg.Values)
 			}
-			const TTL = 2
-			time.Sleep(TTL * time.Second)
 		}
 	}
 }
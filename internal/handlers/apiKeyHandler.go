@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyHandler struct represents the handler of the API keys of users
+type APIKeyHandler struct {
+	srv APIKeyService
+	vl  *vld.Validate
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(srv APIKeyService, vl *vld.Validate) *APIKeyHandler {
+	return &APIKeyHandler{srv: srv, vl: vl}
+}
+
+// APIKeyService interface, which contains the API key methods
+type APIKeyService interface {
+	Create(ctx context.Context, request *model.APIKeyRequest) (*model.APIKeySecret, error)
+	GetAll(ctx context.Context) ([]*model.APIKey, error)
+	Rotate(ctx context.Context, id uuid.UUID) (*model.APIKeySecret, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
+
+// Create function receives POST request from client
+// @Summary Create an API key
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Creates an API key acting for the user with the given scopes, services send it in the X-API-Key header.
+// @Description The key is only returned here
+// @Accept json
+// @Produce json
+// @Param key body model.APIKeyRequest true "Name, scopes and expiry of the key"
+// @Success 200 {object} model.APIKeySecret "API key"
+// @Failure 400 {string} string "Bad request"
+// @Failure 403 {string} string "Scope not granted"
+// @Router /api/user/apikeys [post]
+func (handler *APIKeyHandler) Create(c echo.Context) error {
+	request := &model.APIKeyRequest{}
+	err := c.Bind(request)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(request)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"request": request}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	key, err := handler.srv.Create(c.Request().Context(), request)
+	if err != nil {
+		return apiKeyError(err, "Create", logrus.Fields{"name": request.Name, "scopes": request.Scopes})
+	}
+	return c.JSON(http.StatusOK, key)
+}
+
+// GetAll function receives GET request from client
+// @Summary Get API keys
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Returns the API keys of the user, oldest first, along with when they were last used
+// @Produce json
+// @Success 200 {array} model.APIKey "API keys"
+// @Router /api/user/apikeys [get]
+func (handler *APIKeyHandler) GetAll(c echo.Context) error {
+	keys, err := handler.srv.GetAll(c.Request().Context())
+	if err != nil {
+		return apiKeyError(err, "GetAll", logrus.Fields{})
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// Rotate function receives POST request from client
+// @Summary Rotate an API key
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Replaces an API key of the user with a new key, the previous key stops working at once. The key is only returned here
+// @Produce json
+// @Param id path string true "ID of the API key"
+// @Success 200 {object} model.APIKeySecret "API key"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No active API key"
+// @Router /api/user/apikeys/{id}/rotate [post]
+func (handler *APIKeyHandler) Rotate(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	key, err := handler.srv.Rotate(c.Request().Context(), id)
+	if err != nil {
+		return apiKeyError(err, "Rotate", logrus.Fields{"id": id})
+	}
+	return c.JSON(http.StatusOK, key)
+}
+
+// Revoke function receives DELETE request from client
+// @Summary Revoke an API key
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Revokes an API key of the user
+// @Produce plain
+// @Param id path string true "ID of the API key"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No active API key"
+// @Router /api/user/apikeys/{id} [delete]
+func (handler *APIKeyHandler) Revoke(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	err = handler.srv.Revoke(c.Request().Context(), id)
+	if err != nil {
+		return apiKeyError(err, "Revoke", logrus.Fields{"id": id})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// apiKeyError logs a failed API key call and maps it to a status
+func apiKeyError(err error, op string, fields logrus.Fields) error {
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	switch {
+	case errors.Is(err, model.ErrUnknownPermission), errors.Is(err, model.ErrAPIKeyExpiry):
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %v", op, err))
+	case errors.Is(err, model.ErrAPIKeyScope):
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("%s: %v", op, err))
+	case errors.Is(err, model.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyCreate(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewAPIKeyService(t)
+	srv.On("Create", mock.Anything, mock.MatchedBy(func(request *model.APIKeyRequest) bool { return request.Name == "batch" })).
+		Return(&model.APIKeySecret{APIKey: &model.APIKey{ID: id, Name: "batch", Hash: []byte("hash")}, Key: "ak_secret"}, nil).Once()
+	srv.On("Create", mock.Anything, mock.MatchedBy(func(request *model.APIKeyRequest) bool { return request.Name == "admin" })).
+		Return(nil, fmt.Errorf("Create: %w", model.ErrAPIKeyScope)).Once()
+	handler := NewAPIKeyHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/apikeys", "/user/apikeys", `{"name":"batch","scopes":["person:read"]}`, nil, handler.Create)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var created map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Equal(t, "ak_secret", created["key"])
+	require.Equal(t, id.String(), created["id"])
+	require.NotContains(t, created, "hash")
+	rec = servePerson(http.MethodPost, "/user/apikeys", "/user/apikeys", `{"name":"admin","scopes":["user:admin"]}`, nil, handler.Create)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/apikeys", "/user/apikeys", `{"name":"batch","scopes":[]}`, nil, handler.Create)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPIKeyManage(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewAPIKeyService(t)
+	srv.On("GetAll", mock.Anything).Return([]*model.APIKey{{ID: id, Name: "batch"}}, nil).Once()
+	srv.On("Rotate", mock.Anything, id).Return(&model.APIKeySecret{APIKey: &model.APIKey{ID: id}, Key: "ak_rotated"}, nil).Once()
+	srv.On("Revoke", mock.Anything, id).Return(nil).Once()
+	srv.On("Revoke", mock.Anything, id).Return(fmt.Errorf("Revoke: %w", model.ErrNotFound)).Once()
+	handler := NewAPIKeyHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/user/apikeys", "/user/apikeys", "", nil, handler.GetAll)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var keys []*model.APIKey
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &keys))
+	require.Len(t, keys, 1)
+	rec = servePerson(http.MethodPost, "/user/apikeys/"+id.String()+"/rotate", "/user/apikeys/:id/rotate", "", nil, handler.Rotate)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "ak_rotated")
+	rec = servePerson(http.MethodDelete, "/user/apikeys/"+id.String(), "/user/apikeys/:id", "", nil, handler.Revoke)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/user/apikeys/"+id.String(), "/user/apikeys/:id", "", nil, handler.Revoke)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodDelete, "/user/apikeys/1", "/user/apikeys/:id", "", nil, handler.Revoke)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
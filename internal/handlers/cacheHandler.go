@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheHandler struct represents the cache admin handler
+type CacheHandler struct {
+	srv CacheService
+	vl  *vld.Validate
+}
+
+// NewCacheHandler creates a new CacheHandler
+func NewCacheHandler(srv CacheService, vl *vld.Validate) *CacheHandler {
+	return &CacheHandler{srv: srv, vl: vl}
+}
+
+// CacheService interface, which contains cache inspection methods
+type CacheService interface {
+	Stats(ctx context.Context) []*model.CacheTierStats
+	Breakers(ctx context.Context) []*model.BreakerState
+	WriteBehind(ctx context.Context) (*model.WriteBehindStats, error)
+	Inspect(ctx context.Context, entity string, id uuid.UUID) (*model.CacheEntry, error)
+	Flush(ctx context.Context, entity string) (*model.CacheFlushResult, error)
+	Warm(ctx context.Context, entity string, limit int) (*model.CacheWarmResult, error)
+}
+
+// Stats function receives GET request from client
+// @Summary Get cache hit ratios
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns hits, misses, evictions and the hit ratio of the in-process and the Redis tier of every cache
+// @Produce json
+// @Success 200 {array} model.CacheTierStats "Cache tier stats"
+// @Router /api/admin/cache/stats [get]
+func (handler *CacheHandler) Stats(c echo.Context) error {
+	return c.JSON(http.StatusOK, handler.srv.Stats(c.Request().Context()))
+}
+
+// Breakers function receives GET request from client
+// @Summary Get cache circuit breakers
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns the state of the circuit breakers in front of the caches, an open breaker serves every read from the primary store
+// @Produce json
+// @Success 200 {array} model.BreakerState "Circuit breaker states"
+// @Router /api/admin/cache/breakers [get]
+func (handler *CacheHandler) Breakers(c echo.Context) error {
+	return c.JSON(http.StatusOK, handler.srv.Breakers(c.Request().Context()))
+}
+
+// WriteBehind function receives GET request from client
+// @Summary Get the write-behind queue
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns the queued person cache writes, the failed writes and the given up changes.
+// @Description Stuck deletes keep failing, their persons are read from the primary store until the delete succeeds
+// @Produce json
+// @Success 200 {object} model.WriteBehindStats "Write-behind counters"
+// @Failure 404 {string} string "The person cache policy is not write_behind"
+// @Router /api/admin/cache/write-behind [get]
+func (handler *CacheHandler) WriteBehind(c echo.Context) error {
+	stats, err := handler.srv.WriteBehind(c.Request().Context())
+	if err != nil {
+		return cacheError(err, "WriteBehind", logrus.Fields{})
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// Inspect function receives GET request from client
+// @Summary Inspect a cached entity
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns a cached person or user as stored in Redis along with its key and TTL, user secrets are left out
+// @Produce json
+// @Param entity path string true "Cached entity (person or user)"
+// @Param id path string true "ID of the entity"
+// @Success 200 {object} model.CacheEntry "Cache entry"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Unknown cache or entity not cached"
+// @Router /api/admin/cache/{entity}/{id} [get]
+func (handler *CacheHandler) Inspect(c echo.Context) error {
+	entity := c.Param("entity")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	entry, err := handler.srv.Inspect(c.Request().Context(), entity, id)
+	if err != nil {
+		return cacheError(err, "Inspect", logrus.Fields{"entity": entity, "id": id})
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// Flush function receives DELETE request from client
+// @Summary Flush a cache
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Deletes every cached entry and query result of an entity on all instances
+// @Produce json
+// @Param entity path string true "Cached entity (person or user)"
+// @Success 200 {object} model.CacheFlushResult "Number of deleted keys"
+// @Failure 404 {string} string "Unknown cache"
+// @Router /api/admin/cache/{entity} [delete]
+func (handler *CacheHandler) Flush(c echo.Context) error {
+	entity := c.Param("entity")
+	result, err := handler.srv.Flush(c.Request().Context(), entity)
+	if err != nil {
+		return cacheError(err, "Flush", logrus.Fields{"entity": entity})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// Warm function receives POST request from client
+// @Summary Warm a cache
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Loads entities from the primary store into their cache in ID order, deleted persons are skipped
+// @Produce json
+// @Param entity path string true "Cached entity (person or user)"
+// @Param limit query int false "Number of entities to load (default 1000, at most 100000)"
+// @Success 200 {object} model.CacheWarmResult "Number of cached entities"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Unknown cache"
+// @Router /api/admin/cache/{entity}/warm [post]
+func (handler *CacheHandler) Warm(c echo.Context) error {
+	entity := c.Param("entity")
+	query := &model.CacheWarmQuery{}
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, query)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"query": query}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	result, err := handler.srv.Warm(c.Request().Context(), entity, query.Limit)
+	if err != nil {
+		return cacheError(err, "Warm", logrus.Fields{"entity": entity})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// cacheError logs a failed cache administration call and maps it to a status,
+// unknown caches and entries which are not cached are not found
+func cacheError(err error, op string, fields logrus.Fields) error {
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	if errors.Is(err, model.ErrUnknownCache) || errors.Is(err, model.ErrCacheMiss) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
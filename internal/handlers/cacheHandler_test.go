@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheStats(t *testing.T) {
+	srv := mocks.NewCacheService(t)
+	srv.On("Stats", mock.Anything).Return([]*model.CacheTierStats{
+		{Cache: "person", Tier: "local", Hits: 3, Misses: 1, HitRatio: 0.75},
+	}).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/cache/stats", "/admin/cache/stats", "", nil, handler.Stats)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats []*model.CacheTierStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	require.Len(t, stats, 1)
+	require.Equal(t, 0.75, stats[0].HitRatio)
+}
+
+func TestCacheBreakers(t *testing.T) {
+	srv := mocks.NewCacheService(t)
+	srv.On("Breakers", mock.Anything).Return([]*model.BreakerState{
+		{Name: "redis", State: "open", Failures: 5, Threshold: 5},
+	}).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/cache/breakers", "/admin/cache/breakers", "", nil, handler.Breakers)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var states []*model.BreakerState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &states))
+	require.Len(t, states, 1)
+	require.Equal(t, "open", states[0].State)
+}
+
+func TestCacheWriteBehind(t *testing.T) {
+	srv := mocks.NewCacheService(t)
+	srv.On("WriteBehind", mock.Anything).Return(&model.WriteBehindStats{Queued: 2, Stuck: 1, Failures: 7, GaveUp: 1}, nil).Once()
+	srv.On("WriteBehind", mock.Anything).Return(nil, fmt.Errorf("write-behind queue: %w", model.ErrUnknownCache)).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/cache/write-behind", "/admin/cache/write-behind", "", nil, handler.WriteBehind)
+	require.Equal(t, http.StatusOK, rec.Code)
+	stats := &model.WriteBehindStats{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), stats))
+	require.Equal(t, 1, stats.Stuck)
+	require.Equal(t, uint64(1), stats.GaveUp)
+	rec = servePerson(http.MethodGet, "/admin/cache/write-behind", "/admin/cache/write-behind", "", nil, handler.WriteBehind)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCacheInspect(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewCacheService(t)
+	srv.On("Inspect", mock.Anything, "person", id).Return(&model.CacheEntry{Entity: "person", ID: id, Key: "myapp:person:" + id.String()}, nil).Once()
+	srv.On("Inspect", mock.Anything, "user", id).Return(nil, fmt.Errorf("Inspect(): %w", model.ErrCacheMiss)).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/cache/person/"+id.String(), "/admin/cache/:entity/:id", "", nil, handler.Inspect)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entry model.CacheEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entry))
+	require.Equal(t, id, entry.ID)
+
+	rec = servePerson(http.MethodGet, "/admin/cache/user/"+id.String(), "/admin/cache/:entity/:id", "", nil, handler.Inspect)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodGet, "/admin/cache/user/1", "/admin/cache/:entity/:id", "", nil, handler.Inspect)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCacheFlush(t *testing.T) {
+	srv := mocks.NewCacheService(t)
+	srv.On("Flush", mock.Anything, "person").Return(&model.CacheFlushResult{Entity: "person", Flushed: 7}, nil).Once()
+	srv.On("Flush", mock.Anything, "audit").Return(nil, model.ErrUnknownCache).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodDelete, "/admin/cache/person", "/admin/cache/:entity", "", nil, handler.Flush)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result model.CacheFlushResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, int64(7), result.Flushed)
+
+	rec = servePerson(http.MethodDelete, "/admin/cache/audit", "/admin/cache/:entity", "", nil, handler.Flush)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCacheWarm(t *testing.T) {
+	srv := mocks.NewCacheService(t)
+	srv.On("Warm", mock.Anything, "user", 50).Return(&model.CacheWarmResult{Entity: "user", Warmed: 12}, nil).Once()
+	handler := NewCacheHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/admin/cache/user/warm?limit=50", "/admin/cache/:entity/warm", "", nil, handler.Warm)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result model.CacheWarmResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, 12, result.Warmed)
+
+	rec = servePerson(http.MethodPost, "/admin/cache/user/warm?limit=1000000", "/admin/cache/:entity/warm", "", nil, handler.Warm)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// EnrollmentHandler struct represents the handler of invitations and role requests
+type EnrollmentHandler struct {
+	srv EnrollmentService
+	vl  *vld.Validate
+}
+
+// NewEnrollmentHandler creates a new EnrollmentHandler
+func NewEnrollmentHandler(srv EnrollmentService, vl *vld.Validate) *EnrollmentHandler {
+	return &EnrollmentHandler{srv: srv, vl: vl}
+}
+
+// EnrollmentService interface, which contains invitation and role request methods
+type EnrollmentService interface {
+	CreateInvitation(ctx context.Context, role string) (*model.InvitationCode, error)
+	RequestRole(ctx context.Context, role string) (*model.RoleRequest, error)
+	GetRoleRequests(ctx context.Context, status string) ([]*model.RoleRequest, error)
+	DecideRoleRequest(ctx context.Context, id uuid.UUID, approve bool) (*model.RoleRequest, error)
+}
+
+// CreateInvitation function receives POST request from client
+// @Summary Create an invitation
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Creates a single-use, expiring invitation code, signing up with it grants the role. The code is only returned here
+// @Accept json
+// @Produce json
+// @Param invitation body model.InvitationRequest true "Role granted by the invitation"
+// @Success 200 {object} model.InvitationCode "Invitation code"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Unknown role"
+// @Router /api/admin/invitations [post]
+func (handler *EnrollmentHandler) CreateInvitation(c echo.Context) error {
+	request := &model.InvitationRequest{}
+	err := c.Bind(request)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(request)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"request": request}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	invitation, err := handler.srv.CreateInvitation(c.Request().Context(), request.Role)
+	if err != nil {
+		return enrollmentError(err, "CreateInvitation", logrus.Fields{"role": request.Role})
+	}
+	return c.JSON(http.StatusOK, invitation)
+}
+
+// RequestRole function receives POST request from client
+// @Summary Request a role
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Queues a request of the user for a role until an admin approves or rejects it
+// @Accept json
+// @Produce json
+// @Param request body model.RoleAssignment true "Requested role"
+// @Success 200 {object} model.RoleRequest "Pending role request"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Unknown role"
+// @Router /api/user/role-request [post]
+func (handler *EnrollmentHandler) RequestRole(c echo.Context) error {
+	request := &model.RoleAssignment{}
+	err := c.Bind(request)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(request)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"request": request}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	roleRequest, err := handler.srv.RequestRole(c.Request().Context(), request.Role)
+	if err != nil {
+		return enrollmentError(err, "RequestRole", logrus.Fields{"role": request.Role})
+	}
+	return c.JSON(http.StatusOK, roleRequest)
+}
+
+// GetRoleRequests function receives GET request from client
+// @Summary Get role requests
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns the role requests with the given status, oldest first
+// @Produce json
+// @Param status query string false "Status of the requests (pending, approved or rejected, default pending)"
+// @Success 200 {array} model.RoleRequest "Role requests"
+// @Failure 400 {string} string "Bad request"
+// @Router /api/admin/role-requests [get]
+func (handler *EnrollmentHandler) GetRoleRequests(c echo.Context) error {
+	query := &model.RoleRequestQuery{}
+	err := (&echo.DefaultBinder{}).BindQueryParams(c, query)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"query": query}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	requests, err := handler.srv.GetRoleRequests(c.Request().Context(), query.Status)
+	if err != nil {
+		return enrollmentError(err, "GetRoleRequests", logrus.Fields{"status": query.Status})
+	}
+	return c.JSON(http.StatusOK, requests)
+}
+
+// ApproveRoleRequest function receives POST request from client
+// @Summary Approve a role request
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Approves a pending role request and assigns the role to the user
+// @Produce json
+// @Param id path string true "ID of the role request"
+// @Success 200 {object} model.RoleRequest "Approved role request"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No pending role request"
+// @Router /api/admin/role-requests/{id}/approve [post]
+func (handler *EnrollmentHandler) ApproveRoleRequest(c echo.Context) error {
+	return handler.decide(c, true)
+}
+
+// RejectRoleRequest function receives POST request from client
+// @Summary Reject a role request
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Rejects a pending role request
+// @Produce json
+// @Param id path string true "ID of the role request"
+// @Success 200 {object} model.RoleRequest "Rejected role request"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No pending role request"
+// @Router /api/admin/role-requests/{id}/reject [post]
+func (handler *EnrollmentHandler) RejectRoleRequest(c echo.Context) error {
+	return handler.decide(c, false)
+}
+
+// decide approves or rejects the role request of the path
+func (handler *EnrollmentHandler) decide(c echo.Context, approve bool) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	request, err := handler.srv.DecideRoleRequest(c.Request().Context(), id, approve)
+	if err != nil {
+		return enrollmentError(err, "DecideRoleRequest", logrus.Fields{"id": id, "approve": approve})
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+// enrollmentError logs a failed invitation or role request call and maps it to a status,
+// unknown roles and requests which are not pending are not found
+func enrollmentError(err error, op string, fields logrus.Fields) error {
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	if errors.Is(err, model.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
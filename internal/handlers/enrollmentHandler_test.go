@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrollmentCreateInvitation(t *testing.T) {
+	srv := mocks.NewEnrollmentService(t)
+	srv.On("CreateInvitation", mock.Anything, model.RoleAdmin).Return(&model.InvitationCode{Code: "code", Role: model.RoleAdmin}, nil).Once()
+	srv.On("CreateInvitation", mock.Anything, "unknown").Return(nil, fmt.Errorf("CreateInvitation: %w", model.ErrNotFound)).Once()
+	handler := NewEnrollmentHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/admin/invitations", "/admin/invitations", `{"role":"admin"}`, nil, handler.CreateInvitation)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var invitation model.InvitationCode
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invitation))
+	require.Equal(t, "code", invitation.Code)
+	rec = servePerson(http.MethodPost, "/admin/invitations", "/admin/invitations", `{"role":"unknown"}`, nil, handler.CreateInvitation)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/invitations", "/admin/invitations", `{}`, nil, handler.CreateInvitation)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestEnrollmentRequestRole(t *testing.T) {
+	srv := mocks.NewEnrollmentService(t)
+	srv.On("RequestRole", mock.Anything, model.RoleAdmin).Return(&model.RoleRequest{Role: model.RoleAdmin, Status: model.RoleRequestPending}, nil).Once()
+	handler := NewEnrollmentHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/role-request", "/user/role-request", `{"role":"admin"}`, nil, handler.RequestRole)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var request model.RoleRequest
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &request))
+	require.Equal(t, model.RoleRequestPending, request.Status)
+}
+
+func TestEnrollmentRoleRequests(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewEnrollmentService(t)
+	srv.On("GetRoleRequests", mock.Anything, "").Return([]*model.RoleRequest{{ID: id}}, nil).Once()
+	srv.On("DecideRoleRequest", mock.Anything, id, true).Return(&model.RoleRequest{ID: id, Status: model.RoleRequestApproved}, nil).Once()
+	srv.On("DecideRoleRequest", mock.Anything, id, false).Return(nil, fmt.Errorf("Decide: %w", model.ErrNotFound)).Once()
+	handler := NewEnrollmentHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/role-requests", "/admin/role-requests", "", nil, handler.GetRoleRequests)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodGet, "/admin/role-requests?status=lost", "/admin/role-requests", "", nil, handler.GetRoleRequests)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = servePerson(http.MethodPost, "/admin/role-requests/"+id.String()+"/approve", "/admin/role-requests/:id/approve", "", nil, handler.ApproveRoleRequest)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/role-requests/"+id.String()+"/reject", "/admin/role-requests/:id/reject", "", nil, handler.RejectRoleRequest)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/role-requests/1/reject", "/admin/role-requests/:id/reject", "", nil, handler.RejectRoleRequest)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set, keys are published well before they sign
+const jwksMaxAge = "public, max-age=300"
+
+// JWKSHandler struct represents the handler publishing the public keys verifying our tokens
+type JWKSHandler struct {
+	src KeySource
+}
+
+// NewJWKSHandler creates a new JWKSHandler
+func NewJWKSHandler(src KeySource) *JWKSHandler {
+	return &JWKSHandler{src: src}
+}
+
+// KeySource interface, which provides the public keys verifying our tokens
+type KeySource interface {
+	JWKS() *model.JWKS
+}
+
+// JWKS returns the public keys verifying access and refresh tokens, tokens name their key in the kid header
+// @Summary Get token verification keys
+// @tags authentication methods
+// @Description Returns the JSON Web Key Set verifying the RS256 signed tokens
+// @Produce json
+// @Success 200 {object} model.JWKS "Key set"
+// @Router /.well-known/jwks.json [get]
+func (handler *JWKSHandler) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", jwksMaxAge)
+	return c.JSON(http.StatusOK, handler.src.JWKS())
+}
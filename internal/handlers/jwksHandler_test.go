@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKS(t *testing.T) {
+	src := mocks.NewKeySource(t)
+	src.On("JWKS").Return(&model.JWKS{Keys: []*model.JWK{{Kty: "RSA", Kid: "key", Alg: "RS256"}}}).Once()
+	handler := NewJWKSHandler(src)
+
+	rec := servePerson(http.MethodGet, "/.well-known/jwks.json", "/.well-known/jwks.json", "", nil, handler.JWKS)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, jwksMaxAge, rec.Header().Get("Cache-Control"))
+	var jwks model.JWKS
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, "key", jwks.Keys[0].Kid)
+}
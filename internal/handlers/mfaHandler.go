@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// MFAHandler struct represents the handler of the second factors of users
+type MFAHandler struct {
+	srv MFAService
+	vl  *vld.Validate
+}
+
+// NewMFAHandler creates a new MFAHandler
+func NewMFAHandler(srv MFAService, vl *vld.Validate) *MFAHandler {
+	return &MFAHandler{srv: srv, vl: vl}
+}
+
+// MFAService interface, which contains the second factor methods
+type MFAService interface {
+	Enroll(ctx context.Context, userID uuid.UUID) (*model.MFAEnrollment, error)
+	Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Disable(ctx context.Context, userID uuid.UUID, code string) error
+	Reset(ctx context.Context, userID uuid.UUID) error
+}
+
+// Enroll function receives POST request from client
+// @Summary Enroll a second factor
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Creates a new TOTP secret of the user along with its provisioning URI, it is enabled once confirmed
+// @Produce json
+// @Success 200 {object} model.MFAEnrollment "TOTP secret"
+// @Failure 409 {string} string "Second factor already enabled"
+// @Router /api/user/mfa/enroll [post]
+func (handler *MFAHandler) Enroll(c echo.Context) error {
+	actor, ok := mdlwr.ActorFromContext(c.Request().Context())
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing actor")
+	}
+	enrollment, err := handler.srv.Enroll(c.Request().Context(), actor.ID)
+	if err != nil {
+		return mfaError(err, "Enroll", logrus.Fields{"user_id": actor.ID})
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm function receives POST request from client
+// @Summary Confirm a second factor
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Enables the enrolled second factor with a TOTP code and returns its recovery codes, they are only returned here
+// @Accept json
+// @Produce json
+// @Param code body model.MFACode true "TOTP code"
+// @Success 200 {object} model.RecoveryCodes "Recovery codes"
+// @Failure 400 {string} string "Invalid code"
+// @Failure 404 {string} string "No enrolled second factor"
+// @Failure 409 {string} string "Second factor already enabled"
+// @Router /api/user/mfa/confirm [post]
+func (handler *MFAHandler) Confirm(c echo.Context) error {
+	actor, code, err := handler.bindCode(c)
+	if err != nil {
+		return err
+	}
+	codes, err := handler.srv.Confirm(c.Request().Context(), actor.ID, code.Code)
+	if err != nil {
+		return mfaError(err, "Confirm", logrus.Fields{"user_id": actor.ID})
+	}
+	return c.JSON(http.StatusOK, &model.RecoveryCodes{Codes: codes})
+}
+
+// Disable function receives POST request from client
+// @Summary Disable the second factor
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Removes the second factor of the user, a TOTP code or a recovery code proves it is the user's decision
+// @Accept json
+// @Produce plain
+// @Param code body model.MFACode true "TOTP code or recovery code"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Invalid code"
+// @Failure 404 {string} string "No second factor"
+// @Router /api/user/mfa/disable [post]
+func (handler *MFAHandler) Disable(c echo.Context) error {
+	actor, code, err := handler.bindCode(c)
+	if err != nil {
+		return err
+	}
+	err = handler.srv.Disable(c.Request().Context(), actor.ID, code.Code)
+	if err != nil {
+		return mfaError(err, "Disable", logrus.Fields{"user_id": actor.ID})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// Reset function receives DELETE request from client
+// @Summary Reset the second factor of a user
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Removes the second factor of a user who lost it, users of roles requiring one enroll a new one at their next login
+// @Produce plain
+// @Param id path string true "ID of the user"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No second factor"
+// @Router /api/admin/user/{id}/mfa [delete]
+func (handler *MFAHandler) Reset(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	err = handler.srv.Reset(c.Request().Context(), id)
+	if err != nil {
+		return mfaError(err, "Reset", logrus.Fields{"user_id": id})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// bindCode returns the actor along with the code of the request body
+func (handler *MFAHandler) bindCode(c echo.Context) (model.Actor, *model.MFACode, error) {
+	actor, ok := mdlwr.ActorFromContext(c.Request().Context())
+	if !ok {
+		return model.Actor{}, nil, echo.NewHTTPError(http.StatusUnauthorized, "Missing actor")
+	}
+	code := &model.MFACode{}
+	err := c.Bind(code)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return model.Actor{}, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(code)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"user_id": actor.ID}).Errorf("Validate: %v", err)
+		return model.Actor{}, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	return actor, code, nil
+}
+
+// mfaError logs a failed second factor call and maps it to a status
+func mfaError(err error, op string, fields logrus.Fields) error {
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	switch {
+	case errors.Is(err, model.ErrInvalidMFACode):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	case errors.Is(err, model.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	case errors.Is(err, model.ErrMFAEnabled):
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%s: %v", op, err))
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// asActor runs h as the given actor, like UserIdentity does
+func asActor(id uuid.UUID, h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := mdlwr.WithActor(c.Request().Context(), model.Actor{ID: id, Role: model.RoleUser})
+		c.SetRequest(c.Request().WithContext(ctx))
+		return h(c)
+	}
+}
+
+func TestMFAEnroll(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewMFAService(t)
+	srv.On("Enroll", mock.Anything, id).Return(&model.MFAEnrollment{Secret: "SECRET", URI: "otpauth://totp/myapp:eugen"}, nil).Once()
+	srv.On("Enroll", mock.Anything, id).Return(nil, fmt.Errorf("Create: %w", model.ErrMFAEnabled)).Once()
+	handler := NewMFAHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/mfa/enroll", "/user/mfa/enroll", "", nil, asActor(id, handler.Enroll))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var enrollment model.MFAEnrollment
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &enrollment))
+	require.Equal(t, "SECRET", enrollment.Secret)
+	rec = servePerson(http.MethodPost, "/user/mfa/enroll", "/user/mfa/enroll", "", nil, asActor(id, handler.Enroll))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/mfa/enroll", "/user/mfa/enroll", "", nil, handler.Enroll)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestMFAConfirm(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewMFAService(t)
+	srv.On("Confirm", mock.Anything, id, "123456").Return([]string{"abcd-efgh"}, nil).Once()
+	srv.On("Confirm", mock.Anything, id, "000000").Return(nil, fmt.Errorf("validateTOTP: %w", model.ErrInvalidMFACode)).Once()
+	srv.On("Confirm", mock.Anything, id, "111111").Return(nil, fmt.Errorf("Get: %w", model.ErrNotFound)).Once()
+	handler := NewMFAHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/mfa/confirm", "/user/mfa/confirm", `{"code":"123456"}`, nil, asActor(id, handler.Confirm))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var codes model.RecoveryCodes
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &codes))
+	require.Equal(t, []string{"abcd-efgh"}, codes.Codes)
+	rec = servePerson(http.MethodPost, "/user/mfa/confirm", "/user/mfa/confirm", `{"code":"000000"}`, nil, asActor(id, handler.Confirm))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/mfa/confirm", "/user/mfa/confirm", `{"code":"111111"}`, nil, asActor(id, handler.Confirm))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/mfa/confirm", "/user/mfa/confirm", `{}`, nil, asActor(id, handler.Confirm))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestMFADisableAndReset(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewMFAService(t)
+	srv.On("Disable", mock.Anything, id, "abcd-efgh").Return(nil).Once()
+	srv.On("Reset", mock.Anything, id).Return(nil).Once()
+	srv.On("Reset", mock.Anything, id).Return(fmt.Errorf("Delete: %w", model.ErrNotFound)).Once()
+	handler := NewMFAHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/mfa/disable", "/user/mfa/disable", `{"code":"abcd-efgh"}`, nil, asActor(id, handler.Disable))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/admin/user/"+id.String()+"/mfa", "/admin/user/:id/mfa", "", nil, handler.Reset)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/admin/user/"+id.String()+"/mfa", "/admin/user/:id/mfa", "", nil, handler.Reset)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodDelete, "/admin/user/1/mfa", "/admin/user/:id/mfa", "", nil, handler.Reset)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
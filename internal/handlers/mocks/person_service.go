@@ -40,13 +40,36 @@ func (_m *PersonService) Create(ctx context.Context, entity *model.Person) (uuid
 	return r0, r1
 }
 
-// Delete provides a mock function with given fields: ctx, uuidString
-func (_m *PersonService) Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
-	ret := _m.Called(ctx, uuidString)
+// CreateMany provides a mock function with given fields: ctx, persons, atomic
+func (_m *PersonService) CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	ret := _m.Called(ctx, persons, atomic)
+
+	var r0 []error
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Person, bool) []error); ok {
+		r0 = rf(ctx, persons, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Person, bool) error); ok {
+		r1 = rf(ctx, persons, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Delete provides a mock function with given fields: ctx, uuidString, version
+func (_m *PersonService) Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error) {
+	ret := _m.Called(ctx, uuidString, version)
 
 	var r0 uuid.UUID
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) uuid.UUID); ok {
-		r0 = rf(ctx, uuidString)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, int64) uuid.UUID); ok {
+		r0 = rf(ctx, uuidString, version)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(uuid.UUID)
@@ -54,8 +77,8 @@ func (_m *PersonService) Delete(ctx context.Context, uuidString uuid.UUID) (uuid
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
-		r1 = rf(ctx, uuidString)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, int64) error); ok {
+		r1 = rf(ctx, uuidString, version)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -63,22 +86,68 @@ func (_m *PersonService) Delete(ctx context.Context, uuidString uuid.UUID) (uuid
 	return r0, r1
 }
 
-// GetAll provides a mock function with given fields: ctx
-func (_m *PersonService) GetAll(ctx context.Context) ([]*model.Person, error) {
-	ret := _m.Called(ctx)
+// DeleteMany provides a mock function with given fields: ctx, items, atomic
+func (_m *PersonService) DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	ret := _m.Called(ctx, items, atomic)
 
-	var r0 []*model.Person
-	if rf, ok := ret.Get(0).(func(context.Context) []*model.Person); ok {
-		r0 = rf(ctx)
+	var r0 []error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.PersonVersion, bool) []error); ok {
+		r0 = rf(ctx, items, atomic)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]*model.Person)
+			r0 = ret.Get(0).([]error)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(ctx)
+	if rf, ok := ret.Get(1).(func(context.Context, []model.PersonVersion, bool) error); ok {
+		r1 = rf(ctx, items, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAll provides a mock function with given fields: ctx, query
+func (_m *PersonService) GetAll(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 *model.PersonPage
+	if rf, ok := ret.Get(0).(func(context.Context, *model.PersonQuery) *model.PersonPage); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.PersonPage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.PersonQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAudit provides a mock function with given fields: ctx, query
+func (_m *PersonService) GetAudit(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []*model.AuditRecord
+	if rf, ok := ret.Get(0).(func(context.Context, *model.AuditQuery) []*model.AuditRecord); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.AuditQuery) error); ok {
+		r1 = rf(ctx, query)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -109,6 +178,75 @@ func (_m *PersonService) GetByID(ctx context.Context, id uuid.UUID) (*model.Pers
 	return r0, r1
 }
 
+// GetDeleted provides a mock function with given fields: ctx
+func (_m *PersonService) GetDeleted(ctx context.Context) ([]*model.Person, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*model.Person
+	if rf, ok := ret.Get(0).(func(context.Context) []*model.Person); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Person)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Purge provides a mock function with given fields: ctx, uuidString
+func (_m *PersonService) Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	ret := _m.Called(ctx, uuidString)
+
+	var r0 uuid.UUID
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) uuid.UUID); ok {
+		r0 = rf(ctx, uuidString)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(uuid.UUID)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, uuidString)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Restore provides a mock function with given fields: ctx, uuidString
+func (_m *PersonService) Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	ret := _m.Called(ctx, uuidString)
+
+	var r0 uuid.UUID
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) uuid.UUID); ok {
+		r0 = rf(ctx, uuidString)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(uuid.UUID)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, uuidString)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: ctx, uuidString, entity
 func (_m *PersonService) Update(ctx context.Context, uuidString uuid.UUID, entity *model.Person) (uuid.UUID, error) {
 	ret := _m.Called(ctx, uuidString, entity)
@@ -132,6 +270,29 @@ func (_m *PersonService) Update(ctx context.Context, uuidString uuid.UUID, entit
 	return r0, r1
 }
 
+// UpdateMany provides a mock function with given fields: ctx, persons, atomic
+func (_m *PersonService) UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	ret := _m.Called(ctx, persons, atomic)
+
+	var r0 []error
+	if rf, ok := ret.Get(0).(func(context.Context, []*model.Person, bool) []error); ok {
+		r0 = rf(ctx, persons, atomic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []*model.Person, bool) error); ok {
+		r1 = rf(ctx, persons, atomic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewPersonService interface {
 	mock.TestingT
 	Cleanup(func())
@@ -31,27 +31,50 @@ func (_m *UserService) Delete(ctx context.Context, id uuid.UUID) error {
 	return r0
 }
 
-// GenerateTokens provides a mock function with given fields: ctx, login, password
-func (_m *UserService) GenerateTokens(ctx context.Context, login string, password string) (string, string, error) {
-	ret := _m.Called(ctx, login, password)
+// EnrollMFA provides a mock function with given fields: ctx, challenge
+func (_m *UserService) EnrollMFA(ctx context.Context, challenge string) (*model.MFAEnrollment, error) {
+	ret := _m.Called(ctx, challenge)
+
+	var r0 *model.MFAEnrollment
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.MFAEnrollment); ok {
+		r0 = rf(ctx, challenge)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.MFAEnrollment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, challenge)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GenerateTokens provides a mock function with given fields: ctx, login, password, ip
+func (_m *UserService) GenerateTokens(ctx context.Context, login string, password string, ip string) (string, string, error) {
+	ret := _m.Called(ctx, login, password, ip)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
-		r0 = rf(ctx, login, password)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) string); ok {
+		r0 = rf(ctx, login, password, ip)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 string
-	if rf, ok := ret.Get(1).(func(context.Context, string, string) string); ok {
-		r1 = rf(ctx, login, password)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) string); ok {
+		r1 = rf(ctx, login, password, ip)
 	} else {
 		r1 = ret.Get(1).(string)
 	}
 
 	var r2 error
-	if rf, ok := ret.Get(2).(func(context.Context, string, string) error); ok {
-		r2 = rf(ctx, login, password)
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, string) error); ok {
+		r2 = rf(ctx, login, password, ip)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -82,6 +105,43 @@ func (_m *UserService) GetAll(ctx context.Context) ([]*model.User, error) {
 	return r0, r1
 }
 
+// LoginMFA provides a mock function with given fields: ctx, challenge, code, ip
+func (_m *UserService) LoginMFA(ctx context.Context, challenge string, code string, ip string) (*model.MFATokens, error) {
+	ret := _m.Called(ctx, challenge, code, ip)
+
+	var r0 *model.MFATokens
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *model.MFATokens); ok {
+		r0 = rf(ctx, challenge, code, ip)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.MFATokens)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, challenge, code, ip)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Logout provides a mock function with given fields: ctx, accessToken
+func (_m *UserService) Logout(ctx context.Context, accessToken string) error {
+	ret := _m.Called(ctx, accessToken)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, accessToken)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RefreshTokenPair provides a mock function with given fields: ctx, accessToken, refreshToken, id
 func (_m *UserService) RefreshTokenPair(ctx context.Context, accessToken string, refreshToken string, id uuid.UUID) (string, string, error) {
 	ret := _m.Called(ctx, accessToken, refreshToken, id)
@@ -110,13 +170,41 @@ func (_m *UserService) RefreshTokenPair(ctx context.Context, accessToken string,
 	return r0, r1, r2
 }
 
-// Signup provides a mock function with given fields: ctx, entity
-func (_m *UserService) Signup(ctx context.Context, entity *model.User) error {
-	ret := _m.Called(ctx, entity)
+// RevokeSessions provides a mock function with given fields: ctx, id
+func (_m *UserService) RevokeSessions(ctx context.Context, id uuid.UUID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Signup provides a mock function with given fields: ctx, entity, invitation
+func (_m *UserService) Signup(ctx context.Context, entity *model.User, invitation string) error {
+	ret := _m.Called(ctx, entity, invitation)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.User, string) error); ok {
+		r0 = rf(ctx, entity, invitation)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Unlock provides a mock function with given fields: ctx, login, ip
+func (_m *UserService) Unlock(ctx context.Context, login string, ip string) error {
+	ret := _m.Called(ctx, login, ip)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.User) error); ok {
-		r0 = rf(ctx, entity)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, login, ip)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// PasswordHandler struct represents the handler of password changes and resets
+type PasswordHandler struct {
+	srv PasswordService
+	vl  *vld.Validate
+}
+
+// NewPasswordHandler creates a new PasswordHandler
+func NewPasswordHandler(srv PasswordService, vl *vld.Validate) *PasswordHandler {
+	return &PasswordHandler{srv: srv, vl: vl}
+}
+
+// PasswordService interface, which contains the password change and reset methods
+type PasswordService interface {
+	ChangePassword(ctx context.Context, userID uuid.UUID, current, password, ip string) error
+	ForgotPassword(ctx context.Context, login, ip string) error
+	ResetPassword(ctx context.Context, token, password string) error
+}
+
+// Change function receives POST request from client
+// @Summary Change the password
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Replaces the password of the user, the current password confirms the change. The user is logged out everywhere
+// @Accept json
+// @Produce plain
+// @Param input body model.PasswordChange true "Current and new password"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Weak password"
+// @Failure 403 {string} string "Wrong current password"
+// @Failure 429 {string} string "Too many failed attempts"
+// @Router /api/user/password/change [post]
+func (handler *PasswordHandler) Change(c echo.Context) error {
+	actor, ok := mdlwr.ActorFromContext(c.Request().Context())
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing actor")
+	}
+	input := &model.PasswordChange{}
+	err := handler.bind(c, input)
+	if err != nil {
+		return err
+	}
+	err = handler.srv.ChangePassword(c.Request().Context(), actor.ID, input.CurrentPassword, input.NewPassword, c.RealIP())
+	if throttledErr := loginThrottled(c, err, actor.ID.String()); throttledErr != nil {
+		return throttledErr
+	}
+	if err != nil {
+		return passwordError(err, "ChangePassword", logrus.Fields{"user_id": actor.ID, "ip": c.RealIP()})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// Forgot function receives POST request from client
+// @Summary Request a password reset
+// @tags authentication methods
+// @Description Mails a single-use password reset token to the login, if it is a known mail address.
+// @Description The response is the same for every login, requests beyond the limits of the login or the IP mail nothing
+// @Accept json
+// @Produce plain
+// @Param input body model.PasswordForgot true "Login"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Router /api/user/password/forgot [post]
+func (handler *PasswordHandler) Forgot(c echo.Context) error {
+	input := &model.PasswordForgot{}
+	err := handler.bind(c, input)
+	if err != nil {
+		return err
+	}
+	err = handler.srv.ForgotPassword(c.Request().Context(), input.Login, c.RealIP())
+	if err != nil {
+		return passwordError(err, "ForgotPassword", logrus.Fields{"login": input.Login, "ip": c.RealIP()})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// Reset function receives POST request from client
+// @Summary Reset the password
+// @tags authentication methods
+// @Description Sets a new password with a mailed password reset token and logs the user out everywhere
+// @Accept json
+// @Produce plain
+// @Param input body model.PasswordResetRequest true "Reset token and new password"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Weak password"
+// @Failure 403 {string} string "Invalid reset token"
+// @Router /api/user/password/reset [post]
+func (handler *PasswordHandler) Reset(c echo.Context) error {
+	input := &model.PasswordResetRequest{}
+	err := handler.bind(c, input)
+	if err != nil {
+		return err
+	}
+	err = handler.srv.ResetPassword(c.Request().Context(), input.Token, input.Password)
+	if err != nil {
+		return passwordError(err, "ResetPassword", logrus.Fields{"ip": c.RealIP()})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// bind binds and validates the request body, the body is not logged since it holds passwords
+func (handler *PasswordHandler) bind(c echo.Context, input interface{}) error {
+	err := c.Bind(input)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(input)
+	if err != nil {
+		logrus.Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	return nil
+}
+
+// passwordError logs a failed password call and maps it to a status
+func passwordError(err error, op string, fields logrus.Fields) error {
+	switch {
+	case errors.Is(err, model.ErrWeakPassword):
+		logrus.WithFields(fields).Warnf("%s: %v", op, err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %v", op, err))
+	case errors.Is(err, model.ErrInvalidCredentials):
+		logrus.WithFields(fields).Warnf("%s: %v", op, err)
+		return echo.NewHTTPError(http.StatusForbidden, "Wrong current password")
+	case errors.Is(err, model.ErrInvalidResetToken):
+		logrus.WithFields(fields).Warnf("%s: %v", op, err)
+		return echo.NewHTTPError(http.StatusForbidden, "Invalid reset token")
+	case errors.Is(err, model.ErrNotFound):
+		logrus.WithFields(fields).Errorf("%s: %v", op, err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	}
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
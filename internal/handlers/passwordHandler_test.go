@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordChange(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewPasswordService(t)
+	srv.On("ChangePassword", mock.Anything, id, "old", "Battery-Staple-7", "192.0.2.1").Return(nil).Once()
+	srv.On("ChangePassword", mock.Anything, id, "wrong", "Battery-Staple-7", "192.0.2.1").
+		Return(fmt.Errorf("CompareHashAndPassword: %w", model.ErrInvalidCredentials)).Once()
+	srv.On("ChangePassword", mock.Anything, id, "old", "short", "192.0.2.1").
+		Return(fmt.Errorf("Check: %w", model.ErrWeakPassword)).Once()
+	srv.On("ChangePassword", mock.Anything, id, "old", "Battery-Staple-7", "192.0.2.1").
+		Return(&model.LoginThrottledError{Scope: model.LoginScopeIP, RetryAfter: 1500 * time.Millisecond}).Once()
+	handler := NewPasswordHandler(srv, vld.New())
+	change := func(body string) int {
+		return servePerson(http.MethodPost, "/user/password/change", "/user/password/change", body, nil, asActor(id, handler.Change)).Code
+	}
+
+	require.Equal(t, http.StatusOK, change(`{"current_password":"old","new_password":"Battery-Staple-7"}`))
+	require.Equal(t, http.StatusForbidden, change(`{"current_password":"wrong","new_password":"Battery-Staple-7"}`))
+	require.Equal(t, http.StatusBadRequest, change(`{"current_password":"old","new_password":"short"}`))
+	rec := servePerson(http.MethodPost, "/user/password/change", "/user/password/change",
+		`{"current_password":"old","new_password":"Battery-Staple-7"}`, nil, asActor(id, handler.Change))
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusBadRequest, change(`{"current_password":"old"}`))
+	rec = servePerson(http.MethodPost, "/user/password/change", "/user/password/change",
+		`{"current_password":"old","new_password":"Battery-Staple-7"}`, nil, handler.Change)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestPasswordForgot(t *testing.T) {
+	srv := mocks.NewPasswordService(t)
+	srv.On("ForgotPassword", mock.Anything, "eugen@example.com", mock.Anything).Return(nil).Once()
+	handler := NewPasswordHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/password/forgot", "/user/password/forgot", `{"login":"eugen@example.com"}`, nil, handler.Forgot)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/password/forgot", "/user/password/forgot", `{}`, nil, handler.Forgot)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	srv := mocks.NewPasswordService(t)
+	srv.On("ResetPassword", mock.Anything, "token", "Battery-Staple-7").Return(nil).Once()
+	srv.On("ResetPassword", mock.Anything, "used", "Battery-Staple-7").Return(fmt.Errorf("Redeem: %w", model.ErrInvalidResetToken)).Once()
+	srv.On("ResetPassword", mock.Anything, "token", "short").Return(fmt.Errorf("Check: %w", model.ErrWeakPassword)).Once()
+	handler := NewPasswordHandler(srv, vld.New())
+	reset := func(body string) int {
+		return servePerson(http.MethodPost, "/user/password/reset", "/user/password/reset", body, nil, handler.Reset).Code
+	}
+
+	require.Equal(t, http.StatusOK, reset(`{"token":"token","password":"Battery-Staple-7"}`))
+	require.Equal(t, http.StatusForbidden, reset(`{"token":"used","password":"Battery-Staple-7"}`))
+	require.Equal(t, http.StatusBadRequest, reset(`{"token":"token","password":"short"}`))
+	require.Equal(t, http.StatusBadRequest, reset(`{"password":"Battery-Staple-7"}`))
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/eugenshima/myapp/internal/model"
 
@@ -33,10 +36,17 @@ func NewPersonHandler(srv PersonService, vl *vld.Validate) *PersonHandler {
 // PersonService interface, which contains Service methods
 type PersonService interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*model.Person, error)
-	GetAll(ctx context.Context) ([]*model.Person, error)
-	Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	GetAll(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error)
+	Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error)
 	Create(ctx context.Context, entity *model.Person) (uuid.UUID, error)
 	Update(ctx context.Context, uuidString uuid.UUID, entity *model.Person) (uuid.UUID, error)
+	GetDeleted(ctx context.Context) ([]*model.Person, error)
+	Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	GetAudit(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error)
+	CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error)
 }
 
 // GetByID function receives Get request from client
@@ -47,6 +57,7 @@ type PersonService interface {
 // @Produce json
 // @Param id path string true "ID of the person"
 // @Success 200 {object} model.Person "Person object"
+// @Header 200 {string} ETag "Version of the person"
 // @Failure 400 {string} string "Bad request"
 // @Failure 404 {string} string "Person not found"
 // @Router /api/person/getById/{id} [get]
@@ -57,10 +68,15 @@ func (handler *PersonHandler) GetByID(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
 	}
 	result, err := handler.srv.GetByID(c.Request().Context(), ID)
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(logrus.Fields{"id": ID}).Errorf("GetByID: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("GetByID: %v", err))
+	}
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"id": ID}).Errorf("GetByID: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetByID: %v", err))
 	}
+	c.Response().Header().Set("ETag", etag(result.Version))
 	return c.JSON(http.StatusOK, result)
 }
 
@@ -68,13 +84,34 @@ func (handler *PersonHandler) GetByID(c echo.Context) error {
 // @Summary Get All
 // @Security ApiKeyAuth
 // @Tags Person CRUD
-// @Description Get All ^ :)
+// @Description Returns a page of persons, supports limit/offset and cursor pagination
 // @Produce json
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of persons to skip, ignored with cursor"
+// @Param cursor query string false "Cursor from next_cursor of the previous page"
+// @Param sort query string false "Sort field (name or age)"
+// @Param order query string false "Sort order (asc or desc)"
+// @Param min_age query int false "Minimal age"
+// @Param max_age query int false "Maximal age"
+// @Param ishealthy query bool false "Health status"
+// @Param name_prefix query string false "Name prefix"
+// @Success 200 {object} model.PersonPage "Page of persons"
 // @Failure 400 {string} string "Bad request"
 // @Failure 404 {string} string "Person not found"
 // @Router /api/person/getAll [get]
 func (handler *PersonHandler) GetAll(c echo.Context) error {
-	results, err := handler.srv.GetAll(c.Request().Context())
+	query := &model.PersonQuery{}
+	err := c.Bind(query)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"query": query}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	results, err := handler.srv.GetAll(c.Request().Context(), query)
 	if err != nil {
 		logrus.Errorf("GetAll: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetAll: %v", err))
@@ -90,9 +127,12 @@ func (handler *PersonHandler) GetAll(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param id path string true "ID of the person"
+// @Param If-Match header string true "ETag of the person"
 // @Success 200 {object} model.Person "Person object"
 // @Failure 400 {string} string "Bad request"
 // @Failure 404 {string} string "Person not found"
+// @Failure 412 {string} string "Person was changed"
+// @Failure 428 {string} string "Missing If-Match header"
 // @Router /api/person/delete/{id} [delete]
 func (handler *PersonHandler) Delete(c echo.Context) error {
 	id, err := uuid.Parse(c.Param("id"))
@@ -100,7 +140,19 @@ func (handler *PersonHandler) Delete(c echo.Context) error {
 		logrus.Errorf("Parse: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Parse: %v", err))
 	}
-	id, err = handler.srv.Delete(c.Request().Context(), id)
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	id, err = handler.srv.Delete(c.Request().Context(), id, version)
+	if errors.Is(err, model.ErrVersionConflict) {
+		logrus.WithFields(logrus.Fields{"id": id, "version": version}).Errorf("Delete: %v", err)
+		return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("Delete: %v", err))
+	}
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(logrus.Fields{"id": id, "version": version}).Errorf("Delete: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Delete: %v", err))
+	}
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"id": &id}).Errorf("Delete: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Delete: %v", err))
@@ -139,6 +191,7 @@ func (handler *PersonHandler) Create(c echo.Context) error {
 		logrus.WithFields(logrus.Fields{"person": &person}).Errorf("Create: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Create: %v", err))
 	}
+	c.Response().Header().Set("ETag", etag(person.Version))
 	return c.String(http.StatusOK, fmt.Sprintf("inserted ID: %v", id))
 }
 
@@ -150,10 +203,14 @@ func (handler *PersonHandler) Create(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param id path string true "ID of the person"
+// @Param If-Match header string true "ETag of the person"
 // @Param entity body model.Person true "Updated person data"
 // @Success 200 {string} string "ID of the created person"
+// @Header 200 {string} ETag "New version of the person"
 // @Failure 400 {string} string "Bad request"
-// @Failure 404 {string} string "Error message"
+// @Failure 404 {string} string "Person not found"
+// @Failure 412 {string} string "Person was changed"
+// @Failure 428 {string} string "Missing If-Match header"
 // @Router /api/person/update/{id} [patch]
 func (handler *PersonHandler) Update(c echo.Context) error {
 	var person *model.Person
@@ -172,10 +229,345 @@ func (handler *PersonHandler) Update(c echo.Context) error {
 		logrus.WithFields(logrus.Fields{"person": person}).Errorf("Validate: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Validate: %v", err))
 	}
+	person.Version, err = ifMatch(c)
+	if err != nil {
+		return err
+	}
 	id, err = handler.srv.Update(c.Request().Context(), id, person)
+	if errors.Is(err, model.ErrVersionConflict) {
+		logrus.WithFields(logrus.Fields{"id": id, "person": person}).Errorf("Update: %v", err)
+		return echo.NewHTTPError(http.StatusPreconditionFailed, fmt.Sprintf("Update: %v", err))
+	}
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(logrus.Fields{"id": id, "person": person}).Errorf("Update: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Update: %v", err))
+	}
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"id": id, "person": person}).Errorf("Update: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Update: %v", err))
 	}
+	c.Response().Header().Set("ETag", etag(person.Version))
 	return c.String(http.StatusOK, fmt.Sprintf("Updated id --> %v", id))
 }
+
+// GetDeleted function receives GET request from client
+// @Summary Get deleted persons
+// @Security ApiKeyAuth
+// @Tags Person trash
+// @Description Lists soft deleted persons, recently deleted first
+// @Produce json
+// @Success 200 {array} model.Person "Deleted persons"
+// @Failure 500 {string} string "Error message"
+// @Router /api/person/trash [get]
+func (handler *PersonHandler) GetDeleted(c echo.Context) error {
+	results, err := handler.srv.GetDeleted(c.Request().Context())
+	if err != nil {
+		logrus.Errorf("GetDeleted: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetDeleted: %v", err))
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// Restore function receives POST request from client
+// @Summary Restore person
+// @Security ApiKeyAuth
+// @Tags Person trash
+// @Description Brings a soft deleted person back
+// @Produce plain
+// @Param id path string true "ID of the person"
+// @Success 200 {string} string "ID of the restored person"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No soft deleted person with the ID"
+// @Failure 500 {string} string "Error message"
+// @Router /api/person/restore/{id} [post]
+func (handler *PersonHandler) Restore(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	id, err = handler.srv.Restore(c.Request().Context(), id)
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("Restore: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Restore: %v", err))
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("Restore: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Restore: %v", err))
+	}
+	return c.String(http.StatusOK, fmt.Sprintf("Restored ID: %v", id))
+}
+
+// Purge function receives DELETE request from client
+// @Summary Purge person
+// @Security ApiKeyAuth
+// @Tags Person trash
+// @Description Permanently deletes a soft deleted person
+// @Produce plain
+// @Param id path string true "ID of the person"
+// @Success 200 {string} string "ID of the purged person"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "No soft deleted person with the ID"
+// @Failure 500 {string} string "Error message"
+// @Router /api/person/purge/{id} [delete]
+func (handler *PersonHandler) Purge(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	id, err = handler.srv.Purge(c.Request().Context(), id)
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("Purge: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Purge: %v", err))
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("Purge: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Purge: %v", err))
+	}
+	return c.String(http.StatusOK, fmt.Sprintf("Purged ID: %v", id))
+}
+
+// GetAudit function receives GET request from client
+// @Summary Get person audit trail
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns who created, updated or deleted persons and what changed, newest first
+// @Produce json
+// @Param person_id query string false "ID of the person"
+// @Param actor_id query string false "ID of the user who made the change"
+// @Param from query string false "Start of the time range, RFC 3339"
+// @Param to query string false "End of the time range (exclusive), RFC 3339"
+// @Param limit query int false "Number of records (default 100, max 1000)"
+// @Success 200 {array} model.AuditRecord "Audit records"
+// @Failure 400 {string} string "Bad request"
+// @Failure 500 {string} string "Error message"
+// @Router /api/admin/audit [get]
+func (handler *PersonHandler) GetAudit(c echo.Context) error {
+	query := &model.AuditQuery{}
+	err := c.Bind(query)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"query": query}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	results, err := handler.srv.GetAudit(c.Request().Context(), query)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"query": query}).Errorf("GetAudit: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetAudit: %v", err))
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// BulkCreate function receives POST request from client
+// @Summary Create persons in bulk
+// @Security ApiKeyAuth
+// @Tags Person bulk
+// @Description Creates up to 1000 persons, atomic mode (default) creates all of them or none,
+// @Description best_effort mode creates the valid ones and reports the rest
+// @Accept json
+// @Produce json
+// @Param request body model.BulkPersons true "Mode and persons to be created"
+// @Success 200 {object} model.BulkReport "Result per person"
+// @Failure 400 {object} model.BulkReport "Invalid request or persons"
+// @Failure 409 {object} model.BulkReport "Atomic request rolled back"
+// @Router /api/person/bulk/insert [post]
+func (handler *PersonHandler) BulkCreate(c echo.Context) error {
+	request := &model.BulkPersons{}
+	err := handler.bind(c, request)
+	if err != nil {
+		return err
+	}
+	report := newBulkReport(request.Mode, len(request.Persons))
+	persons, index := handler.validPersons(report, request.Persons, func(person *model.Person) error {
+		person.ID = uuid.New()
+		return nil
+	})
+	return handler.bulk(c, report, index, func(ctx context.Context, atomic bool) ([]error, error) {
+		return handler.srv.CreateMany(ctx, persons, atomic)
+	}, func(j int) (uuid.UUID, int64) {
+		return persons[j].ID, persons[j].Version
+	})
+}
+
+// BulkUpdate function receives PATCH request from client
+// @Summary Update persons in bulk
+// @Security ApiKeyAuth
+// @Tags Person bulk
+// @Description Updates up to 1000 persons, each person carries its id and the version it was read in,
+// @Description atomic mode (default) updates all of them or none, best_effort mode reports the failed ones
+// @Accept json
+// @Produce json
+// @Param request body model.BulkPersons true "Mode and persons to be updated"
+// @Success 200 {object} model.BulkReport "Result per person"
+// @Failure 400 {object} model.BulkReport "Invalid request or persons"
+// @Failure 409 {object} model.BulkReport "Atomic request rolled back"
+// @Router /api/person/bulk/update [patch]
+func (handler *PersonHandler) BulkUpdate(c echo.Context) error {
+	request := &model.BulkPersons{}
+	err := handler.bind(c, request)
+	if err != nil {
+		return err
+	}
+	report := newBulkReport(request.Mode, len(request.Persons))
+	persons, index := handler.validPersons(report, request.Persons, func(person *model.Person) error {
+		if person.ID == uuid.Nil {
+			return errors.New("missing id")
+		}
+		return nil
+	})
+	return handler.bulk(c, report, index, func(ctx context.Context, atomic bool) ([]error, error) {
+		return handler.srv.UpdateMany(ctx, persons, atomic)
+	}, func(j int) (uuid.UUID, int64) {
+		return persons[j].ID, persons[j].Version
+	})
+}
+
+// BulkDelete function receives POST request from client
+// @Summary Delete persons in bulk
+// @Security ApiKeyAuth
+// @Tags Person bulk
+// @Description Soft deletes up to 1000 persons given by id and version,
+// @Description atomic mode (default) deletes all of them or none, best_effort mode reports the failed ones
+// @Accept json
+// @Produce json
+// @Param request body model.BulkDelete true "Mode and persons to be deleted"
+// @Success 200 {object} model.BulkReport "Result per person"
+// @Failure 400 {object} model.BulkReport "Invalid request or persons"
+// @Failure 409 {object} model.BulkReport "Atomic request rolled back"
+// @Router /api/person/bulk/delete [post]
+func (handler *PersonHandler) BulkDelete(c echo.Context) error {
+	request := &model.BulkDelete{}
+	err := handler.bind(c, request)
+	if err != nil {
+		return err
+	}
+	report := newBulkReport(request.Mode, len(request.Items))
+	items := make([]model.PersonVersion, 0, len(request.Items))
+	index := make([]int, 0, len(request.Items))
+	for i, item := range request.Items {
+		if item.ID == uuid.Nil {
+			reportItem(report, i, item.ID, 0, errors.New("missing id"))
+			continue
+		}
+		items = append(items, item)
+		index = append(index, i)
+	}
+	return handler.bulk(c, report, index, func(ctx context.Context, atomic bool) ([]error, error) {
+		return handler.srv.DeleteMany(ctx, items, atomic)
+	}, func(j int) (uuid.UUID, int64) {
+		return items[j].ID, 0
+	})
+}
+
+// bind binds and validates a bulk request
+func (handler *PersonHandler) bind(c echo.Context, request interface{}) error {
+	err := c.Bind(request)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(request)
+	if err != nil {
+		logrus.Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	return nil
+}
+
+// validPersons prepares every person with prepare and validates it, invalid persons are reported,
+// it returns the valid persons with their indexes in the request
+func (handler *PersonHandler) validPersons(report *model.BulkReport, persons []*model.Person,
+	prepare func(person *model.Person) error) (valid []*model.Person, index []int) {
+	for i, person := range persons {
+		if person == nil {
+			reportItem(report, i, uuid.Nil, 0, errors.New("missing person"))
+			continue
+		}
+		err := prepare(person)
+		if err == nil {
+			err = handler.vl.Struct(person)
+		}
+		if err != nil {
+			reportItem(report, i, person.ID, 0, err)
+			continue
+		}
+		valid = append(valid, person)
+		index = append(index, i)
+	}
+	return valid, index
+}
+
+// bulk runs a bulk request over the items left after validation and writes the report,
+// index maps the items passed to run back to the request and result describes an applied item
+func (handler *PersonHandler) bulk(c echo.Context, report *model.BulkReport, index []int,
+	run func(ctx context.Context, atomic bool) ([]error, error), result func(j int) (uuid.UUID, int64)) error {
+	atomic := report.Mode == model.BulkAtomic
+	if atomic && report.Failed > 0 {
+		for j, i := range index {
+			id, _ := result(j)
+			reportItem(report, i, id, 0, model.ErrBulkAborted)
+		}
+		return c.JSON(http.StatusBadRequest, report)
+	}
+	if len(index) > 0 {
+		errs, err := run(c.Request().Context(), atomic)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"mode": report.Mode, "items": len(index)}).Errorf("Bulk: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bulk: %v", err))
+		}
+		for j, i := range index {
+			id, version := result(j)
+			if errs[j] != nil {
+				version = 0
+			}
+			reportItem(report, i, id, version, errs[j])
+		}
+	}
+	if atomic && report.Failed > 0 {
+		return c.JSON(http.StatusConflict, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// newBulkReport returns an empty report of a bulk request with n items
+func newBulkReport(mode string, n int) *model.BulkReport {
+	if mode == "" {
+		mode = model.BulkAtomic
+	}
+	return &model.BulkReport{Mode: mode, Results: make([]model.BulkResult, n)}
+}
+
+// reportItem fills the result of the i-th item of a bulk request
+func reportItem(report *model.BulkReport, i int, id uuid.UUID, version int64, err error) {
+	report.Results[i] = model.BulkResult{Index: i, ID: id, Version: version}
+	if err != nil {
+		report.Results[i].Error = err.Error()
+		report.Failed++
+		return
+	}
+	report.Succeeded++
+}
+
+// etag formats a person version as a strong ETag
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch returns the person version required by the If-Match header
+func ifMatch(c echo.Context) (int64, error) {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return 0, echo.NewHTTPError(http.StatusPreconditionRequired, "Missing If-Match header")
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"If-Match": header}).Errorf("ParseInt: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid If-Match header: %v", err))
+	}
+	return version, nil
+}
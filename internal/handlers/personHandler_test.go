@@ -2,12 +2,22 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
 	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
 	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -42,22 +52,22 @@ func TestCreate(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	mockPersonService.On("Delete", mock.Anything, mock.AnythingOfType("uuid.UUID")).Return(uuid.UUID{}, nil).Once()
+	mockPersonService.On("Delete", mock.Anything, mock.AnythingOfType("uuid.UUID"), mock.AnythingOfType("int64")).Return(uuid.UUID{}, nil).Once()
 
-	id, err := mockPersonService.Delete(context.Background(), mockPersonEntity.ID)
+	id, err := mockPersonService.Delete(context.Background(), mockPersonEntity.ID, mockPersonEntity.Version)
 	require.NoError(t, err)
 	require.NotNil(t, id)
 }
 
 func TestGetAll(t *testing.T) {
-	mockPersonService.On("GetAll", mock.Anything).Return([]*model.Person{}, nil).Twice()
+	mockPersonService.On("GetAll", mock.Anything, mock.AnythingOfType("*model.PersonQuery")).Return(&model.PersonPage{Items: []*model.Person{}}, nil).Twice()
 	handler := NewPersonHandler(mockPersonService, nil)
-	res, err := mockPersonService.GetAll(context.Background())
+	res, err := mockPersonService.GetAll(context.Background(), &model.PersonQuery{})
 	require.NoError(t, err)
-	results, err := handler.srv.GetAll(context.Background())
+	results, err := handler.srv.GetAll(context.Background(), &model.PersonQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, results)
-	require.Equal(t, len(res), len(results))
+	require.Equal(t, len(res.Items), len(results.Items))
 }
 
 func TestUpdate(t *testing.T) {
@@ -75,3 +85,191 @@ func TestGetByID(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, id)
 }
+
+// testValidator adapts go-playground validator to echo
+type testValidator struct {
+	vl *vld.Validate
+}
+
+func (v *testValidator) Validate(i interface{}) error {
+	return v.vl.Struct(i)
+}
+
+// servePerson serves a single request through a PersonHandler route
+func servePerson(method, path, route, body string, header http.Header, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.Validator = &testValidator{vl: vld.New()}
+	e.Add(method, route, h)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetByIDETag(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("GetByID", mock.Anything, mockPersonEntity.ID).Return(&model.Person{ID: mockPersonEntity.ID, Version: 3}, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/person/"+mockPersonEntity.ID.String(), "/person/:id", "", nil, handler.GetByID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"3"`, rec.Header().Get("ETag"))
+	srv.AssertExpectations(t)
+}
+
+func TestUpdateWithoutIfMatch(t *testing.T) {
+	srv := new(mocks.PersonService)
+	handler := NewPersonHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPatch, "/person/"+mockPersonEntity.ID.String(), "/person/:id", `{"name":"test","age":20}`, nil, handler.Update)
+	require.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	srv.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpdateVersionConflict(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("Update", mock.Anything, mockPersonEntity.ID, mock.MatchedBy(func(p *model.Person) bool { return p.Version == 2 })).
+		Return(uuid.Nil, model.ErrVersionConflict).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	header := http.Header{"If-Match": {`"2"`}}
+	rec := servePerson(http.MethodPatch, "/person/"+mockPersonEntity.ID.String(), "/person/:id", `{"name":"test","age":20}`, header, handler.Update)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestUpdateDeleteNotFound(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("Update", mock.Anything, mockPersonEntity.ID, mock.Anything).
+		Return(uuid.Nil, fmt.Errorf("Update: %w", model.ErrNotFound)).Once()
+	srv.On("Delete", mock.Anything, mockPersonEntity.ID, int64(2)).
+		Return(uuid.Nil, fmt.Errorf("Delete: %w", model.ErrNotFound)).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	header := http.Header{"If-Match": {`"2"`}}
+	rec := servePerson(http.MethodPatch, "/person/"+mockPersonEntity.ID.String(), "/person/:id", `{"name":"test","age":20}`, header, handler.Update)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodDelete, "/person/"+mockPersonEntity.ID.String(), "/person/:id", "", header, handler.Delete)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestDeleteIfMatch(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("Delete", mock.Anything, mockPersonEntity.ID, int64(4)).Return(mockPersonEntity.ID, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	header := http.Header{"If-Match": {`W/"4"`}}
+	rec := servePerson(http.MethodDelete, "/person/"+mockPersonEntity.ID.String(), "/person/:id", "", header, handler.Delete)
+	require.Equal(t, http.StatusOK, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestGetDeleted(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("GetDeleted", mock.Anything).Return([]*model.Person{&mockPersonEntity}, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/person/trash", "/person/trash", "", nil, handler.GetDeleted)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), mockPersonEntity.ID.String())
+	srv.AssertExpectations(t)
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("Restore", mock.Anything, mockPersonEntity.ID).Return(mockPersonEntity.ID, nil).Once()
+	srv.On("Purge", mock.Anything, mockPersonEntity.ID).Return(uuid.Nil, errors.New("not deleted")).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/restore/"+mockPersonEntity.ID.String(), "/restore/:id", "", nil, handler.Restore)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/purge/"+mockPersonEntity.ID.String(), "/purge/:id", "", nil, handler.Purge)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestRestoreAndPurgeNotFound(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("Restore", mock.Anything, mockPersonEntity.ID).
+		Return(uuid.Nil, fmt.Errorf("Restore: %w", model.ErrNotFound)).Once()
+	srv.On("Purge", mock.Anything, mockPersonEntity.ID).
+		Return(uuid.Nil, fmt.Errorf("Purge: %w", model.ErrNotFound)).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/restore/"+mockPersonEntity.ID.String(), "/restore/:id", "", nil, handler.Restore)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodDelete, "/purge/"+mockPersonEntity.ID.String(), "/purge/:id", "", nil, handler.Purge)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestGetAudit(t *testing.T) {
+	srv := new(mocks.PersonService)
+	from := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	srv.On("GetAudit", mock.Anything, mock.MatchedBy(func(q *model.AuditQuery) bool {
+		return q.PersonID != nil && *q.PersonID == mockPersonEntity.ID && q.From != nil && q.From.Equal(from) && q.ActorID == nil
+	})).Return([]*model.AuditRecord{{PersonID: mockPersonEntity.ID, Action: model.AuditCreate}}, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	path := "/admin/audit?person_id=" + mockPersonEntity.ID.String() + "&from=" + from.Format(time.RFC3339)
+	rec := servePerson(http.MethodGet, path, "/admin/audit", "", nil, handler.GetAudit)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), model.AuditCreate)
+
+	rec = servePerson(http.MethodGet, "/admin/audit?actor_id=admin", "/admin/audit", "", nil, handler.GetAudit)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	srv.AssertExpectations(t)
+}
+
+func TestBulkCreateBestEffort(t *testing.T) {
+	srv := new(mocks.PersonService)
+	srv.On("CreateMany", mock.Anything, mock.MatchedBy(func(persons []*model.Person) bool {
+		return len(persons) == 2 && persons[0].Name == "First" && persons[1].Name == "Third"
+	}), false).Return([]error{nil, model.ErrVersionConflict}, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	body := `{"mode":"best_effort","persons":[{"name":"First","age":20},{"name":"","age":21},{"name":"Third","age":22}]}`
+	rec := servePerson(http.MethodPost, "/bulk/insert", "/bulk/insert", body, nil, handler.BulkCreate)
+	require.Equal(t, http.StatusOK, rec.Code)
+	report := model.BulkReport{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Equal(t, 1, report.Succeeded)
+	require.Equal(t, 2, report.Failed)
+	require.Empty(t, report.Results[0].Error)
+	require.NotEmpty(t, report.Results[1].Error)
+	require.Equal(t, model.ErrVersionConflict.Error(), report.Results[2].Error)
+	srv.AssertExpectations(t)
+}
+
+func TestBulkCreateAtomicInvalid(t *testing.T) {
+	srv := new(mocks.PersonService)
+	handler := NewPersonHandler(srv, vld.New())
+
+	body := `{"persons":[{"name":"First","age":20},{"name":"","age":21}]}`
+	rec := servePerson(http.MethodPost, "/bulk/insert", "/bulk/insert", body, nil, handler.BulkCreate)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	report := model.BulkReport{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Equal(t, model.BulkAtomic, report.Mode)
+	require.Equal(t, model.ErrBulkAborted.Error(), report.Results[0].Error)
+	srv.AssertNotCalled(t, "CreateMany", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBulkDeleteAtomicConflict(t *testing.T) {
+	srv := new(mocks.PersonService)
+	items := []model.PersonVersion{{ID: uuid.New(), Version: 1}, {ID: uuid.New(), Version: 2}}
+	srv.On("DeleteMany", mock.Anything, items, true).
+		Return([]error{model.ErrBulkAborted, model.ErrVersionConflict}, nil).Once()
+	handler := NewPersonHandler(srv, vld.New())
+
+	raw, err := json.Marshal(model.BulkDelete{Items: items})
+	require.NoError(t, err)
+	rec := servePerson(http.MethodPost, "/bulk/delete", "/bulk/delete", string(raw), nil, handler.BulkDelete)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	srv.AssertExpectations(t)
+}
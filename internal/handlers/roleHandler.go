@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// RoleHandler struct represents the role admin handler
+type RoleHandler struct {
+	srv RoleService
+	vl  *vld.Validate
+}
+
+// NewRoleHandler creates a new RoleHandler
+func NewRoleHandler(srv RoleService, vl *vld.Validate) *RoleHandler {
+	return &RoleHandler{srv: srv, vl: vl}
+}
+
+// RoleService interface, which contains role management methods
+type RoleService interface {
+	GetAll(ctx context.Context) ([]*model.Role, error)
+	Save(ctx context.Context, role *model.Role) error
+	Delete(ctx context.Context, name string) error
+	AssignRole(ctx context.Context, id uuid.UUID, role string) error
+}
+
+// GetAll function receives GET request from client
+// @Summary Get all roles
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Returns every role along with the permissions it grants
+// @Produce json
+// @Success 200 {array} model.Role "Roles"
+// @Router /api/admin/roles [get]
+func (handler *RoleHandler) GetAll(c echo.Context) error {
+	roles, err := handler.srv.GetAll(c.Request().Context())
+	if err != nil {
+		logrus.Errorf("GetAll: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetAll: %v", err))
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// Save function receives PUT request from client
+// @Summary Create or update a role
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Creates a role or replaces its permissions, the admin role always grants every permission
+// @Accept json
+// @Produce json
+// @Param name path string true "Name of the role"
+// @Param permissions body model.Role true "Permissions of the role"
+// @Success 200 {object} model.Role "Saved role"
+// @Failure 400 {string} string "Bad request"
+// @Router /api/admin/roles/{name} [put]
+func (handler *RoleHandler) Save(c echo.Context) error {
+	role := &model.Role{}
+	err := c.Bind(role)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	role.Name = c.Param("name")
+	err = handler.vl.Struct(role)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"role": role}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	err = handler.srv.Save(c.Request().Context(), role)
+	if err != nil {
+		return roleError(err, "Save", logrus.Fields{"role": role.Name})
+	}
+	return c.JSON(http.StatusOK, role)
+}
+
+// Delete function receives DELETE request from client
+// @Summary Delete a role
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Deletes a role which is not built in, users holding it are left without permissions
+// @Produce plain
+// @Param name path string true "Name of the role"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Built-in role"
+// @Failure 404 {string} string "Unknown role"
+// @Router /api/admin/roles/{name} [delete]
+func (handler *RoleHandler) Delete(c echo.Context) error {
+	name := c.Param("name")
+	err := handler.srv.Delete(c.Request().Context(), name)
+	if err != nil {
+		return roleError(err, "Delete", logrus.Fields{"role": name})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// AssignRole function receives PUT request from client
+// @Summary Assign a role to a user
+// @Security ApiKeyAuth
+// @Tags Admin
+// @Description Assigns an existing role to a user, the access tokens of the user are revoked and the next refresh carries the new role
+// @Accept json
+// @Produce plain
+// @Param id path string true "ID of the user"
+// @Param role body model.RoleAssignment true "Role to assign"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Unknown user or role"
+// @Router /api/admin/user/{id}/role [put]
+func (handler *RoleHandler) AssignRole(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	assignment := &model.RoleAssignment{}
+	err = c.Bind(assignment)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.vl.Struct(assignment)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	err = handler.srv.AssignRole(c.Request().Context(), id, assignment.Role)
+	if err != nil {
+		return roleError(err, "AssignRole", logrus.Fields{"id": id, "role": assignment.Role})
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// roleError logs a failed role administration call and maps it to a status,
+// unknown roles and users are not found, changes of built-in roles and unknown permissions are bad requests
+func roleError(err error, op string, fields logrus.Fields) error {
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s: %v", op, err))
+	case errors.Is(err, model.ErrBuiltinRole), errors.Is(err, model.ErrUnknownPermission):
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s: %v", op, err))
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", op, err))
+}
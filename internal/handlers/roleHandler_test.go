@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
+	"github.com/eugenshima/myapp/internal/model"
+
+	vld "github.com/go-playground/validator"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleGetAll(t *testing.T) {
+	srv := mocks.NewRoleService(t)
+	srv.On("GetAll", mock.Anything).Return([]*model.Role{{Name: model.RoleUser, Permissions: []string{model.PermissionPersonRead}}}, nil).Once()
+	handler := NewRoleHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodGet, "/admin/roles", "/admin/roles", "", nil, handler.GetAll)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var roles []*model.Role
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &roles))
+	require.Len(t, roles, 1)
+	require.Equal(t, model.RoleUser, roles[0].Name)
+}
+
+func TestRoleSave(t *testing.T) {
+	srv := mocks.NewRoleService(t)
+	editor := &model.Role{Name: "editor", Permissions: []string{model.PermissionPersonRead, model.PermissionPersonWrite}}
+	srv.On("Save", mock.Anything, editor).Return(nil).Once()
+	srv.On("Save", mock.Anything, &model.Role{Name: model.RoleAdmin, Permissions: []string{}}).
+		Return(fmt.Errorf("Save: %w", model.ErrBuiltinRole)).Once()
+	handler := NewRoleHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPut, "/admin/roles/editor", "/admin/roles/:name", `{"permissions":["person:read","person:write"]}`, nil, handler.Save)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPut, "/admin/roles/admin", "/admin/roles/:name", `{"permissions":[]}`, nil, handler.Save)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = servePerson(http.MethodPut, "/admin/roles/editor", "/admin/roles/:name", `{}`, nil, handler.Save)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRoleDelete(t *testing.T) {
+	srv := mocks.NewRoleService(t)
+	srv.On("Delete", mock.Anything, "editor").Return(nil).Once()
+	srv.On("Delete", mock.Anything, "missing").Return(fmt.Errorf("Delete: %w", model.ErrNotFound)).Once()
+	handler := NewRoleHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodDelete, "/admin/roles/editor", "/admin/roles/:name", "", nil, handler.Delete)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/admin/roles/missing", "/admin/roles/:name", "", nil, handler.Delete)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRoleAssign(t *testing.T) {
+	id := uuid.New()
+	srv := mocks.NewRoleService(t)
+	srv.On("AssignRole", mock.Anything, id, "editor").Return(nil).Once()
+	srv.On("AssignRole", mock.Anything, id, "missing").Return(fmt.Errorf("AssignRole: %w", model.ErrNotFound)).Once()
+	handler := NewRoleHandler(srv, vld.New())
+
+	path := "/admin/user/" + id.String() + "/role"
+	rec := servePerson(http.MethodPut, path, "/admin/user/:id/role", `{"role":"editor"}`, nil, handler.AssignRole)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPut, path, "/admin/user/:id/role", `{"role":"missing"}`, nil, handler.AssignRole)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = servePerson(http.MethodPut, path, "/admin/user/:id/role", `{}`, nil, handler.AssignRole)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = servePerson(http.MethodPut, "/admin/user/1/role", "/admin/user/:id/role", `{"role":"editor"}`, nil, handler.AssignRole)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
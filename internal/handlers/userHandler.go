@@ -3,12 +3,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
 	"github.com/eugenshima/myapp/internal/model"
 
 	vl "github.com/go-playground/validator"
@@ -33,22 +38,29 @@ func NewUserHandler(srv UserService, validator *vl.Validate) *UserHandler {
 
 // UserService interface implementation
 type UserService interface {
-	GenerateTokens(ctx context.Context, login, password string) (string, string, error)
-	Signup(ctx context.Context, entity *model.User) error
+	GenerateTokens(ctx context.Context, login, password, ip string) (string, string, error)
+	Signup(ctx context.Context, entity *model.User, invitation string) error
 	RefreshTokenPair(ctx context.Context, accessToken string, refreshToken string, id uuid.UUID) (string, string, error)
 	GetAll(ctx context.Context) ([]*model.User, error)
 	Delete(ctx context.Context, id uuid.UUID) error
+	Logout(ctx context.Context, accessToken string) error
+	RevokeSessions(ctx context.Context, id uuid.UUID) error
+	Unlock(ctx context.Context, login, ip string) error
+	LoginMFA(ctx context.Context, challenge, code, ip string) (*model.MFATokens, error)
+	EnrollMFA(ctx context.Context, challenge string) (*model.MFAEnrollment, error)
 }
 
 // Login receives a GET request from client and returns a user(if exists)
 // @Summary Login user
 // @tags authentication methods
-// @Description Logs in a user and returns access and refresh tokens
+// @Description Logs in a user and returns access and refresh tokens. A user with a second factor, or whose role
+// @Description requires one, gets an MFA challenge token instead, which /api/user/login/mfa exchanges for the tokens
 // @Accept json
 // @Produce json
 // @Param input body model.Login true "Login details"
-// @Success 200 {object} map[string]interface{} " Generating access and refresh tokens"
-// @Failure 404 {string} string "Error message"
+// @Success 200 {object} map[string]interface{} " Generating access and refresh tokens, or the MFA challenge"
+// @Failure 401 {string} string "Invalid login or password"
+// @Failure 429 {string} string "Too many failed logins"
 // @Router /api/user/login [post]
 func (handler *UserHandler) Login(c echo.Context) error {
 	input := model.Login{}
@@ -63,7 +75,22 @@ func (handler *UserHandler) Login(c echo.Context) error {
 		logrus.WithFields(logrus.Fields{"input": input}).Errorf("Validate: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Validate: %v", err))
 	}
-	accessToken, refreshToken, err := handler.srv.GenerateTokens(c.Request().Context(), input.Login, input.Password)
+	accessToken, refreshToken, err := handler.srv.GenerateTokens(c.Request().Context(), input.Login, input.Password, c.RealIP())
+	var required *model.MFARequiredError
+	if errors.As(err, &required) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mfa_required": true,
+			"challenge":    required.Challenge,
+			"enroll":       required.Enroll,
+		})
+	}
+	if throttledErr := loginThrottled(c, err, input.Login); throttledErr != nil {
+		return throttledErr
+	}
+	if errors.Is(err, model.ErrInvalidCredentials) {
+		logrus.WithFields(logrus.Fields{"login": input.Login, "ip": c.RealIP()}).Warnf("GenerateTokens: %v", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid login or password")
+	}
 	if err != nil {
 		logrus.Errorf("GenerateTokens %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GenerateTokens: %v", err))
@@ -75,15 +102,101 @@ func (handler *UserHandler) Login(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// LoginMFA receives a POST request from client and exchanges an MFA challenge for tokens
+// @Summary Login with the second factor
+// @tags authentication methods
+// @Description Passes the second factor of a login with a TOTP code or a recovery code and returns access and refresh tokens.
+// @Description A login enrolling a second factor confirms it and returns its recovery codes along with the tokens
+// @Accept json
+// @Produce json
+// @Param input body model.MFALogin true "Challenge and code"
+// @Success 200 {object} model.MFATokens "Generating access and refresh tokens"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Invalid challenge or code"
+// @Failure 429 {string} string "Too many failed logins"
+// @Router /api/user/login/mfa [post]
+func (handler *UserHandler) LoginMFA(c echo.Context) error {
+	input := model.MFALogin{}
+	err := c.Bind(&input)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.validator.Struct(input)
+	if err != nil {
+		logrus.Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	tokens, err := handler.srv.LoginMFA(c.Request().Context(), input.Challenge, input.Code, c.RealIP())
+	if throttledErr := loginThrottled(c, err, ""); throttledErr != nil {
+		return throttledErr
+	}
+	if errors.Is(err, model.ErrInvalidCredentials) || errors.Is(err, model.ErrInvalidMFACode) {
+		logrus.WithFields(logrus.Fields{"ip": c.RealIP()}).Warnf("LoginMFA: %v", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid challenge or code")
+	}
+	if err != nil {
+		return mfaError(err, "LoginMFA", logrus.Fields{"ip": c.RealIP()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// EnrollMFA receives a POST request from client and enrolls a second factor during a login
+// @Summary Enroll a second factor during a login
+// @tags authentication methods
+// @Description Creates the TOTP secret of a user whose role requires a second factor, the challenge of the login stands for the user
+// @Accept json
+// @Produce json
+// @Param input body model.MFAChallenge true "Challenge"
+// @Success 200 {object} model.MFAEnrollment "TOTP secret"
+// @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Invalid challenge"
+// @Failure 409 {string} string "Second factor already enabled"
+// @Router /api/user/login/mfa/enroll [post]
+func (handler *UserHandler) EnrollMFA(c echo.Context) error {
+	input := model.MFAChallenge{}
+	err := c.Bind(&input)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.validator.Struct(input)
+	if err != nil {
+		logrus.Errorf("Validate: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Validate: %v", err))
+	}
+	enrollment, err := handler.srv.EnrollMFA(c.Request().Context(), input.Challenge)
+	if errors.Is(err, model.ErrInvalidCredentials) {
+		logrus.WithFields(logrus.Fields{"ip": c.RealIP()}).Warnf("EnrollMFA: %v", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid challenge")
+	}
+	if err != nil {
+		return mfaError(err, "EnrollMFA", logrus.Fields{"ip": c.RealIP()})
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// loginThrottled returns a 429 error with a Retry-After header if err is a *model.LoginThrottledError
+func loginThrottled(c echo.Context, err error, login string) error {
+	var throttled *model.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{"login": login, "ip": c.RealIP()}).Warnf("login throttled: %v", err)
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "Too many failed logins, try again later")
+}
+
 // Signup receives a POST request from client to sign up a user
 // @Summary Sign up user
 // @tags authentication methods
-// @Description Registers a new user
+// @Description Registers a new basic user, an invitation code issued by an admin grants its role instead
 // @Accept json
 // @Produce json
 // @Param reqBody body model.Signup true "Signup details"
 // @Success 200 {string} string "User created"
-// @Failure 400 {string} string "Error message"
+// @Failure 400 {string} string "Weak password"
+// @Failure 403 {string} string "Invalid invitation"
 // @Failure 500 {string} string "Internal server error"
 // @Router /api/user/signup [post]
 func (handler *UserHandler) Signup(c echo.Context) error {
@@ -97,7 +210,6 @@ func (handler *UserHandler) Signup(c echo.Context) error {
 		ID:       uuid.New(),
 		Login:    reqBody.Login,
 		Password: []byte(reqBody.Password),
-		Role:     reqBody.Role,
 	}
 
 	err = c.Validate(person)
@@ -105,7 +217,15 @@ func (handler *UserHandler) Signup(c echo.Context) error {
 		logrus.Errorf("Validate: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Validate: %v", err))
 	}
-	err = handler.srv.Signup(c.Request().Context(), person)
+	err = handler.srv.Signup(c.Request().Context(), person, reqBody.Invitation)
+	if errors.Is(err, model.ErrWeakPassword) {
+		logrus.WithFields(logrus.Fields{"login": person.Login}).Warnf("Signup: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Signup: %v", err))
+	}
+	if errors.Is(err, model.ErrInvalidInvitation) {
+		logrus.WithFields(logrus.Fields{"login": person.Login}).Warnf("Signup: %v", err)
+		return echo.NewHTTPError(http.StatusForbidden, "Invalid invitation")
+	}
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"person": person}).Errorf("Signup: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Signup: %v", err))
@@ -134,6 +254,7 @@ func (handler *UserHandler) GetAll(c echo.Context) error {
 // @Param reqBody body model.Tokens true "Token pair details"
 // @Success 200 {object} map[string]interface{} "Refreshed token pair"
 // @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Refresh token revoked"
 // @Failure 404 {string} string "Error message"
 // @Router /api/user/refresh/{id} [post]
 func (handler *UserHandler) RefreshTokenPair(c echo.Context) error {
@@ -152,6 +273,9 @@ func (handler *UserHandler) RefreshTokenPair(c echo.Context) error {
 	accessToken, refreshToken, err := handler.srv.RefreshTokenPair(c.Request().Context(), reqBody.AccessToken, reqBody.RefreshToken, id)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"reqBody.AccessToken": reqBody.AccessToken, "reqBody.RefreshToken": reqBody.RefreshToken, "id": id}).Errorf("RefreshTokenPair: %v", err)
+		if errors.Is(err, model.ErrTokenReuse) || errors.Is(err, model.ErrTokenRevoked) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "refresh token revoked, log in again")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("RefreshTokenPair: %v", err))
 	}
 	response := map[string]string{
@@ -161,6 +285,80 @@ func (handler *UserHandler) RefreshTokenPair(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// Logout receives a POST request from client and revokes its access token along with its session
+// @Summary Logout user
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Revokes the access token of the request and the refresh tokens of its session
+// @Produce plain
+// @Success 200 {string} string "OK"
+// @Failure 401 {string} string "Invalid token"
+// @Router /api/user/logout [post]
+func (handler *UserHandler) Logout(c echo.Context) error {
+	accessToken := strings.TrimPrefix(c.Request().Header.Get("Authorization"), mdlwr.Bearer+" ")
+	err := handler.srv.Logout(c.Request().Context(), accessToken)
+	if err != nil {
+		logrus.Errorf("Logout: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Logout: %v", err))
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// RevokeSessions receives a DELETE request from an admin and revokes every session of a user
+// @Summary Revoke all sessions of a user
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Revokes every access token issued to the user so far and every refresh token of the user
+// @Produce plain
+// @Param id path string true "ID of the user"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Router /api/admin/user/{id}/sessions [delete]
+func (handler *UserHandler) RevokeSessions(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	err = handler.srv.RevokeSessions(c.Request().Context(), id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("RevokeSessions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("RevokeSessions: %v", err))
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// Unlock receives a POST request from an admin and forgets the failed logins of a login and/or an IP
+// @Summary Unlock logins
+// @Security ApiKeyAuth
+// @tags authentication methods
+// @Description Lifts the login backoff and lockout of a login and/or a client IP
+// @Accept json
+// @Produce plain
+// @Param reqBody body model.Unlock true "Login and/or IP to unlock"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Bad request"
+// @Router /api/admin/unlock [post]
+func (handler *UserHandler) Unlock(c echo.Context) error {
+	reqBody := model.Unlock{}
+	err := c.Bind(&reqBody)
+	if err != nil {
+		logrus.Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Bind: %v", err))
+	}
+	err = handler.validator.Struct(reqBody)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqBody": reqBody}).Errorf("Struct: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Struct: %v", err))
+	}
+	err = handler.srv.Unlock(c.Request().Context(), reqBody.Login, reqBody.IP)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqBody": reqBody}).Errorf("Unlock: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Unlock: %v", err))
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
 // Delete func receives a path variable abd return deleted id (if exists)
 func (handler *UserHandler) Delete(c echo.Context) error {
 	id, err := uuid.Parse(c.Param("id"))
@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	mocks "github.com/eugenshima/myapp/internal/handlers/mocks"
 	"github.com/eugenshima/myapp/internal/model"
+	vld "github.com/go-playground/validator"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -35,15 +40,15 @@ func TestUserhandlerGetAll(t *testing.T) {
 }
 
 func TestUserHandlerSignUp(t *testing.T) {
-	mockUserService.On("Signup", mock.Anything, mock.AnythingOfType("*model.User")).Return(nil).Once()
+	mockUserService.On("Signup", mock.Anything, mock.AnythingOfType("*model.User"), "").Return(nil).Once()
 
-	err := mockUserService.Signup(context.Background(), &mockUserEntity)
+	err := mockUserService.Signup(context.Background(), &mockUserEntity, "")
 	require.NoError(t, err)
 }
 
 func TestUserhandlerLogin(t *testing.T) {
-	mockUserService.On("GenerateTokens", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(str, str, nil).Once()
-	access, refresh, err := mockUserService.GenerateTokens(context.Background(), mockUserEntity.Login, string(mockUserEntity.Password))
+	mockUserService.On("GenerateTokens", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(str, str, nil).Once()
+	access, refresh, err := mockUserService.GenerateTokens(context.Background(), mockUserEntity.Login, string(mockUserEntity.Password), "")
 	require.NoError(t, err)
 	require.IsType(t, "string", access)
 	require.IsType(t, "string", refresh)
@@ -62,3 +67,132 @@ func TestUserHandlerDelete(t *testing.T) {
 	err := mockUserService.Delete(context.Background(), mockUserEntity.ID)
 	require.NoError(t, err)
 }
+
+func TestUserHandlerLogout(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("Logout", mock.Anything, "token").Return(nil).Once()
+	handler := NewUserHandler(srv, nil)
+
+	rec := servePerson(http.MethodPost, "/user/logout", "/user/logout", "", http.Header{"Authorization": {"Bearer token"}}, handler.Logout)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUserHandlerRevokeSessions(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("RevokeSessions", mock.Anything, mockUserEntity.ID).Return(nil).Once()
+	handler := NewUserHandler(srv, nil)
+
+	rec := servePerson(http.MethodDelete, "/admin/user/"+mockUserEntity.ID.String()+"/sessions", "/admin/user/:id/sessions", "", nil, handler.RevokeSessions)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodDelete, "/admin/user/1/sessions", "/admin/user/:id/sessions", "", nil, handler.RevokeSessions)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUserHandlerSignupInvitation(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	basic := mock.MatchedBy(func(user *model.User) bool { return user.Login == "eugen" && user.Role == "" })
+	srv.On("Signup", mock.Anything, basic, "").Return(nil).Once()
+	srv.On("Signup", mock.Anything, basic, "stolen").Return(fmt.Errorf("RedeemInvitation: %w", model.ErrInvalidInvitation)).Once()
+	handler := NewUserHandler(srv, nil)
+
+	// a role picked by the client is ignored
+	rec := servePerson(http.MethodPost, "/user/signup", "/user/signup", `{"login":"eugen","password":"password","role":"admin"}`, nil, handler.Signup)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/signup", "/user/signup", `{"login":"eugen","password":"password","invitation":"stolen"}`, nil, handler.Signup)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestUserHandlerLoginThrottled(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("GenerateTokens", mock.Anything, "eugen", "wrong", "192.0.2.1").
+		Return("", "", fmt.Errorf("CompareHashAndPassword: %w", model.ErrInvalidCredentials)).Once()
+	srv.On("GenerateTokens", mock.Anything, "eugen", "guess", "192.0.2.1").
+		Return("", "", fmt.Errorf("Check: %w", &model.LoginThrottledError{Scope: model.LoginScopeLogin, RetryAfter: 1500 * time.Millisecond})).Once()
+	handler := NewUserHandler(srv, nil)
+
+	rec := servePerson(http.MethodPost, "/user/login", "/user/login", `{"login":"eugen","password":"wrong"}`, nil, handler.Login)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/login", "/user/login", `{"login":"eugen","password":"guess"}`, nil, handler.Login)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+func TestUserHandlerSignupWeakPassword(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("Signup", mock.Anything, mock.AnythingOfType("*model.User"), "").
+		Return(fmt.Errorf("Check: %w: shorter than 10 characters", model.ErrWeakPassword)).Once()
+	handler := NewUserHandler(srv, nil)
+
+	rec := servePerson(http.MethodPost, "/user/signup", "/user/signup", `{"login":"eugen","password":"short"}`, nil, handler.Signup)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "shorter than 10 characters")
+}
+
+func TestUserHandlerUnlock(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("Unlock", mock.Anything, "eugen", "").Return(nil).Once()
+	srv.On("Unlock", mock.Anything, "", "192.0.2.1").Return(nil).Once()
+	handler := NewUserHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/admin/unlock", "/admin/unlock", `{"login":"eugen"}`, nil, handler.Unlock)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/unlock", "/admin/unlock", `{"ip":"192.0.2.1"}`, nil, handler.Unlock)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/unlock", "/admin/unlock", `{}`, nil, handler.Unlock)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = servePerson(http.MethodPost, "/admin/unlock", "/admin/unlock", `{"ip":"nope"}`, nil, handler.Unlock)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUserHandlerLoginMFA(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("GenerateTokens", mock.Anything, "eugen", "password", "192.0.2.1").
+		Return("", "", fmt.Errorf("challenge: %w", &model.MFARequiredError{Challenge: "challenge", Enroll: true})).Once()
+	srv.On("LoginMFA", mock.Anything, "challenge", "123456", "192.0.2.1").
+		Return(&model.MFATokens{AccessToken: "access", RefreshToken: "refresh", RecoveryCodes: []string{"abcd-efgh"}}, nil).Once()
+	srv.On("LoginMFA", mock.Anything, "challenge", "000000", "192.0.2.1").
+		Return(nil, fmt.Errorf("second factor: %w", model.ErrInvalidMFACode)).Once()
+	srv.On("LoginMFA", mock.Anything, "challenge", "654321", "192.0.2.1").
+		Return(nil, fmt.Errorf("Check: %w", &model.LoginThrottledError{Scope: model.LoginScopeIP, RetryAfter: time.Second})).Once()
+	handler := NewUserHandler(srv, vld.New())
+
+	// Step 1: the password returns the challenge
+	rec := servePerson(http.MethodPost, "/user/login", "/user/login", `{"login":"eugen","password":"password"}`, nil, handler.Login)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var required map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &required))
+	require.Equal(t, map[string]interface{}{"mfa_required": true, "challenge": "challenge", "enroll": true}, required)
+
+	// Step 2: the challenge and a code return the tokens
+	rec = servePerson(http.MethodPost, "/user/login/mfa", "/user/login/mfa", `{"challenge":"challenge","code":"123456"}`, nil, handler.LoginMFA)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var tokens model.MFATokens
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	require.Equal(t, "access", tokens.AccessToken)
+	require.Equal(t, []string{"abcd-efgh"}, tokens.RecoveryCodes)
+
+	// Step 3: wrong codes are unauthorized and throttled
+	rec = servePerson(http.MethodPost, "/user/login/mfa", "/user/login/mfa", `{"challenge":"challenge","code":"000000"}`, nil, handler.LoginMFA)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/login/mfa", "/user/login/mfa", `{"challenge":"challenge","code":"654321"}`, nil, handler.LoginMFA)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	rec = servePerson(http.MethodPost, "/user/login/mfa", "/user/login/mfa", `{"challenge":"challenge"}`, nil, handler.LoginMFA)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUserHandlerEnrollMFA(t *testing.T) {
+	srv := mocks.NewUserService(t)
+	srv.On("EnrollMFA", mock.Anything, "challenge").Return(&model.MFAEnrollment{Secret: "SECRET", URI: "otpauth://totp/myapp:eugen"}, nil).Once()
+	srv.On("EnrollMFA", mock.Anything, "token").Return(nil, fmt.Errorf("parseChallenge: %w", model.ErrInvalidCredentials)).Once()
+	srv.On("EnrollMFA", mock.Anything, "enabled").Return(nil, fmt.Errorf("EnrollMFA: %w", model.ErrMFAEnabled)).Once()
+	handler := NewUserHandler(srv, vld.New())
+
+	rec := servePerson(http.MethodPost, "/user/login/mfa/enroll", "/user/login/mfa/enroll", `{"challenge":"challenge"}`, nil, handler.EnrollMFA)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "SECRET")
+	rec = servePerson(http.MethodPost, "/user/login/mfa/enroll", "/user/login/mfa/enroll", `{"challenge":"token"}`, nil, handler.EnrollMFA)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = servePerson(http.MethodPost, "/user/login/mfa/enroll", "/user/login/mfa/enroll", `{"challenge":"enabled"}`, nil, handler.EnrollMFA)
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
@@ -0,0 +1,134 @@
+// Package mail sends the mails of the service through SMTP, or writes them to a file or the log where no mail server is available
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SMTPMailer sends mails through an SMTP server, the connection is upgraded with STARTTLS when the server supports it
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer is a constructor for SMTPMailer, addr is the host:port of the server.
+// Without a username mails are sent unauthenticated
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send sends the mail, the server is not contacted once the context is done
+func (m *SMTPMailer) Send(ctx context.Context, msg *model.Mail) error {
+	body, err := message(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("Send: %w", err)
+	}
+	err = smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, body)
+	if err != nil {
+		return fmt.Errorf("SendMail(): %w", err)
+	}
+	return nil
+}
+
+// FileMailer appends mails to a file instead of sending them, for development and tests without a mail server
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+// NewFileMailer is a constructor for FileMailer, the file is created on the first mail
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+// Send appends the mail to the file
+func (m *FileMailer) Send(_ context.Context, msg *model.Mail) error {
+	body, err := message(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("OpenFile(): %w", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(body, "\r\n"...))
+	if err != nil {
+		return fmt.Errorf("Write(): %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes mails to the log instead of sending them. Mails may contain secrets
+// such as password reset tokens, so it is only meant for development
+type LogMailer struct{}
+
+// NewLogMailer is a constructor for LogMailer
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send logs the mail
+func (m *LogMailer) Send(_ context.Context, msg *model.Mail) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{"to": msg.To, "subject": msg.Subject}).Info(msg.Body)
+	return nil
+}
+
+// message returns the mail in the internet message format (RFC 5322)
+func message(from string, msg *model.Mail, at time.Time) ([]byte, error) {
+	if err := validate(msg); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+// validate rejects recipients which are no plain mail address and subjects which would inject headers
+func validate(msg *model.Mail) error {
+	addr, err := mail.ParseAddress(msg.To)
+	if err != nil || addr.Address != msg.To {
+		return fmt.Errorf("validate: invalid recipient %q", msg.To)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("validate: invalid subject %q", msg.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testMail = model.Mail{To: "eugen@example.com", Subject: "Reset your password", Body: "line one\nline two"}
+
+func TestMessage(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	body, err := message("noreply@example.com", &testMail, at)
+	require.NoError(t, err)
+	require.Equal(t, "From: noreply@example.com\r\n"+
+		"To: eugen@example.com\r\n"+
+		"Subject: Reset your password\r\n"+
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: 8bit\r\n\r\n"+
+		"line one\r\nline two\r\n", string(body))
+
+	for _, msg := range []model.Mail{
+		{To: "eugen", Subject: "s"},
+		{To: "Eugen <eugen@example.com>", Subject: "s"},
+		{To: "eugen@example.com\r\nBcc: other@example.com", Subject: "s"},
+		{To: "eugen@example.com", Subject: "s\r\nBcc: other@example.com"},
+	} {
+		msg := msg
+		_, err = message("noreply@example.com", &msg, at)
+		require.Error(t, err, msg.To)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer := NewFileMailer(path, "noreply@example.com")
+	require.NoError(t, mailer.Send(context.Background(), &testMail))
+	require.NoError(t, mailer.Send(context.Background(), &testMail))
+	require.Error(t, mailer.Send(context.Background(), &model.Mail{To: "eugen"}))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(content), "To: eugen@example.com\r\n"))
+	require.Equal(t, 2, strings.Count(string(content), "line one\r\nline two\r\n"))
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go serveSMTP(listener, received)
+
+	mailer := NewSMTPMailer(listener.Addr().String(), "noreply@example.com", "", "")
+	require.NoError(t, mailer.Send(context.Background(), &testMail))
+	lines := <-received
+	require.Contains(t, lines, "MAIL FROM:<noreply@example.com>")
+	require.Contains(t, lines, "RCPT TO:<eugen@example.com>")
+	require.Contains(t, lines, "Subject: Reset your password")
+	require.Contains(t, lines, "line two")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, mailer.Send(ctx, &testMail), context.Canceled)
+}
+
+// serveSMTP accepts one SMTP session and reports the lines the client sent
+func serveSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+	var lines []string
+	reply("220 localhost ESMTP")
+	data := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			received <- lines
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		switch {
+		case data && line == ".":
+			data = false
+			reply("250 OK")
+		case data:
+		case strings.HasPrefix(line, "EHLO"):
+			reply("250 localhost")
+		case line == "DATA":
+			data = true
+			reply("354 go ahead")
+		case line == "QUIT":
+			reply("221 bye")
+			received <- lines
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
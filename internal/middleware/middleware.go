@@ -2,6 +2,7 @@
 package middleware
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,10 +10,9 @@ import (
 	"strings"
 	"time"
 
-	"github.com/eugenshima/myapp/internal/config"
+	"github.com/eugenshima/myapp/internal/model"
 	"github.com/google/uuid"
 
-	"github.com/caarlos0/env/v9"
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 )
@@ -26,13 +26,84 @@ type tokenClaims struct {
 // const for middlware
 const (
 	Bearer = "Bearer"
-	Admin  = "admin"
+	// APIKeyHeader carries the API key of requests authenticated with one instead of an access token
+	APIKeyHeader = "X-API-Key"
+	// MFAChallengeAudience is the audience of MFA challenge tokens, which stand for a login
+	// until its second factor is passed and are never accepted as access tokens
+	MFAChallengeAudience = "mfa"
+	// RefreshAudience is the audience of refresh tokens, which are only exchanged for a new token pair
+	// and are never accepted as access tokens
+	RefreshAudience = "refresh"
 )
 
-// UserIdentity makes an authorization through access token
-func UserIdentity() echo.MiddlewareFunc {
+// Denylist reports access tokens revoked before they expired, by logout or by revoking every session of their user
+type Denylist interface {
+	IsRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) bool
+}
+
+// APIKeyAuthenticator returns the actor an API key acts for, unknown, expired or revoked keys are an error
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*model.Actor, error)
+}
+
+// PermissionChecker reports whether a role grants a permission
+type PermissionChecker interface {
+	HasPermission(ctx context.Context, role, permission string) bool
+}
+
+// actorKey is the context key of the request actor
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the authenticated actor
+func WithActor(ctx context.Context, actor model.Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by UserIdentity
+func ActorFromContext(ctx context.Context) (model.Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(model.Actor)
+	return actor, ok
+}
+
+// setActor stores the actor in the request context
+func setActor(c echo.Context, actor model.Actor) {
+	ctx := WithActor(c.Request().Context(), actor)
+	c.SetRequest(c.Request().WithContext(ctx))
+}
+
+// isRevoked reports whether the access token with the given claims is on the denylist
+func isRevoked(c echo.Context, denylist Denylist, token *jwt.Token, id uuid.UUID) bool {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return true
+	}
+	tokenID, _ := claims["jti"].(string)
+	return denylist.IsRevoked(c.Request().Context(), tokenID, id, issuedAt(claims))
+}
+
+// issuedAt returns the issue time of a token in milliseconds, tokens without the iat_ms claim
+// are taken as issued at the start of their iat second
+func issuedAt(claims jwt.MapClaims) time.Time {
+	if ms, ok := claims["iat_ms"].(float64); ok {
+		return time.UnixMilli(int64(ms))
+	}
+	iat, _ := claims["iat"].(float64)
+	return time.Unix(int64(iat), 0)
+}
+
+// UserIdentity makes an authorization through access token which is not on the denylist,
+// keyfunc returns the key verifying the token. Requests with an API key instead are authorized through apiKeys
+func UserIdentity(keyfunc jwt.Keyfunc, denylist Denylist, apiKeys APIKeyAuthenticator) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if key := c.Request().Header.Get(APIKeyHeader); key != "" {
+				actor, err := apiKeys.Authenticate(c.Request().Context(), key)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Invalid API key")
+				}
+				setActor(c, *actor)
+				return next(c)
+			}
 			// Chtcking for auth header
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" {
@@ -43,14 +114,8 @@ func UserIdentity() echo.MiddlewareFunc {
 			if len(headerParts) != 2 || headerParts[0] != Bearer {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header format")
 			}
-			// getting environment variable
-			cfg := config.Config{}
-			err := env.Parse(&cfg)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid env variable")
-			}
 			// checking for valid access token
-			token, err := ValidateToken(headerParts[1], cfg.SigningKey)
+			token, err := ValidateToken(headerParts[1], keyfunc)
 			if err != nil || !token.Valid {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 			}
@@ -60,51 +125,39 @@ func UserIdentity() echo.MiddlewareFunc {
 				if exp < float64(time.Now().Unix()) {
 					return echo.NewHTTPError(http.StatusUnauthorized, "Token is expired")
 				}
+				if claims.VerifyAudience(MFAChallengeAudience, true) {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token is an MFA challenge")
+				}
+				if claims.VerifyAudience(RefreshAudience, true) {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Token is a refresh token")
+				}
 			}
-
+			id, role, err := GetPayloadFromToken(headerParts[1])
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token payload")
+			}
+			if isRevoked(c, denylist, token, id) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token is revoked")
+			}
+			setActor(c, model.Actor{ID: id, Role: role})
 			return next(c)
 		}
 	}
 }
 
-// AdminIdentity makes an authorization through access token for admin only
-func AdminIdentity() echo.MiddlewareFunc {
+// RequirePermission lets requests through only when the role of the actor grants every given permission,
+// and for an actor authenticated with an API key only when the scopes of the key include it.
+// It runs after UserIdentity, which authenticates the actor
+func RequirePermission(checker PermissionChecker, permissions ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			// Chtcking for auth header
-			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader == "" {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Missing authorization header")
-			}
-			// checking for auth header format
-			headerParts := strings.Split(authHeader, " ")
-			if len(headerParts) != 2 || headerParts[0] != Bearer {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header format")
-			}
-			// getting environment variable
-			cfg := config.Config{}
-			err := env.Parse(&cfg)
-			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid env variable")
-			}
-			// checking for valid access token
-			token, err := ValidateToken(headerParts[1], cfg.SigningKey)
-			if err != nil || !token.Valid {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			actor, ok := ActorFromContext(c.Request().Context())
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Missing actor")
 			}
-			id, role, err := GetPayloadFromToken(headerParts[1])
-			fmt.Println("middleware id working --> ", id)
-			if err != nil {
-				return err
-			}
-			if role != Admin {
-				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid role")
-			}
-			// checking for token expiration
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				exp := claims["exp"].(float64)
-				if exp < float64(time.Now().Unix()) {
-					return echo.NewHTTPError(http.StatusUnauthorized, "Token is expired")
+			for _, permission := range permissions {
+				if !checker.HasPermission(c.Request().Context(), actor.Role, permission) || !inScope(actor, permission) {
+					return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Missing permission %s", permission))
 				}
 			}
 			return next(c)
@@ -112,37 +165,40 @@ func AdminIdentity() echo.MiddlewareFunc {
 	}
 }
 
-// RoleValidation is used to validate the role
-func RoleValidation(tokenString string) (bool, error) {
-	parts := strings.Split(tokenString, ".")
-	payload := parts[1]
-
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(payload)
-	if err != nil {
-		return false, fmt.Errorf("DecodeString: %w", err)
+// RequireSession lets requests through only for actors authenticated with an access token, it guards the routes
+// a user reaches without a permission, which API keys are not meant for. It runs after UserIdentity
+func RequireSession() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			actor, ok := ActorFromContext(c.Request().Context())
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Missing actor")
+			}
+			if actor.APIKeyID != nil {
+				return echo.NewHTTPError(http.StatusForbidden, "Not allowed with an API key")
+			}
+			return next(c)
+		}
 	}
+}
 
-	var claims tokenClaims
-	err = json.Unmarshal(payloadBytes, &claims)
-	if err != nil {
-		return false, fmt.Errorf("Unmarshal(): %w", err)
+// inScope reports whether the permission is in the scopes of an actor authenticated with an API key,
+// sessions are not limited by scopes
+func inScope(actor model.Actor, permission string) bool {
+	if actor.APIKeyID == nil {
+		return true
 	}
-
-	role := claims.Role
-	if role != Admin {
-		return false, fmt.Errorf("invalid role: %w", err)
+	for _, scope := range actor.Scopes {
+		if scope == permission {
+			return true
+		}
 	}
-	return true, nil
+	return false
 }
 
-// ValidateToken parses tokenString and returns valid jwt token string
-func ValidateToken(tokenString, signingKey string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Parse(): %v", token.Header["alg"])
-		}
-		return []byte(signingKey), nil
-	})
+// ValidateToken parses tokenString and returns valid jwt token string, keyfunc picks the key verifying it from the key set
+func ValidateToken(tokenString string, keyfunc jwt.Keyfunc) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, keyfunc)
 	if err != nil {
 		return nil, fmt.Errorf("Parse(): %w", err)
 	}
@@ -169,7 +225,7 @@ func GetPayloadFromToken(token string) (uuid.UUID, string, error) {
 
 	// Получение значения ролей
 	role := claims.Role
-	id, err := uuid.Parse(claims.Id)
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return uuid.Nil, "", fmt.Errorf("Parse(): %w", err)
 	}
@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -8,7 +11,7 @@ import (
 	"testing"
 	"time"
 
-	cfgration "github.com/eugenshima/myapp/internal/config"
+	"github.com/eugenshima/myapp/internal/model"
 	"github.com/stretchr/testify/require"
 	"gotest.tools/assert"
 
@@ -17,33 +20,38 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func setupMiddlware() (e *echo.Echo, cfg *cfgration.Config, tokenString string, invalidTokenString string, err error) {
+func setupMiddlware() (e *echo.Echo, signingKey *rsa.PrivateKey, tokenString string, invalidTokenString string, err error) {
 	e = echo.New()
-	cfg, err = cfgration.NewConfig()
+	signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		return nil, nil, "", "", fmt.Errorf("Error extracting env variables: %w", err)
+		return nil, nil, "", "", fmt.Errorf("Error generating signing key: %w", err)
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, "", "", fmt.Errorf("Error generating signing key: %w", err)
+	}
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
 		Role: "admin",
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(50 * time.Millisecond).Unix(),
 			IssuedAt:  time.Now().Unix(),
 			Id:        uuid.New().String(),
+			Subject:   uuid.New().String(),
 		},
 	})
-	tokenString, err = accessToken.SignedString([]byte(cfg.SigningKey))
+	tokenString, err = accessToken.SignedString(signingKey)
 	if err != nil {
 		return nil, nil, "", "", fmt.Errorf("Error creating token string: %w", err)
 	}
-	invalidTokenString, err = accessToken.SignedString([]byte("invalidSigningKey"))
+	invalidTokenString, err = accessToken.SignedString(otherKey)
 	if err != nil {
 		return nil, nil, "", "", fmt.Errorf("Error creating token string: %w", err)
 	}
-	return e, cfg, tokenString, invalidTokenString, nil
+	return e, signingKey, tokenString, invalidTokenString, nil
 }
 
 func TestMain(m *testing.M) {
-	e, cfg, tokenString, invalidTokenString, err = setupMiddlware()
+	e, signingKey, tokenString, invalidTokenString, err = setupMiddlware()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -52,16 +60,44 @@ func TestMain(m *testing.M) {
 	os.Exit(exitVal)
 }
 
+// keyfunc verifies the tokens signed with signingKey
+func keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return &signingKey.PublicKey, nil
+}
+
+// testDenylist is a denylist of token IDs
+type testDenylist map[string]bool
+
+func (d testDenylist) IsRevoked(_ context.Context, tokenID string, _ uuid.UUID, _ time.Time) bool {
+	return d[tokenID]
+}
+
+// testAPIKeys maps API keys to the actors they act for
+type testAPIKeys map[string]model.Actor
+
+func (k testAPIKeys) Authenticate(_ context.Context, key string) (*model.Actor, error) {
+	actor, ok := k[key]
+	if !ok {
+		return nil, model.ErrInvalidAPIKey
+	}
+	return &actor, nil
+}
+
 var (
+	apiKeys            = testAPIKeys{}
+	denylist           = testDenylist{}
 	invalidTokenString string
 	err                error
 	tokenString        string
-	cfg                *cfgration.Config
+	signingKey         *rsa.PrivateKey
 	e                  *echo.Echo
 )
 
 func TestUserIdentity(t *testing.T) {
-	e.Use(UserIdentity())
+	e.Use(UserIdentity(keyfunc, denylist, apiKeys))
 
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
@@ -75,50 +111,61 @@ func TestUserIdentity(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
 
-func TestAdminIdentity(t *testing.T) {
-	e.Use(AdminIdentity())
+// testPermissions maps roles to the permissions they grant
+type testPermissions map[string][]string
 
-	e.GET("/", func(c echo.Context) error {
-		return c.String(http.StatusOK, "OK")
-	})
-
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("Authorization", "Bearer "+tokenString)
-	rec := httptest.NewRecorder()
-	e.ServeHTTP(rec, req)
-	require.Equal(t, http.StatusOK, rec.Code)
-}
-
-func TestRoleValidation(t *testing.T) {
-	validRole, err := RoleValidation(tokenString)
-	require.True(t, validRole)
-	require.NoError(t, err)
+func (p testPermissions) HasPermission(_ context.Context, role, permission string) bool {
+	for _, granted := range p[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
 }
 
-func TestInvalidRoleValidation(t *testing.T) {
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
-		Role: "invalid",
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(50 * time.Millisecond).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Id:        uuid.New().String(),
-		},
-	})
-	invalidRoleToken, err := accessToken.SignedString([]byte(cfg.SigningKey))
-	require.NoError(t, err)
-	invalidRole, err := RoleValidation(invalidRoleToken)
-	require.False(t, invalidRole)
-	require.Error(t, err)
+func TestRequirePermission(t *testing.T) {
+	checker := testPermissions{model.RoleAdmin: {"person:read", "person:write"}, model.RoleUser: {"person:read"}}
+	echoPermission := echo.New()
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	}
+	echoPermission.GET("/read", handler, UserIdentity(keyfunc, denylist, apiKeys), RequirePermission(checker, "person:read"))
+	echoPermission.GET("/write", handler, UserIdentity(keyfunc, denylist, apiKeys), RequirePermission(checker, "person:read", "person:write"))
+	echoPermission.GET("/anonymous", handler, RequirePermission(checker, "person:read"))
+	for role, codes := range map[string]map[string]int{
+		model.RoleAdmin: {"/read": http.StatusOK, "/write": http.StatusOK, "/anonymous": http.StatusUnauthorized},
+		model.RoleUser:  {"/read": http.StatusOK, "/write": http.StatusForbidden},
+		"unknown":       {"/read": http.StatusForbidden, "/write": http.StatusForbidden},
+	} {
+		accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+			Role: role,
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+				IssuedAt:  time.Now().Unix(),
+				Id:        uuid.New().String(),
+				Subject:   uuid.New().String(),
+			},
+		})
+		roleToken, err := accessToken.SignedString(signingKey)
+		require.NoError(t, err)
+		for path, code := range codes {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Authorization", "Bearer "+roleToken)
+			rec := httptest.NewRecorder()
+			echoPermission.ServeHTTP(rec, req)
+			require.Equal(t, code, rec.Code, role+" "+path)
+		}
+	}
 }
 
 func TestValidateToken(t *testing.T) {
-	token, err := ValidateToken(tokenString, cfg.SigningKey)
+	token, err := ValidateToken(tokenString, keyfunc)
 	require.NotNil(t, token)
 	require.NoError(t, err)
 }
 
 func TestValidateWrongToken(t *testing.T) {
-	invalidToken, err := ValidateToken(invalidTokenString, cfg.SigningKey)
+	invalidToken, err := ValidateToken(invalidTokenString, keyfunc)
 	require.Nil(t, invalidToken)
 	require.Error(t, err)
 }
@@ -131,7 +178,7 @@ func TestGetPayloadFromToken(t *testing.T) {
 }
 
 func TestMiddlewareWithoutAuthHeader(t *testing.T) {
-	e.Use(UserIdentity())
+	e.Use(UserIdentity(keyfunc, denylist, apiKeys))
 
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
@@ -146,7 +193,7 @@ func TestMiddlewareWithoutAuthHeader(t *testing.T) {
 }
 
 func TestMiddlewareInvalidTokenFormat(t *testing.T) {
-	e.Use(UserIdentity())
+	e.Use(UserIdentity(keyfunc, denylist, apiKeys))
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
 	})
@@ -161,7 +208,7 @@ func TestMiddlewareInvalidTokenFormat(t *testing.T) {
 }
 
 func TestMiddlewareInvalidToken(t *testing.T) {
-	e.Use(UserIdentity())
+	e.Use(UserIdentity(keyfunc, denylist, apiKeys))
 
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
@@ -177,7 +224,7 @@ func TestMiddlewareInvalidToken(t *testing.T) {
 }
 
 func TestMiddlewareExpiredToken(t *testing.T) {
-	e.Use(UserIdentity())
+	e.Use(UserIdentity(keyfunc, denylist, apiKeys))
 
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
@@ -193,3 +240,159 @@ func TestMiddlewareExpiredToken(t *testing.T) {
 
 	assert.Equal(t, http.StatusUnauthorized, rec.Code)
 }
+
+func TestUserIdentityActor(t *testing.T) {
+	actorID := uuid.New()
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+		Role: model.RoleAdmin,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        uuid.New().String(),
+			Subject:   actorID.String(),
+		},
+	})
+	actorToken, err := accessToken.SignedString(signingKey)
+	require.NoError(t, err)
+
+	echoActor := echo.New()
+	echoActor.GET("/", func(c echo.Context) error {
+		actor, ok := ActorFromContext(c.Request().Context())
+		require.True(t, ok)
+		require.Equal(t, model.RoleAdmin, actor.Role)
+		require.Equal(t, actorID, actor.ID)
+		return c.String(http.StatusOK, "OK")
+	}, UserIdentity(keyfunc, denylist, apiKeys))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+actorToken)
+	rec := httptest.NewRecorder()
+	echoActor.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRevokedToken(t *testing.T) {
+	tokenID := uuid.New().String()
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+		Role: model.RoleAdmin,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        tokenID,
+			Subject:   uuid.New().String(),
+		},
+	})
+	revokedToken, err := accessToken.SignedString(signingKey)
+	require.NoError(t, err)
+	revoked := testDenylist{tokenID: true}
+
+	echoRevoked := echo.New()
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	}
+	echoRevoked.GET("/user", handler, UserIdentity(keyfunc, revoked, apiKeys))
+	echoRevoked.GET("/other", handler, UserIdentity(keyfunc, denylist, apiKeys))
+	for path, code := range map[string]int{"/user": http.StatusUnauthorized, "/other": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+revokedToken)
+		rec := httptest.NewRecorder()
+		echoRevoked.ServeHTTP(rec, req)
+		require.Equal(t, code, rec.Code, path)
+	}
+}
+
+func TestIssuedAt(t *testing.T) {
+	at := time.UnixMilli(1700000000250)
+	require.Equal(t, at, issuedAt(jwt.MapClaims{"iat": float64(at.Unix()), "iat_ms": float64(at.UnixMilli())}))
+	require.Equal(t, time.Unix(at.Unix(), 0), issuedAt(jwt.MapClaims{"iat": float64(at.Unix())}))
+}
+
+func TestValidateTokenWrongMethod(t *testing.T) {
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{Role: model.RoleAdmin}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = ValidateToken(hmacToken, keyfunc)
+	require.Error(t, err)
+}
+
+func TestUserIdentityMFAChallenge(t *testing.T) {
+	challenge, err := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  MFAChallengeAudience,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        uuid.New().String(),
+			Subject:   uuid.New().String(),
+		},
+	}).SignedString(signingKey)
+	require.NoError(t, err)
+
+	echoChallenge := echo.New()
+	echoChallenge.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	}, UserIdentity(keyfunc, denylist, apiKeys))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	rec := httptest.NewRecorder()
+	echoChallenge.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserIdentityRefreshToken(t *testing.T) {
+	refresh, err := jwt.NewWithClaims(jwt.SigningMethodRS256, &tokenClaims{
+		Role: model.RoleAdmin,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  RefreshAudience,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        uuid.New().String(),
+			Subject:   uuid.New().String(),
+		},
+	}).SignedString(signingKey)
+	require.NoError(t, err)
+
+	echoRefresh := echo.New()
+	echoRefresh.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	}, UserIdentity(keyfunc, denylist, apiKeys))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+refresh)
+	rec := httptest.NewRecorder()
+	echoRefresh.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserIdentityAPIKey(t *testing.T) {
+	keyID := uuid.New()
+	userID := uuid.New()
+	checker := testPermissions{model.RoleUser: {"person:read", "person:write"}}
+	keys := testAPIKeys{
+		"ak_read": {ID: userID, Role: model.RoleUser, APIKeyID: &keyID, Scopes: []string{"person:read"}},
+		"ak_both": {ID: userID, Role: model.RoleAdmin, APIKeyID: &keyID, Scopes: []string{"person:read", "person:write"}},
+	}
+	echoKeys := echo.New()
+	handler := func(c echo.Context) error {
+		actor, ok := ActorFromContext(c.Request().Context())
+		require.True(t, ok)
+		require.Equal(t, userID, actor.ID)
+		return c.String(http.StatusOK, "OK")
+	}
+	echoKeys.GET("/read", handler, UserIdentity(keyfunc, denylist, keys), RequirePermission(checker, "person:read"))
+	echoKeys.GET("/write", handler, UserIdentity(keyfunc, denylist, keys), RequirePermission(checker, "person:write"))
+	echoKeys.GET("/session", handler, UserIdentity(keyfunc, denylist, keys), RequireSession())
+	// the scopes of a key and the role of its user both limit it
+	for key, codes := range map[string]map[string]int{
+		"ak_read":    {"/read": http.StatusOK, "/write": http.StatusForbidden, "/session": http.StatusForbidden},
+		"ak_both":    {"/read": http.StatusForbidden, "/write": http.StatusForbidden},
+		"ak_unknown": {"/read": http.StatusUnauthorized},
+	} {
+		for path, code := range codes {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set(APIKeyHeader, key)
+			rec := httptest.NewRecorder()
+			echoKeys.ServeHTTP(rec, req)
+			require.Equal(t, code, rec.Code, key+" "+path)
+		}
+	}
+}
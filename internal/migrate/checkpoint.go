@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// Checkpoint is the migration state saved after every batch
+type Checkpoint struct {
+	From     string               `json:"from"`
+	To       string               `json:"to"`
+	Entities map[string]*Progress `json:"entities"`
+}
+
+// Progress is the copy position of a single entity
+type Progress struct {
+	After  uuid.UUID `json:"after"`
+	Copied int64     `json:"copied"`
+	Done   bool      `json:"done"`
+}
+
+// loadCheckpoint reads the checkpoint at path or starts a new one when there is none,
+// a checkpoint of another migration direction is refused
+func loadCheckpoint(path, from, to string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{From: from, To: to, Entities: map[string]*Progress{}}
+	raw, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile(): %w", err)
+	}
+	err = json.Unmarshal(raw, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal(): %w", err)
+	}
+	if checkpoint.From != from || checkpoint.To != to {
+		return nil, fmt.Errorf("checkpoint %s belongs to a %s -> %s migration", path, checkpoint.From, checkpoint.To)
+	}
+	if checkpoint.Entities == nil {
+		checkpoint.Entities = map[string]*Progress{}
+	}
+	return checkpoint, nil
+}
+
+// progress returns the progress of the entity, creating it on the first call
+func (c *Checkpoint) progress(entity string) *Progress {
+	progress, ok := c.Entities[entity]
+	if !ok {
+		progress = &Progress{}
+		c.Entities[entity] = progress
+	}
+	return progress
+}
+
+// save writes the checkpoint to a temporary file and renames it over path,
+// so an interruption never leaves a half written checkpoint
+func (c *Checkpoint) save(path string) error {
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("MarshalIndent(): %w", err)
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, raw, 0o600)
+	if err != nil {
+		return fmt.Errorf("WriteFile(): %w", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("Rename(): %w", err)
+	}
+	return nil
+}
+
+// removeCheckpoint deletes the checkpoint at path if there is one
+func removeCheckpoint(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Remove(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Persons describes the person migration, soft deleted persons included
+func Persons(source, target Store[*model.Person]) Entity[*model.Person] {
+	return Entity[*model.Person]{
+		Name:      "person",
+		Source:    source,
+		Target:    target,
+		ID:        func(person *model.Person) uuid.UUID { return person.ID },
+		Canonical: canonicalPerson,
+	}
+}
+
+// Users describes the user migration
+func Users(source, target Store[*model.User]) Entity[*model.User] {
+	return Entity[*model.User]{
+		Name:      "user",
+		Source:    source,
+		Target:    target,
+		ID:        func(user *model.User) uuid.UUID { return user.ID },
+		Canonical: canonicalUser,
+	}
+}
+
+// canonicalPerson encodes the person with the deletion time in UTC at millisecond precision,
+// which is what MongoDB keeps of a PostgreSQL timestamp
+func canonicalPerson(person *model.Person) ([]byte, error) {
+	canonical := *person
+	if canonical.DeletedAt != nil {
+		deletedAt := canonical.DeletedAt.UTC().Truncate(time.Millisecond)
+		canonical.DeletedAt = &deletedAt
+	}
+	return json.Marshal(canonical)
+}
+
+// canonicalUser encodes the user treating empty and missing byte fields alike
+func canonicalUser(user *model.User) ([]byte, error) {
+	canonical := *user
+	if len(canonical.Password) == 0 {
+		canonical.Password = nil
+	}
+	if len(canonical.RefreshToken) == 0 {
+		canonical.RefreshToken = nil
+	}
+	return json.Marshal(canonical)
+}
@@ -0,0 +1,153 @@
+// Package migrate copies persons and users between storage backends in resumable batches
+// and verifies the copy afterwards, the other stores are not copied (see Uncovered)
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultBatch is the number of records copied at once when no batch size is given
+const DefaultBatch = 500
+
+// ErrMismatch is returned when the source and the target differ after the copy
+var ErrMismatch = errors.New("source and target differ")
+
+// Store is a backend records are streamed from and imported into
+type Store[T any] interface {
+	GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]T, error)
+	Import(ctx context.Context, items []T) error
+}
+
+// Entity describes one kind of record to migrate
+type Entity[T any] struct {
+	Name   string
+	Source Store[T]
+	Target Store[T]
+	// ID returns the record ID, records are streamed in ID order
+	ID func(T) uuid.UUID
+	// Canonical returns a backend independent encoding of the record used for checksums
+	Canonical func(T) ([]byte, error)
+}
+
+// Summary is the record count and the checksum of a backend
+type Summary struct {
+	Count    int64
+	Checksum string
+}
+
+// Migrator streams entities from one backend to another saving a checkpoint after every batch
+type Migrator struct {
+	batch      int
+	checkpoint *Checkpoint
+	path       string
+}
+
+// NewMigrator is a constructor for Migrator, it resumes the checkpoint at path when there is one
+func NewMigrator(path, from, to string, batch int) (*Migrator, error) {
+	if batch <= 0 {
+		batch = DefaultBatch
+	}
+	checkpoint, err := loadCheckpoint(path, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loadCheckpoint(): %w", err)
+	}
+	return &Migrator{batch: batch, checkpoint: checkpoint, path: path}, nil
+}
+
+// Done removes the checkpoint, call it once every entity is copied and verified
+func (m *Migrator) Done() error {
+	return removeCheckpoint(m.path)
+}
+
+// Copy func streams the entity from the source to the target starting right after the last
+// copied batch, the batch being copied when interrupted is imported again on resume
+func Copy[T any](ctx context.Context, m *Migrator, e Entity[T]) error {
+	progress := m.checkpoint.progress(e.Name)
+	if progress.Done {
+		logrus.WithFields(logrus.Fields{"entity": e.Name, "copied": progress.Copied}).Info("already copied")
+		return nil
+	}
+	for {
+		items, err := e.Source.GetBatch(ctx, progress.After, m.batch)
+		if err != nil {
+			return fmt.Errorf("GetBatch(): %w", err)
+		}
+		if len(items) == 0 {
+			progress.Done = true
+			return m.save()
+		}
+		err = e.Target.Import(ctx, items)
+		if err != nil {
+			return fmt.Errorf("Import(): %w", err)
+		}
+		progress.After = e.ID(items[len(items)-1])
+		progress.Copied += int64(len(items))
+		err = m.save()
+		if err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{"entity": e.Name, "copied": progress.Copied}).Info("batch copied")
+	}
+}
+
+// Verify func compares record counts and checksums of the source and the target,
+// it returns ErrMismatch when they differ
+func Verify[T any](ctx context.Context, m *Migrator, e Entity[T]) (source, target *Summary, err error) {
+	source, err = summarize(ctx, e.Source, e, m.batch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("summarize(source): %w", err)
+	}
+	target, err = summarize(ctx, e.Target, e, m.batch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("summarize(target): %w", err)
+	}
+	if *source != *target {
+		return source, target, fmt.Errorf("%s: %w (count %d/%d, checksum %s/%s)",
+			e.Name, ErrMismatch, source.Count, target.Count, source.Checksum, target.Checksum)
+	}
+	return source, target, nil
+}
+
+// summarize streams the whole store hashing the canonical records in ID order
+func summarize[T any](ctx context.Context, store Store[T], e Entity[T], batch int) (*Summary, error) {
+	hash := sha256.New()
+	summary := &Summary{}
+	after := uuid.Nil
+	for {
+		items, err := store.GetBatch(ctx, after, batch)
+		if err != nil {
+			return nil, fmt.Errorf("GetBatch(): %w", err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			raw, err := e.Canonical(item)
+			if err != nil {
+				return nil, fmt.Errorf("Canonical(): %w", err)
+			}
+			hash.Write(raw)
+			hash.Write([]byte{'\n'})
+		}
+		summary.Count += int64(len(items))
+		after = e.ID(items[len(items)-1])
+	}
+	summary.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return summary, nil
+}
+
+// save writes the checkpoint to disk
+func (m *Migrator) save() error {
+	err := m.checkpoint.save(m.path)
+	if err != nil {
+		return fmt.Errorf("save(): %w", err)
+	}
+	return nil
+}
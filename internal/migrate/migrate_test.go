@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore fails every import after the first n
+type failingStore struct {
+	Store[*model.Person]
+	n int
+}
+
+func (s *failingStore) Import(ctx context.Context, persons []*model.Person) error {
+	if s.n == 0 {
+		return errors.New("connection lost")
+	}
+	s.n--
+	return s.Store.Import(ctx, persons)
+}
+
+func seedPersons(t *testing.T, store Store[*model.Person], n int) {
+	deletedAt := time.Now()
+	persons := make([]*model.Person, n)
+	for i := range persons {
+		persons[i] = &model.Person{ID: uuid.New(), Name: fmt.Sprintf("Person %d", i), Age: i, Version: 1}
+	}
+	persons[0].DeletedAt = &deletedAt
+	require.NoError(t, store.Import(context.Background(), persons))
+}
+
+func TestCopyAndVerify(t *testing.T) {
+	ctx := context.Background()
+	source, target := repository.NewMemoryConnection(), repository.NewMemoryConnection()
+	seedPersons(t, source, 7)
+	users := []*model.User{{ID: uuid.New(), Login: "eugen", Password: []byte("hash"), Role: "admin"}}
+	userSource, userTarget := repository.NewUserMemoryConnection(), repository.NewUserMemoryConnection()
+	require.NoError(t, userSource.Import(ctx, users))
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	m, err := NewMigrator(path, "postgres", "mongo", 3)
+	require.NoError(t, err)
+	require.NoError(t, Copy(ctx, m, Persons(source, target)))
+	require.NoError(t, Copy(ctx, m, Users(userSource, userTarget)))
+	require.Equal(t, int64(7), m.checkpoint.Entities["person"].Copied)
+
+	src, dst, err := Verify(ctx, m, Persons(source, target))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), src.Count)
+	require.Equal(t, src, dst)
+	_, _, err = Verify(ctx, m, Users(userSource, userTarget))
+	require.NoError(t, err)
+	require.NoError(t, m.Done())
+}
+
+func TestCopyResume(t *testing.T) {
+	ctx := context.Background()
+	source, target := repository.NewMemoryConnection(), repository.NewMemoryConnection()
+	seedPersons(t, source, 10)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Step 1: interrupt the copy after two batches
+	m, err := NewMigrator(path, "postgres", "mongo", 3)
+	require.NoError(t, err)
+	err = Copy(ctx, m, Persons(source, &failingStore{Store: target, n: 2}))
+	require.Error(t, err)
+
+	// Step 2: resume from the saved checkpoint
+	m, err = NewMigrator(path, "postgres", "mongo", 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), m.checkpoint.Entities["person"].Copied)
+	require.NoError(t, Copy(ctx, m, Persons(source, target)))
+	require.Equal(t, int64(10), m.checkpoint.Entities["person"].Copied)
+	_, _, err = Verify(ctx, m, Persons(source, target))
+	require.NoError(t, err)
+
+	// Step 3: a checkpoint of another direction is refused
+	_, err = NewMigrator(path, "mongo", "postgres", 3)
+	require.Error(t, err)
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	ctx := context.Background()
+	source, target := repository.NewMemoryConnection(), repository.NewMemoryConnection()
+	seedPersons(t, source, 4)
+	m, err := NewMigrator(filepath.Join(t.TempDir(), "checkpoint.json"), "postgres", "mongo", 2)
+	require.NoError(t, err)
+	require.NoError(t, Copy(ctx, m, Persons(source, target)))
+
+	persons, err := target.GetBatch(ctx, uuid.Nil, 1)
+	require.NoError(t, err)
+	persons[0].Name = "Changed"
+	require.NoError(t, target.Import(ctx, persons))
+	src, dst, err := Verify(ctx, m, Persons(source, target))
+	require.ErrorIs(t, err, ErrMismatch)
+	require.Equal(t, src.Count, dst.Count)
+	require.NotEqual(t, src.Checksum, dst.Checksum)
+}
+
+// storeCounts counts records from a map, failing for the store named fail
+type storeCounts map[string]int64
+
+func (c storeCounts) Count(_ context.Context, store string) (int64, error) {
+	if store == "fail" {
+		return 0, errors.New("connection lost")
+	}
+	return c[store], nil
+}
+
+func TestCheckUncovered(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, CheckUncovered(ctx, storeCounts{}, nil))
+
+	counts := storeCounts{"signing_key": 2, "person_audit": 5}
+	err := CheckUncovered(ctx, counts, nil)
+	require.ErrorIs(t, err, ErrUncovered)
+	require.Contains(t, err.Error(), "person_audit (5)")
+	require.Contains(t, err.Error(), "signing_key (2)")
+	require.ErrorIs(t, CheckUncovered(ctx, counts, []string{"signing_key"}), ErrUncovered)
+	require.NoError(t, CheckUncovered(ctx, counts, []string{"signing_key", "person_audit"}))
+
+	require.Error(t, CheckUncovered(ctx, counts, []string{"person"}))
+}
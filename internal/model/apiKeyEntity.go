@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey struct is a named key a user creates for services calling the API on the user's behalf. The key can only
+// use the permissions of its scopes the role of the user grants. Only the hash of the key is stored, the key itself
+// is shown once when it is created or rotated, Prefix is its beginning which tells keys apart in listings
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" bson:"_id"`
+	UserID     uuid.UUID  `json:"user_id" bson:"user_id"`
+	Name       string     `json:"name" bson:"name"`
+	Prefix     string     `json:"prefix" bson:"prefix"`
+	Hash       []byte     `json:"-" bson:"hash"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty" bson:"rotated_at"`
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revoked_at"`
+}
+
+// APIKeyRequest is a request to create an API key, a key without expiry expires after the longest lifetime allowed
+type APIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=64"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeySecret is a created or rotated API key along with the key itself
+type APIKeySecret struct {
+	*APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// audit actions on a person
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// Actor struct is the authenticated user behind a request
+type Actor struct {
+	ID   uuid.UUID `json:"id" bson:"id"`
+	Role string    `json:"role" bson:"role"`
+	// APIKeyID is the API key the actor authenticated with, nil for a session
+	APIKeyID *uuid.UUID `json:"api_key_id,omitempty" bson:"api_key_id,omitempty"`
+	// Scopes limit the permissions of the role for an actor authenticated with an API key
+	Scopes []string `json:"scopes,omitempty" bson:"scopes,omitempty"`
+}
+
+// AuditRecord struct is a single change of a person with snapshots before and after it
+type AuditRecord struct {
+	ID        uuid.UUID `json:"id" bson:"_id"`
+	PersonID  uuid.UUID `json:"person_id" bson:"person_id"`
+	ActorID   uuid.UUID `json:"actor_id" bson:"actor_id"`
+	ActorRole string    `json:"actor_role" bson:"actor_role"`
+	Action    string    `json:"action" bson:"action"`
+	Before    *Person   `json:"before,omitempty" bson:"before,omitempty"`
+	After     *Person   `json:"after,omitempty" bson:"after,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// AuditQuery struct holds filters for the audit trail, From is inclusive and To is exclusive
+type AuditQuery struct {
+	PersonID *uuid.UUID `query:"person_id"`
+	ActorID  *uuid.UUID `query:"actor_id"`
+	From     *time.Time `query:"from"`
+	To       *time.Time `query:"to"`
+	Limit    int        `query:"limit" validate:"min=0,max=1000"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import "github.com/google/uuid"
+
+// bulk modes
+const (
+	// BulkAtomic applies every item or none of them
+	BulkAtomic = "atomic"
+	// BulkBestEffort applies the items it can and reports the failed ones
+	BulkBestEffort = "best_effort"
+)
+
+// BulkPersons struct is a bulk create or update request
+type BulkPersons struct {
+	Mode    string    `json:"mode" validate:"omitempty,oneof=atomic best_effort"`
+	Persons []*Person `json:"persons" validate:"required,min=1,max=1000"`
+}
+
+// PersonVersion struct identifies a person in a version that the caller has seen
+type PersonVersion struct {
+	ID      uuid.UUID `json:"id"`
+	Version int64     `json:"version"`
+}
+
+// BulkDelete struct is a bulk delete request
+type BulkDelete struct {
+	Mode  string          `json:"mode" validate:"omitempty,oneof=atomic best_effort"`
+	Items []PersonVersion `json:"items" validate:"required,min=1,max=1000"`
+}
+
+// BulkResult struct is the outcome of a single bulk item, Index points into the request
+type BulkResult struct {
+	Index   int       `json:"index"`
+	ID      uuid.UUID `json:"id"`
+	Version int64     `json:"version,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// BulkReport struct is the outcome of a bulk request
+type BulkReport struct {
+	Mode      string       `json:"mode"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CacheTierStats struct holds the counters of one tier of a cache. Evictions counts the entries
+// the tier dropped: when full or expired in process, on invalidations and flushes in Redis
+type CacheTierStats struct {
+	Cache     string  `json:"cache"`
+	Tier      string  `json:"tier"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+// WriteBehindStats struct holds the counters of the write-behind person cache. Stuck counts the queued deletes
+// of given up changes which keep failing, their persons are read from the primary store until the delete succeeds
+type WriteBehindStats struct {
+	Queued   int    `json:"queued"`
+	Stuck    int    `json:"stuck"`
+	Failures uint64 `json:"failures"`
+	GaveUp   uint64 `json:"gave_up"`
+}
+
+// CacheEntry struct describes a cached entity, Local reports whether this instance holds it in process too
+type CacheEntry struct {
+	Entity        string      `json:"entity"`
+	ID            uuid.UUID   `json:"id"`
+	Key           string      `json:"key"`
+	SchemaVersion int         `json:"schema_version"`
+	TTLMillis     int64       `json:"ttl_ms"`
+	Local         bool        `json:"local"`
+	Value         interface{} `json:"value"`
+}
+
+// CachedUser struct is the part of a cached user shown to operators, secrets are only reported as present
+type CachedUser struct {
+	Login           string `json:"login"`
+	Role            string `json:"role"`
+	HasRefreshToken bool   `json:"has_refresh_token"`
+}
+
+// CacheWarmQuery struct holds the number of entities to load into a cache
+type CacheWarmQuery struct {
+	Limit int `query:"limit" validate:"min=0,max=100000"`
+}
+
+// CacheFlushResult struct reports the keys deleted by a cache flush
+type CacheFlushResult struct {
+	Entity  string `json:"entity"`
+	Flushed int64  `json:"flushed"`
+}
+
+// CacheWarmResult struct reports the entities loaded into a cache
+type CacheWarmResult struct {
+	Entity string `json:"entity"`
+	Warmed int    `json:"warmed"`
+}
+
+// BreakerState struct is a snapshot of a circuit breaker, OpenedAt and RetryAt are set unless it is closed
+type BreakerState struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	Threshold int        `json:"threshold"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import "errors"
+
+// Errors shared by the repository implementations
+var (
+	// ErrVersionConflict is returned when an entity was changed since the version the caller has seen
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrNotFound is returned when an entity does not exist or is deleted
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when an entity is created with the ID of an existing one
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrBulkAborted is reported for the items of an atomic bulk request that was rolled back
+	ErrBulkAborted = errors.New("bulk request aborted")
+	// ErrCacheMiss is returned by the caches when an entry is not cached, it is not a cache failure
+	ErrCacheMiss = errors.New("not cached")
+	// ErrUnknownCache is returned for cache administration of an entity without a managed cache
+	ErrUnknownCache = errors.New("unknown cache")
+	// ErrTokenReuse is returned when a refresh token which was already rotated is presented again
+	ErrTokenReuse = errors.New("refresh token reuse")
+	// ErrTokenRevoked is returned for refresh tokens of a revoked token family
+	ErrTokenRevoked = errors.New("refresh token revoked")
+	// ErrUnknownPermission is returned for roles granting a permission which does not exist
+	ErrUnknownPermission = errors.New("unknown permission")
+	// ErrBuiltinRole is returned when deleting a role the service relies on
+	ErrBuiltinRole = errors.New("built-in role")
+	// ErrInvalidInvitation is returned for invitation codes which do not exist, expired or were used
+	ErrInvalidInvitation = errors.New("invalid invitation")
+	// ErrInvalidCredentials is returned for logins of unknown users or with a wrong password
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrWeakPassword is returned for passwords which don't satisfy the password policy
+	ErrWeakPassword = errors.New("weak password")
+	// ErrLoginThrottled is returned for logins attempted too soon after failed ones
+	ErrLoginThrottled = errors.New("too many failed logins")
+	// ErrMFAEnabled is returned when enrolling a user whose two-factor authentication is already enabled
+	ErrMFAEnabled = errors.New("two-factor authentication already enabled")
+	// ErrInvalidMFACode is returned for wrong, expired or already used TOTP and recovery codes
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	// ErrMFARequired is returned for logins which have to pass the second factor before tokens are issued
+	ErrMFARequired = errors.New("two-factor authentication required")
+	// ErrInvalidAPIKey is returned for API keys which do not exist, expired or were revoked
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyScope is returned for API key scopes which are no permissions the role of the user grants
+	ErrAPIKeyScope = errors.New("scope not granted")
+	// ErrAPIKeyExpiry is returned for API key expiries in the past or beyond the longest lifetime allowed
+	ErrAPIKeyExpiry = errors.New("invalid API key expiry")
+	// ErrInvalidResetToken is returned for password reset tokens which do not exist, expired or were used
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	// ErrCircuitOpen is returned instead of calling a backend the circuit breaker considers down
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
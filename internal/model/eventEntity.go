@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// person change event types
+const (
+	EventPersonCreated = "person.created"
+	EventPersonUpdated = "person.updated"
+	EventPersonDeleted = "person.deleted"
+)
+
+// Event struct is a domain event waiting in the outbox to be published,
+// events of the same outbox are published in Seq order
+type Event struct {
+	ID          uuid.UUID `json:"id" bson:"_id"`
+	Seq         int64     `json:"seq" bson:"seq"`
+	Type        string    `json:"type" bson:"type"`
+	AggregateID uuid.UUID `json:"aggregate_id" bson:"aggregate_id"`
+	Payload     []byte    `json:"payload" bson:"payload"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFA struct is the TOTP second factor (RFC 6238) of a user, it is enabled once the user confirms it with a code.
+// Only the hashes of the recovery codes are kept, LastStep is the latest time step a code was accepted for
+type MFA struct {
+	UserID        uuid.UUID  `json:"user_id" bson:"_id"`
+	Secret        []byte     `json:"-" bson:"secret"`
+	RecoveryCodes []string   `json:"-" bson:"recovery_codes"`
+	LastStep      int64      `json:"-" bson:"last_step"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	ConfirmedAt   *time.Time `json:"confirmed_at,omitempty" bson:"confirmed_at"`
+}
+
+// MFAEnrollment struct is a new TOTP secret along with its provisioning URI for authenticator apps
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFACode struct contains a TOTP code or a recovery code
+type MFACode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// MFAChallenge struct contains the challenge token returned by a login which needs the second factor
+type MFAChallenge struct {
+	Challenge string `json:"challenge" validate:"required"`
+}
+
+// MFALogin struct exchanges a challenge token and a TOTP code or a recovery code for a token pair
+type MFALogin struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code" validate:"required"`
+}
+
+// MFATokens struct is the token pair issued after the second factor, along with the recovery codes
+// of a second factor enrolled during the login
+type MFATokens struct {
+	AccessToken   string   `json:"access_token"`
+	RefreshToken  string   `json:"refresh_token"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// RecoveryCodes struct contains the single-use recovery codes of a second factor, they are shown once
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFARequiredError is returned for a login with the right password which has to pass the second factor,
+// the challenge token stands for the login until then. Enroll is set when the role of the user requires
+// a second factor the user has not enabled yet. It unwraps to ErrMFARequired
+type MFARequiredError struct {
+	Challenge string
+	Enroll    bool
+}
+
+// Error implements the error interface
+func (e *MFARequiredError) Error() string {
+	if e.Enroll {
+		return fmt.Sprintf("%v: enrollment required", ErrMFARequired)
+	}
+	return ErrMFARequired.Error()
+}
+
+// Unwrap returns ErrMFARequired
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
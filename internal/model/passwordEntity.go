@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordReset struct is a single-use token mailed to a user who forgot the password, resetting the password
+// with it before it expires proves the user reads the mail of the login. Only the hash of the token is stored
+type PasswordReset struct {
+	ID        uuid.UUID  `json:"id" bson:"_id"`
+	UserID    uuid.UUID  `json:"user_id" bson:"user_id"`
+	TokenHash []byte     `json:"-" bson:"token_hash"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at"`
+}
+
+// PasswordChange struct changes the password of the logged in user, the current password confirms it
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// PasswordForgot struct names the login a password reset token is mailed to
+type PasswordForgot struct {
+	Login string `json:"login" validate:"required"`
+}
+
+// PasswordResetRequest struct sets a new password with a mailed password reset token
+type PasswordResetRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// Mail struct is a plain text mail to a single recipient
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
@@ -2,20 +2,49 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
 // Person struct for person entity in the database
 type Person struct {
-	ID        uuid.UUID `json:"id" bson:"_id"`
-	Name      string    `json:"name" bson:"name" validate:"required"`
-	Age       int       `json:"age" bson:"age" validate:"required,min=0,max=140"`
-	IsHealthy bool      `json:"ishealthy" bson:"is_healthy"`
+	ID        uuid.UUID  `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name" validate:"required"`
+	Age       int        `json:"age" bson:"age" validate:"required,min=0,max=140"`
+	IsHealthy bool       `json:"ishealthy" bson:"is_healthy"`
+	Version   int64      `json:"version" bson:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
-// PersonRedis struct for Person entity in Redis database
+// PersonRedis struct for Person entity in Redis database,
+// SchemaVersion and ID are missing from payloads written before the versioned key schema
 type PersonRedis struct {
-	Name      string `json:"name" bson:"name" validate:"required"`
-	Age       int    `json:"age" bson:"age" validate:"required,min=0,max=140"`
-	IsHealthy bool   `json:"ishealthy" bson:"is_healthy"`
+	SchemaVersion int       `json:"v,omitempty"`
+	ID            uuid.UUID `json:"id"`
+	Name          string    `json:"name" bson:"name" validate:"required"`
+	Age           int       `json:"age" bson:"age" validate:"required,min=0,max=140"`
+	IsHealthy     bool      `json:"ishealthy" bson:"is_healthy"`
+	Version       int64     `json:"version" bson:"version"`
+}
+
+// PersonQuery struct holds pagination, sorting and filtering options for person lists.
+// Cursor (keyset) pagination takes precedence over Offset when both are given.
+type PersonQuery struct {
+	Limit      int    `query:"limit" validate:"min=0,max=100"`
+	Offset     int    `query:"offset" validate:"min=0"`
+	Cursor     string `query:"cursor"`
+	SortBy     string `query:"sort" validate:"omitempty,oneof=name age"`
+	Order      string `query:"order" validate:"omitempty,oneof=asc desc"`
+	MinAge     *int   `query:"min_age" validate:"omitempty,min=0,max=140"`
+	MaxAge     *int   `query:"max_age" validate:"omitempty,min=0,max=140"`
+	IsHealthy  *bool  `query:"ishealthy"`
+	NamePrefix string `query:"name_prefix"`
+}
+
+// PersonPage struct is a single page of persons with pagination metadata
+type PersonPage struct {
+	Items      []*Person `json:"items"`
+	Total      int64     `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
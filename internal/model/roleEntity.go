@@ -0,0 +1,97 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Permissions granted by roles, each guards a group of routes
+const (
+	PermissionPersonRead  = "person:read"
+	PermissionPersonWrite = "person:write"
+	PermissionPersonAdmin = "person:admin"
+	PermissionUserRead    = "user:read"
+	PermissionUserAdmin   = "user:admin"
+	PermissionImageRead   = "image:read"
+	PermissionImageWrite  = "image:write"
+	PermissionCacheAdmin  = "cache:admin"
+)
+
+// Built-in roles, they are created on startup and can't be deleted
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permissions lists every known permission
+var Permissions = []string{
+	PermissionPersonRead,
+	PermissionPersonWrite,
+	PermissionPersonAdmin,
+	PermissionUserRead,
+	PermissionUserAdmin,
+	PermissionImageRead,
+	PermissionImageWrite,
+	PermissionCacheAdmin,
+}
+
+// Role is a named set of permissions assigned to users
+type Role struct {
+	Name        string   `json:"name" bson:"_id"`
+	Permissions []string `json:"permissions" bson:"permissions" validate:"required"`
+}
+
+// RoleAssignment is a request to assign a role to a user
+type RoleAssignment struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// Statuses of a role request
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestRejected = "rejected"
+)
+
+// Invitation struct is a single-use code issued by an admin, signing up with it grants Role.
+// Only the hash of the code is stored, the code itself is shown once when the invitation is created
+type Invitation struct {
+	ID        uuid.UUID  `json:"id" bson:"_id"`
+	CodeHash  []byte     `json:"-" bson:"code_hash"`
+	Role      string     `json:"role" bson:"role"`
+	CreatedBy uuid.UUID  `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at"`
+	UsedBy    *uuid.UUID `json:"used_by,omitempty" bson:"used_by"`
+}
+
+// InvitationRequest is a request to invite a user with the given role
+type InvitationRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// InvitationCode is a created invitation along with its code
+type InvitationCode struct {
+	ID        uuid.UUID `json:"id"`
+	Code      string    `json:"code"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RoleRequest struct is a request of a user for a role, it waits for an admin to approve or reject it
+type RoleRequest struct {
+	ID        uuid.UUID  `json:"id" bson:"_id"`
+	UserID    uuid.UUID  `json:"user_id" bson:"user_id"`
+	Role      string     `json:"role" bson:"role" validate:"required"`
+	Status    string     `json:"status" bson:"status"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	DecidedBy *uuid.UUID `json:"decided_by,omitempty" bson:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty" bson:"decided_at,omitempty"`
+}
+
+// RoleRequestQuery selects role requests by status
+type RoleRequestQuery struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending approved rejected"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenFamily struct is the chain of refresh tokens issued from one login. Only the hash of the latest
+// token is kept, presenting an older one means the family leaked and revokes it
+type TokenFamily struct {
+	ID         uuid.UUID  `json:"id" bson:"_id"`
+	UserID     uuid.UUID  `json:"user_id" bson:"user_id"`
+	TokenHash  []byte     `json:"token_hash" bson:"token_hash"`
+	Generation int        `json:"generation" bson:"generation"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	RotatedAt  time.Time  `json:"rotated_at" bson:"rotated_at"`
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// SigningKey struct is a private key signing tokens, tokens name it in their kid header. It signs from
+// ActiveFrom until a newer key becomes active and is published until ExpiresAt, when the tokens it signed have expired
+type SigningKey struct {
+	ID         string    `json:"id" bson:"_id"`
+	PrivateKey []byte    `json:"-" bson:"private_key"`
+	ActiveFrom time.Time `json:"active_from" bson:"active_from"`
+	ExpiresAt  time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+// JWK struct is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS struct is the set of public keys verifying our tokens
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
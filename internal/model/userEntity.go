@@ -1,7 +1,12 @@
 // Package model provides a struct for our User entity in database
 package model
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // User struct user which represents database entity with the same name
 type User struct {
@@ -18,11 +23,11 @@ type Login struct {
 	Password string `db:"password" bson:"password" validate:"required"`
 }
 
-// Signup struct for user
+// Signup struct for user, signing up creates a basic user unless an invitation grants another role
 type Signup struct {
-	Login    string `db:"login" bson:"login" validate:"required"`
-	Password string `db:"password" bson:"password" validate:"required"`
-	Role     string `json:"role" bson:"role" validate:"required"`
+	Login      string `db:"login" bson:"login" validate:"required"`
+	Password   string `db:"password" bson:"password" validate:"required"`
+	Invitation string `json:"invitation,omitempty"`
 }
 
 // GetUser struct for user
@@ -39,10 +44,58 @@ type Tokens struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-// UserRedis struct for cached user
+// UserRedis struct for cached user,
+// SchemaVersion and ID are missing from payloads written before the versioned key schema
 type UserRedis struct {
-	Login        string `db:"login" bson:"login" validate:"required"`
-	Password     []byte `db:"password" bson:"password" validate:"required"`
-	Role         string `db:"role" bson:"role"`
-	RefreshToken []byte `db:"refresh_token" bson:"refresh_token"`
+	SchemaVersion int       `json:"v,omitempty"`
+	ID            uuid.UUID `json:"id"`
+	Login         string    `db:"login" bson:"login" validate:"required"`
+	Password      []byte    `db:"password" bson:"password" validate:"required"`
+	Role          string    `db:"role" bson:"role"`
+	RefreshToken  []byte    `db:"refresh_token" bson:"refresh_token"`
+}
+
+// Login throttling scopes, failed logins are counted per login and per client IP
+const (
+	LoginScopeLogin = "login"
+	LoginScopeIP    = "ip"
+)
+
+// Password reset throttling scopes, reset requests are counted per login and per client IP
+const (
+	ResetScopeLogin = "reset_login"
+	ResetScopeIP    = "reset_ip"
+)
+
+// LoginFailures struct contains the failed logins counted for a login or an IP since the counter was reset
+type LoginFailures struct {
+	Count  int       `json:"count"`
+	LastAt time.Time `json:"last_at"`
+}
+
+// Unlock struct names the login and/or the IP whose failed logins are forgotten
+type Unlock struct {
+	Login string `json:"login" validate:"required_without=IP"`
+	IP    string `json:"ip" validate:"omitempty,ip"`
+}
+
+// LoginThrottledError is returned for a login attempted before the backoff of earlier failures has passed
+// or while the login or the IP is locked out, it unwraps to ErrLoginThrottled
+type LoginThrottledError struct {
+	Scope      string
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("%s locked out for %v: %v", e.Scope, e.RetryAfter, ErrLoginThrottled)
+	}
+	return fmt.Sprintf("%s throttled for %v: %v", e.Scope, e.RetryAfter, ErrLoginThrottled)
+}
+
+// Unwrap returns ErrLoginThrottled
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
 }
@@ -4,55 +4,91 @@ package producer
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"time"
 
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// relay defaults
+const (
+	relayInterval = time.Second
+	relayBatch    = 100
 )
 
-// RedisProducer represents the
-type RedisProducer struct {
-	rdb *redis.Client
+// Outbox interface, which contains the outbox methods used by the relay
+type Outbox interface {
+	Pending(ctx context.Context, limit int) ([]*model.Event, error)
+	Ack(ctx context.Context, ids []uuid.UUID) error
 }
 
-// NewProducer creates a new RedisProducer
-func NewProducer(rdb *redis.Client) *RedisProducer {
-	return &RedisProducer{rdb: rdb}
+// OutboxRelay publishes the events of an outbox to a redis stream in outbox order.
+// An event is removed from the outbox only after it was published, so it is delivered at least once
+type OutboxRelay struct {
+	outbox Outbox
+	rdb    *redis.Client
+	stream string
 }
 
-// RedisProducer creates a new redis stream entry
-func (rdbClient *RedisProducer) RedisProducer(ctx context.Context) {
-	identificator := 0
+// NewOutboxRelay creates a new OutboxRelay publishing to the given stream
+func NewOutboxRelay(outbox Outbox, rdb *redis.Client, stream string) *OutboxRelay {
+	return &OutboxRelay{outbox: outbox, rdb: rdb, stream: stream}
+}
+
+// Run publishes pending events until ctx is done, a full batch is followed by the next one right away
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
 	for {
+		n, err := r.Publish(ctx)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"stream": r.stream}).Errorf("Publish: %v", err)
+		}
+		if err == nil && n == relayBatch && ctx.Err() == nil {
+			continue
+		}
 		select {
 		case <-ctx.Done():
-			fmt.Println("Goroutine Closed...")
 			return
-		case <-time.After(5 * time.Second):
-			fmt.Println("Goroutine Closed...")
-			return
-		default:
-			id := strconv.FormatInt(time.Now().Unix(), 10)
-			payload := map[string]interface{}{
-				"timestamp": id,
-				"content":   fmt.Sprintf("Redis streaming %d...", identificator),
-			}
-
-			identificator++
-			id = id + "-" + strconv.Itoa(identificator)
-
-			err := rdbClient.rdb.XAdd(context.Background(), &redis.XAddArgs{
-				Stream: "testStream",
-				MaxLen: 0,
-				ID:     id,
-				Values: payload,
-			}).Err()
-			if err != nil {
-				fmt.Println("Error adding message to Redis Stream:", err)
-			}
-			const TTL = 2
-			time.Sleep(TTL * time.Second)
+		case <-ticker.C:
 		}
 	}
+}
 
+// Publish publishes a single batch of pending events and returns how many of them were published,
+// it stops at the first failed event to keep the order
+func (r *OutboxRelay) Publish(ctx context.Context) (int, error) {
+	events, err := r.outbox.Pending(ctx, relayBatch)
+	if err != nil {
+		return 0, fmt.Errorf("Pending: %w", err)
+	}
+	published := make([]uuid.UUID, 0, len(events))
+	var xaddErr error
+	for _, event := range events {
+		xaddErr = r.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.stream,
+			Values: map[string]interface{}{
+				"id":           event.ID.String(),
+				"type":         event.Type,
+				"aggregate_id": event.AggregateID.String(),
+				"payload":      string(event.Payload),
+				"created_at":   event.CreatedAt.Format(time.RFC3339Nano),
+			},
+		}).Err()
+		if xaddErr != nil {
+			xaddErr = fmt.Errorf("XAdd: %w", xaddErr)
+			break
+		}
+		published = append(published, event.ID)
+	}
+	if len(published) > 0 {
+		err = r.outbox.Ack(ctx, published)
+		if err != nil {
+			return 0, fmt.Errorf("Ack: %w", err)
+		}
+	}
+	return len(published), xaddErr
 }
@@ -1,3 +1,5 @@
+//go:build integration
+
 package repository
 
 import (
@@ -131,3 +133,39 @@ func TestMongoUserGetRoleByWrongID(t *testing.T) {
 	err = urpsM.Delete(context.Background(), mongotestUser.ID)
 	require.NoError(t, err)
 }
+
+func TestMongoUserSetRole(t *testing.T) {
+	err := urpsM.Signup(context.Background(), &mongotestUser)
+	require.NoError(t, err)
+	err = urpsM.SetRole(context.Background(), mongotestUser.ID, model.RoleAdmin)
+	require.NoError(t, err)
+	testRole, err := urpsM.GetRoleByID(context.Background(), mongotestUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoleAdmin, testRole)
+	login, err := urpsM.GetLoginByID(context.Background(), mongotestUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, mongotestUser.Login, login)
+	err = urpsM.SetRole(context.Background(), uuid.New(), model.RoleAdmin)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	_, err = urpsM.GetLoginByID(context.Background(), uuid.New())
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	_, err = urpsM.GetRoleByID(context.Background(), uuid.New())
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	err = urpsM.Delete(context.Background(), mongotestUser.ID)
+	require.NoError(t, err)
+}
+
+func TestMongoUserSetPassword(t *testing.T) {
+	err := urpsM.Signup(context.Background(), &mongotestUser)
+	require.NoError(t, err)
+	password := hashPassword([]byte("new"))
+	err = urpsM.SetPassword(context.Background(), mongotestUser.ID, password)
+	require.NoError(t, err)
+	user, err := urpsM.GetUser(context.Background(), mongotestUser.Login)
+	assert.NoError(t, err)
+	assert.Equal(t, password, user.Password)
+	err = urpsM.SetPassword(context.Background(), uuid.New(), password)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	err = urpsM.Delete(context.Background(), mongotestUser.ID)
+	require.NoError(t, err)
+}
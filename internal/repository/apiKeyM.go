@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyMongoDBConnection is a struct, which contains *mongo.Client variable
+type APIKeyMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewAPIKeyMongoDBConnection func is a constructor of APIKeyMongoDBConnection struct
+func NewAPIKeyMongoDBConnection(client *mongo.Client) *APIKeyMongoDBConnection {
+	return &APIKeyMongoDBConnection{client: client}
+}
+
+// collection returns the api_key collection
+func (db *APIKeyMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("api_key")
+}
+
+// Create function executes "db.api_key.insertOne()" command
+func (db *APIKeyMongoDBConnection) Create(ctx context.Context, key *model.APIKey) error {
+	_, err := db.collection().InsertOne(ctx, key)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// GetByHash function executes "db.api_key.findOne()" command selecting the API key with the given hash
+func (db *APIKeyMongoDBConnection) GetByHash(ctx context.Context, hash []byte) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	err := db.collection().FindOne(ctx, bson.M{"hash": hash}).Decode(key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOne: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOne: %w", err)
+	}
+	return key, nil
+}
+
+// GetByUser function executes "db.api_key.find()" command selecting the API keys of a user, oldest first
+func (db *APIKeyMongoDBConnection) GetByUser(ctx context.Context, userID uuid.UUID) ([]*model.APIKey, error) {
+	cursor, err := db.collection().Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Find: %w", err)
+	}
+	keys := []*model.APIKey{}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("All: %w", err)
+	}
+	return keys, nil
+}
+
+// Rotate function executes "db.api_key.findOneAndUpdate()" replacing the key of an API key of the user, the previous
+// key stops working. Only a key which was not revoked and has not expired at the given time can be rotated
+func (db *APIKeyMongoDBConnection) Rotate(ctx context.Context, id, userID uuid.UUID, prefix string, hash []byte, at time.Time) (*model.APIKey, error) {
+	filter := bson.M{"_id": id, "user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"prefix": prefix, "hash": hash, "rotated_at": at}}
+	key := &model.APIKey{}
+	err := db.collection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", err)
+	}
+	return key, nil
+}
+
+// Revoke function executes "db.api_key.updateOne()" revoking an API key of the user which was not revoked yet
+func (db *APIKeyMongoDBConnection) Revoke(ctx context.Context, id, userID uuid.UUID, at time.Time) error {
+	res, err := db.collection().UpdateOne(ctx, bson.M{"_id": id, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// RevokeUser function executes "db.api_key.updateMany()" revoking every API key of the user which was not revoked yet
+func (db *APIKeyMongoDBConnection) RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	res, err := db.collection().UpdateMany(ctx, bson.M{"user_id": userID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return 0, fmt.Errorf("UpdateMany: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// Touch function executes "db.api_key.updateOne()" recording the use of an API key, the last use never goes back in time
+func (db *APIKeyMongoDBConnection) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	filter := bson.M{"_id": id, "$or": bson.A{bson.M{"last_used_at": nil}, bson.M{"last_used_at": bson.M{"$lt": at}}}}
+	_, err := db.collection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_used_at": at}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var apiKeyM *APIKeyMongoDBConnection
+
+func TestMongoAPIKey(t *testing.T) {
+	checkAPIKeys(t, apiKeyM)
+}
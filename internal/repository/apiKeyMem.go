@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyMemoryConnection is an in-memory API key storage, safe for concurrent use
+type APIKeyMemoryConnection struct {
+	mu   sync.Mutex
+	keys map[uuid.UUID]*model.APIKey
+}
+
+// NewAPIKeyMemoryConnection is a constructor for APIKeyMemoryConnection
+func NewAPIKeyMemoryConnection() *APIKeyMemoryConnection {
+	return &APIKeyMemoryConnection{keys: make(map[uuid.UUID]*model.APIKey)}
+}
+
+// copyAPIKey returns a deep copy of the API key
+func copyAPIKey(key *model.APIKey) *model.APIKey {
+	cp := *key
+	cp.Hash = cloneBytes(key.Hash)
+	cp.Scopes = append([]string(nil), key.Scopes...)
+	for _, at := range []**time.Time{&cp.RotatedAt, &cp.LastUsedAt, &cp.RevokedAt} {
+		if *at != nil {
+			t := **at
+			*at = &t
+		}
+	}
+	return &cp
+}
+
+// find returns the stored API key with the given hash, the caller must hold the lock
+func (db *APIKeyMemoryConnection) find(hash []byte) *model.APIKey {
+	for _, key := range db.keys {
+		if bytes.Equal(key.Hash, hash) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Create stores a copy of a new API key
+func (db *APIKeyMemoryConnection) Create(_ context.Context, key *model.APIKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.keys[key.ID]; ok || db.find(key.Hash) != nil {
+		return fmt.Errorf("Create: API key %v already exists", key.ID)
+	}
+	db.keys[key.ID] = copyAPIKey(key)
+	return nil
+}
+
+// GetByHash returns a copy of the API key with the given hash
+func (db *APIKeyMemoryConnection) GetByHash(_ context.Context, hash []byte) (*model.APIKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key := db.find(hash)
+	if key == nil {
+		return nil, fmt.Errorf("GetByHash: %w", model.ErrNotFound)
+	}
+	return copyAPIKey(key), nil
+}
+
+// GetByUser returns copies of the API keys of a user, oldest first
+func (db *APIKeyMemoryConnection) GetByUser(_ context.Context, userID uuid.UUID) ([]*model.APIKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := []*model.APIKey{}
+	for _, key := range db.keys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID.String() < keys[j].ID.String()
+	})
+	return keys, nil
+}
+
+// Rotate replaces the key of an API key of the user, the previous key stops working.
+// Only a key which was not revoked and has not expired at the given time can be rotated
+func (db *APIKeyMemoryConnection) Rotate(_ context.Context, id, userID uuid.UUID, prefix string, hash []byte, at time.Time) (*model.APIKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key, ok := db.keys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil || !key.ExpiresAt.After(at) {
+		return nil, fmt.Errorf("Rotate: %w", model.ErrNotFound)
+	}
+	key.Prefix, key.Hash, key.RotatedAt = prefix, cloneBytes(hash), &at
+	return copyAPIKey(key), nil
+}
+
+// Revoke revokes an API key of the user which was not revoked yet
+func (db *APIKeyMemoryConnection) Revoke(_ context.Context, id, userID uuid.UUID, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key, ok := db.keys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return fmt.Errorf("Revoke: %w", model.ErrNotFound)
+	}
+	key.RevokedAt = &at
+	return nil
+}
+
+// RevokeUser revokes every API key of the user which was not revoked yet
+func (db *APIKeyMemoryConnection) RevokeUser(_ context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var revoked int64
+	for _, key := range db.keys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			revokedAt := at
+			key.RevokedAt = &revokedAt
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// Touch records the use of an API key, the last use never goes back in time
+func (db *APIKeyMemoryConnection) Touch(_ context.Context, id uuid.UUID, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key, ok := db.keys[id]
+	if ok && (key.LastUsedAt == nil || key.LastUsedAt.Before(at)) {
+		key.LastUsedAt = &at
+	}
+	return nil
+}
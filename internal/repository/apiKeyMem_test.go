@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRepository is implemented by every API key backend
+type apiKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	GetByHash(ctx context.Context, hash []byte) (*model.APIKey, error)
+	GetByUser(ctx context.Context, userID uuid.UUID) ([]*model.APIKey, error)
+	Rotate(ctx context.Context, id, userID uuid.UUID, prefix string, hash []byte, at time.Time) (*model.APIKey, error)
+	Revoke(ctx context.Context, id, userID uuid.UUID, at time.Time) error
+	RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error)
+	Touch(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+// checkAPIKeys finds, lists, rotates, touches and revokes API keys, one by one and per user
+func checkAPIKeys(t *testing.T, db apiKeyRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID := uuid.New()
+	key := &model.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      "batch",
+		Prefix:    "ak_first",
+		Hash:      []byte(uuid.NewString()),
+		Scopes:    []string{model.PermissionPersonRead},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	other := &model.APIKey{ID: uuid.New(), UserID: userID, Name: "other", Prefix: "ak_other", Hash: []byte(uuid.NewString()),
+		Scopes: []string{model.PermissionPersonWrite}, CreatedAt: now.Add(time.Second), ExpiresAt: now}
+	require.NoError(t, db.Create(ctx, key))
+	require.NoError(t, db.Create(ctx, other))
+	require.Error(t, db.Create(ctx, key))
+
+	// Step 1: keys are found by hash and listed per user
+	found, err := db.GetByHash(ctx, key.Hash)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, found.ID)
+	require.Equal(t, []string{model.PermissionPersonRead}, found.Scopes)
+	_, err = db.GetByHash(ctx, []byte("unknown"))
+	require.ErrorIs(t, err, model.ErrNotFound)
+	keys, err := db.GetByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, key.ID, keys[0].ID)
+	keys, err = db.GetByUser(ctx, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// Step 2: the last use only moves forward
+	require.NoError(t, db.Touch(ctx, key.ID, now.Add(time.Minute)))
+	require.NoError(t, db.Touch(ctx, key.ID, now))
+	found, err = db.GetByHash(ctx, key.Hash)
+	require.NoError(t, err)
+	require.NotNil(t, found.LastUsedAt)
+	require.True(t, found.LastUsedAt.Equal(now.Add(time.Minute)))
+
+	// Step 3: rotation replaces the hash of active keys of the user
+	hash := []byte(uuid.NewString())
+	_, err = db.Rotate(ctx, key.ID, uuid.New(), "ak_second", hash, now)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = db.Rotate(ctx, other.ID, userID, "ak_second", hash, now)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	rotated, err := db.Rotate(ctx, key.ID, userID, "ak_second", hash, now)
+	require.NoError(t, err)
+	require.Equal(t, "ak_second", rotated.Prefix)
+	require.NotNil(t, rotated.RotatedAt)
+	_, err = db.GetByHash(ctx, key.Hash)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 4: a key is revoked once and can't be rotated afterwards
+	require.ErrorIs(t, db.Revoke(ctx, key.ID, uuid.New(), now), model.ErrNotFound)
+	require.NoError(t, db.Revoke(ctx, key.ID, userID, now))
+	require.ErrorIs(t, db.Revoke(ctx, key.ID, userID, now), model.ErrNotFound)
+	found, err = db.GetByHash(ctx, hash)
+	require.NoError(t, err)
+	require.NotNil(t, found.RevokedAt)
+	_, err = db.Rotate(ctx, key.ID, userID, "ak_third", []byte(uuid.NewString()), now)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 5: revoking the keys of the user revokes the ones which are still active
+	revoked, err := db.RevokeUser(ctx, userID, now)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, revoked)
+	found, err = db.GetByHash(ctx, other.Hash)
+	require.NoError(t, err)
+	require.NotNil(t, found.RevokedAt)
+	revoked, err = db.RevokeUser(ctx, userID, now)
+	require.NoError(t, err)
+	require.Zero(t, revoked)
+}
+
+func TestAPIKeyMemory(t *testing.T) {
+	checkAPIKeys(t, NewAPIKeyMemoryConnection())
+}
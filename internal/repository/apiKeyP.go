@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// apiKeyColumns are the selected columns of the api_key table, in the order scanAPIKey reads them
+const apiKeyColumns = `id, user_id, name, prefix, hash, scopes, created_at, rotated_at, expires_at, last_used_at, revoked_at`
+
+// APIKeyPsqlConnection struct represents a connection to the api_key table
+type APIKeyPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewAPIKeyPsqlConnection is a constructor for APIKeyPsqlConnection
+func NewAPIKeyPsqlConnection(pool *pgxpool.Pool) *APIKeyPsqlConnection {
+	return &APIKeyPsqlConnection{pool: pool}
+}
+
+// scanAPIKey scans a row of apiKeyColumns
+func scanAPIKey(row pgx.Row) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.Scopes, &key.CreatedAt, &key.RotatedAt,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Create function executes SQL request to insert a new API key
+func (db *APIKeyPsqlConnection) Create(ctx context.Context, key *model.APIKey) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.api_key (id, user_id, name, prefix, hash, scopes, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, key.Scopes, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// GetByHash function executes SQL request to select the API key with the given hash
+func (db *APIKeyPsqlConnection) GetByHash(ctx context.Context, hash []byte) (*model.APIKey, error) {
+	key, err := scanAPIKey(db.pool.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM goschema.api_key WHERE hash=$1`, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return key, nil
+}
+
+// GetByUser function executes SQL request to select the API keys of a user, oldest first
+func (db *APIKeyPsqlConnection) GetByUser(ctx context.Context, userID uuid.UUID) ([]*model.APIKey, error) {
+	rows, err := db.pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM goschema.api_key WHERE user_id=$1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Err(): %w", err)
+	}
+	return keys, nil
+}
+
+// Rotate function executes SQL request replacing the key of an API key of the user, the previous key stops working.
+// Only a key which was not revoked and has not expired at the given time can be rotated
+func (db *APIKeyPsqlConnection) Rotate(ctx context.Context, id, userID uuid.UUID, prefix string, hash []byte, at time.Time) (*model.APIKey, error) {
+	key, err := scanAPIKey(db.pool.QueryRow(ctx, `UPDATE goschema.api_key SET prefix=$3, hash=$4, rotated_at=$5
+	WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL AND expires_at > $5
+	RETURNING `+apiKeyColumns, id, userID, prefix, hash, at))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return key, nil
+}
+
+// Revoke function executes SQL request revoking an API key of the user which was not revoked yet
+func (db *APIKeyPsqlConnection) Revoke(ctx context.Context, id, userID uuid.UUID, at time.Time) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.api_key SET revoked_at=$3 WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`,
+		id, userID, at)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Revoke: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// RevokeUser function executes SQL request revoking every API key of the user which was not revoked yet,
+// within the transaction of ctx if there is one
+func (db *APIKeyPsqlConnection) RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	tag, err := conn(ctx, db.pool).Exec(ctx, `UPDATE goschema.api_key SET revoked_at=$2 WHERE user_id=$1 AND revoked_at IS NULL`,
+		userID, at)
+	if err != nil {
+		return 0, fmt.Errorf("Exec(): %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Touch function executes SQL request recording the use of an API key, the last use never goes back in time
+func (db *APIKeyPsqlConnection) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := db.pool.Exec(ctx, `UPDATE goschema.api_key SET last_used_at=$2 WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < $2)`,
+		id, at)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
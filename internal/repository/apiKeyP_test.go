@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var apiKeyP *APIKeyPsqlConnection
+
+func TestPgxAPIKey(t *testing.T) {
+	checkAPIKeys(t, apiKeyP)
+}
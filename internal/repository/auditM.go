@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditMongoDBConnection is a struct, which contains *mongo.Client variable
+type AuditMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewAuditMongoDBConnection func is a constructor of AuditMongoDBConnection struct
+func NewAuditMongoDBConnection(client *mongo.Client) *AuditMongoDBConnection {
+	return &AuditMongoDBConnection{client: client}
+}
+
+// Add function executes "db.person_audit.insertOne()" command
+func (db *AuditMongoDBConnection) Add(ctx context.Context, record *model.AuditRecord) error {
+	collection := db.client.Database("my_mongo_base").Collection("person_audit")
+	_, err := collection.InsertOne(ctx, record)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// Find function executes "db.person_audit.find()" for records matching the query, newest first
+func (db *AuditMongoDBConnection) Find(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person_audit")
+	filter := bson.M{}
+	if query.PersonID != nil {
+		filter["person_id"] = *query.PersonID
+	}
+	if query.ActorID != nil {
+		filter["actor_id"] = *query.ActorID
+	}
+	createdAt := bson.M{}
+	if query.From != nil {
+		createdAt["$gte"] = *query.From
+	}
+	if query.To != nil {
+		createdAt["$lt"] = *query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(auditLimit(query)))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []*model.AuditRecord
+	for cursor.Next(ctx) {
+		var record *model.AuditRecord
+		err = cursor.Decode(&record)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		results = append(results, record)
+	}
+	return results, cursor.Err()
+}
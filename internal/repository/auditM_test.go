@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var auditM *AuditMongoDBConnection
+
+func TestMongoAudit(t *testing.T) {
+	checkAudit(t, auditM)
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/eugenshima/myapp/internal/model"
+)
+
+// AuditMemoryConnection is an in-memory audit trail, safe for concurrent use
+type AuditMemoryConnection struct {
+	mu      sync.RWMutex
+	records []model.AuditRecord
+}
+
+// NewAuditMemoryConnection is a constructor for AuditMemoryConnection
+func NewAuditMemoryConnection() *AuditMemoryConnection {
+	return &AuditMemoryConnection{}
+}
+
+// Add appends a copy of the audit record
+func (db *AuditMemoryConnection) Add(_ context.Context, record *model.AuditRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.records = append(db.records, copyAuditRecord(record))
+	return nil
+}
+
+// Find returns copies of the audit records matching the query, newest first
+func (db *AuditMemoryConnection) Find(_ context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error) {
+	db.mu.RLock()
+	var results []*model.AuditRecord
+	for i := range db.records {
+		record := copyAuditRecord(&db.records[i])
+		switch {
+		case query.PersonID != nil && record.PersonID != *query.PersonID,
+			query.ActorID != nil && record.ActorID != *query.ActorID,
+			query.From != nil && record.CreatedAt.Before(*query.From),
+			query.To != nil && !record.CreatedAt.Before(*query.To):
+			continue
+		}
+		results = append(results, &record)
+	}
+	db.mu.RUnlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	if limit := auditLimit(query); len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// copyAuditRecord copies the record together with its snapshots
+func copyAuditRecord(record *model.AuditRecord) model.AuditRecord {
+	cp := *record
+	if record.Before != nil {
+		before := *record.Before
+		cp.Before = &before
+	}
+	if record.After != nil {
+		after := *record.After
+		cp.After = &after
+	}
+	return cp
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// auditRepository is implemented by every audit trail backend
+type auditRepository interface {
+	Add(ctx context.Context, record *model.AuditRecord) error
+	Find(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error)
+}
+
+// checkAudit adds records of two persons by two actors and queries them back
+func checkAudit(t *testing.T, db auditRepository) {
+	ctx := context.Background()
+	personID, otherID := uuid.New(), uuid.New()
+	adminID, otherAdminID := uuid.New(), uuid.New()
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	person := model.Person{ID: personID, Name: "Eugen", Age: 20, IsHealthy: true, Version: 1}
+	updated := person
+	updated.Age, updated.Version = 21, 2
+
+	records := []*model.AuditRecord{
+		{PersonID: personID, ActorID: adminID, Action: model.AuditCreate, After: &person},
+		{PersonID: personID, ActorID: otherAdminID, Action: model.AuditUpdate, Before: &person, After: &updated},
+		{PersonID: otherID, ActorID: adminID, Action: model.AuditCreate},
+		{PersonID: personID, ActorID: adminID, Action: model.AuditDelete, Before: &updated},
+	}
+	for i, record := range records {
+		record.ID = uuid.New()
+		record.ActorRole = "admin"
+		record.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		require.NoError(t, db.Add(ctx, record))
+	}
+
+	// Step 1: Trail of a person, newest first
+	trail, err := db.Find(ctx, &model.AuditQuery{PersonID: &personID})
+	require.NoError(t, err)
+	require.Len(t, trail, 3)
+	require.Equal(t, model.AuditDelete, trail[0].Action)
+	require.Nil(t, trail[0].After)
+	require.Equal(t, updated, *trail[0].Before)
+	require.Equal(t, model.AuditUpdate, trail[1].Action)
+	require.Equal(t, person, *trail[1].Before)
+	require.Equal(t, otherAdminID, trail[1].ActorID)
+	require.True(t, records[1].CreatedAt.Equal(trail[1].CreatedAt))
+
+	// Step 2: Changes of an actor within a time range
+	from, to := start.Add(time.Second), start.Add(3*time.Second)
+	trail, err = db.Find(ctx, &model.AuditQuery{ActorID: &adminID, From: &from, To: &to})
+	require.NoError(t, err)
+	require.Len(t, trail, 1)
+	require.Equal(t, otherID, trail[0].PersonID)
+
+	// Step 3: Limit
+	trail, err = db.Find(ctx, &model.AuditQuery{PersonID: &personID, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, trail, 1)
+	require.Equal(t, records[3].ID, trail[0].ID)
+}
+
+func TestMemoryAudit(t *testing.T) {
+	checkAudit(t, NewAuditMemoryConnection())
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// DefaultAuditLimit is the number of audit records returned when the query has no limit
+const DefaultAuditLimit = 100
+
+// AuditPsqlConnection is a struct, which contains Pool variable
+type AuditPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewAuditPsqlConnection constructor for AuditPsqlConnection
+func NewAuditPsqlConnection(pool *pgxpool.Pool) *AuditPsqlConnection {
+	return &AuditPsqlConnection{pool: pool}
+}
+
+// Add function executes SQL request to insert an audit record
+func (db *AuditPsqlConnection) Add(ctx context.Context, record *model.AuditRecord) error {
+	before, err := snapshot(record.Before)
+	if err != nil {
+		return err
+	}
+	after, err := snapshot(record.After)
+	if err != nil {
+		return err
+	}
+	_, err = conn(ctx, db.pool).Exec(ctx, `INSERT INTO goschema.person_audit (id, person_id, actor_id, actor_role, action, before, after, created_at)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8)`,
+		record.ID, record.PersonID, record.ActorID, record.ActorRole, record.Action, before, after, record.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Find function executes SQL request to select audit records matching the query, newest first
+func (db *AuditPsqlConnection) Find(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error) {
+	var conds []string
+	var args []interface{}
+	where := func(cond string, value interface{}) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if query.PersonID != nil {
+		where("person_id = $%d", *query.PersonID)
+	}
+	if query.ActorID != nil {
+		where("actor_id = $%d", *query.ActorID)
+	}
+	if query.From != nil {
+		where("created_at >= $%d", *query.From)
+	}
+	if query.To != nil {
+		where("created_at < $%d", *query.To)
+	}
+	args = append(args, auditLimit(query))
+	sql := fmt.Sprintf(`SELECT id, person_id, actor_id, actor_role, action, before, after, created_at
+	FROM goschema.person_audit%s ORDER BY created_at DESC, id LIMIT $%d`, sqlWhere(conds), len(args))
+	rows, err := conn(ctx, db.pool).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	var results []*model.AuditRecord
+	for rows.Next() {
+		record := &model.AuditRecord{}
+		var before, after []byte
+		err := rows.Scan(&record.ID, &record.PersonID, &record.ActorID, &record.ActorRole, &record.Action, &before, &after, &record.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		if record.Before, err = unmarshalSnapshot(before); err != nil {
+			return nil, err
+		}
+		if record.After, err = unmarshalSnapshot(after); err != nil {
+			return nil, err
+		}
+		results = append(results, record)
+	}
+	return results, rows.Err()
+}
+
+// snapshot encodes a person for a jsonb column, a missing person is stored as NULL
+func snapshot(person *model.Person) ([]byte, error) {
+	if person == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(person)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	return raw, nil
+}
+
+// unmarshalSnapshot decodes a person from a jsonb column
+func unmarshalSnapshot(raw []byte) (*model.Person, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	person := &model.Person{}
+	err := json.Unmarshal(raw, person)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal(): %w", err)
+	}
+	return person, nil
+}
+
+// auditLimit returns the query limit or the default one
+func auditLimit(query *model.AuditQuery) int {
+	if query.Limit <= 0 {
+		return DefaultAuditLimit
+	}
+	return query.Limit
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var auditP *AuditPsqlConnection
+
+func TestPgxAudit(t *testing.T) {
+	checkAudit(t, auditP)
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// const for the cache bus
+const (
+	invalidationChannel = cacheNamespace + ":cache:invalidate"
+	busRetryInterval    = time.Second
+	// flushAll is the target of an invalidation clearing the whole in-process tier of an entity
+	flushAll = "*"
+)
+
+// CacheBus broadcasts cache invalidations to every instance over Redis pub/sub,
+// so the in-process tiers drop entries changed elsewhere
+type CacheBus struct {
+	rdb      *redis.Client
+	instance string
+	mu       sync.RWMutex
+	drops    map[string]func(id uuid.UUID)
+	flushes  map[string]func()
+	clears   []func()
+}
+
+// NewCacheBus creates a new CacheBus, call Run to start receiving invalidations
+func NewCacheBus(rdb *redis.Client) *CacheBus {
+	return &CacheBus{rdb: rdb, instance: uuid.NewString(), drops: make(map[string]func(id uuid.UUID)), flushes: make(map[string]func())}
+}
+
+// subscribe registers the in-process tier of an entity, drop is called with invalidated IDs
+// and clear when the entity is flushed or invalidations could have been missed
+func (bus *CacheBus) subscribe(entity string, drop func(id uuid.UUID), clear func()) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.drops[entity] = drop
+	bus.flushes[entity] = clear
+	bus.clears = append(bus.clears, clear)
+}
+
+// Publish func tells the other instances to drop an entity from their in-process tier
+func (bus *CacheBus) Publish(ctx context.Context, entity string, id uuid.UUID) error {
+	return bus.publish(ctx, entity, id.String())
+}
+
+// PublishFlush func tells the other instances to clear the in-process tier of an entity
+func (bus *CacheBus) PublishFlush(ctx context.Context, entity string) error {
+	return bus.publish(ctx, entity, flushAll)
+}
+
+// publish sends an invalidation of the target, an ID or flushAll
+func (bus *CacheBus) publish(ctx context.Context, entity, target string) error {
+	err := bus.rdb.Publish(ctx, invalidationChannel, strings.Join([]string{entity, target, bus.instance}, " ")).Err()
+	if err != nil {
+		return fmt.Errorf(" Publish: %w", err)
+	}
+	return nil
+}
+
+// Run func receives invalidations until ctx is done. Every (re)subscription clears
+// the in-process tiers, since invalidations published while disconnected are lost.
+func (bus *CacheBus) Run(ctx context.Context) {
+	pubsub := bus.rdb.Subscribe(ctx, invalidationChannel)
+	defer func() {
+		if err := pubsub.Close(); err != nil {
+			logrus.Errorf("Close: %v", err)
+		}
+	}()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			logrus.Errorf("cache bus: Receive: %v", err)
+			bus.clear()
+			time.Sleep(busRetryInterval)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			bus.clear()
+		case *redis.Message:
+			bus.receive(msg.Payload)
+		}
+	}
+}
+
+// receive drops the entity named in an invalidation published by another instance, or all of them on a flush
+func (bus *CacheBus) receive(payload string) {
+	parts := strings.Split(payload, " ")
+	if len(parts) != 3 {
+		logrus.Errorf("cache bus: malformed invalidation %q", payload)
+		return
+	}
+	if parts[2] == bus.instance {
+		return
+	}
+	if parts[1] == flushAll {
+		bus.mu.RLock()
+		flush, ok := bus.flushes[parts[0]]
+		bus.mu.RUnlock()
+		if ok {
+			flush()
+		}
+		return
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		logrus.Errorf("cache bus: malformed invalidation %q: %v", payload, err)
+		return
+	}
+	bus.mu.RLock()
+	drop, ok := bus.drops[parts[0]]
+	bus.mu.RUnlock()
+	if ok {
+		drop(id)
+	}
+}
+
+// clear empties every in-process tier
+func (bus *CacheBus) clear() {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for _, clear := range bus.clears {
+		clear()
+	}
+}
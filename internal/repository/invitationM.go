@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvitationMongoDBConnection is a struct, which contains *mongo.Client variable
+type InvitationMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewInvitationMongoDBConnection func is a constructor of InvitationMongoDBConnection struct
+func NewInvitationMongoDBConnection(client *mongo.Client) *InvitationMongoDBConnection {
+	return &InvitationMongoDBConnection{client: client}
+}
+
+// collection returns the invitation collection
+func (db *InvitationMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("invitation")
+}
+
+// Create function executes "db.invitation.insertOne()" command
+func (db *InvitationMongoDBConnection) Create(ctx context.Context, invitation *model.Invitation) error {
+	_, err := db.collection().InsertOne(ctx, invitation)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// Redeem function executes "db.invitation.findOneAndUpdate()" marking the invitation with the given code hash as used by a user,
+// only an invitation which was not used and has not expired at the given time can be redeemed, even concurrently
+func (db *InvitationMongoDBConnection) Redeem(ctx context.Context, codeHash []byte, userID uuid.UUID, at time.Time) (*model.Invitation, error) {
+	filter := bson.M{"code_hash": codeHash, "used_at": nil, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"used_at": at, "used_by": userID}}
+	invitation := &model.Invitation{}
+	err := db.collection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(invitation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", model.ErrInvalidInvitation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", err)
+	}
+	return invitation, nil
+}
+
+// Release function executes "db.invitation.updateOne()" making an invitation redeemed by the given user usable again
+func (db *InvitationMongoDBConnection) Release(ctx context.Context, codeHash []byte, userID uuid.UUID) error {
+	res, err := db.collection().UpdateOne(ctx, bson.M{"code_hash": codeHash, "used_by": userID},
+		bson.M{"$set": bson.M{"used_at": nil, "used_by": nil}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne: %w", model.ErrNotFound)
+	}
+	return nil
+}
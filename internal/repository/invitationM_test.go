@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var invitationM *InvitationMongoDBConnection
+
+func TestMongoInvitation(t *testing.T) {
+	checkInvitations(t, invitationM)
+}
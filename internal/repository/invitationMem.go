@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// InvitationMemoryConnection is an in-memory invitation storage, safe for concurrent use
+type InvitationMemoryConnection struct {
+	mu          sync.Mutex
+	invitations map[uuid.UUID]*model.Invitation
+}
+
+// NewInvitationMemoryConnection is a constructor for InvitationMemoryConnection
+func NewInvitationMemoryConnection() *InvitationMemoryConnection {
+	return &InvitationMemoryConnection{invitations: make(map[uuid.UUID]*model.Invitation)}
+}
+
+// copyInvitation returns a deep copy of the invitation
+func copyInvitation(invitation *model.Invitation) *model.Invitation {
+	cp := *invitation
+	cp.CodeHash = cloneBytes(invitation.CodeHash)
+	if invitation.UsedAt != nil {
+		usedAt := *invitation.UsedAt
+		cp.UsedAt = &usedAt
+	}
+	if invitation.UsedBy != nil {
+		usedBy := *invitation.UsedBy
+		cp.UsedBy = &usedBy
+	}
+	return &cp
+}
+
+// find returns the stored invitation with the given code hash, the caller must hold the lock
+func (db *InvitationMemoryConnection) find(codeHash []byte) *model.Invitation {
+	for _, invitation := range db.invitations {
+		if bytes.Equal(invitation.CodeHash, codeHash) {
+			return invitation
+		}
+	}
+	return nil
+}
+
+// Create stores a copy of a new invitation
+func (db *InvitationMemoryConnection) Create(_ context.Context, invitation *model.Invitation) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.invitations[invitation.ID]; ok || db.find(invitation.CodeHash) != nil {
+		return fmt.Errorf("Create: invitation %v already exists", invitation.ID)
+	}
+	db.invitations[invitation.ID] = copyInvitation(invitation)
+	return nil
+}
+
+// Redeem marks the invitation with the given code hash as used by a user,
+// only an invitation which was not used and has not expired at the given time can be redeemed
+func (db *InvitationMemoryConnection) Redeem(_ context.Context, codeHash []byte, userID uuid.UUID, at time.Time) (*model.Invitation, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	invitation := db.find(codeHash)
+	if invitation == nil || invitation.UsedAt != nil || !invitation.ExpiresAt.After(at) {
+		return nil, fmt.Errorf("Redeem: %w", model.ErrInvalidInvitation)
+	}
+	invitation.UsedAt, invitation.UsedBy = &at, &userID
+	return copyInvitation(invitation), nil
+}
+
+// Release makes an invitation redeemed by the given user usable again
+func (db *InvitationMemoryConnection) Release(_ context.Context, codeHash []byte, userID uuid.UUID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	invitation := db.find(codeHash)
+	if invitation == nil || invitation.UsedBy == nil || *invitation.UsedBy != userID {
+		return fmt.Errorf("Release: %w", model.ErrNotFound)
+	}
+	invitation.UsedAt, invitation.UsedBy = nil, nil
+	return nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// invitationRepository is implemented by every invitation backend
+type invitationRepository interface {
+	Create(ctx context.Context, invitation *model.Invitation) error
+	Redeem(ctx context.Context, codeHash []byte, userID uuid.UUID, at time.Time) (*model.Invitation, error)
+	Release(ctx context.Context, codeHash []byte, userID uuid.UUID) error
+}
+
+// checkInvitations redeems, releases and concurrently redeems invitations
+func checkInvitations(t *testing.T, db invitationRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	invitation := &model.Invitation{
+		ID:        uuid.New(),
+		CodeHash:  []byte(uuid.NewString()),
+		Role:      model.RoleAdmin,
+		CreatedBy: uuid.New(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, db.Create(ctx, invitation))
+	require.Error(t, db.Create(ctx, invitation))
+
+	// Step 1: an invitation is redeemed once
+	userID := uuid.New()
+	redeemed, err := db.Redeem(ctx, invitation.CodeHash, userID, now)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, redeemed.Role)
+	require.NotNil(t, redeemed.UsedBy)
+	require.Equal(t, userID, *redeemed.UsedBy)
+	_, err = db.Redeem(ctx, invitation.CodeHash, uuid.New(), now)
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+	_, err = db.Redeem(ctx, []byte("unknown"), userID, now)
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+
+	// Step 2: only the user who redeemed it can release it, then it can be redeemed again
+	require.ErrorIs(t, db.Release(ctx, invitation.CodeHash, uuid.New()), model.ErrNotFound)
+	require.NoError(t, db.Release(ctx, invitation.CodeHash, userID))
+
+	// Step 3: concurrent redeems let one user in
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Redeem(ctx, invitation.CodeHash, uuid.New(), now)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, succeeded)
+
+	// Step 4: an expired invitation can't be redeemed
+	expired := &model.Invitation{ID: uuid.New(), CodeHash: []byte(uuid.NewString()), Role: model.RoleAdmin, CreatedAt: now, ExpiresAt: now}
+	require.NoError(t, db.Create(ctx, expired))
+	_, err = db.Redeem(ctx, expired.CodeHash, userID, now)
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+}
+
+func TestInvitationMemory(t *testing.T) {
+	checkInvitations(t, NewInvitationMemoryConnection())
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// InvitationPsqlConnection struct represents a connection to the invitation table
+type InvitationPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewInvitationPsqlConnection is a constructor for InvitationPsqlConnection
+func NewInvitationPsqlConnection(pool *pgxpool.Pool) *InvitationPsqlConnection {
+	return &InvitationPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to insert a new invitation
+func (db *InvitationPsqlConnection) Create(ctx context.Context, invitation *model.Invitation) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.invitation (id, code_hash, role, created_by, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`,
+		invitation.ID, invitation.CodeHash, invitation.Role, invitation.CreatedBy, invitation.CreatedAt, invitation.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Redeem function executes SQL request marking the invitation with the given code hash as used by a user,
+// only an invitation which was not used and has not expired at the given time can be redeemed, even concurrently
+func (db *InvitationPsqlConnection) Redeem(ctx context.Context, codeHash []byte, userID uuid.UUID, at time.Time) (*model.Invitation, error) {
+	invitation := &model.Invitation{}
+	err := db.pool.QueryRow(ctx, `UPDATE goschema.invitation SET used_at=$3, used_by=$2
+	WHERE code_hash=$1 AND used_at IS NULL AND expires_at > $3
+	RETURNING id, code_hash, role, created_by, created_at, expires_at, used_at, used_by`, codeHash, userID, at).
+		Scan(&invitation.ID, &invitation.CodeHash, &invitation.Role, &invitation.CreatedBy, &invitation.CreatedAt,
+			&invitation.ExpiresAt, &invitation.UsedAt, &invitation.UsedBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrInvalidInvitation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return invitation, nil
+}
+
+// Release function executes SQL request making an invitation redeemed by the given user usable again
+func (db *InvitationPsqlConnection) Release(ctx context.Context, codeHash []byte, userID uuid.UUID) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.invitation SET used_at=NULL, used_by=NULL WHERE code_hash=$1 AND used_by=$2`, codeHash, userID)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Release: %w", model.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var invitationP *InvitationPsqlConnection
+
+func TestPgxInvitation(t *testing.T) {
+	checkInvitations(t, invitationP)
+}
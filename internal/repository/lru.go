@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is a bounded in-process cache evicting the least recently used entry,
+// entries also expire after their TTL
+type lru[K comparable, V any] struct {
+	mu        sync.Mutex
+	size      int
+	order     *list.List
+	entries   map[K]*list.Element
+	evictions uint64
+}
+
+// lruItem is an entry of the lru list
+type lruItem[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// newLRU creates an lru holding up to size entries
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	return &lru[K, V]{size: size, order: list.New(), entries: make(map[K]*list.Element)}
+}
+
+// get returns a live entry and marks it as recently used
+func (c *lru[K, V]) get(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	item := elem.Value.(*lruItem[K, V])
+	if time.Now().After(item.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.evictions++
+		return value, false
+	}
+	c.order.MoveToFront(elem)
+	return item.value, true
+}
+
+// peek reports whether a live entry exists without marking it as recently used
+func (c *lru[K, V]) peek(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	return ok && !time.Now().After(elem.Value.(*lruItem[K, V]).expiresAt)
+}
+
+// add stores an entry for ttl evicting the least recently used one when full
+func (c *lru[K, V]) add(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &lruItem[K, V]{key: key, value: value, expiresAt: time.Now().Add(ttl)}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruItem[K, V]{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruItem[K, V]).key)
+		c.evictions++
+	}
+}
+
+// remove drops an entry
+func (c *lru[K, V]) remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// clear drops every entry
+func (c *lru[K, V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[K]*list.Element)
+}
+
+// len returns the number of entries, expired ones included
+func (c *lru[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// evicted returns the number of entries dropped because the lru was full or they expired
+func (c *lru[K, V]) evicted() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUEviction(t *testing.T) {
+	cache := newLRU[string, int](2)
+	cache.add("a", 1, time.Minute)
+	cache.add("b", 2, time.Minute)
+	// reading a makes b the least recently used entry
+	_, ok := cache.get("a")
+	require.True(t, ok)
+	cache.add("c", 3, time.Minute)
+	require.Equal(t, 2, cache.len())
+	require.Equal(t, uint64(1), cache.evicted())
+	require.False(t, cache.peek("b"))
+	_, ok = cache.get("b")
+	require.False(t, ok)
+	value, ok := cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, value)
+	cache.remove("a")
+	_, ok = cache.get("a")
+	require.False(t, ok)
+	cache.clear()
+	require.Zero(t, cache.len())
+}
+
+func TestLRUExpiry(t *testing.T) {
+	cache := newLRU[string, int](2)
+	cache.add("a", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	require.False(t, cache.peek("a"))
+	_, ok := cache.get("a")
+	require.False(t, ok)
+	require.Zero(t, cache.len())
+	require.Equal(t, uint64(1), cache.evicted())
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MFAMongoDBConnection is a struct, which contains *mongo.Client variable
+type MFAMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewMFAMongoDBConnection func is a constructor of MFAMongoDBConnection struct
+func NewMFAMongoDBConnection(client *mongo.Client) *MFAMongoDBConnection {
+	return &MFAMongoDBConnection{client: client}
+}
+
+// collection returns the user_mfa collection
+func (db *MFAMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("user_mfa")
+}
+
+// Create function executes "db.user_mfa.updateOne()" with upsert storing a new second factor of a user, it replaces
+// a second factor which was not confirmed yet, while a confirmed one is kept and model.ErrMFAEnabled is returned
+func (db *MFAMongoDBConnection) Create(ctx context.Context, mfa *model.MFA) error {
+	filter := bson.M{"_id": mfa.UserID, "confirmed_at": nil}
+	update := bson.M{"$set": bson.M{"secret": mfa.Secret, "recovery_codes": []string{}, "last_step": 0, "created_at": mfa.CreatedAt, "confirmed_at": nil}}
+	_, err := db.collection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("UpdateOne: %w", model.ErrMFAEnabled)
+	}
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	return nil
+}
+
+// Get function executes "db.user_mfa.findOne()" returning the second factor of a user
+func (db *MFAMongoDBConnection) Get(ctx context.Context, userID uuid.UUID) (*model.MFA, error) {
+	mfa := &model.MFA{}
+	err := db.collection().FindOne(ctx, bson.M{"_id": userID}).Decode(mfa)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOne: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOne: %w", err)
+	}
+	return mfa, nil
+}
+
+// Confirm function executes "db.user_mfa.updateOne()" enabling the second factor of a user with the hashes of its
+// recovery codes and the time step of the confirming code, only a second factor which was not confirmed yet can be confirmed
+func (db *MFAMongoDBConnection) Confirm(ctx context.Context, userID uuid.UUID, recoveryCodes []string, step int64, at time.Time) error {
+	filter := bson.M{"_id": userID, "confirmed_at": nil}
+	update := bson.M{"$set": bson.M{"confirmed_at": at, "recovery_codes": recoveryCodes, "last_step": step}}
+	res, err := db.collection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// UseStep function executes "db.user_mfa.updateOne()" accepting a code of the given time step,
+// every time step is accepted once, even concurrently, and never after a later one
+func (db *MFAMongoDBConnection) UseStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	filter := bson.M{"_id": userID, "last_step": bson.M{"$lt": step}}
+	res, err := db.collection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_step": step}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne: %w", model.ErrInvalidMFACode)
+	}
+	return nil
+}
+
+// UseRecoveryCode function executes "db.user_mfa.updateOne()" removing the recovery code with the given hash,
+// every code is used once
+func (db *MFAMongoDBConnection) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	filter := bson.M{"_id": userID, "recovery_codes": codeHash}
+	res, err := db.collection().UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": codeHash}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne: %w", model.ErrInvalidMFACode)
+	}
+	return nil
+}
+
+// Delete function executes "db.user_mfa.deleteOne()" removing the second factor of a user
+func (db *MFAMongoDBConnection) Delete(ctx context.Context, userID uuid.UUID) error {
+	res, err := db.collection().DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return fmt.Errorf("DeleteOne: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("DeleteOne: %w", model.ErrNotFound)
+	}
+	return nil
+}
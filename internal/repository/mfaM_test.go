@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var mfaM *MFAMongoDBConnection
+
+func TestMongoMFA(t *testing.T) {
+	checkMFA(t, mfaM)
+}
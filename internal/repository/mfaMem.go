@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// MFAMemoryConnection is an in-memory second factor storage, safe for concurrent use
+type MFAMemoryConnection struct {
+	mu      sync.Mutex
+	factors map[uuid.UUID]*model.MFA
+}
+
+// NewMFAMemoryConnection is a constructor for MFAMemoryConnection
+func NewMFAMemoryConnection() *MFAMemoryConnection {
+	return &MFAMemoryConnection{factors: make(map[uuid.UUID]*model.MFA)}
+}
+
+// copyMFA returns a deep copy of the second factor
+func copyMFA(mfa *model.MFA) *model.MFA {
+	cp := *mfa
+	cp.Secret = cloneBytes(mfa.Secret)
+	cp.RecoveryCodes = append([]string(nil), mfa.RecoveryCodes...)
+	if mfa.ConfirmedAt != nil {
+		confirmedAt := *mfa.ConfirmedAt
+		cp.ConfirmedAt = &confirmedAt
+	}
+	return &cp
+}
+
+// Create stores a new second factor of a user, it replaces a second factor which was not confirmed yet,
+// while a confirmed one is kept and model.ErrMFAEnabled is returned
+func (db *MFAMemoryConnection) Create(_ context.Context, mfa *model.MFA) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if stored, ok := db.factors[mfa.UserID]; ok && stored.ConfirmedAt != nil {
+		return fmt.Errorf("Create: %w", model.ErrMFAEnabled)
+	}
+	db.factors[mfa.UserID] = &model.MFA{UserID: mfa.UserID, Secret: cloneBytes(mfa.Secret), CreatedAt: mfa.CreatedAt}
+	return nil
+}
+
+// Get returns the second factor of a user
+func (db *MFAMemoryConnection) Get(_ context.Context, userID uuid.UUID) (*model.MFA, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	mfa, ok := db.factors[userID]
+	if !ok {
+		return nil, fmt.Errorf("Get: second factor of %v: %w", userID, model.ErrNotFound)
+	}
+	return copyMFA(mfa), nil
+}
+
+// Confirm enables the second factor of a user with the hashes of its recovery codes and the time step
+// of the confirming code, only a second factor which was not confirmed yet can be confirmed
+func (db *MFAMemoryConnection) Confirm(_ context.Context, userID uuid.UUID, recoveryCodes []string, step int64, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	mfa, ok := db.factors[userID]
+	if !ok || mfa.ConfirmedAt != nil {
+		return fmt.Errorf("Confirm: second factor of %v: %w", userID, model.ErrNotFound)
+	}
+	mfa.ConfirmedAt = &at
+	mfa.RecoveryCodes = append([]string(nil), recoveryCodes...)
+	mfa.LastStep = step
+	return nil
+}
+
+// UseStep accepts a code of the given time step, every time step is accepted once and never after a later one
+func (db *MFAMemoryConnection) UseStep(_ context.Context, userID uuid.UUID, step int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	mfa, ok := db.factors[userID]
+	if !ok || mfa.LastStep >= step {
+		return fmt.Errorf("UseStep: %w", model.ErrInvalidMFACode)
+	}
+	mfa.LastStep = step
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code with the given hash, every code is used once
+func (db *MFAMemoryConnection) UseRecoveryCode(_ context.Context, userID uuid.UUID, codeHash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	mfa, ok := db.factors[userID]
+	if ok {
+		for i, code := range mfa.RecoveryCodes {
+			if code == codeHash {
+				mfa.RecoveryCodes = append(mfa.RecoveryCodes[:i], mfa.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("UseRecoveryCode: %w", model.ErrInvalidMFACode)
+}
+
+// Delete removes the second factor of a user
+func (db *MFAMemoryConnection) Delete(_ context.Context, userID uuid.UUID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.factors[userID]; !ok {
+		return fmt.Errorf("Delete: second factor of %v: %w", userID, model.ErrNotFound)
+	}
+	delete(db.factors, userID)
+	return nil
+}
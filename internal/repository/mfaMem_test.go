@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// mfaRepository is implemented by every second factor backend
+type mfaRepository interface {
+	Create(ctx context.Context, mfa *model.MFA) error
+	Get(ctx context.Context, userID uuid.UUID) (*model.MFA, error)
+	Confirm(ctx context.Context, userID uuid.UUID, recoveryCodes []string, step int64, at time.Time) error
+	UseStep(ctx context.Context, userID uuid.UUID, step int64) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
+	Delete(ctx context.Context, userID uuid.UUID) error
+}
+
+// checkMFA enrolls, confirms and uses a second factor, concurrently as well
+func checkMFA(t *testing.T, db mfaRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID := uuid.New()
+	_, err := db.Get(ctx, userID)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.ErrorIs(t, db.Confirm(ctx, userID, nil, 1, now), model.ErrNotFound)
+
+	// Step 1: an unconfirmed second factor is replaced by a new enrollment
+	require.NoError(t, db.Create(ctx, &model.MFA{UserID: userID, Secret: []byte("first"), CreatedAt: now}))
+	require.NoError(t, db.Create(ctx, &model.MFA{UserID: userID, Secret: []byte("second"), CreatedAt: now}))
+	mfa, err := db.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), mfa.Secret)
+	require.Nil(t, mfa.ConfirmedAt)
+
+	// Step 2: a confirmed second factor is kept
+	require.NoError(t, db.Confirm(ctx, userID, []string{"a", "b"}, 10, now))
+	require.ErrorIs(t, db.Confirm(ctx, userID, nil, 11, now), model.ErrNotFound)
+	require.ErrorIs(t, db.Create(ctx, &model.MFA{UserID: userID, Secret: []byte("third"), CreatedAt: now}), model.ErrMFAEnabled)
+	mfa, err = db.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), mfa.Secret)
+	require.True(t, now.Equal(*mfa.ConfirmedAt))
+	require.Equal(t, []string{"a", "b"}, mfa.RecoveryCodes)
+	require.Equal(t, int64(10), mfa.LastStep)
+
+	// Step 3: every time step and recovery code is accepted once, even concurrently
+	require.ErrorIs(t, db.UseStep(ctx, userID, 10), model.ErrInvalidMFACode)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if db.UseStep(ctx, userID, 11) == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, accepted)
+	require.ErrorIs(t, db.UseStep(ctx, userID, 9), model.ErrInvalidMFACode)
+	require.NoError(t, db.UseRecoveryCode(ctx, userID, "a"))
+	require.ErrorIs(t, db.UseRecoveryCode(ctx, userID, "a"), model.ErrInvalidMFACode)
+	require.ErrorIs(t, db.UseRecoveryCode(ctx, uuid.New(), "b"), model.ErrInvalidMFACode)
+	mfa, err = db.Get(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, mfa.RecoveryCodes)
+
+	// Step 4: a deleted second factor can be enrolled again
+	require.NoError(t, db.Delete(ctx, userID))
+	require.ErrorIs(t, db.Delete(ctx, userID), model.ErrNotFound)
+	require.NoError(t, db.Create(ctx, &model.MFA{UserID: userID, Secret: []byte("third"), CreatedAt: now}))
+}
+
+func TestMFAMemory(t *testing.T) {
+	checkMFA(t, NewMFAMemoryConnection())
+}
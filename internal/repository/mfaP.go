@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// MFAPsqlConnection struct represents a connection to the user_mfa table
+type MFAPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewMFAPsqlConnection is a constructor for MFAPsqlConnection
+func NewMFAPsqlConnection(pool *pgxpool.Pool) *MFAPsqlConnection {
+	return &MFAPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to store a new second factor of a user, it replaces a second factor
+// which was not confirmed yet, while a confirmed one is kept and model.ErrMFAEnabled is returned
+func (db *MFAPsqlConnection) Create(ctx context.Context, mfa *model.MFA) error {
+	tag, err := db.pool.Exec(ctx, `INSERT INTO goschema.user_mfa (user_id, secret, created_at) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, recovery_codes='{}', last_step=0, created_at=EXCLUDED.created_at
+	WHERE goschema.user_mfa.confirmed_at IS NULL`, mfa.UserID, mfa.Secret, mfa.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Create: %w", model.ErrMFAEnabled)
+	}
+	return nil
+}
+
+// Get function executes SQL request to select the second factor of a user
+func (db *MFAPsqlConnection) Get(ctx context.Context, userID uuid.UUID) (*model.MFA, error) {
+	mfa := &model.MFA{}
+	err := db.pool.QueryRow(ctx, `SELECT user_id, secret, recovery_codes, last_step, created_at, confirmed_at
+	FROM goschema.user_mfa WHERE user_id=$1`, userID).
+		Scan(&mfa.UserID, &mfa.Secret, &mfa.RecoveryCodes, &mfa.LastStep, &mfa.CreatedAt, &mfa.ConfirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return mfa, nil
+}
+
+// Confirm function executes SQL request enabling the second factor of a user with the hashes of its recovery codes
+// and the time step of the confirming code, only a second factor which was not confirmed yet can be confirmed
+func (db *MFAPsqlConnection) Confirm(ctx context.Context, userID uuid.UUID, recoveryCodes []string, step int64, at time.Time) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.user_mfa SET confirmed_at=$2, recovery_codes=$3, last_step=$4
+	WHERE user_id=$1 AND confirmed_at IS NULL`, userID, at, recoveryCodes, step)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Confirm: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// UseStep function executes SQL request accepting a code of the given time step,
+// every time step is accepted once, even concurrently, and never after a later one
+func (db *MFAPsqlConnection) UseStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.user_mfa SET last_step=$2 WHERE user_id=$1 AND last_step < $2`, userID, step)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("UseStep: %w", model.ErrInvalidMFACode)
+	}
+	return nil
+}
+
+// UseRecoveryCode function executes SQL request removing the recovery code with the given hash, every code is used once
+func (db *MFAPsqlConnection) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.user_mfa SET recovery_codes=array_remove(recovery_codes, $2)
+	WHERE user_id=$1 AND $2 = ANY(recovery_codes)`, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("UseRecoveryCode: %w", model.ErrInvalidMFACode)
+	}
+	return nil
+}
+
+// Delete function executes SQL request removing the second factor of a user
+func (db *MFAPsqlConnection) Delete(ctx context.Context, userID uuid.UUID) error {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM goschema.user_mfa WHERE user_id=$1`, userID)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Delete: %w", model.ErrNotFound)
+	}
+	return nil
+}
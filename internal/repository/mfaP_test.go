@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var mfaP *MFAPsqlConnection
+
+func TestPgxMFA(t *testing.T) {
+	checkMFA(t, mfaP)
+}
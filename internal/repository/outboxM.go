@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outboxCounter is the _id of the counters document the outbox sequence numbers are taken from
+const outboxCounter = "outbox"
+
+// OutboxMongoDBConnection is a struct, which contains *mongo.Client variable
+type OutboxMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewOutboxMongoDBConnection func is a constructor of OutboxMongoDBConnection struct
+func NewOutboxMongoDBConnection(client *mongo.Client) *OutboxMongoDBConnection {
+	return &OutboxMongoDBConnection{client: client}
+}
+
+// Add function executes "db.outbox.insertMany()" command, inside MongoTxManager
+// the events are written in the transaction of the change
+func (db *OutboxMongoDBConnection) Add(ctx context.Context, events ...*model.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	collection := db.client.Database("my_mongo_base").Collection("outbox")
+	last, err := db.reserve(ctx, int64(len(events)))
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		event.Seq = last - int64(len(events)-1-i)
+		docs[i] = event
+	}
+	_, err = collection.InsertMany(ctx, docs)
+	if err != nil {
+		return fmt.Errorf("InsertMany: %w", err)
+	}
+	return nil
+}
+
+// reserve executes "db.counters.findOneAndUpdate()" with $inc to take n sequence numbers and returns the last one.
+// Inside MongoTxManager the counter is written in the transaction of the change, so concurrent changes
+// conflict on it and events get their sequence numbers in commit order
+func (db *OutboxMongoDBConnection) reserve(ctx context.Context, n int64) (int64, error) {
+	collection := db.client.Database("my_mongo_base").Collection("counters")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": outboxCounter}, bson.M{"$inc": bson.M{"seq": n}}, opts).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("FindOneAndUpdate(): %w", err)
+	}
+	return counter.Seq, nil
+}
+
+// Pending function executes "db.outbox.find()" for the oldest unpublished events
+func (db *OutboxMongoDBConnection) Pending(ctx context.Context, limit int) ([]*model.Event, error) {
+	collection := db.client.Database("my_mongo_base").Collection("outbox")
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []*model.Event
+	for cursor.Next(ctx) {
+		var event *model.Event
+		err = cursor.Decode(&event)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		results = append(results, event)
+	}
+	return results, cursor.Err()
+}
+
+// Ack function executes "db.outbox.deleteMany()" for published events
+func (db *OutboxMongoDBConnection) Ack(ctx context.Context, ids []uuid.UUID) error {
+	collection := db.client.Database("my_mongo_base").Collection("outbox")
+	_, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return fmt.Errorf("DeleteMany(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoOutbox(t *testing.T) {
+	checkOutbox(t, NewOutboxMongoDBConnection(rpsM.client))
+}
+
+func TestMongoTxRollback(t *testing.T) {
+	ctx := context.Background()
+	outbox := NewOutboxMongoDBConnection(rpsM.client)
+	txm, err := NewMongoTxManager(ctx, rpsM.client)
+	require.NoError(t, err)
+	entity := model.Person{Name: "Rolled back", Age: 20}
+	errRollback := errors.New("rollback")
+	// Step 1: The person and its event are written in a transaction that fails
+	err = txm.WithinTx(ctx, func(ctx context.Context) error {
+		_, err := rpsM.Create(ctx, &entity)
+		require.NoError(t, err)
+		require.NoError(t, outbox.Add(ctx, newEvent()))
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	// Step 2: Neither of them is left
+	_, err = rpsM.GetByID(ctx, entity.ID)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	pending, err := outbox.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
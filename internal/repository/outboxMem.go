@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// OutboxMemoryConnection is an in-memory outbox, safe for concurrent use
+type OutboxMemoryConnection struct {
+	mu     sync.Mutex
+	seq    int64
+	events []model.Event
+}
+
+// NewOutboxMemoryConnection is a constructor for OutboxMemoryConnection
+func NewOutboxMemoryConnection() *OutboxMemoryConnection {
+	return &OutboxMemoryConnection{}
+}
+
+// Add appends copies of the events
+func (db *OutboxMemoryConnection) Add(_ context.Context, events ...*model.Event) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, event := range events {
+		db.seq++
+		event.Seq = db.seq
+		stored := *event
+		stored.Payload = cloneBytes(event.Payload)
+		db.events = append(db.events, stored)
+	}
+	return nil
+}
+
+// Pending returns copies of the oldest events
+func (db *OutboxMemoryConnection) Pending(_ context.Context, limit int) ([]*model.Event, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var results []*model.Event
+	for i := 0; i < len(db.events) && i < limit; i++ {
+		event := db.events[i]
+		event.Payload = cloneBytes(event.Payload)
+		results = append(results, &event)
+	}
+	return results, nil
+}
+
+// Ack removes published events
+func (db *OutboxMemoryConnection) Ack(_ context.Context, ids []uuid.UUID) error {
+	acked := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	kept := db.events[:0]
+	for _, event := range db.events {
+		if !acked[event.ID] {
+			kept = append(kept, event)
+		}
+	}
+	db.events = kept
+	return nil
+}
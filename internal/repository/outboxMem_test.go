@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// outboxRepository is implemented by every outbox backend
+type outboxRepository interface {
+	Add(ctx context.Context, events ...*model.Event) error
+	Pending(ctx context.Context, limit int) ([]*model.Event, error)
+	Ack(ctx context.Context, ids []uuid.UUID) error
+}
+
+// newEvent returns a person.created event of a new person
+func newEvent() *model.Event {
+	return &model.Event{
+		ID:          uuid.New(),
+		Type:        model.EventPersonCreated,
+		AggregateID: uuid.New(),
+		Payload:     []byte(`{"name":"Eugen"}`),
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+// checkOutbox makes sure events come out of an empty outbox in the order they were added
+func checkOutbox(t *testing.T, repo outboxRepository) {
+	ctx := context.Background()
+	events := []*model.Event{newEvent(), newEvent(), newEvent()}
+	require.NoError(t, repo.Add(ctx, events[0], events[1]))
+	require.NoError(t, repo.Add(ctx, events[2]))
+	require.Less(t, events[0].Seq, events[1].Seq)
+	require.Less(t, events[1].Seq, events[2].Seq)
+	// Step 1: The oldest events come first
+	pending, err := repo.Pending(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, events[0].ID, pending[0].ID)
+	require.Equal(t, events[1].ID, pending[1].ID)
+	require.JSONEq(t, string(events[0].Payload), string(pending[0].Payload))
+	require.True(t, events[0].CreatedAt.Equal(pending[0].CreatedAt))
+	// Step 2: Acked events are gone
+	require.NoError(t, repo.Ack(ctx, []uuid.UUID{events[0].ID}))
+	pending, err = repo.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, events[1].ID, pending[0].ID)
+	require.Equal(t, events[2].ID, pending[1].ID)
+	require.NoError(t, repo.Ack(ctx, []uuid.UUID{events[1].ID, events[2].ID}))
+	pending, err = repo.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestMemoryOutbox(t *testing.T) {
+	checkOutbox(t, NewOutboxMemoryConnection())
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// outboxLock is the advisory lock serializing outbox writers, so events become visible in seq order
+const outboxLock = 0x6f7574626f78
+
+// OutboxPsqlConnection is a struct, which contains Pool variable
+type OutboxPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewOutboxPsqlConnection constructor for OutboxPsqlConnection
+func NewOutboxPsqlConnection(pool *pgxpool.Pool) *OutboxPsqlConnection {
+	return &OutboxPsqlConnection{pool: pool}
+}
+
+// Add function executes SQL request to insert events into the outbox, inside PsqlTxManager
+// they are written in the transaction of the change and hold the outbox lock until it ends
+func (db *OutboxPsqlConnection) Add(ctx context.Context, events ...*model.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	_, err := conn(ctx, db.pool).Exec(ctx, "SELECT pg_advisory_xact_lock($1)", outboxLock)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	for _, event := range events {
+		err := conn(ctx, db.pool).QueryRow(ctx, `INSERT INTO goschema.outbox (id, type, aggregate_id, payload, created_at)
+		VALUES($1,$2,$3,$4,$5) RETURNING seq`,
+			event.ID, event.Type, event.AggregateID, event.Payload, event.CreatedAt).Scan(&event.Seq)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", err)
+		}
+	}
+	return nil
+}
+
+// Pending function executes SQL request to select the oldest unpublished events
+func (db *OutboxPsqlConnection) Pending(ctx context.Context, limit int) ([]*model.Event, error) {
+	rows, err := conn(ctx, db.pool).Query(ctx, `SELECT seq, id, type, aggregate_id, payload, created_at FROM goschema.outbox
+	ORDER BY seq LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	var results []*model.Event
+	for rows.Next() {
+		event := &model.Event{}
+		err := rows.Scan(&event.Seq, &event.ID, &event.Type, &event.AggregateID, &event.Payload, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		results = append(results, event)
+	}
+	return results, rows.Err()
+}
+
+// Ack function executes SQL request to remove published events from the outbox
+func (db *OutboxPsqlConnection) Ack(ctx context.Context, ids []uuid.UUID) error {
+	_, err := conn(ctx, db.pool).Exec(ctx, "DELETE FROM goschema.outbox WHERE id = ANY($1::uuid[])", uuidStrings(ids))
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPgxOutbox(t *testing.T) {
+	checkOutbox(t, NewOutboxPsqlConnection(rps.pool))
+}
+
+func TestPgxTxRollback(t *testing.T) {
+	ctx := context.Background()
+	outbox := NewOutboxPsqlConnection(rps.pool)
+	entity := model.Person{Name: "Rolled back", Age: 20}
+	errRollback := errors.New("rollback")
+	// Step 1: The person and its event are written in a transaction that fails
+	err := NewPsqlTxManager(rps.pool).WithinTx(ctx, func(ctx context.Context) error {
+		_, err := rps.Create(ctx, &entity)
+		require.NoError(t, err)
+		require.NoError(t, outbox.Add(ctx, newEvent()))
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+	// Step 2: Neither of them is left
+	_, err = rps.GetByID(ctx, entity.ID)
+	require.Error(t, err)
+	pending, err := outbox.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	require.NotEqual(t, uuid.Nil, entity.ID)
+}
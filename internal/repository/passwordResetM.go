@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PasswordResetMongoDBConnection is a struct, which contains *mongo.Client variable
+type PasswordResetMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewPasswordResetMongoDBConnection func is a constructor of PasswordResetMongoDBConnection struct
+func NewPasswordResetMongoDBConnection(client *mongo.Client) *PasswordResetMongoDBConnection {
+	return &PasswordResetMongoDBConnection{client: client}
+}
+
+// collection returns the password_reset collection
+func (db *PasswordResetMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("password_reset")
+}
+
+// Create function executes "db.password_reset.insertOne()" command
+func (db *PasswordResetMongoDBConnection) Create(ctx context.Context, reset *model.PasswordReset) error {
+	_, err := db.collection().InsertOne(ctx, reset)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// Get function executes "db.password_reset.findOne()" command for the password reset with the given token hash
+func (db *PasswordResetMongoDBConnection) Get(ctx context.Context, tokenHash []byte) (*model.PasswordReset, error) {
+	reset := &model.PasswordReset{}
+	err := db.collection().FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(reset)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOne: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOne: %w", err)
+	}
+	return reset, nil
+}
+
+// Redeem function executes "db.password_reset.findOneAndUpdate()" marking the password reset with the given token hash as used,
+// only a password reset which was not used and has not expired at the given time can be redeemed, even concurrently
+func (db *PasswordResetMongoDBConnection) Redeem(ctx context.Context, tokenHash []byte, at time.Time) (*model.PasswordReset, error) {
+	filter := bson.M{"token_hash": tokenHash, "used_at": nil, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"used_at": at}}
+	reset := &model.PasswordReset{}
+	err := db.collection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(reset)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", model.ErrInvalidResetToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", err)
+	}
+	return reset, nil
+}
+
+// RevokeUser function executes "db.password_reset.updateMany()" marking every unused password reset of the user as used
+func (db *PasswordResetMongoDBConnection) RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	res, err := db.collection().UpdateMany(ctx, bson.M{"user_id": userID, "used_at": nil}, bson.M{"$set": bson.M{"used_at": at}})
+	if err != nil {
+		return 0, fmt.Errorf("UpdateMany: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var passwordResetM *PasswordResetMongoDBConnection
+
+func TestMongoPasswordReset(t *testing.T) {
+	checkPasswordResets(t, passwordResetM)
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetMemoryConnection is an in-memory password reset storage, safe for concurrent use
+type PasswordResetMemoryConnection struct {
+	mu     sync.Mutex
+	resets map[uuid.UUID]*model.PasswordReset
+}
+
+// NewPasswordResetMemoryConnection is a constructor for PasswordResetMemoryConnection
+func NewPasswordResetMemoryConnection() *PasswordResetMemoryConnection {
+	return &PasswordResetMemoryConnection{resets: make(map[uuid.UUID]*model.PasswordReset)}
+}
+
+// copyPasswordReset returns a deep copy of the password reset
+func copyPasswordReset(reset *model.PasswordReset) *model.PasswordReset {
+	cp := *reset
+	cp.TokenHash = cloneBytes(reset.TokenHash)
+	if reset.UsedAt != nil {
+		usedAt := *reset.UsedAt
+		cp.UsedAt = &usedAt
+	}
+	return &cp
+}
+
+// find returns the stored password reset with the given token hash, the caller must hold the lock
+func (db *PasswordResetMemoryConnection) find(tokenHash []byte) *model.PasswordReset {
+	for _, reset := range db.resets {
+		if bytes.Equal(reset.TokenHash, tokenHash) {
+			return reset
+		}
+	}
+	return nil
+}
+
+// Create stores a copy of a new password reset
+func (db *PasswordResetMemoryConnection) Create(_ context.Context, reset *model.PasswordReset) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.resets[reset.ID]; ok || db.find(reset.TokenHash) != nil {
+		return fmt.Errorf("Create: password reset %v already exists", reset.ID)
+	}
+	db.resets[reset.ID] = copyPasswordReset(reset)
+	return nil
+}
+
+// Get returns a copy of the password reset with the given token hash
+func (db *PasswordResetMemoryConnection) Get(_ context.Context, tokenHash []byte) (*model.PasswordReset, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	reset := db.find(tokenHash)
+	if reset == nil {
+		return nil, fmt.Errorf("Get: %w", model.ErrNotFound)
+	}
+	return copyPasswordReset(reset), nil
+}
+
+// Redeem marks the password reset with the given token hash as used,
+// only a password reset which was not used and has not expired at the given time can be redeemed
+func (db *PasswordResetMemoryConnection) Redeem(_ context.Context, tokenHash []byte, at time.Time) (*model.PasswordReset, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	reset := db.find(tokenHash)
+	if reset == nil || reset.UsedAt != nil || !reset.ExpiresAt.After(at) {
+		return nil, fmt.Errorf("Redeem: %w", model.ErrInvalidResetToken)
+	}
+	reset.UsedAt = &at
+	return copyPasswordReset(reset), nil
+}
+
+// RevokeUser marks every unused password reset of the user as used and returns how many were revoked
+func (db *PasswordResetMemoryConnection) RevokeUser(_ context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var revoked int64
+	for _, reset := range db.resets {
+		if reset.UserID == userID && reset.UsedAt == nil {
+			usedAt := at
+			reset.UsedAt = &usedAt
+			revoked++
+		}
+	}
+	return revoked, nil
+}
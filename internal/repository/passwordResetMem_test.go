@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// passwordResetRepository is implemented by every password reset backend
+type passwordResetRepository interface {
+	Create(ctx context.Context, reset *model.PasswordReset) error
+	Get(ctx context.Context, tokenHash []byte) (*model.PasswordReset, error)
+	Redeem(ctx context.Context, tokenHash []byte, at time.Time) (*model.PasswordReset, error)
+	RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error)
+}
+
+// checkPasswordResets redeems, concurrently redeems and revokes password resets
+func checkPasswordResets(t *testing.T, db passwordResetRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	userID := uuid.New()
+	newReset := func(expiresAt time.Time) *model.PasswordReset {
+		reset := &model.PasswordReset{ID: uuid.New(), UserID: userID, TokenHash: []byte(uuid.NewString()), CreatedAt: now, ExpiresAt: expiresAt}
+		require.NoError(t, db.Create(ctx, reset))
+		return reset
+	}
+	reset := newReset(now.Add(time.Hour))
+	require.Error(t, db.Create(ctx, reset))
+
+	// Step 1: a password reset is found by its token hash
+	found, err := db.Get(ctx, reset.TokenHash)
+	require.NoError(t, err)
+	require.Equal(t, reset.ID, found.ID)
+	require.Equal(t, userID, found.UserID)
+	require.Nil(t, found.UsedAt)
+	_, err = db.Get(ctx, []byte("unknown"))
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 2: concurrent redeems use it once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Redeem(ctx, reset.TokenHash, now)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, succeeded)
+	_, err = db.Redeem(ctx, reset.TokenHash, now)
+	require.ErrorIs(t, err, model.ErrInvalidResetToken)
+	found, err = db.Get(ctx, reset.TokenHash)
+	require.NoError(t, err)
+	require.NotNil(t, found.UsedAt)
+
+	// Step 3: an expired or unknown password reset can't be redeemed
+	expired := newReset(now)
+	_, err = db.Redeem(ctx, expired.TokenHash, now)
+	require.ErrorIs(t, err, model.ErrInvalidResetToken)
+	_, err = db.Redeem(ctx, []byte("unknown"), now)
+	require.ErrorIs(t, err, model.ErrInvalidResetToken)
+
+	// Step 4: revoking the user uses up the unused password resets of the user only
+	first, second := newReset(now.Add(time.Hour)), newReset(now.Add(time.Hour))
+	other := &model.PasswordReset{ID: uuid.New(), UserID: uuid.New(), TokenHash: []byte(uuid.NewString()), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, db.Create(ctx, other))
+	revoked, err := db.RevokeUser(ctx, userID, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), revoked)
+	for _, r := range []*model.PasswordReset{first, second} {
+		_, err = db.Redeem(ctx, r.TokenHash, now)
+		require.ErrorIs(t, err, model.ErrInvalidResetToken)
+	}
+	_, err = db.Redeem(ctx, other.TokenHash, now)
+	require.NoError(t, err)
+}
+
+func TestPasswordResetMemory(t *testing.T) {
+	checkPasswordResets(t, NewPasswordResetMemoryConnection())
+}
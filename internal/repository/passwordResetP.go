@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PasswordResetPsqlConnection struct represents a connection to the password_reset table,
+// its queries run within the transaction of ctx if there is one
+type PasswordResetPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewPasswordResetPsqlConnection is a constructor for PasswordResetPsqlConnection
+func NewPasswordResetPsqlConnection(pool *pgxpool.Pool) *PasswordResetPsqlConnection {
+	return &PasswordResetPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to insert a new password reset
+func (db *PasswordResetPsqlConnection) Create(ctx context.Context, reset *model.PasswordReset) error {
+	_, err := conn(ctx, db.pool).Exec(ctx, `INSERT INTO goschema.password_reset (id, user_id, token_hash, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)`,
+		reset.ID, reset.UserID, reset.TokenHash, reset.CreatedAt, reset.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Get function executes SQL request to select the password reset with the given token hash
+func (db *PasswordResetPsqlConnection) Get(ctx context.Context, tokenHash []byte) (*model.PasswordReset, error) {
+	reset := &model.PasswordReset{}
+	err := conn(ctx, db.pool).QueryRow(ctx, `SELECT id, user_id, token_hash, created_at, expires_at, used_at
+	FROM goschema.password_reset WHERE token_hash=$1`, tokenHash).
+		Scan(&reset.ID, &reset.UserID, &reset.TokenHash, &reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return reset, nil
+}
+
+// Redeem function executes SQL request marking the password reset with the given token hash as used,
+// only a password reset which was not used and has not expired at the given time can be redeemed, even concurrently
+func (db *PasswordResetPsqlConnection) Redeem(ctx context.Context, tokenHash []byte, at time.Time) (*model.PasswordReset, error) {
+	reset := &model.PasswordReset{}
+	err := conn(ctx, db.pool).QueryRow(ctx, `UPDATE goschema.password_reset SET used_at=$2
+	WHERE token_hash=$1 AND used_at IS NULL AND expires_at > $2
+	RETURNING id, user_id, token_hash, created_at, expires_at, used_at`, tokenHash, at).
+		Scan(&reset.ID, &reset.UserID, &reset.TokenHash, &reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrInvalidResetToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return reset, nil
+}
+
+// RevokeUser function executes SQL request marking every unused password reset of the user as used
+func (db *PasswordResetPsqlConnection) RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error) {
+	tag, err := conn(ctx, db.pool).Exec(ctx, `UPDATE goschema.password_reset SET used_at=$2 WHERE user_id=$1 AND used_at IS NULL`, userID, at)
+	if err != nil {
+		return 0, fmt.Errorf("Exec(): %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var passwordResetP *PasswordResetPsqlConnection
+
+func TestPgxPasswordReset(t *testing.T) {
+	checkPasswordResets(t, passwordResetP)
+}
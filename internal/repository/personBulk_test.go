@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkRepository is implemented by every person repository
+type bulkRepository interface {
+	GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Person, error)
+	CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error)
+}
+
+// txBulkRepository runs every bulk write of a repository in a transaction of txm, as PersonService does.
+// A failed atomic write rolls its transaction back
+type txBulkRepository struct {
+	bulkRepository
+	txm interface {
+		WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+}
+
+// CreateMany implements bulkRepository
+func (r txBulkRepository) CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	return r.within(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		return r.bulkRepository.CreateMany(ctx, persons, atomic)
+	})
+}
+
+// UpdateMany implements bulkRepository
+func (r txBulkRepository) UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	return r.within(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		return r.bulkRepository.UpdateMany(ctx, persons, atomic)
+	})
+}
+
+// DeleteMany implements bulkRepository
+func (r txBulkRepository) DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	return r.within(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		return r.bulkRepository.DeleteMany(ctx, items, atomic)
+	})
+}
+
+// within runs write in a transaction
+func (r txBulkRepository) within(ctx context.Context, atomic bool, write func(ctx context.Context) ([]error, error)) ([]error, error) {
+	var errs []error
+	err := r.txm.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		errs, err = write(ctx)
+		if err == nil && atomic && failed(errs) {
+			return model.ErrBulkAborted
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, model.ErrBulkAborted) {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// createBulk creates n persons in atomic mode
+func createBulk(t *testing.T, repo bulkRepository, n int) []*model.Person {
+	persons := make([]*model.Person, n)
+	for i := range persons {
+		persons[i] = &model.Person{ID: uuid.New(), Name: "Bulk", Age: 20 + i, IsHealthy: true}
+	}
+	errs, err := repo.CreateMany(context.Background(), persons, true)
+	require.NoError(t, err)
+	require.Equal(t, make([]error, n), errs)
+	for _, person := range persons {
+		require.Equal(t, int64(1), person.Version)
+	}
+	return persons
+}
+
+// checkBulkBestEffort makes sure failed items of a best effort request don't stop the others
+func checkBulkBestEffort(t *testing.T, repo bulkRepository) {
+	ctx := context.Background()
+	persons := createBulk(t, repo, 3)
+	// Step 1: Update with a stale version, a missing person and a current one
+	stale, missing, current := *persons[0], model.Person{ID: uuid.New(), Name: "Missing", Age: 1}, *persons[1]
+	stale.Version = 5
+	current.Age = 99
+	errs, err := repo.UpdateMany(ctx, []*model.Person{&stale, &missing, &current}, false)
+	require.NoError(t, err)
+	require.ErrorIs(t, errs[0], model.ErrVersionConflict)
+	require.ErrorIs(t, errs[1], model.ErrNotFound)
+	require.NoError(t, errs[2])
+	require.Equal(t, int64(2), current.Version)
+	// Step 2: Delete the updated person in its old version and the untouched one
+	errs, err = repo.DeleteMany(ctx, []model.PersonVersion{{ID: current.ID, Version: 1}, {ID: persons[2].ID, Version: 1}}, false)
+	require.NoError(t, err)
+	require.ErrorIs(t, errs[0], model.ErrVersionConflict)
+	require.NoError(t, errs[1])
+	// Step 3: Data consistency check
+	stored, err := repo.GetByIDs(ctx, []uuid.UUID{persons[0].ID, persons[1].ID, persons[2].ID})
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	for _, person := range stored {
+		if person.ID == current.ID {
+			require.Equal(t, 99, person.Age)
+			require.Equal(t, int64(2), person.Version)
+		}
+	}
+}
+
+// checkBulkCreate makes sure created persons keep their IDs and a taken ID fails only its own item
+func checkBulkCreate(t *testing.T, repo bulkRepository) {
+	ctx := context.Background()
+	existing := createBulk(t, repo, 1)[0]
+	// Step 1: Create a person with an ID, one without and two with taken IDs
+	given, generated := &model.Person{ID: uuid.New(), Name: "Given", Age: 30}, &model.Person{Name: "Generated", Age: 31}
+	taken, twice := &model.Person{ID: existing.ID, Name: "Taken", Age: 32}, &model.Person{ID: given.ID, Name: "Twice", Age: 33}
+	givenID := given.ID
+	errs, err := repo.CreateMany(ctx, []*model.Person{given, generated, taken, twice}, false)
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	require.ErrorIs(t, errs[2], model.ErrAlreadyExists)
+	require.ErrorIs(t, errs[3], model.ErrAlreadyExists)
+	require.Equal(t, givenID, given.ID)
+	require.NotEqual(t, uuid.Nil, generated.ID)
+	stored, err := repo.GetByIDs(ctx, []uuid.UUID{given.ID, generated.ID, existing.ID})
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	for _, person := range stored {
+		require.NotEqual(t, "Taken", person.Name)
+		require.NotEqual(t, "Twice", person.Name)
+	}
+}
+
+// checkBulkAtomic makes sure a single failed item rolls an atomic request back
+func checkBulkAtomic(t *testing.T, repo bulkRepository) {
+	ctx := context.Background()
+	persons := createBulk(t, repo, 2)
+	// Step 1: A stale version aborts the update of the other person
+	first, second := *persons[0], *persons[1]
+	first.Age, second.Version = 50, 7
+	errs, err := repo.UpdateMany(ctx, []*model.Person{&first, &second}, true)
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	require.ErrorIs(t, errs[1], model.ErrVersionConflict)
+	stored, err := repo.GetByIDs(ctx, []uuid.UUID{first.ID})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, persons[0].Age, stored[0].Age)
+	require.Equal(t, int64(1), stored[0].Version)
+	// Step 2: Deleting the same person twice aborts the whole delete
+	items := []model.PersonVersion{{ID: first.ID, Version: 1}, {ID: second.ID, Version: 1}, {ID: first.ID, Version: 1}}
+	errs, err = repo.DeleteMany(ctx, items, true)
+	require.NoError(t, err)
+	require.Error(t, errs[2])
+	stored, err = repo.GetByIDs(ctx, []uuid.UUID{first.ID, second.ID})
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	// Step 3: Both deletes pass on their own
+	errs, err = repo.DeleteMany(ctx, items[:2], true)
+	require.NoError(t, err)
+	require.Equal(t, make([]error, 2), errs)
+	stored, err = repo.GetByIDs(ctx, []uuid.UUID{first.ID, second.ID})
+	require.NoError(t, err)
+	require.Empty(t, stored)
+	// Step 4: An ID which is taken, by a deleted person too, aborts the whole create
+	fresh := &model.Person{ID: uuid.New(), Name: "Fresh", Age: 34}
+	errs, err = repo.CreateMany(ctx, []*model.Person{fresh, {ID: first.ID, Name: "Taken", Age: 35}}, true)
+	require.NoError(t, err)
+	require.ErrorIs(t, errs[1], model.ErrAlreadyExists)
+	stored, err = repo.GetByIDs(ctx, []uuid.UUID{fresh.ID})
+	require.NoError(t, err)
+	require.Empty(t, stored)
+}
+
+func TestMemoryBulk(t *testing.T) {
+	checkBulkBestEffort(t, NewMemoryConnection())
+	checkBulkAtomic(t, NewMemoryConnection())
+	checkBulkCreate(t, NewMemoryConnection())
+	inTx := txBulkRepository{bulkRepository: NewMemoryConnection(), txm: NewMemoryTxManager()}
+	checkBulkBestEffort(t, inTx)
+	checkBulkAtomic(t, inTx)
+	checkBulkCreate(t, inTx)
+}
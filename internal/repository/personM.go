@@ -3,13 +3,17 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"time"
 
 	"github.com/eugenshima/myapp/internal/model"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // MongoDBConnection is a struct, which contains *mongo.Client variable
@@ -22,33 +26,78 @@ func NewMongoDBConnection(client *mongo.Client) *MongoDBConnection {
 	return &MongoDBConnection{client: client}
 }
 
-// Update is a func which executes MongoDB command db.person.updateOne
+// Update is a func which executes MongoDB command db.person.updateOne,
+// person.Version must match the stored version and is incremented on success
 func (db *MongoDBConnection) Update(ctx context.Context, uuidString uuid.UUID, person *model.Person) (uuid.UUID, error) {
 	collection := db.client.Database("my_mongo_base").Collection("person")
-	filter := bson.M{"_id": uuidString}
-	update := bson.M{"$set": person}
-	_, err := collection.UpdateOne(ctx, filter, update)
+	filter := bson.M{"_id": uuidString, "version": versionFilter(person.Version), "deleted_at": nil}
+	update := bson.M{
+		"$set": bson.M{"name": person.Name, "age": person.Age, "is_healthy": person.IsHealthy},
+		"$inc": bson.M{"version": 1},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("UpdateOne: %w", err)
 	}
-	return person.ID, nil
+	if res.MatchedCount == 0 {
+		return uuid.Nil, versionConflict(ctx, collection, uuidString)
+	}
+	person.ID = uuidString
+	person.Version++
+	return uuidString, nil
 }
 
-// Delete is a func which executes MongoDB command db.person.deleteOne
-func (db *MongoDBConnection) Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+// Delete is a func which soft deletes the given version of a person with db.person.updateOne
+func (db *MongoDBConnection) Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error) {
 	collection := db.client.Database("my_mongo_base").Collection("person")
-	filter := bson.M{"_id": uuidString}
-	_, err := collection.DeleteOne(ctx, filter)
+	filter := bson.M{"_id": uuidString, "version": versionFilter(version), "deleted_at": nil}
+	update := bson.M{
+		"$set": bson.M{"deleted_at": time.Now().UTC()},
+		"$inc": bson.M{"version": 1},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("error in DeleteOne : %v", err)
+		return uuid.Nil, fmt.Errorf("error in UpdateOne : %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return uuid.Nil, versionConflict(ctx, collection, uuidString)
 	}
 	return uuidString, nil
 }
 
-// Create function executes "db.person.insertOne()" command
+// versionFilter matches the given version, documents written before versioning count as version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// versionConflict tells why a write matched no person, ErrVersionConflict if the person exists
+// and ErrNotFound if it is missing or soft deleted
+func versionConflict(ctx context.Context, collection *mongo.Collection, id uuid.UUID) error {
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": id, "deleted_at": nil})
+	if err != nil {
+		return fmt.Errorf("CountDocuments(): %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("CountDocuments(): person %v: %w", id, model.ErrNotFound)
+	}
+	return model.ErrVersionConflict
+}
+
+// Create function executes "db.person.insertOne()" command, generating an ID if the person has none
 func (db *MongoDBConnection) Create(ctx context.Context, person *model.Person) (uuid.UUID, error) {
 	collection := db.client.Database("my_mongo_base").Collection("person")
+	if person.ID == uuid.Nil {
+		person.ID = uuid.New()
+	}
+	person.Version = 1
+	person.DeletedAt = nil
 	_, err := collection.InsertOne(ctx, person)
+	if mongo.IsDuplicateKeyError(err) {
+		return uuid.Nil, fmt.Errorf("InsertOne: person %v: %w", person.ID, model.ErrAlreadyExists)
+	}
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("InsertOne: %w", err)
 	}
@@ -58,10 +107,12 @@ func (db *MongoDBConnection) Create(ctx context.Context, person *model.Person) (
 // GetByID function executes "db.person.FindOne()" command
 func (db *MongoDBConnection) GetByID(ctx context.Context, ID uuid.UUID) (*model.Person, error) {
 	collection := db.client.Database("my_mongo_base").Collection("person")
-	filter := bson.M{"_id": ID}
+	filter := bson.M{"_id": ID, "deleted_at": nil}
 	var person model.Person
 	err := collection.FindOne(ctx, filter).Decode(&person)
-
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("Decode(): person %v: %w", ID, model.ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Decode(): %w", err)
 	}
@@ -71,7 +122,7 @@ func (db *MongoDBConnection) GetByID(ctx context.Context, ID uuid.UUID) (*model.
 // GetAll function executes "db.person.FindOne()" command
 func (db *MongoDBConnection) GetAll(ctx context.Context) ([]*model.Person, error) {
 	collection := db.client.Database("my_mongo_base").Collection("person")
-	filter := bson.M{}
+	filter := bson.M{"deleted_at": nil}
 	var all []*model.Person
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
@@ -89,3 +140,427 @@ func (db *MongoDBConnection) GetAll(ctx context.Context) ([]*model.Person, error
 	}
 	return all, nil
 }
+
+// GetDeleted function executes "db.person.find()" for soft deleted persons, recently deleted first
+func (db *MongoDBConnection) GetDeleted(ctx context.Context) ([]*model.Person, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	filter := bson.M{"deleted_at": bson.M{"$ne": nil}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var all []*model.Person
+	for cursor.Next(ctx) {
+		var pers *model.Person
+		err = cursor.Decode(&pers)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		all = append(all, pers)
+	}
+	return all, cursor.Err()
+}
+
+// Restore function executes "db.person.updateOne()" to bring a soft deleted person back
+func (db *MongoDBConnection) Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	filter := bson.M{"_id": uuidString, "deleted_at": bson.M{"$ne": nil}}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return uuid.Nil, fmt.Errorf("UpdateOne: deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	return uuidString, nil
+}
+
+// Purge function executes "db.person.deleteOne()" to permanently delete a soft deleted person
+func (db *MongoDBConnection) Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	filter := bson.M{"_id": uuidString, "deleted_at": bson.M{"$ne": nil}}
+	res, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("DeleteOne(): %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return uuid.Nil, fmt.Errorf("DeleteOne(): deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	return uuidString, nil
+}
+
+// GetPage function executes "db.person.find()" with filters, sorting and a limit
+func (db *MongoDBConnection) GetPage(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	q, err := newPageQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("newPageQuery: %w", err)
+	}
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	filter := bson.M{"deleted_at": nil}
+	age := bson.M{}
+	if q.MinAge != nil {
+		age["$gte"] = *q.MinAge
+	}
+	if q.MaxAge != nil {
+		age["$lte"] = *q.MaxAge
+	}
+	if len(age) > 0 {
+		filter["age"] = age
+	}
+	if q.IsHealthy != nil {
+		filter["is_healthy"] = *q.IsHealthy
+	}
+	if q.NamePrefix != "" {
+		filter["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.NamePrefix)}
+	}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("CountDocuments(): %w", err)
+	}
+
+	field, op, order := "name", "$gt", 1
+	if q.SortBy == sortByAge {
+		field = "age"
+	}
+	if q.desc() {
+		op, order = "$lt", -1
+	}
+	if q.cursor != nil {
+		var value interface{} = q.cursor.Name
+		if q.SortBy == sortByAge {
+			value = q.cursor.Age
+		}
+		filter = bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{field: bson.M{op: value}},
+			bson.M{field: value, "_id": bson.M{op: q.cursor.ID}},
+		}}}}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(int64(q.Offset)).
+		SetLimit(int64(q.Limit + 1))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := make([]*model.Person, 0, q.Limit+1)
+	for cursor.Next(ctx) {
+		var pers *model.Person
+		err = cursor.Decode(&pers)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		results = append(results, pers)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("Cursor(): %w", err)
+	}
+	return q.page(results, total)
+}
+
+// GetByIDs function executes "db.person.find()" for the not deleted persons with the given IDs
+func (db *MongoDBConnection) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Person, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil})
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var all []*model.Person
+	for cursor.Next(ctx) {
+		var pers *model.Person
+		err = cursor.Decode(&pers)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		all = append(all, pers)
+	}
+	return all, cursor.Err()
+}
+
+// CreateMany function executes "db.person.bulkWrite()" with an insertOne per person, persons keep their IDs
+// and get a new one if they have none. A person whose ID is taken gets ErrAlreadyExists, taken IDs are looked up
+// before the write since a duplicate key aborts the transaction the write may run in.
+// Atomic mode runs in a transaction and needs MongoDB to be a replica set
+func (db *MongoDBConnection) CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	ids := make([]uuid.UUID, len(persons))
+	for i, person := range persons {
+		if person.ID == uuid.Nil {
+			person.ID = uuid.New()
+		}
+		person.Version = 1
+		person.DeletedAt = nil
+		ids[i] = person.ID
+	}
+	errs := make([]error, len(persons))
+	return errs, db.bulk(ctx, atomic, func(ctx context.Context) (bool, error) {
+		taken, err := db.taken(ctx, collection, ids)
+		if err != nil {
+			return false, err
+		}
+		var models []mongo.WriteModel
+		var index []int
+		for i, person := range persons {
+			errs[i] = nil
+			if taken[person.ID] {
+				errs[i] = model.ErrAlreadyExists
+				continue
+			}
+			taken[person.ID] = true
+			models = append(models, mongo.NewInsertOneModel().SetDocument(person))
+			index = append(index, i)
+		}
+		if len(models) == 0 || atomic && failed(errs) {
+			return failed(errs), nil
+		}
+		_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(atomic))
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return failed(errs), err
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			errs[index[writeErr.Index]] = writeErr
+			if mongo.IsDuplicateKeyError(writeErr) {
+				errs[index[writeErr.Index]] = model.ErrAlreadyExists
+			}
+		}
+		return true, nil
+	})
+}
+
+// taken returns the IDs among ids which belong to a stored person, deleted ones included
+func (db *MongoDBConnection) taken(ctx context.Context, collection *mongo.Collection, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	res := make(map[uuid.UUID]bool, len(ids))
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID uuid.UUID `bson:"_id"`
+		}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		res[doc.ID] = true
+	}
+	return res, cursor.Err()
+}
+
+// UpdateMany function executes "db.person.bulkWrite()" with an updateOne per person,
+// atomic mode runs in a transaction and needs MongoDB to be a replica set
+func (db *MongoDBConnection) UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	items := make([]model.PersonVersion, len(persons))
+	for i, person := range persons {
+		items[i] = model.PersonVersion{ID: person.ID, Version: person.Version}
+	}
+	var errs []error
+	err := db.bulk(ctx, atomic, func(ctx context.Context) (bool, error) {
+		var err error
+		errs, err = db.writeVersioned(ctx, collection, items, func(i int) bson.M {
+			return bson.M{
+				"$set": bson.M{"name": persons[i].Name, "age": persons[i].Age, "is_healthy": persons[i].IsHealthy},
+				"$inc": bson.M{"version": 1},
+			}
+		})
+		return failed(errs), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if atomic && failed(errs) {
+		return errs, nil
+	}
+	for i, person := range persons {
+		if errs[i] == nil {
+			person.Version++
+		}
+	}
+	return errs, nil
+}
+
+// DeleteMany function executes "db.person.bulkWrite()" with a soft deleting updateOne per person,
+// atomic mode runs in a transaction and needs MongoDB to be a replica set
+func (db *MongoDBConnection) DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	var errs []error
+	err := db.bulk(ctx, atomic, func(ctx context.Context) (bool, error) {
+		var err error
+		errs, err = db.writeVersioned(ctx, collection, items, func(int) bson.M {
+			return bson.M{
+				"$set": bson.M{"deleted_at": time.Now().UTC()},
+				"$inc": bson.M{"version": 1},
+			}
+		})
+		return failed(errs), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// writeVersioned checks the versions of the items, applies update to the current ones with a single
+// bulkWrite and checks the versions again to catch the items changed in between
+func (db *MongoDBConnection) writeVersioned(ctx context.Context, collection *mongo.Collection, items []model.PersonVersion,
+	update func(i int) bson.M) ([]error, error) {
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	current, err := db.versions(ctx, collection, ids)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(items))
+	seen := make(map[uuid.UUID]bool, len(items))
+	var models []mongo.WriteModel
+	for i, item := range items {
+		version, ok := current[item.ID]
+		switch {
+		case !ok:
+			errs[i] = model.ErrNotFound
+		case version != item.Version || seen[item.ID]:
+			errs[i] = model.ErrVersionConflict
+		default:
+			filter := bson.M{"_id": item.ID, "version": versionFilter(item.Version), "deleted_at": nil}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update(i)))
+		}
+		seen[item.ID] = true
+	}
+	if len(models) == 0 {
+		return errs, nil
+	}
+	res, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return nil, fmt.Errorf("BulkWrite(): %w", err)
+	}
+	if res.MatchedCount == int64(len(models)) {
+		return errs, nil
+	}
+	after, err := db.versions(ctx, collection, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if version, ok := after[item.ID]; errs[i] == nil && ok && version != item.Version+1 {
+			errs[i] = model.ErrVersionConflict
+		}
+	}
+	return errs, nil
+}
+
+// versions returns the stored versions of the not deleted persons among ids
+func (db *MongoDBConnection) versions(ctx context.Context, collection *mongo.Collection, ids []uuid.UUID) (map[uuid.UUID]int64, error) {
+	opts := options.Find().SetProjection(bson.M{"version": 1})
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	res := make(map[uuid.UUID]int64, len(ids))
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID        uuid.UUID  `bson:"_id"`
+			Version   int64      `bson:"version"`
+			DeletedAt *time.Time `bson:"deleted_at"`
+		}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		if doc.DeletedAt == nil {
+			res[doc.ID] = doc.Version
+		}
+	}
+	return res, cursor.Err()
+}
+
+// bulk runs write, in atomic mode inside a transaction that is aborted when write reports failed items.
+// Inside MongoTxManager it joins the running transaction and leaves aborting it to the caller
+func (db *MongoDBConnection) bulk(ctx context.Context, atomic bool, write func(ctx context.Context) (bool, error)) error {
+	if !atomic || mongo.SessionFromContext(ctx) != nil {
+		_, err := write(ctx)
+		return err
+	}
+	session, err := db.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("StartSession(): %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		hasFailed, err := write(sc)
+		if err == nil && hasFailed {
+			err = model.ErrBulkAborted
+		}
+		return nil, err
+	})
+	if err != nil && !errors.Is(err, model.ErrBulkAborted) {
+		return fmt.Errorf("WithTransaction(): %w", err)
+	}
+	return nil
+}
+
+// failed reports whether any item failed
+func failed(errs []error) bool {
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBatch function executes "db.person.find()" for up to limit persons, deleted ones included,
+// with IDs greater than after in ID order
+func (db *MongoDBConnection) GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.Person, error) {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := make([]*model.Person, 0, limit)
+	for cursor.Next(ctx) {
+		var pers *model.Person
+		err = cursor.Decode(&pers)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		results = append(results, pers)
+	}
+	return results, cursor.Err()
+}
+
+// Import function executes "db.person.bulkWrite()" replacing the persons with the given ones as they are,
+// keeping IDs, versions and deletion times, importing the same persons twice is harmless
+func (db *MongoDBConnection) Import(ctx context.Context, persons []*model.Person) error {
+	collection := db.client.Database("my_mongo_base").Collection("person")
+	models := make([]mongo.WriteModel, len(persons))
+	for i, person := range persons {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": person.ID}).SetReplacement(person).SetUpsert(true)
+	}
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("BulkWrite(): %w", err)
+	}
+	return nil
+}
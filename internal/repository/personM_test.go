@@ -1,3 +1,5 @@
+//go:build integration
+
 package repository
 
 import (
@@ -31,9 +33,11 @@ func TestMongoCreate(t *testing.T) {
 	require.Equal(t, testEntity.Age, entityMongoEugen.Age)
 	require.Equal(t, testEntity.IsHealthy, entityMongoEugen.IsHealthy)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID)
+	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoDelete(t *testing.T) {
@@ -42,9 +46,11 @@ func TestMongoDelete(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, id)
 	// Step 2: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), id)
+	deletedID, err := rpsM.Delete(context.Background(), id, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoDeleteNil(t *testing.T) {
@@ -53,13 +59,15 @@ func TestMongoDeleteNil(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, id)
 	// Step 2: Try to delete with wrong ID
-	wrongID, err := rpsM.Delete(context.Background(), uuid.New())
-	require.NoError(t, err)
-	require.NotEqual(t, wrongID, id)
+	wrongID, err := rpsM.Delete(context.Background(), uuid.New(), entityMongoEugen.Version)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.Equal(t, uuid.Nil, wrongID)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), id)
+	deletedID, err := rpsM.Delete(context.Background(), id, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoGetAll(t *testing.T) {
@@ -80,9 +88,11 @@ func TestMongoUpdate(t *testing.T) {
 	require.NotEmpty(t, id)
 	require.Equal(t, id, entityEugen.ID)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID)
+	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoUpdateWrongID(t *testing.T) {
@@ -92,12 +102,14 @@ func TestMongoUpdateWrongID(t *testing.T) {
 	require.NotEmpty(t, id)
 	// Step 2: Try to update test entity with wrong ID
 	wrongID, err := rpsM.Update(context.Background(), uuid.New(), &entityEugen)
-	require.NoError(t, err)
-	require.NotEqual(t, wrongID, id)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.Equal(t, uuid.Nil, wrongID)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID)
+	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoGetByID(t *testing.T) {
@@ -113,9 +125,11 @@ func TestMongoGetByID(t *testing.T) {
 	require.Equal(t, testEntity.Age, entityMongoEugen.Age)
 	require.Equal(t, testEntity.IsHealthy, entityMongoEugen.IsHealthy)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID)
+	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
 }
 
 func TestMongoGetByIDWrongID(t *testing.T) {
@@ -128,7 +142,38 @@ func TestMongoGetByIDWrongID(t *testing.T) {
 	require.Error(t, err)
 	require.NotEqual(t, wrongID, id)
 	// Step 3: Delete test entity
-	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID)
+	deletedID, err := rpsM.Delete(context.Background(), entityMongoEugen.ID, entityMongoEugen.Version)
 	require.NoError(t, err)
 	require.NotEmpty(t, deletedID)
+	_, err = rpsM.Purge(context.Background(), deletedID)
+	require.NoError(t, err)
+}
+
+func TestMongoGetPage(t *testing.T) {
+	checkGetPage(t, rpsM)
+}
+
+func TestMongoVersion(t *testing.T) {
+	checkVersion(t, rpsM)
+}
+
+func TestMongoSoftDelete(t *testing.T) {
+	checkSoftDelete(t, rpsM)
+}
+
+func TestMongoBulk(t *testing.T) {
+	checkBulkBestEffort(t, rpsM)
+	checkBulkAtomic(t, rpsM)
+	checkBulkCreate(t, rpsM)
+}
+
+// TestMongoBulkInTx runs the bulk writes in the transaction PersonService opens around them,
+// where a single failed write would abort every other item
+func TestMongoBulkInTx(t *testing.T) {
+	txm, err := NewMongoTxManager(context.Background(), rpsM.client)
+	require.NoError(t, err)
+	inTx := txBulkRepository{bulkRepository: rpsM, txm: txm}
+	checkBulkBestEffort(t, inTx)
+	checkBulkAtomic(t, inTx)
+	checkBulkCreate(t, inTx)
 }
@@ -0,0 +1,454 @@
+// Package repository provides functions for interacting with a database
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// MemoryConnection is an in-memory person storage, safe for concurrent use
+type MemoryConnection struct {
+	mu      sync.RWMutex
+	persons map[uuid.UUID]model.Person
+}
+
+// NewMemoryConnection is a constructor for MemoryConnection
+func NewMemoryConnection() *MemoryConnection {
+	return &MemoryConnection{persons: make(map[uuid.UUID]model.Person)}
+}
+
+// GetByID returns a copy of the person with the given ID
+func (db *MemoryConnection) GetByID(_ context.Context, ID uuid.UUID) (*model.Person, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	person, ok := db.persons[ID]
+	if !ok || person.DeletedAt != nil {
+		return nil, fmt.Errorf("GetByID: person %v: %w", ID, model.ErrNotFound)
+	}
+	return &person, nil
+}
+
+// GetAll returns copies of all stored persons
+func (db *MemoryConnection) GetAll(_ context.Context) ([]*model.Person, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	results := make([]*model.Person, 0, len(db.persons))
+	for _, person := range db.persons {
+		person := person
+		if person.DeletedAt == nil {
+			results = append(results, &person)
+		}
+	}
+	return results, nil
+}
+
+// Delete soft deletes the person with the given ID and version
+func (db *MemoryConnection) Delete(_ context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	person, ok := db.persons[uuidString]
+	if !ok || person.DeletedAt != nil {
+		return uuid.Nil, fmt.Errorf("Delete: person %v: %w", uuidString, model.ErrNotFound)
+	}
+	if person.Version != version {
+		return uuid.Nil, fmt.Errorf("Delete: %w", model.ErrVersionConflict)
+	}
+	now := time.Now().UTC()
+	person.DeletedAt = &now
+	person.Version++
+	db.persons[uuidString] = person
+	return uuidString, nil
+}
+
+// Create stores a copy of the person, generating an ID if it has none
+func (db *MemoryConnection) Create(_ context.Context, entity *model.Person) (uuid.UUID, error) {
+	if entity.ID == uuid.Nil {
+		entity.ID = uuid.New()
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.persons[entity.ID]; ok {
+		return uuid.Nil, fmt.Errorf("Create: person %v: %w", entity.ID, model.ErrAlreadyExists)
+	}
+	entity.Version = 1
+	entity.DeletedAt = nil
+	db.persons[entity.ID] = *entity
+	return entity.ID, nil
+}
+
+// Update overwrites the person with the given ID,
+// person.Version must match the stored version and is incremented on success
+func (db *MemoryConnection) Update(_ context.Context, uuidString uuid.UUID, person *model.Person) (uuid.UUID, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.persons[uuidString]
+	if !ok || stored.DeletedAt != nil {
+		return uuid.Nil, fmt.Errorf("Update: person %v: %w", uuidString, model.ErrNotFound)
+	}
+	if stored.Version != person.Version {
+		return uuid.Nil, fmt.Errorf("Update: %w", model.ErrVersionConflict)
+	}
+	person.ID = uuidString
+	person.Version++
+	person.DeletedAt = nil
+	db.persons[uuidString] = *person
+	return uuidString, nil
+}
+
+// GetDeleted returns soft deleted persons, recently deleted first
+func (db *MemoryConnection) GetDeleted(_ context.Context) ([]*model.Person, error) {
+	db.mu.RLock()
+	var results []*model.Person
+	for _, person := range db.persons {
+		person := person
+		if person.DeletedAt != nil {
+			results = append(results, &person)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].DeletedAt.After(*results[j].DeletedAt) })
+	return results, nil
+}
+
+// Restore brings a soft deleted person back
+func (db *MemoryConnection) Restore(_ context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	person, ok := db.persons[uuidString]
+	if !ok || person.DeletedAt == nil {
+		return uuid.Nil, fmt.Errorf("Restore: deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	person.DeletedAt = nil
+	person.Version++
+	db.persons[uuidString] = person
+	return uuidString, nil
+}
+
+// Purge permanently removes a soft deleted person
+func (db *MemoryConnection) Purge(_ context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	person, ok := db.persons[uuidString]
+	if !ok || person.DeletedAt == nil {
+		return uuid.Nil, fmt.Errorf("Purge: deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	delete(db.persons, uuidString)
+	return uuidString, nil
+}
+
+// GetPage returns a filtered and sorted page of persons
+func (db *MemoryConnection) GetPage(_ context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	q, err := newPageQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("newPageQuery: %w", err)
+	}
+	db.mu.RLock()
+	matched := make([]*model.Person, 0, len(db.persons))
+	for _, person := range db.persons {
+		person := person
+		if q.matches(&person) {
+			matched = append(matched, &person)
+		}
+	}
+	db.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool { return q.less(matched[i], matched[j]) })
+	results := make([]*model.Person, 0, q.Limit+1)
+	skipped := 0
+	for _, person := range matched {
+		if !q.afterCursor(person) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		results = append(results, person)
+		if len(results) > q.Limit {
+			break
+		}
+	}
+	return q.page(results, int64(len(matched)))
+}
+
+// GetByIDs returns copies of the not deleted persons with the given IDs
+func (db *MemoryConnection) GetByIDs(_ context.Context, ids []uuid.UUID) ([]*model.Person, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var results []*model.Person
+	for _, id := range ids {
+		person, ok := db.persons[id]
+		if ok && person.DeletedAt == nil {
+			results = append(results, &person)
+		}
+	}
+	return results, nil
+}
+
+// CreateMany stores copies of the persons, in atomic mode only if all of them can be stored
+func (db *MemoryConnection) CreateMany(_ context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.applyMany(len(persons), atomic, func(i int, staged map[uuid.UUID]model.Person) error {
+		person := persons[i]
+		if person.ID == uuid.Nil {
+			person.ID = uuid.New()
+		}
+		if _, ok := db.staged(staged, person.ID); ok {
+			return fmt.Errorf("CreateMany: person %v: %w", person.ID, model.ErrAlreadyExists)
+		}
+		stored := *person
+		stored.Version = 1
+		stored.DeletedAt = nil
+		staged[person.ID] = stored
+		return nil
+	}, func(i int) {
+		persons[i].Version = 1
+		persons[i].DeletedAt = nil
+	})
+}
+
+// UpdateMany overwrites the persons, in atomic mode only if all of them are current
+func (db *MemoryConnection) UpdateMany(_ context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.applyMany(len(persons), atomic, func(i int, staged map[uuid.UUID]model.Person) error {
+		person := persons[i]
+		stored, ok := db.staged(staged, person.ID)
+		if !ok || stored.DeletedAt != nil {
+			return model.ErrNotFound
+		}
+		if stored.Version != person.Version {
+			return model.ErrVersionConflict
+		}
+		updated := *person
+		updated.Version++
+		updated.DeletedAt = nil
+		staged[person.ID] = updated
+		return nil
+	}, func(i int) {
+		persons[i].Version++
+		persons[i].DeletedAt = nil
+	})
+}
+
+// DeleteMany soft deletes the persons, in atomic mode only if all of them are current
+func (db *MemoryConnection) DeleteMany(_ context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UTC()
+	return db.applyMany(len(items), atomic, func(i int, staged map[uuid.UUID]model.Person) error {
+		stored, ok := db.staged(staged, items[i].ID)
+		if !ok || stored.DeletedAt != nil {
+			return model.ErrNotFound
+		}
+		if stored.Version != items[i].Version {
+			return model.ErrVersionConflict
+		}
+		stored.DeletedAt = &now
+		stored.Version++
+		staged[stored.ID] = stored
+		return nil
+	}, nil)
+}
+
+// applyMany stages n items with apply and stores the staged persons unless an atomic request failed,
+// an optional done is called for every applied item after that, the caller holds the write lock
+func (db *MemoryConnection) applyMany(n int, atomic bool,
+	apply func(i int, staged map[uuid.UUID]model.Person) error, done func(i int)) ([]error, error) {
+	staged := make(map[uuid.UUID]model.Person, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		errs[i] = apply(i, staged)
+	}
+	if atomic && failed(errs) {
+		return errs, nil
+	}
+	for id, person := range staged {
+		db.persons[id] = person
+	}
+	for i := 0; i < n && done != nil; i++ {
+		if errs[i] == nil {
+			done(i)
+		}
+	}
+	return errs, nil
+}
+
+// staged returns the person with the given ID as staged by the running bulk request or stored
+func (db *MemoryConnection) staged(staged map[uuid.UUID]model.Person, id uuid.UUID) (model.Person, bool) {
+	if person, ok := staged[id]; ok {
+		return person, true
+	}
+	person, ok := db.persons[id]
+	return person, ok
+}
+
+// GetBatch returns copies of up to limit persons, deleted ones included, with IDs greater than after in ID order
+func (db *MemoryConnection) GetBatch(_ context.Context, after uuid.UUID, limit int) ([]*model.Person, error) {
+	db.mu.RLock()
+	results := make([]*model.Person, 0, len(db.persons))
+	for id, person := range db.persons {
+		person := person
+		if bytes.Compare(id[:], after[:]) > 0 {
+			results = append(results, &person)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(results, func(i, j int) bool { return bytes.Compare(results[i].ID[:], results[j].ID[:]) < 0 })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Import stores copies of the persons as they are, replacing the stored ones
+func (db *MemoryConnection) Import(_ context.Context, persons []*model.Person) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, person := range persons {
+		db.persons[person.ID] = *person
+	}
+	return nil
+}
+
+// memoryEntry is a cached value with its expiration time
+type memoryEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// MemoryCacheConnection is an in-memory stand-in for the person Redis cache
+type MemoryCacheConnection struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]memoryEntry[model.Person]
+	locks   map[uuid.UUID]memoryEntry[string]
+	queries map[string]memoryEntry[[]byte]
+	tags    map[string]int64
+}
+
+// NewMemoryCacheConnection is a constructor for MemoryCacheConnection
+func NewMemoryCacheConnection() *MemoryCacheConnection {
+	return &MemoryCacheConnection{
+		entries: make(map[uuid.UUID]memoryEntry[model.Person]),
+		locks:   make(map[uuid.UUID]memoryEntry[string]),
+		queries: make(map[string]memoryEntry[[]byte]),
+		tags:    make(map[string]int64),
+	}
+}
+
+// RedisGetByID returns a cached person
+func (rdb *MemoryCacheConnection) RedisGetByID(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	person, _, err := rdb.RedisGetWithTTL(ctx, id)
+	return person, err
+}
+
+// RedisGetWithTTL returns a cached person along with the time left until it expires
+func (rdb *MemoryCacheConnection) RedisGetWithTTL(_ context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	entry, ok := rdb.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(rdb.entries, id)
+		return nil, 0, fmt.Errorf(" Get: person %v: %w", id, model.ErrCacheMiss)
+	}
+	person := entry.value
+	return &person, time.Until(entry.expiresAt), nil
+}
+
+// RedisSetByID caches a copy of the person unless a newer version of it is cached
+func (rdb *MemoryCacheConnection) RedisSetByID(_ context.Context, entity *model.Person) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	if entry, ok := rdb.entries[entity.ID]; ok && time.Now().Before(entry.expiresAt) && entry.value.Version > entity.Version {
+		return nil
+	}
+	rdb.entries[entity.ID] = memoryEntry[model.Person]{value: *entity, expiresAt: time.Now().Add(jitteredTTL())}
+	return nil
+}
+
+// RedisLock takes the loading lock of a person for ttl unless somebody else holds it
+func (rdb *MemoryCacheConnection) RedisLock(_ context.Context, id uuid.UUID, ttl time.Duration) (string, bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	if lock, ok := rdb.locks[id]; ok && time.Now().Before(lock.expiresAt) {
+		return "", false, nil
+	}
+	token := uuid.New().String()
+	rdb.locks[id] = memoryEntry[string]{value: token, expiresAt: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+// RedisUnlock releases the loading lock of a person if it is still held with the token
+func (rdb *MemoryCacheConnection) RedisUnlock(_ context.Context, id uuid.UUID, token string) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	if lock, ok := rdb.locks[id]; ok && lock.value == token {
+		delete(rdb.locks, id)
+	}
+	return nil
+}
+
+// RedisQueryKey returns the key of a person query result for the current versions of its tags
+func (rdb *MemoryCacheConnection) RedisQueryKey(_ context.Context, hash string, tags ...string) (string, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	versions := make([]int64, len(tags))
+	for i, tag := range tags {
+		versions[i] = rdb.tags[tag]
+	}
+	return QueryKey(PersonCacheEntity, hash, versions), nil
+}
+
+// RedisGetQuery returns a cached query result
+func (rdb *MemoryCacheConnection) RedisGetQuery(_ context.Context, key string) ([]byte, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	entry, ok := rdb.queries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(rdb.queries, key)
+		return nil, fmt.Errorf(" Get: query %s: %w", key, model.ErrCacheMiss)
+	}
+	return cloneBytes(entry.value), nil
+}
+
+// RedisSetQuery caches a copy of a query result for QueryTTL, dropping expired results
+// which outdated tag versions left behind
+func (rdb *MemoryCacheConnection) RedisSetQuery(_ context.Context, key string, val []byte) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	now := time.Now()
+	for k, entry := range rdb.queries {
+		if now.After(entry.expiresAt) {
+			delete(rdb.queries, k)
+		}
+	}
+	rdb.queries[key] = memoryEntry[[]byte]{value: cloneBytes(val), expiresAt: time.Now().Add(QueryTTL)}
+	return nil
+}
+
+// RedisInvalidateTags bumps the versions of the tags at once
+func (rdb *MemoryCacheConnection) RedisInvalidateTags(_ context.Context, tags ...string) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	for _, tag := range tags {
+		rdb.tags[tag]++
+	}
+	return nil
+}
+
+// RedisDeleteByID removes the person from the cache, removing a person which is not cached is fine
+func (rdb *MemoryCacheConnection) RedisDeleteByID(_ context.Context, id uuid.UUID) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	delete(rdb.entries, id)
+	return nil
+}
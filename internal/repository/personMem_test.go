@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// entityEugen is the person the single-entity tests of every backend store
+var entityEugen = model.Person{
+	ID:        uuid.New(),
+	Name:      "Eugen",
+	Age:       20,
+	IsHealthy: true,
+}
+
+func TestMemoryCreate(t *testing.T) {
+	rpsMem := NewMemoryConnection()
+	entity := entityEugen
+	id, err := rpsMem.Create(context.Background(), &entity)
+	require.NoError(t, err)
+	testEntity, err := rpsMem.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, entity, *testEntity)
+	_, err = rpsMem.Create(context.Background(), &entity)
+	require.Error(t, err)
+}
+
+func TestMemoryGetByWrongID(t *testing.T) {
+	rpsMem := NewMemoryConnection()
+	entity, err := rpsMem.GetByID(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.Nil(t, entity)
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	rpsMem := NewMemoryConnection()
+	entity := entityEugen
+	id, err := rpsMem.Create(context.Background(), &entity)
+	require.NoError(t, err)
+	updatedID, err := rpsMem.Update(context.Background(), id, &model.Person{Name: "Updated", Age: 30, Version: 1})
+	require.NoError(t, err)
+	require.Equal(t, id, updatedID)
+	testEntity, err := rpsMem.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, id, testEntity.ID)
+	require.Equal(t, "Updated", testEntity.Name)
+	require.Equal(t, int64(2), testEntity.Version)
+	_, err = rpsMem.Update(context.Background(), uuid.New(), &entity)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestMemoryDelete(t *testing.T) {
+	rpsMem := NewMemoryConnection()
+	entity := entityEugen
+	id, err := rpsMem.Create(context.Background(), &entity)
+	require.NoError(t, err)
+	deletedID, err := rpsMem.Delete(context.Background(), id, entity.Version)
+	require.NoError(t, err)
+	require.Equal(t, id, deletedID)
+	_, err = rpsMem.Delete(context.Background(), id, entity.Version)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+// versionRepository is implemented by every person repository
+type versionRepository interface {
+	pageRepository
+	GetByID(ctx context.Context, id uuid.UUID) (*model.Person, error)
+	Update(ctx context.Context, uuidString uuid.UUID, entity *model.Person) (uuid.UUID, error)
+}
+
+// checkVersion makes sure stale updates and deletes of the given repository are rejected
+func checkVersion(t *testing.T, repo versionRepository) {
+	// Step 1: Create test entity with version 1
+	entity := model.Person{ID: uuid.New(), Name: "Versioned", Age: 20}
+	id, err := repo.Create(context.Background(), &entity)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), entity.Version)
+	// Step 2: Two writers read version 1, the first one wins
+	first, second := entity, entity
+	first.Age, second.Age = 21, 22
+	_, err = repo.Update(context.Background(), id, &first)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), first.Version)
+	_, err = repo.Update(context.Background(), id, &second)
+	require.ErrorIs(t, err, model.ErrVersionConflict)
+	stored, err := repo.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, 21, stored.Age)
+	require.Equal(t, int64(2), stored.Version)
+	// Step 3: Stale delete is rejected, current one succeeds
+	_, err = repo.Delete(context.Background(), id, 1)
+	require.ErrorIs(t, err, model.ErrVersionConflict)
+	_, err = repo.Delete(context.Background(), id, 2)
+	require.NoError(t, err)
+}
+
+func TestMemoryVersion(t *testing.T) {
+	checkVersion(t, NewMemoryConnection())
+}
+
+// trashRepository is implemented by every person repository
+type trashRepository interface {
+	versionRepository
+	GetAll(ctx context.Context) ([]*model.Person, error)
+	GetDeleted(ctx context.Context) ([]*model.Person, error)
+	Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+}
+
+// containsPerson reports whether the list has a person with the given ID
+func containsPerson(persons []*model.Person, id uuid.UUID) bool {
+	for _, person := range persons {
+		if person.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSoftDelete walks a person of the given repository through delete, restore and purge
+func checkSoftDelete(t *testing.T, repo trashRepository) {
+	// Step 1: Soft deleted person is hidden from normal reads
+	entity := model.Person{ID: uuid.New(), Name: "Trashed", Age: 20}
+	id, err := repo.Create(context.Background(), &entity)
+	require.NoError(t, err)
+	_, err = repo.Delete(context.Background(), id, entity.Version)
+	require.NoError(t, err)
+	_, err = repo.GetByID(context.Background(), id)
+	require.Error(t, err)
+	all, err := repo.GetAll(context.Background())
+	require.NoError(t, err)
+	require.False(t, containsPerson(all, id))
+	_, err = repo.Purge(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	// Step 2: It is listed in the trash and can be restored
+	deleted, err := repo.GetDeleted(context.Background())
+	require.NoError(t, err)
+	require.True(t, containsPerson(deleted, id))
+	_, err = repo.Restore(context.Background(), id)
+	require.NoError(t, err)
+	restored, err := repo.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Nil(t, restored.DeletedAt)
+	require.Equal(t, int64(3), restored.Version)
+	_, err = repo.Purge(context.Background(), id)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	// Step 3: Purge removes it permanently
+	_, err = repo.Delete(context.Background(), id, restored.Version)
+	require.NoError(t, err)
+	_, err = repo.Purge(context.Background(), id)
+	require.NoError(t, err)
+	_, err = repo.Restore(context.Background(), id)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestMemorySoftDelete(t *testing.T) {
+	checkSoftDelete(t, NewMemoryConnection())
+}
+
+func TestMemoryConcurrentCreate(t *testing.T) {
+	rpsMem := NewMemoryConnection()
+	errs := make([]error, 50)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = rpsMem.Create(context.Background(), &model.Person{Name: "Eugen", Age: 20})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	all, err := rpsMem.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Len(t, all, 50)
+}
+
+func TestMemoryCache(t *testing.T) {
+	rdbMem := NewMemoryCacheConnection()
+	err := rdbMem.RedisSetByID(context.Background(), &entityEugen)
+	require.NoError(t, err)
+	entity, err := rdbMem.RedisGetByID(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+	require.Equal(t, entityEugen, *entity)
+	err = rdbMem.RedisDeleteByID(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+	_, err = rdbMem.RedisGetByID(context.Background(), entityEugen.ID)
+	require.Error(t, err)
+	err = rdbMem.RedisDeleteByID(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+}
+
+func TestMemoryCacheLock(t *testing.T) {
+	rdbMem := NewMemoryCacheConnection()
+	id := uuid.New()
+	token, ok, err := rdbMem.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = rdbMem.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, rdbMem.RedisUnlock(context.Background(), id, token))
+	_, ok, err = rdbMem.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// queryCache is implemented by both person caches
+type queryCache interface {
+	RedisQueryKey(ctx context.Context, hash string, tags ...string) (string, error)
+	RedisGetQuery(ctx context.Context, key string) ([]byte, error)
+	RedisSetQuery(ctx context.Context, key string, val []byte) error
+	RedisInvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// checkQueryCache checks that bumping a tag hides the results depending on it
+func checkQueryCache(t *testing.T, cache queryCache) {
+	ctx := context.Background()
+	list, trash := "list-"+uuid.NewString(), "trash-"+uuid.NewString()
+	key, err := cache.RedisQueryKey(ctx, "hash", list)
+	require.NoError(t, err)
+	require.NoError(t, cache.RedisSetQuery(ctx, key, []byte("page")))
+	val, err := cache.RedisGetQuery(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("page"), val)
+	// Step 1: bumping another tag keeps the result
+	require.NoError(t, cache.RedisInvalidateTags(ctx, trash))
+	same, err := cache.RedisQueryKey(ctx, "hash", list)
+	require.NoError(t, err)
+	require.Equal(t, key, same)
+	// Step 2: bumping its tag hides the result
+	require.NoError(t, cache.RedisInvalidateTags(ctx, list, trash))
+	next, err := cache.RedisQueryKey(ctx, "hash", list)
+	require.NoError(t, err)
+	require.NotEqual(t, key, next)
+	_, err = cache.RedisGetQuery(ctx, next)
+	require.Error(t, err)
+}
+
+func TestMemoryQueryCache(t *testing.T) {
+	checkQueryCache(t, NewMemoryCacheConnection())
+}
@@ -5,11 +5,15 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/eugenshima/myapp/internal/model"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -26,10 +30,13 @@ func NewPsqlConnection(pool *pgxpool.Pool) *PsqlConnection {
 // GetByID function executes SQL request to select all rows, where id=Id
 func (db *PsqlConnection) GetByID(ctx context.Context, ID uuid.UUID) (*model.Person, error) {
 	var person model.Person
-	query := `SELECT id, name, age, is_healthy FROM goschema.person WHERE id=$1`
+	query := `SELECT id, name, age, is_healthy, version FROM goschema.person WHERE id=$1 AND deleted_at IS NULL`
 
 	// Execute a SQL query on a database
-	err := db.pool.QueryRow(ctx, query, ID).Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy)
+	err := conn(ctx, db.pool).QueryRow(ctx, query, ID).Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): person %v: %w", ID, model.ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("QueryRow(): %w", err)
 	}
@@ -38,7 +45,7 @@ func (db *PsqlConnection) GetByID(ctx context.Context, ID uuid.UUID) (*model.Per
 
 // GetAll function executes SQL request to select all rows from Database
 func (db *PsqlConnection) GetAll(ctx context.Context) ([]*model.Person, error) {
-	rows, err := db.pool.Query(ctx, "SELECT id, name, age, is_healthy FROM goschema.person")
+	rows, err := conn(ctx, db.pool).Query(ctx, "SELECT id, name, age, is_healthy, version FROM goschema.person WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, fmt.Errorf("Query(): %w", err)
 	}
@@ -50,7 +57,7 @@ func (db *PsqlConnection) GetAll(ctx context.Context) ([]*model.Person, error) {
 	// go;) through each line
 	for rows.Next() {
 		person := &model.Person{}
-		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy)
+		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version)
 		if err != nil {
 			return nil, fmt.Errorf("Scan(): %w", err) // Returning error message
 		}
@@ -59,44 +66,493 @@ func (db *PsqlConnection) GetAll(ctx context.Context) ([]*model.Person, error) {
 	return results, rows.Err()
 }
 
-// Delete function executes SQL reauest to delete row with certain uuid
-func (db *PsqlConnection) Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+// Delete function executes SQL reauest to soft delete row with certain uuid and version
+func (db *PsqlConnection) Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error) {
 	// Execute a SQL query on a database
-	err := db.pool.QueryRow(ctx, `SELECT id FROM goschema.person WHERE id=$1`, uuidString).Scan(&uuidString)
+	err := conn(ctx, db.pool).QueryRow(ctx, `SELECT id FROM goschema.person WHERE id=$1 AND deleted_at IS NULL`, uuidString).Scan(&uuidString)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("QueryRow(): person %v: %w", uuidString, model.ErrNotFound)
+	}
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("QueryRow(): %w", err)
 	}
-	bd, err := db.pool.Exec(ctx, "DELETE FROM goschema.person WHERE id=$1", uuidString)
-	if err != nil && !bd.Delete() {
+	bd, err := conn(ctx, db.pool).Exec(ctx, "UPDATE goschema.person SET deleted_at=now(), version=version+1 WHERE id=$1 AND version=$2 AND deleted_at IS NULL", uuidString, version)
+	if err != nil {
 		return uuid.Nil, fmt.Errorf("Exec(): %w", err) // Returning error message
 	}
+	if bd.RowsAffected() == 0 {
+		return uuid.Nil, fmt.Errorf("Exec(): %w", model.ErrVersionConflict)
+	}
 	return uuidString, nil
 }
 
-// Create function executes SQL request to insert person into database
+// Create function executes SQL request to insert person into database, the person keeps its ID
+// and gets a new one if it has none. A taken ID gives ErrAlreadyExists
 func (db *PsqlConnection) Create(ctx context.Context, entity *model.Person) (uuid.UUID, error) {
-	entity.ID = uuid.New()
+	if entity.ID == uuid.Nil {
+		entity.ID = uuid.New()
+	}
 
-	bd, err := db.pool.Exec(ctx,
-		`INSERT INTO goschema.person (id, name, age, is_healthy) 
-	VALUES($1,$2,$3,$4)`,
+	bd, err := conn(ctx, db.pool).Exec(ctx,
+		`INSERT INTO goschema.person (id, name, age, is_healthy, version) 
+	VALUES($1,$2,$3,$4,1) ON CONFLICT (id) DO NOTHING`,
 		entity.ID, entity.Name, entity.Age, entity.IsHealthy)
-	if err != nil && !bd.Insert() {
+	if err != nil {
 		return uuid.Nil, fmt.Errorf("Exec(): %w", err) // Returning error message
 	}
+	if bd.RowsAffected() == 0 {
+		return uuid.Nil, fmt.Errorf("Exec(): person %v: %w", entity.ID, model.ErrAlreadyExists)
+	}
+	entity.Version = 1
+	entity.DeletedAt = nil
 	return entity.ID, nil
 }
 
-// Update function executes SQL request to update person data in database
+// Update function executes SQL request to update person data in database,
+// person.Version must match the stored version and is incremented on success
 func (db *PsqlConnection) Update(ctx context.Context, uuidString uuid.UUID, person *model.Person) (uuid.UUID, error) {
 	// Execute a SQL query on a database
-	err := db.pool.QueryRow(ctx, `SELECT id FROM goschema.person WHERE id=$1`, uuidString).Scan(&uuidString)
+	err := conn(ctx, db.pool).QueryRow(ctx, `SELECT id FROM goschema.person WHERE id=$1 AND deleted_at IS NULL`, uuidString).Scan(&uuidString)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("QueryRow(): person %v: %w", uuidString, model.ErrNotFound)
+	}
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("QueryRow(): %w", err)
 	}
-	bd, err := db.pool.Exec(ctx, "UPDATE goschema.person SET name=$1, age=$2, is_healthy=$3 WHERE id=$4", person.Name, person.Age, person.IsHealthy, uuidString)
-	if err != nil && !bd.Update() {
-		return uuid.Nil, fmt.Errorf("Exec(): %w", err) // Returning error message
+	err = conn(ctx, db.pool).QueryRow(ctx, `UPDATE goschema.person SET name=$1, age=$2, is_healthy=$3, version=version+1
+	WHERE id=$4 AND version=$5 AND deleted_at IS NULL RETURNING version`,
+		person.Name, person.Age, person.IsHealthy, uuidString, person.Version).Scan(&person.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("QueryRow(): %w", model.ErrVersionConflict)
 	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("QueryRow(): %w", err) // Returning error message
+	}
+	person.ID = uuidString
 	return uuidString, nil
 }
+
+// GetDeleted function executes SQL request to select soft deleted persons, recently deleted first
+func (db *PsqlConnection) GetDeleted(ctx context.Context) ([]*model.Person, error) {
+	rows, err := conn(ctx, db.pool).Query(ctx, `SELECT id, name, age, is_healthy, version, deleted_at FROM goschema.person
+	WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	var results []*model.Person
+	for rows.Next() {
+		person := &model.Person{}
+		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version, &person.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		results = append(results, person)
+	}
+	return results, rows.Err()
+}
+
+// Restore function executes SQL request to bring a soft deleted person back
+func (db *PsqlConnection) Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	bd, err := conn(ctx, db.pool).Exec(ctx, "UPDATE goschema.person SET deleted_at=NULL, version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL", uuidString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Exec(): %w", err)
+	}
+	if bd.RowsAffected() == 0 {
+		return uuid.Nil, fmt.Errorf("Exec(): deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	return uuidString, nil
+}
+
+// Purge function executes SQL request to permanently delete a soft deleted person
+func (db *PsqlConnection) Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
+	bd, err := conn(ctx, db.pool).Exec(ctx, "DELETE FROM goschema.person WHERE id=$1 AND deleted_at IS NOT NULL", uuidString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Exec(): %w", err)
+	}
+	if bd.RowsAffected() == 0 {
+		return uuid.Nil, fmt.Errorf("Exec(): deleted person %v: %w", uuidString, model.ErrNotFound)
+	}
+	return uuidString, nil
+}
+
+// GetPage function executes SQL requests to select a filtered and sorted page of persons
+func (db *PsqlConnection) GetPage(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	q, err := newPageQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("newPageQuery: %w", err)
+	}
+	conds := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	where := func(cond string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conds = append(conds, fmt.Sprintf(cond, placeholders...))
+	}
+	if q.MinAge != nil {
+		where("age >= $%d", *q.MinAge)
+	}
+	if q.MaxAge != nil {
+		where("age <= $%d", *q.MaxAge)
+	}
+	if q.IsHealthy != nil {
+		where("is_healthy = $%d", *q.IsHealthy)
+	}
+	if q.NamePrefix != "" {
+		where(`starts_with("name", $%d)`, q.NamePrefix)
+	}
+
+	var total int64
+	err = conn(ctx, db.pool).QueryRow(ctx, "SELECT count(*) FROM goschema.person"+sqlWhere(conds), args...).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+
+	column, op, order := `"name"`, ">", "ASC"
+	if q.SortBy == sortByAge {
+		column = "age"
+	}
+	if q.desc() {
+		op, order = "<", "DESC"
+	}
+	if q.cursor != nil {
+		var value interface{} = q.cursor.Name
+		if q.SortBy == sortByAge {
+			value = q.cursor.Age
+		}
+		where(fmt.Sprintf("(%s, id) %s ($%%d, $%%d)", column, op), value, q.cursor.ID)
+	}
+	args = append(args, q.Limit+1, q.Offset)
+	sql := fmt.Sprintf("SELECT id, name, age, is_healthy, version FROM goschema.person%s ORDER BY %s %s, id %s LIMIT $%d OFFSET $%d",
+		sqlWhere(conds), column, order, order, len(args)-1, len(args))
+	rows, err := conn(ctx, db.pool).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Person, 0, q.Limit+1)
+	for rows.Next() {
+		person := &model.Person{}
+		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		results = append(results, person)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Rows(): %w", err)
+	}
+	return q.page(results, total)
+}
+
+// sqlWhere joins the conditions into a WHERE clause
+func sqlWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// GetByIDs function executes SQL request to select the not deleted persons with the given IDs
+func (db *PsqlConnection) GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Person, error) {
+	return getByIDs(ctx, conn(ctx, db.pool), ids)
+}
+
+// getByIDs selects the not deleted persons with the given IDs through q
+func getByIDs(ctx context.Context, q pgxConn, ids []uuid.UUID) ([]*model.Person, error) {
+	rows, err := q.Query(ctx, `SELECT id, name, age, is_healthy, version FROM goschema.person
+	WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL`, uuidStrings(ids))
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	var results []*model.Person
+	for rows.Next() {
+		person := &model.Person{}
+		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		results = append(results, person)
+	}
+	return results, rows.Err()
+}
+
+// CreateMany function executes a batch of inserts in a single transaction, persons keep their IDs
+// and get a new one if they have none. A person whose ID is taken gets ErrAlreadyExists,
+// in atomic mode it rolls the transaction back
+func (db *PsqlConnection) CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	for _, person := range persons {
+		if person.ID == uuid.Nil {
+			person.ID = uuid.New()
+		}
+	}
+	errs, err := db.runBatch(ctx, len(persons), atomic, func(batch *pgx.Batch, i int) {
+		person := persons[i]
+		batch.Queue(`INSERT INTO goschema.person (id, name, age, is_healthy, version) VALUES($1,$2,$3,$4,1)
+		ON CONFLICT (id) DO NOTHING`, person.ID, person.Name, person.Age, person.IsHealthy)
+	}, func(_ int, res pgx.BatchResults) (bool, error) {
+		tag, err := res.Exec()
+		return err == nil && tag.RowsAffected() == 1, err
+	}, func(_ context.Context, _ pgxConn, failed []int, errs []error) error {
+		for _, i := range failed {
+			errs[i] = model.ErrAlreadyExists
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if atomic && failed(errs) {
+		return errs, nil
+	}
+	for i, person := range persons {
+		if errs[i] == nil {
+			person.Version = 1
+			person.DeletedAt = nil
+		}
+	}
+	return errs, nil
+}
+
+// UpdateMany function executes a batch of updates in a single transaction,
+// in atomic mode a single failed item rolls the transaction back
+func (db *PsqlConnection) UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	versions := make([]int64, len(persons))
+	errs, err := db.runBatch(ctx, len(persons), atomic, func(batch *pgx.Batch, i int) {
+		person := persons[i]
+		batch.Queue(`UPDATE goschema.person SET name=$1, age=$2, is_healthy=$3, version=version+1
+		WHERE id=$4 AND version=$5 AND deleted_at IS NULL RETURNING version`,
+			person.Name, person.Age, person.IsHealthy, person.ID, person.Version)
+	}, func(i int, res pgx.BatchResults) (bool, error) {
+		err := res.QueryRow().Scan(&versions[i])
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}, versionErrors(func(i int) uuid.UUID { return persons[i].ID }))
+	if err != nil {
+		return nil, err
+	}
+	if atomic && failed(errs) {
+		return errs, nil
+	}
+	for i, person := range persons {
+		if errs[i] == nil {
+			person.Version = versions[i]
+		}
+	}
+	return errs, nil
+}
+
+// DeleteMany function executes a batch of soft deletes in a single transaction,
+// in atomic mode a single failed item rolls the transaction back
+func (db *PsqlConnection) DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	return db.runBatch(ctx, len(items), atomic, func(batch *pgx.Batch, i int) {
+		batch.Queue("UPDATE goschema.person SET deleted_at=now(), version=version+1 WHERE id=$1 AND version=$2 AND deleted_at IS NULL",
+			items[i].ID, items[i].Version)
+	}, func(_ int, res pgx.BatchResults) (bool, error) {
+		tag, err := res.Exec()
+		return err == nil && tag.RowsAffected() == 1, err
+	}, versionErrors(func(i int) uuid.UUID { return items[i].ID }))
+}
+
+// runBatch runs the n statements queued by queue in a transaction (a savepoint inside PsqlTxManager) and reads
+// every result with scan, the errors of the items that scan reports as not applied are set by notApplied.
+// Atomic mode sends every statement in one batch and an item failing in PostgreSQL rolls the transaction back.
+// Best-effort mode runs each statement in its own savepoint, so an item failing in PostgreSQL gets the error
+// and the other items are kept
+func (db *PsqlConnection) runBatch(ctx context.Context, n int, atomic bool,
+	queue func(batch *pgx.Batch, i int),
+	scan func(i int, res pgx.BatchResults) (bool, error),
+	notApplied func(ctx context.Context, q pgxConn, failed []int, errs []error) error) ([]error, error) {
+	tx, err := conn(ctx, db.pool).Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Begin(): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	errs := make([]error, n)
+	var missed []int
+	if atomic {
+		missed, err = sendBatch(ctx, tx, 0, n, queue, scan, errs)
+	} else {
+		for i := 0; i < n && err == nil; i++ {
+			var itemMissed []int
+			itemMissed, err = sendItem(ctx, tx, i, queue, scan, errs)
+			missed = append(missed, itemMissed...)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if atomic && failed(errs) {
+		return errs, nil
+	}
+
+	if len(missed) > 0 {
+		err = notApplied(ctx, tx, missed, errs)
+		if err != nil {
+			return nil, err
+		}
+		if atomic {
+			return errs, nil
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Commit(): %w", err)
+	}
+	return errs, nil
+}
+
+// sendItem runs the statement of item i in a savepoint, which is rolled back if the item fails in PostgreSQL
+func sendItem(ctx context.Context, tx pgx.Tx, i int,
+	queue func(batch *pgx.Batch, i int),
+	scan func(i int, res pgx.BatchResults) (bool, error), errs []error) ([]int, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Begin(): item %d: %w", i, err)
+	}
+	failed, err := sendBatch(ctx, savepoint, i, i+1, queue, scan, errs)
+	if err != nil || errs[i] != nil {
+		_ = savepoint.Rollback(ctx)
+		return nil, err
+	}
+	err = savepoint.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Commit(): item %d: %w", i, err)
+	}
+	return failed, nil
+}
+
+// sendBatch sends the statements of the items from up to to in one batch and reads their results with scan.
+// The first item failing in PostgreSQL gets the error and ends the batch, the items after it are not run
+func sendBatch(ctx context.Context, q pgxConn, from, to int,
+	queue func(batch *pgx.Batch, i int),
+	scan func(i int, res pgx.BatchResults) (bool, error), errs []error) ([]int, error) {
+	batch := &pgx.Batch{}
+	for i := from; i < to; i++ {
+		queue(batch, i)
+	}
+	res := q.SendBatch(ctx, batch)
+	var failed []int
+	for i := from; i < to; i++ {
+		ok, err := scan(i, res)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			errs[i] = err
+			_ = res.Close()
+			return failed, nil
+		}
+		if err != nil {
+			_ = res.Close()
+			return nil, fmt.Errorf("SendBatch(): item %d: %w", i, err)
+		}
+		if !ok {
+			failed = append(failed, i)
+		}
+	}
+	err := res.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Close(): %w", err)
+	}
+	return failed, nil
+}
+
+// versionErrors tells why versioned writes were not applied, ErrVersionConflict for persons which exist
+// and ErrNotFound for the others
+func versionErrors(id func(i int) uuid.UUID) func(ctx context.Context, q pgxConn, failed []int, errs []error) error {
+	return func(ctx context.Context, q pgxConn, failed []int, errs []error) error {
+		ids := make([]uuid.UUID, len(failed))
+		for j, i := range failed {
+			ids[j] = id(i)
+		}
+		persons, err := getByIDs(ctx, q, ids)
+		if err != nil {
+			return err
+		}
+		existing := make(map[uuid.UUID]bool, len(persons))
+		for _, person := range persons {
+			existing[person.ID] = true
+		}
+		for _, i := range failed {
+			errs[i] = model.ErrNotFound
+			if existing[id(i)] {
+				errs[i] = model.ErrVersionConflict
+			}
+		}
+		return nil
+	}
+}
+
+// uuidStrings formats ids for a uuid[] parameter
+func uuidStrings(ids []uuid.UUID) []string {
+	res := make([]string, len(ids))
+	for i, id := range ids {
+		res[i] = id.String()
+	}
+	return res
+}
+
+// GetBatch function executes SQL request to select up to limit persons, deleted ones included,
+// with IDs greater than after in ID order
+func (db *PsqlConnection) GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.Person, error) {
+	rows, err := conn(ctx, db.pool).Query(ctx, `SELECT id, name, age, is_healthy, version, deleted_at FROM goschema.person
+	WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]*model.Person, 0, limit)
+	for rows.Next() {
+		person := &model.Person{}
+		err := rows.Scan(&person.ID, &person.Name, &person.Age, &person.IsHealthy, &person.Version, &person.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		results = append(results, person)
+	}
+	return results, rows.Err()
+}
+
+// Import function replaces the persons with the given ones as they are, keeping IDs, versions
+// and deletion times, importing the same persons twice is harmless
+func (db *PsqlConnection) Import(ctx context.Context, persons []*model.Person) error {
+	ids := make([]uuid.UUID, len(persons))
+	rows := make([][]interface{}, len(persons))
+	for i, person := range persons {
+		ids[i] = person.ID
+		rows[i] = []interface{}{person.ID, person.Name, person.Age, person.IsHealthy, person.Version, person.DeletedAt}
+	}
+	tx, err := conn(ctx, db.pool).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin(): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	_, err = tx.Exec(ctx, "DELETE FROM goschema.person WHERE id = ANY($1::uuid[])", uuidStrings(ids))
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"goschema", "person"},
+		[]string{"id", "name", "age", "is_healthy", "version", "deleted_at"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("CopyFrom(): %w", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("Commit(): %w", err)
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
+//go:build integration
+
 package repository
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/eugenshima/myapp/internal/model"
@@ -12,43 +15,49 @@ import (
 
 var rps *PsqlConnection
 
-var entityEugen = model.Person{
-	ID:        uuid.New(),
-	Name:      "Eugen",
-	Age:       20,
-	IsHealthy: true,
+// newPgxEugen returns a copy of entityEugen without ID, Create gives it a new one
+func newPgxEugen() *model.Person {
+	entity := entityEugen
+	entity.ID = uuid.Nil
+	return &entity
 }
 
 func TestPgxCreate(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
-	require.NoError(t, err)
-	testEntity, err := rps.GetByID(context.Background(), entityEugen.ID)
-	require.NoError(t, err)
-	require.Equal(t, testEntity.ID, entityEugen.ID)
-	require.Equal(t, testEntity.Name, entityEugen.Name)
-	require.Equal(t, testEntity.Age, entityEugen.Age)
-	require.Equal(t, testEntity.IsHealthy, entityEugen.IsHealthy)
-	deletedID, err := rps.Delete(context.Background(), id)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
+	require.NoError(t, err)
+	testEntity, err := rps.GetByID(context.Background(), entity.ID)
+	require.NoError(t, err)
+	require.Equal(t, testEntity.ID, entity.ID)
+	require.Equal(t, testEntity.Name, entity.Name)
+	require.Equal(t, testEntity.Age, entity.Age)
+	require.Equal(t, testEntity.IsHealthy, entity.IsHealthy)
+	again := *entity
+	_, err = rps.Create(context.Background(), &again)
+	require.ErrorIs(t, err, model.ErrAlreadyExists)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NotNil(t, deletedID)
 	require.NoError(t, err)
 }
 
 func TestPgxDelete(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
-	deletedID, err := rps.Delete(context.Background(), id)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NotNil(t, deletedID)
 	require.NoError(t, err)
 }
 
 func TestPgxDeleteNil(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
-	deletedID, err := rps.Delete(context.Background(), uuid.New())
-	require.Error(t, err)
+	deletedID, err := rps.Delete(context.Background(), uuid.New(), entity.Version)
+	require.ErrorIs(t, err, model.ErrNotFound)
 	require.NotNil(t, deletedID)
 	require.NotEqual(t, id, deletedID)
-	deletingTrash, err := rps.Delete(context.Background(), id)
+	deletingTrash, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NotNil(t, deletingTrash)
 	require.NoError(t, err)
 }
@@ -57,55 +66,110 @@ func TestPgxGetAll(t *testing.T) {
 	res, err := rps.GetAll(context.Background())
 	require.NoError(t, err)
 	var count int
-	err = rps.pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM  goschema.person").Scan(&count)
+	err = rps.pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM  goschema.person WHERE deleted_at IS NULL").Scan(&count)
 	require.NoError(t, err)
 	require.Equal(t, len(res), count)
 }
 
 func TestPgxUpdate(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
-	anotherID, err := rps.Update(context.Background(), entityEugen.ID, &entityEugen)
+	anotherID, err := rps.Update(context.Background(), entity.ID, entity)
 	require.NoError(t, err)
 	require.Equal(t, anotherID, id)
-	deletedID, err := rps.Delete(context.Background(), id)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NoError(t, err)
 	require.NotNil(t, deletedID)
 }
 
 func TestPgxUpdateNil(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
-	anotherID, err := rps.Update(context.Background(), uuid.New(), &entityEugen)
+	anotherID, err := rps.Update(context.Background(), uuid.New(), entity)
 	require.Error(t, err)
 	require.NotEqual(t, anotherID, id)
-	deletedID, err := rps.Delete(context.Background(), id)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NoError(t, err)
 	require.NotNil(t, deletedID)
 }
 
 func TestGetByID(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
 	testEntity, err := rps.GetByID(context.Background(), id)
 	require.NoError(t, err)
-	require.Equal(t, testEntity.ID, entityEugen.ID)
-	require.Equal(t, testEntity.Name, entityEugen.Name)
-	require.Equal(t, testEntity.Age, entityEugen.Age)
-	require.Equal(t, testEntity.IsHealthy, entityEugen.IsHealthy)
-	deletedID, err := rps.Delete(context.Background(), id)
+	require.Equal(t, testEntity.ID, entity.ID)
+	require.Equal(t, testEntity.Name, entity.Name)
+	require.Equal(t, testEntity.Age, entity.Age)
+	require.Equal(t, testEntity.IsHealthy, entity.IsHealthy)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NoError(t, err)
 	require.NotNil(t, deletedID)
 }
 
 func TestGetByWrongID(t *testing.T) {
-	id, err := rps.Create(context.Background(), &entityEugen)
+	entity := newPgxEugen()
+	id, err := rps.Create(context.Background(), entity)
 	require.NoError(t, err)
-	entityEugen.ID = uuid.New()
-	testEntity, err := rps.GetByID(context.Background(), entityEugen.ID)
+	entity.ID = uuid.New()
+	testEntity, err := rps.GetByID(context.Background(), entity.ID)
 	require.Error(t, err)
 	require.Nil(t, testEntity)
-	deletedID, err := rps.Delete(context.Background(), id)
+	deletedID, err := rps.Delete(context.Background(), id, entity.Version)
 	require.NoError(t, err)
 	require.NotNil(t, deletedID)
 }
+
+func TestPgxGetPage(t *testing.T) {
+	checkGetPage(t, rps)
+}
+
+func TestPgxVersion(t *testing.T) {
+	checkVersion(t, rps)
+}
+
+func TestPgxSoftDelete(t *testing.T) {
+	checkSoftDelete(t, rps)
+}
+
+func TestPgxBulk(t *testing.T) {
+	checkBulkBestEffort(t, rps)
+	checkBulkAtomic(t, rps)
+	checkBulkCreate(t, rps)
+	inTx := txBulkRepository{bulkRepository: rps, txm: NewPsqlTxManager(rps.pool)}
+	checkBulkBestEffort(t, inTx)
+	checkBulkAtomic(t, inTx)
+	checkBulkCreate(t, inTx)
+}
+
+func TestPgxBulkItemError(t *testing.T) {
+	ctx := context.Background()
+	persons := func() []*model.Person {
+		return []*model.Person{
+			{ID: uuid.New(), Name: "Bulk", Age: 20},
+			{ID: uuid.New(), Name: strings.Repeat("x", 300), Age: 21},
+			{ID: uuid.New(), Name: "Bulk", Age: 22},
+		}
+	}
+	// Step 1: In best effort mode a person PostgreSQL rejects gets the error and the others are created
+	bestEffort := persons()
+	errs, err := rps.CreateMany(ctx, bestEffort, false)
+	require.NoError(t, err)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+	found, err := rps.GetByIDs(ctx, []uuid.UUID{bestEffort[0].ID, bestEffort[1].ID, bestEffort[2].ID})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	// Step 2: In atomic mode it rolls every person back
+	atomic := persons()
+	errs, err = rps.CreateMany(ctx, atomic, true)
+	require.NoError(t, err)
+	require.Error(t, errs[1])
+	found, err = rps.GetByIDs(ctx, []uuid.UUID{atomic[0].ID, atomic[2].ID})
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
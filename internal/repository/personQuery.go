@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// const for person lists
+const (
+	DefaultPageLimit = 20
+	sortByName       = "name"
+	sortByAge        = "age"
+	orderDesc        = "desc"
+)
+
+// personCursor is the keyset position right after the last returned person
+type personCursor struct {
+	Name string    `json:"n,omitempty"`
+	Age  int       `json:"a,omitempty"`
+	ID   uuid.UUID `json:"id"`
+}
+
+// pageQuery is a PersonQuery with defaults applied and its cursor decoded
+type pageQuery struct {
+	model.PersonQuery
+	cursor *personCursor
+}
+
+// newPageQuery applies defaults to the query and decodes its cursor
+func newPageQuery(query *model.PersonQuery) (*pageQuery, error) {
+	q := &pageQuery{}
+	if query != nil {
+		q.PersonQuery = *query
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageLimit
+	}
+	if q.SortBy == "" {
+		q.SortBy = sortByName
+	}
+	if q.SortBy != sortByName && q.SortBy != sortByAge {
+		return nil, fmt.Errorf("unknown sort field %q", q.SortBy)
+	}
+	if q.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("DecodeString: %w", err)
+		}
+		q.cursor = &personCursor{}
+		err = json.Unmarshal(raw, q.cursor)
+		if err != nil {
+			return nil, fmt.Errorf("Unmarshal(): %w", err)
+		}
+		q.Offset = 0
+	}
+	return q, nil
+}
+
+// desc reports whether the query is sorted in descending order
+func (q *pageQuery) desc() bool {
+	return q.Order == orderDesc
+}
+
+// page trims the Limit+1 fetched persons to a page and fills the next cursor
+func (q *pageQuery) page(items []*model.Person, total int64) (*model.PersonPage, error) {
+	page := &model.PersonPage{Items: items, Total: total}
+	if len(items) <= q.Limit {
+		return page, nil
+	}
+	page.Items = items[:q.Limit]
+	last := page.Items[q.Limit-1]
+	raw, err := json.Marshal(personCursor{Name: last.Name, Age: last.Age, ID: last.ID})
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	return page, nil
+}
+
+// matches reports whether the person is not deleted and passes the query filters
+func (q *pageQuery) matches(person *model.Person) bool {
+	switch {
+	case person.DeletedAt != nil,
+		q.MinAge != nil && person.Age < *q.MinAge,
+		q.MaxAge != nil && person.Age > *q.MaxAge,
+		q.IsHealthy != nil && person.IsHealthy != *q.IsHealthy,
+		!strings.HasPrefix(person.Name, q.NamePrefix):
+		return false
+	}
+	return true
+}
+
+// compare orders two persons by the sort field with the ID as a tie-breaker,
+// it ignores the sort direction
+func (q *pageQuery) compare(a, b *personCursor) int {
+	switch {
+	case q.SortBy == sortByAge && a.Age != b.Age:
+		if a.Age < b.Age {
+			return -1
+		}
+		return 1
+	case q.SortBy == sortByName && a.Name != b.Name:
+		return strings.Compare(a.Name, b.Name)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// less reports whether person a goes before person b in the query order
+func (q *pageQuery) less(a, b *model.Person) bool {
+	cmp := q.compare(&personCursor{Name: a.Name, Age: a.Age, ID: a.ID}, &personCursor{Name: b.Name, Age: b.Age, ID: b.ID})
+	if q.desc() {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// afterCursor reports whether the person goes after the query cursor
+func (q *pageQuery) afterCursor(person *model.Person) bool {
+	if q.cursor == nil {
+		return true
+	}
+	cmp := q.compare(&personCursor{Name: person.Name, Age: person.Age, ID: person.ID}, q.cursor)
+	if q.desc() {
+		return cmp < 0
+	}
+	return cmp > 0
+}
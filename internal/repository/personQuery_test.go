@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// pageRepository is implemented by every person repository
+type pageRepository interface {
+	Create(ctx context.Context, entity *model.Person) (uuid.UUID, error)
+	Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error)
+	GetPage(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error)
+}
+
+// checkGetPage walks paginated, sorted and filtered person lists of the given repository
+func checkGetPage(t *testing.T, repo pageRepository) {
+	prefix := uuid.NewString()
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		id, err := repo.Create(context.Background(), &model.Person{
+			ID:        uuid.New(),
+			Name:      fmt.Sprintf("%s-%d", prefix, i),
+			Age:       20 + i%3,
+			IsHealthy: i%2 == 0,
+		})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			_, err := repo.Delete(context.Background(), id, 1)
+			require.NoError(t, err)
+		}
+	}()
+
+	// Step 1: keyset pagination visits every person exactly once
+	var names []string
+	query := &model.PersonQuery{Limit: 2, NamePrefix: prefix}
+	for {
+		page, err := repo.GetPage(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), page.Total)
+		for _, person := range page.Items {
+			names = append(names, person.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	require.Equal(t, []string{prefix + "-0", prefix + "-1", prefix + "-2", prefix + "-3", prefix + "-4"}, names)
+
+	// Step 2: offset pagination in descending age order
+	page, err := repo.GetPage(context.Background(), &model.PersonQuery{Limit: 2, Offset: 1, SortBy: "age", Order: "desc", NamePrefix: prefix})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	require.Equal(t, 21, page.Items[0].Age)
+	require.Equal(t, 21, page.Items[1].Age)
+	require.NotEmpty(t, page.NextCursor)
+
+	// Step 3: filters
+	minAge, healthy := 22, true
+	page, err = repo.GetPage(context.Background(), &model.PersonQuery{MinAge: &minAge, IsHealthy: &healthy, NamePrefix: prefix})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), page.Total)
+	require.Equal(t, prefix+"-2", page.Items[0].Name)
+	require.Empty(t, page.NextCursor)
+}
+
+func TestMemoryGetPage(t *testing.T) {
+	checkGetPage(t, NewMemoryConnection())
+}
+
+func TestGetPageWrongCursor(t *testing.T) {
+	page, err := NewMemoryConnection().GetPage(context.Background(), &model.PersonQuery{Cursor: "not a cursor"})
+	require.Error(t, err)
+	require.Nil(t, page)
+}
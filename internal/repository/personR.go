@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/eugenshima/myapp/internal/model"
@@ -26,48 +27,194 @@ func NewRedisConnection(rdb *redis.Client) *RedisConnection {
 // const for Redis
 const (
 	TTL = 20 * time.Minute
+	// QueryTTL bounds how long a query result outlives a lost tag invalidation
+	QueryTTL = time.Minute
+	// TTLJitter is the largest random amount cut off TTL, so keys cached together don't expire together
+	TTLJitter = TTL / 10
+	// unlockScript deletes a lock only if it still holds the caller's token
+	unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+	// setNewerScript caches a person unless the cached one has a newer version
+	setNewerScript = `local cached = redis.call("GET", KEYS[1])
+if cached then
+	local ok, person = pcall(cjson.decode, cached)
+	if ok and type(person) == "table" and tonumber(person.version) and tonumber(person.version) > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+return 1`
 )
 
-// RedisGetByID func returns a Redis entity by ID
+// RedisGetByID func returns a Redis entity by ID, it reads payloads of older schema versions too
 func (rdb *RedisConnection) RedisGetByID(ctx context.Context, id uuid.UUID) (*model.Person, error) {
-	val, err := rdb.rdb.Get(ctx, id.String()).Result()
+	person, _, err := rdb.RedisGetWithTTL(ctx, id)
+	return person, err
+}
+
+// RedisGetWithTTL func returns a Redis entity by ID along with the time left until it expires
+func (rdb *RedisConnection) RedisGetWithTTL(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	key := CacheKey(PersonCacheEntity, id)
+	pipe := rdb.rdb.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
 	if err != nil {
-		return nil, fmt.Errorf(" Get: %w", err)
+		return nil, 0, fmt.Errorf(" Get: %w", cacheMiss(err))
 	}
-	err = rdb.rdb.Expire(ctx, id.String(), TTL).Err()
+	cached := &model.PersonRedis{}
+	err = json.Unmarshal([]byte(get.Val()), cached)
 	if err != nil {
-		return nil, fmt.Errorf(" Expire: %w", err)
+		return nil, 0, fmt.Errorf(" Unmarshal: %w", err)
 	}
-	person := &model.Person{}
-	err = json.Unmarshal([]byte(val), &person)
+	err = checkPayloadVersion(cached.SchemaVersion)
 	if err != nil {
-		return nil, fmt.Errorf(" Unmarshal: %w", err)
+		return nil, 0, err
 	}
-	return person, nil
+	return &model.Person{
+		ID:        id,
+		Name:      cached.Name,
+		Age:       cached.Age,
+		IsHealthy: cached.IsHealthy,
+		Version:   cached.Version,
+	}, ttl.Val(), nil
 }
 
-// RedisSetByID func inserting entity to redis database
+// RedisSetByID func inserting entity to redis database, a cached entity with a newer version is kept
+// so a change reaching the cache late doesn't replace the one committed after it
 func (rdb *RedisConnection) RedisSetByID(ctx context.Context, entity *model.Person) error {
 	val, err := json.Marshal(model.PersonRedis{
-		Name:      entity.Name,
-		Age:       entity.Age,
-		IsHealthy: entity.IsHealthy,
+		SchemaVersion: CachePayloadVersion,
+		ID:            entity.ID,
+		Name:          entity.Name,
+		Age:           entity.Age,
+		IsHealthy:     entity.IsHealthy,
+		Version:       entity.Version,
 	})
 	if err != nil {
 		return fmt.Errorf(" Marshal: %w", err)
 	}
-	_, err = rdb.rdb.Set(ctx, entity.ID.String(), val, TTL).Result()
+	err = redis.NewScript(setNewerScript).Run(ctx, rdb.rdb, []string{CacheKey(PersonCacheEntity, entity.ID)},
+		val, entity.Version, jitteredTTL().Milliseconds()).Err()
 	if err != nil {
-		return fmt.Errorf(" Set: %w", err)
+		return fmt.Errorf(" Run: %w", err)
 	}
 	return nil
 }
 
-// RedisDeleteByID func deleting entity from redis database
+// RedisDeleteByID func deleting entity from redis database, deleting an entity which is not cached is not an error
 func (rdb *RedisConnection) RedisDeleteByID(ctx context.Context, id uuid.UUID) error {
-	res, err := rdb.rdb.Del(ctx, id.String()).Result()
-	if err != nil || res == 0 {
+	err := rdb.rdb.Del(ctx, CacheKey(PersonCacheEntity, id)).Err()
+	if err != nil {
 		return fmt.Errorf(" Del: %w", err)
 	}
 	return nil
 }
+
+// RedisLock func takes the loading lock of a person for ttl, it returns the lock token
+// and false if somebody else holds the lock
+func (rdb *RedisConnection) RedisLock(ctx context.Context, id uuid.UUID, ttl time.Duration) (string, bool, error) {
+	token := uuid.New().String()
+	ok, err := rdb.rdb.SetNX(ctx, LockKey(PersonCacheEntity, id), token, ttl).Result()
+	if err != nil {
+		return "", false, fmt.Errorf(" SetNX: %w", err)
+	}
+	return token, ok, nil
+}
+
+// RedisUnlock func releases the loading lock of a person if it is still held with the token
+func (rdb *RedisConnection) RedisUnlock(ctx context.Context, id uuid.UUID, token string) error {
+	err := redis.NewScript(unlockScript).Run(ctx, rdb.rdb, []string{LockKey(PersonCacheEntity, id)}, token).Err()
+	if err != nil {
+		return fmt.Errorf(" Run: %w", err)
+	}
+	return nil
+}
+
+// RedisQueryKey func returns the key of a person query result for the current versions of its tags
+func (rdb *RedisConnection) RedisQueryKey(ctx context.Context, hash string, tags ...string) (string, error) {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = TagKey(tag)
+	}
+	vals, err := rdb.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return "", fmt.Errorf(" MGet: %w", err)
+	}
+	versions := make([]int64, len(vals))
+	for i, val := range vals {
+		if val == nil {
+			continue
+		}
+		versions[i], err = strconv.ParseInt(val.(string), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf(" ParseInt: %w", err)
+		}
+	}
+	return QueryKey(PersonCacheEntity, hash, versions), nil
+}
+
+// RedisGetQuery func returns a cached query result
+func (rdb *RedisConnection) RedisGetQuery(ctx context.Context, key string) ([]byte, error) {
+	val, err := rdb.rdb.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf(" Get: %w", cacheMiss(err))
+	}
+	return val, nil
+}
+
+// RedisSetQuery func caches a query result for QueryTTL
+func (rdb *RedisConnection) RedisSetQuery(ctx context.Context, key string, val []byte) error {
+	err := rdb.rdb.Set(ctx, key, val, QueryTTL).Err()
+	if err != nil {
+		return fmt.Errorf(" Set: %w", err)
+	}
+	return nil
+}
+
+// RedisInvalidateTags func bumps the versions of the tags in a single transaction,
+// so the query results depending on any of them are never read again
+func (rdb *RedisConnection) RedisInvalidateTags(ctx context.Context, tags ...string) error {
+	_, err := rdb.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			pipe.Incr(ctx, TagKey(tag))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(" Incr: %w", err)
+	}
+	return nil
+}
+
+// RedisInspect func returns the cached person as stored, along with its key and the time left until it expires
+func (rdb *RedisConnection) RedisInspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error) {
+	key := CacheKey(PersonCacheEntity, id)
+	val, ttl, err := inspectKey(ctx, rdb.rdb, key)
+	if err != nil {
+		return nil, err
+	}
+	cached := &model.PersonRedis{}
+	err = json.Unmarshal([]byte(val), cached)
+	if err != nil {
+		return nil, fmt.Errorf(" Unmarshal: %w", err)
+	}
+	return &model.CacheEntry{
+		Entity:        PersonCacheEntity,
+		ID:            id,
+		Key:           key,
+		SchemaVersion: payloadVersion(cached.SchemaVersion),
+		TTLMillis:     ttl.Milliseconds(),
+		Value: &model.Person{
+			ID:        id,
+			Name:      cached.Name,
+			Age:       cached.Age,
+			IsHealthy: cached.IsHealthy,
+			Version:   cached.Version,
+		},
+	}, nil
+}
+
+// RedisFlush func deletes every cached person and person query result, it returns the number of deleted keys
+func (rdb *RedisConnection) RedisFlush(ctx context.Context) (int64, error) {
+	return flushEntity(ctx, rdb.rdb, PersonCacheEntity)
+}
@@ -1,8 +1,13 @@
+//go:build integration
+
 package repository
 
 import (
 	"context"
 	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -27,6 +32,24 @@ func TestRedisGetByID(t *testing.T) {
 	require.Equal(t, entityEugen.IsHealthy, entity.IsHealthy)
 }
 
+func TestRedisSetOlderVersion(t *testing.T) {
+	ctx := context.Background()
+	newer := model.Person{ID: uuid.New(), Name: "Newer", Age: 20, Version: 3}
+	older := newer
+	older.Name, older.Version = "Older", 2
+	require.NoError(t, redisConnPerson.RedisSetByID(ctx, &newer))
+	require.NoError(t, redisConnPerson.RedisSetByID(ctx, &older))
+	entity, err := redisConnPerson.RedisGetByID(ctx, newer.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Newer", entity.Name)
+	newer.Name, newer.Version = "Newest", 4
+	require.NoError(t, redisConnPerson.RedisSetByID(ctx, &newer))
+	entity, err = redisConnPerson.RedisGetByID(ctx, newer.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Newest", entity.Name)
+	require.NoError(t, redisConnPerson.RedisDeleteByID(ctx, newer.ID))
+}
+
 func TestRedisGetByWrongID(t *testing.T) {
 	entity, err := redisConnPerson.RedisGetByID(context.Background(), uuid.New())
 	require.Error(t, err)
@@ -42,5 +65,87 @@ func TestRedisDeleteByID(t *testing.T) {
 
 func TestRedisDeleteNil(t *testing.T) {
 	err := redisConnPerson.RedisDeleteByID(context.Background(), uuid.New())
+	require.NoError(t, err)
+}
+
+func TestRedisKeySchema(t *testing.T) {
+	err := redisConnPerson.RedisSetByID(context.Background(), &entityEugen)
+	require.NoError(t, err)
+	exists, err := redisConnPerson.rdb.Exists(context.Background(), "myapp:person:"+entityEugen.ID.String()).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), exists)
+	err = redisConnPerson.RedisDeleteByID(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+}
+
+func TestRedisOldPayload(t *testing.T) {
+	id := uuid.New()
+	// Step 1: a payload without schema version and ID is still read
+	err := redisConnPerson.rdb.Set(context.Background(), CacheKey(PersonCacheEntity, id),
+		`{"name":"Eugen","age":20,"ishealthy":true,"version":3}`, TTL).Err()
+	require.NoError(t, err)
+	entity, err := redisConnPerson.RedisGetByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, id, entity.ID)
+	require.Equal(t, int64(3), entity.Version)
+	// Step 2: a payload of a newer schema is refused
+	err = redisConnPerson.rdb.Set(context.Background(), CacheKey(PersonCacheEntity, id), `{"v":99,"name":"Eugen"}`, TTL).Err()
+	require.NoError(t, err)
+	_, err = redisConnPerson.RedisGetByID(context.Background(), id)
 	require.Error(t, err)
+	require.NoError(t, redisConnPerson.RedisDeleteByID(context.Background(), id))
+}
+
+func TestRedisLegacyKeys(t *testing.T) {
+	legacy := uuid.New().String()
+	err := redisConnPerson.rdb.Set(context.Background(), legacy, `{"name":"Eugen"}`, TTL).Err()
+	require.NoError(t, err)
+	err = redisConnPerson.RedisSetByID(context.Background(), &entityEugen)
+	require.NoError(t, err)
+
+	keys := NewLegacyCacheKeys(redisConnPerson.rdb)
+	found, err := keys.Scan(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, found, legacy)
+	require.NotContains(t, found, CacheKey(PersonCacheEntity, entityEugen.ID))
+	deleted, err := keys.Purge(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(found)), deleted)
+	_, err = redisConnPerson.RedisGetByID(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+	require.NoError(t, redisConnPerson.RedisDeleteByID(context.Background(), entityEugen.ID))
+}
+
+func TestRedisGetWithTTL(t *testing.T) {
+	err := redisConnPerson.RedisSetByID(context.Background(), &entityEugen)
+	require.NoError(t, err)
+	entity, ttl, err := redisConnPerson.RedisGetWithTTL(context.Background(), entityEugen.ID)
+	require.NoError(t, err)
+	require.Equal(t, entityEugen.Name, entity.Name)
+	require.LessOrEqual(t, ttl, TTL)
+	require.Greater(t, ttl, TTL-TTLJitter-time.Minute)
+	require.NoError(t, redisConnPerson.RedisDeleteByID(context.Background(), entityEugen.ID))
+}
+
+func TestRedisLock(t *testing.T) {
+	id := uuid.New()
+	token, ok, err := redisConnPerson.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = redisConnPerson.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+	// a wrong token doesn't release the lock
+	require.NoError(t, redisConnPerson.RedisUnlock(context.Background(), id, "wrong"))
+	_, ok, err = redisConnPerson.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, redisConnPerson.RedisUnlock(context.Background(), id, token))
+	_, ok, err = redisConnPerson.RedisLock(context.Background(), id, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestRedisQueryCache(t *testing.T) {
+	checkQueryCache(t, redisConnPerson)
 }
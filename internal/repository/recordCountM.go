@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecordCountMongoDBConnection struct represents a connection counting the documents of the collections
+type RecordCountMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewRecordCountMongoDBConnection is a constructor for RecordCountMongoDBConnection
+func NewRecordCountMongoDBConnection(client *mongo.Client) *RecordCountMongoDBConnection {
+	return &RecordCountMongoDBConnection{client: client}
+}
+
+// Count function executes "db.<collection>.countDocuments()" command
+func (db *RecordCountMongoDBConnection) Count(ctx context.Context, collection string) (int64, error) {
+	count, err := db.client.Database("my_mongo_base").Collection(collection).CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("CountDocuments(): %w", err)
+	}
+	return count, nil
+}
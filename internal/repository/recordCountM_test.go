@@ -0,0 +1,24 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoRecordCount(t *testing.T) {
+	ctx := context.Background()
+	counter := NewRecordCountMongoDBConnection(rpsM.client)
+	before, err := counter.Count(ctx, "person")
+	require.NoError(t, err)
+	_, err = rpsM.Create(ctx, &model.Person{Name: "Counted", Age: 20})
+	require.NoError(t, err)
+	after, err := counter.Count(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, before+1, after)
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RecordCountPsqlConnection struct represents a connection counting the rows of the goschema tables
+type RecordCountPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewRecordCountPsqlConnection is a constructor for RecordCountPsqlConnection
+func NewRecordCountPsqlConnection(pool *pgxpool.Pool) *RecordCountPsqlConnection {
+	return &RecordCountPsqlConnection{pool: pool}
+}
+
+// Count function executes SQL request to count the rows of the table
+func (db *RecordCountPsqlConnection) Count(ctx context.Context, table string) (int64, error) {
+	var count int64
+	err := db.pool.QueryRow(ctx, "SELECT count(*) FROM "+pgx.Identifier{"goschema", table}.Sanitize()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,24 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPgxRecordCount(t *testing.T) {
+	ctx := context.Background()
+	counter := NewRecordCountPsqlConnection(rps.pool)
+	before, err := counter.Count(ctx, "person")
+	require.NoError(t, err)
+	_, err = rps.Create(ctx, newPgxEugen())
+	require.NoError(t, err)
+	after, err := counter.Count(ctx, "person")
+	require.NoError(t, err)
+	require.Equal(t, before+1, after)
+	_, err = counter.Count(ctx, "missing")
+	require.Error(t, err)
+}
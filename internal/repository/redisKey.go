@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// const for the cache key schema
+const (
+	cacheNamespace = "myapp"
+	// CachePayloadVersion is the version of the cached payloads written by this build,
+	// payloads without a version are version 1, written under bare UUID keys
+	CachePayloadVersion = 2
+	legacyScanCount     = 1000
+	flushScanCount      = 1000
+)
+
+// Cache entities, each one has its own key prefix
+const (
+	PersonCacheEntity      = "person"
+	UserCacheEntity        = "user"
+	TokenFamilyCacheEntity = "token_family"
+	DeniedTokenEntity      = "denied_token"
+	DeniedUserEntity       = "denied_user"
+	LoginFailureEntity     = "login_failure"
+)
+
+// CacheKey returns the cache key of an entity, e.g. myapp:person:<id>
+func CacheKey(entity string, id uuid.UUID) string {
+	return fmt.Sprintf("%s:%s:%s", cacheNamespace, entity, id)
+}
+
+// deniedTokenKey returns the denylist key of an access token, token IDs are not necessarily UUIDs
+func deniedTokenKey(tokenID string) string {
+	return fmt.Sprintf("%s:%s:%s", cacheNamespace, DeniedTokenEntity, tokenID)
+}
+
+// loginFailureKey returns the key counting the failed logins of a login or an IP, e.g. myapp:login_failure:ip:<ip>
+func loginFailureKey(scope, subject string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", cacheNamespace, LoginFailureEntity, scope, subject)
+}
+
+// cacheMiss maps a missing key to model.ErrCacheMiss, so callers can tell misses from outages
+func cacheMiss(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return model.ErrCacheMiss
+	}
+	return err
+}
+
+// LockKey returns the key of the loading lock of an entity, e.g. myapp:lock:person:<id>
+func LockKey(entity string, id uuid.UUID) string {
+	return fmt.Sprintf("%s:lock:%s:%s", cacheNamespace, entity, id)
+}
+
+// TagKey returns the key holding the version of a cache tag, e.g. myapp:tag:person:list
+func TagKey(tag string) string {
+	return fmt.Sprintf("%s:tag:%s", cacheNamespace, tag)
+}
+
+// QueryKey returns the key of a cached query result, it embeds the versions of the tags
+// the result depends on, so bumping a tag makes every result depending on it unreachable
+func QueryKey(entity, hash string, versions []int64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:query:%s:%s", cacheNamespace, entity, hash)
+	for _, version := range versions {
+		fmt.Fprintf(&b, ":%d", version)
+	}
+	return b.String()
+}
+
+// entityPatterns returns the key patterns of the entries and query results of an entity
+func entityPatterns(entity string) []string {
+	return []string{
+		fmt.Sprintf("%s:%s:*", cacheNamespace, entity),
+		fmt.Sprintf("%s:query:%s:*", cacheNamespace, entity),
+	}
+}
+
+// flushEntity deletes the cached entries and query results of an entity and returns how many keys were deleted,
+// locks and tag versions are kept
+func flushEntity(ctx context.Context, rdb *redis.Client, entity string) (int64, error) {
+	var deleted int64
+	for _, pattern := range entityPatterns(entity) {
+		iter := rdb.Scan(ctx, 0, pattern, flushScanCount).Iterator()
+		batch := make([]string, 0, flushScanCount)
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) < flushScanCount {
+				continue
+			}
+			n, err := rdb.Unlink(ctx, batch...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf(" Unlink: %w", err)
+			}
+			deleted += n
+			batch = batch[:0]
+		}
+		if err := iter.Err(); err != nil {
+			return deleted, fmt.Errorf(" Scan: %w", err)
+		}
+		if len(batch) > 0 {
+			n, err := rdb.Unlink(ctx, batch...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf(" Unlink: %w", err)
+			}
+			deleted += n
+		}
+	}
+	return deleted, nil
+}
+
+// inspectKey reads a cached payload along with the time left until it expires, without prolonging it
+func inspectKey(ctx context.Context, rdb *redis.Client, key string) (string, time.Duration, error) {
+	pipe := rdb.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf(" Get: %w", cacheMiss(err))
+	}
+	return get.Val(), ttl.Val(), nil
+}
+
+// payloadVersion returns the schema version of a payload, payloads without one are version 1
+func payloadVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
+
+// jitteredTTL returns TTL shortened by a random amount up to TTLJitter
+func jitteredTTL() time.Duration {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(TTLJitter)))
+	if err != nil {
+		return TTL
+	}
+	return TTL - time.Duration(n.Int64())
+}
+
+// checkPayloadVersion fails on payloads written by a newer build, older payloads are read as they are
+func checkPayloadVersion(version int) error {
+	if version > CachePayloadVersion {
+		return fmt.Errorf("unsupported cache payload version %d", version)
+	}
+	return nil
+}
+
+// LegacyCacheKeys finds and purges cache keys written before the namespaced key schema
+type LegacyCacheKeys struct {
+	rdb *redis.Client
+}
+
+// NewLegacyCacheKeys creates a new LegacyCacheKeys
+func NewLegacyCacheKeys(rdb *redis.Client) *LegacyCacheKeys {
+	return &LegacyCacheKeys{rdb: rdb}
+}
+
+// Scan func returns the legacy keys, which are bare UUIDs
+func (keys *LegacyCacheKeys) Scan(ctx context.Context) ([]string, error) {
+	var res []string
+	err := keys.scan(ctx, func(batch []string) error {
+		res = append(res, batch...)
+		return nil
+	})
+	return res, err
+}
+
+// Purge func deletes the legacy keys and returns how many were deleted
+func (keys *LegacyCacheKeys) Purge(ctx context.Context) (int64, error) {
+	var deleted int64
+	err := keys.scan(ctx, func(batch []string) error {
+		n, err := keys.rdb.Del(ctx, batch...).Result()
+		if err != nil {
+			return fmt.Errorf(" Del: %w", err)
+		}
+		deleted += n
+		return nil
+	})
+	return deleted, err
+}
+
+// scan walks the whole database and passes every non-empty batch of legacy keys to fn
+func (keys *LegacyCacheKeys) scan(ctx context.Context, fn func(batch []string) error) error {
+	var cursor uint64
+	for {
+		found, next, err := keys.rdb.Scan(ctx, cursor, "*", legacyScanCount).Result()
+		if err != nil {
+			return fmt.Errorf(" Scan: %w", err)
+		}
+		batch := found[:0]
+		for _, key := range found {
+			if isLegacyCacheKey(key) {
+				batch = append(batch, key)
+			}
+		}
+		if len(batch) > 0 {
+			if err = fn(batch); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// isLegacyCacheKey reports whether the key is a bare UUID
+func isLegacyCacheKey(key string) bool {
+	_, err := uuid.Parse(key)
+	return err == nil && len(key) == len(uuid.Nil.String())
+}
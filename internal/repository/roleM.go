@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RoleMongoDBConnection is a struct, which contains *mongo.Client variable
+type RoleMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewRoleMongoDBConnection func is a constructor of RoleMongoDBConnection struct
+func NewRoleMongoDBConnection(client *mongo.Client) *RoleMongoDBConnection {
+	return &RoleMongoDBConnection{client: client}
+}
+
+// GetAll function executes "db.role.find()" command selecting every role ordered by name
+func (db *RoleMongoDBConnection) GetAll(ctx context.Context) ([]*model.Role, error) {
+	collection := db.client.Database("my_mongo_base").Collection("role")
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Find: %w", err)
+	}
+	var roles []*model.Role
+	err = cursor.All(ctx, &roles)
+	if err != nil {
+		return nil, fmt.Errorf("All: %w", err)
+	}
+	return roles, nil
+}
+
+// Save function executes "db.role.replaceOne()" command inserting a role or replacing an existing one
+func (db *RoleMongoDBConnection) Save(ctx context.Context, role *model.Role) error {
+	collection := db.client.Database("my_mongo_base").Collection("role")
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": role.Name}, role, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("ReplaceOne: %w", err)
+	}
+	return nil
+}
+
+// Add function executes "db.role.updateOne()" command inserting a role unless a role with its name exists
+func (db *RoleMongoDBConnection) Add(ctx context.Context, role *model.Role) error {
+	collection := db.client.Database("my_mongo_base").Collection("role")
+	update := bson.M{"$setOnInsert": bson.M{"permissions": role.Permissions}}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": role.Name}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	return nil
+}
+
+// Delete function executes "db.role.deleteOne()" command
+func (db *RoleMongoDBConnection) Delete(ctx context.Context, name string) error {
+	collection := db.client.Database("my_mongo_base").Collection("role")
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return fmt.Errorf("DeleteOne: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("DeleteOne: %w", model.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var roleM *RoleMongoDBConnection
+
+func TestMongoRole(t *testing.T) {
+	checkRoles(t, roleM)
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/eugenshima/myapp/internal/model"
+)
+
+// RoleMemoryConnection is an in-memory role storage, safe for concurrent use
+type RoleMemoryConnection struct {
+	mu    sync.Mutex
+	roles map[string][]string
+}
+
+// NewRoleMemoryConnection is a constructor for RoleMemoryConnection
+func NewRoleMemoryConnection() *RoleMemoryConnection {
+	return &RoleMemoryConnection{roles: make(map[string][]string)}
+}
+
+// GetAll returns copies of every role ordered by name
+func (db *RoleMemoryConnection) GetAll(_ context.Context) ([]*model.Role, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	roles := make([]*model.Role, 0, len(db.roles))
+	for name, permissions := range db.roles {
+		roles = append(roles, &model.Role{Name: name, Permissions: append([]string(nil), permissions...)})
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// Save stores a copy of the role, replacing an existing one
+func (db *RoleMemoryConnection) Save(_ context.Context, role *model.Role) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.roles[role.Name] = append([]string(nil), role.Permissions...)
+	return nil
+}
+
+// Add stores a copy of the role unless a role with its name exists
+func (db *RoleMemoryConnection) Add(_ context.Context, role *model.Role) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.roles[role.Name]; !ok {
+		db.roles[role.Name] = append([]string(nil), role.Permissions...)
+	}
+	return nil
+}
+
+// Delete removes the role
+func (db *RoleMemoryConnection) Delete(_ context.Context, name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.roles[name]; !ok {
+		return fmt.Errorf("Delete: role %q: %w", name, model.ErrNotFound)
+	}
+	delete(db.roles, name)
+	return nil
+}
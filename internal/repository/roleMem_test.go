@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// roleRepository is implemented by every role backend
+type roleRepository interface {
+	GetAll(ctx context.Context) ([]*model.Role, error)
+	Save(ctx context.Context, role *model.Role) error
+	Add(ctx context.Context, role *model.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+// checkRoles adds, replaces and deletes roles
+func checkRoles(t *testing.T, db roleRepository) {
+	ctx := context.Background()
+	reader := &model.Role{Name: "test_reader", Permissions: []string{model.PermissionPersonRead}}
+	require.NoError(t, db.Add(ctx, reader))
+	// Adding a role again keeps the stored permissions, saving replaces them
+	require.NoError(t, db.Add(ctx, &model.Role{Name: reader.Name, Permissions: []string{model.PermissionCacheAdmin}}))
+	require.NoError(t, db.Save(ctx, &model.Role{Name: "test_writer", Permissions: []string{model.PermissionPersonRead}}))
+	require.NoError(t, db.Save(ctx, &model.Role{Name: "test_writer", Permissions: []string{model.PermissionPersonRead, model.PermissionPersonWrite}}))
+
+	roles, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	stored := make(map[string][]string)
+	for _, role := range roles {
+		stored[role.Name] = role.Permissions
+	}
+	require.Equal(t, []string{model.PermissionPersonRead}, stored[reader.Name])
+	require.Equal(t, []string{model.PermissionPersonRead, model.PermissionPersonWrite}, stored["test_writer"])
+
+	require.NoError(t, db.Delete(ctx, reader.Name))
+	require.NoError(t, db.Delete(ctx, "test_writer"))
+	require.ErrorIs(t, db.Delete(ctx, reader.Name), model.ErrNotFound)
+	roles, err = db.GetAll(ctx)
+	require.NoError(t, err)
+	for _, role := range roles {
+		require.NotEqual(t, reader.Name, role.Name)
+	}
+}
+
+func TestRoleMemory(t *testing.T) {
+	checkRoles(t, NewRoleMemoryConnection())
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RolePsqlConnection struct represents a connection to the role table
+type RolePsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewRolePsqlConnection is a constructor for RolePsqlConnection
+func NewRolePsqlConnection(pool *pgxpool.Pool) *RolePsqlConnection {
+	return &RolePsqlConnection{pool: pool}
+}
+
+// GetAll function executes SQL request to select every role ordered by name
+func (db *RolePsqlConnection) GetAll(ctx context.Context) ([]*model.Role, error) {
+	rows, err := db.pool.Query(ctx, "SELECT name, permissions FROM goschema.role ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+	var roles []*model.Role
+	for rows.Next() {
+		role := &model.Role{}
+		err = rows.Scan(&role.Name, &role.Permissions)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+	return roles, nil
+}
+
+// Save function executes SQL request to insert a role or replace the permissions of an existing one
+func (db *RolePsqlConnection) Save(ctx context.Context, role *model.Role) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.role (name, permissions) VALUES ($1, $2)
+	ON CONFLICT (name) DO UPDATE SET permissions=EXCLUDED.permissions`, role.Name, role.Permissions)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Add function executes SQL request to insert a role unless a role with its name exists
+func (db *RolePsqlConnection) Add(ctx context.Context, role *model.Role) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.role (name, permissions) VALUES ($1, $2)
+	ON CONFLICT (name) DO NOTHING`, role.Name, role.Permissions)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Delete function executes SQL request to delete a role
+func (db *RolePsqlConnection) Delete(ctx context.Context, name string) error {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM goschema.role WHERE name=$1", name)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Delete: %w", model.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var roleP *RolePsqlConnection
+
+func TestPgxRole(t *testing.T) {
+	checkRoles(t, roleP)
+}
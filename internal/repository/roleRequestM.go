@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RoleRequestMongoDBConnection is a struct, which contains *mongo.Client variable
+type RoleRequestMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewRoleRequestMongoDBConnection func is a constructor of RoleRequestMongoDBConnection struct
+func NewRoleRequestMongoDBConnection(client *mongo.Client) *RoleRequestMongoDBConnection {
+	return &RoleRequestMongoDBConnection{client: client}
+}
+
+// collection returns the role request collection
+func (db *RoleRequestMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("role_request")
+}
+
+// Create function executes "db.role_request.insertOne()" command
+func (db *RoleRequestMongoDBConnection) Create(ctx context.Context, request *model.RoleRequest) error {
+	_, err := db.collection().InsertOne(ctx, request)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// GetByStatus function executes "db.role_request.find()" command selecting the role requests with the given status, oldest first
+func (db *RoleRequestMongoDBConnection) GetByStatus(ctx context.Context, status string) ([]*model.RoleRequest, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.collection().Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find: %w", err)
+	}
+	var requests []*model.RoleRequest
+	err = cursor.All(ctx, &requests)
+	if err != nil {
+		return nil, fmt.Errorf("All: %w", err)
+	}
+	return requests, nil
+}
+
+// Decide function executes "db.role_request.findOneAndUpdate()" approving or rejecting a role request,
+// only a pending request can be decided
+func (db *RoleRequestMongoDBConnection) Decide(ctx context.Context, id uuid.UUID, status string, by uuid.UUID, at time.Time) (*model.RoleRequest, error) {
+	filter := bson.M{"_id": id, "status": model.RoleRequestPending}
+	update := bson.M{"$set": bson.M{"status": status, "decided_by": by, "decided_at": at}}
+	request := &model.RoleRequest{}
+	err := db.collection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(request)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", err)
+	}
+	return request, nil
+}
@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var roleRequestM *RoleRequestMongoDBConnection
+
+func TestMongoRoleRequest(t *testing.T) {
+	checkRoleRequests(t, roleRequestM)
+}
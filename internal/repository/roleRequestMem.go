@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// RoleRequestMemoryConnection is an in-memory role request storage, safe for concurrent use
+type RoleRequestMemoryConnection struct {
+	mu       sync.Mutex
+	requests map[uuid.UUID]model.RoleRequest
+}
+
+// NewRoleRequestMemoryConnection is a constructor for RoleRequestMemoryConnection
+func NewRoleRequestMemoryConnection() *RoleRequestMemoryConnection {
+	return &RoleRequestMemoryConnection{requests: make(map[uuid.UUID]model.RoleRequest)}
+}
+
+// copyRoleRequest returns a deep copy of the request
+func copyRoleRequest(request *model.RoleRequest) *model.RoleRequest {
+	cp := *request
+	if request.DecidedBy != nil {
+		decidedBy := *request.DecidedBy
+		cp.DecidedBy = &decidedBy
+	}
+	if request.DecidedAt != nil {
+		decidedAt := *request.DecidedAt
+		cp.DecidedAt = &decidedAt
+	}
+	return &cp
+}
+
+// Create stores a copy of a new request
+func (db *RoleRequestMemoryConnection) Create(_ context.Context, request *model.RoleRequest) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.requests[request.ID]; ok {
+		return fmt.Errorf("Create: role request %v already exists", request.ID)
+	}
+	db.requests[request.ID] = *copyRoleRequest(request)
+	return nil
+}
+
+// GetByStatus returns copies of the requests with the given status, oldest first
+func (db *RoleRequestMemoryConnection) GetByStatus(_ context.Context, status string) ([]*model.RoleRequest, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var requests []*model.RoleRequest
+	for _, request := range db.requests {
+		if request.Status == status {
+			requests = append(requests, copyRoleRequest(&request))
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].CreatedAt.Equal(requests[j].CreatedAt) {
+			return requests[i].ID.String() < requests[j].ID.String()
+		}
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// Decide approves or rejects a request, only a pending request can be decided
+func (db *RoleRequestMemoryConnection) Decide(_ context.Context, id uuid.UUID, status string, by uuid.UUID, at time.Time) (*model.RoleRequest, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	request, ok := db.requests[id]
+	if !ok || request.Status != model.RoleRequestPending {
+		return nil, fmt.Errorf("Decide: role request %v: %w", id, model.ErrNotFound)
+	}
+	request.Status, request.DecidedBy, request.DecidedAt = status, &by, &at
+	db.requests[id] = request
+	return copyRoleRequest(&request), nil
+}
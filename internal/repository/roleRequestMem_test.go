@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// roleRequestRepository is implemented by every role request backend
+type roleRequestRepository interface {
+	Create(ctx context.Context, request *model.RoleRequest) error
+	GetByStatus(ctx context.Context, status string) ([]*model.RoleRequest, error)
+	Decide(ctx context.Context, id uuid.UUID, status string, by uuid.UUID, at time.Time) (*model.RoleRequest, error)
+}
+
+// checkRoleRequests creates requests, lists the pending ones and decides them
+func checkRoleRequests(t *testing.T, db roleRequestRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newer := &model.RoleRequest{ID: uuid.New(), UserID: uuid.New(), Role: "editor", Status: model.RoleRequestPending, CreatedAt: now}
+	older := &model.RoleRequest{ID: uuid.New(), UserID: uuid.New(), Role: model.RoleAdmin, Status: model.RoleRequestPending, CreatedAt: now.Add(-time.Minute)}
+	require.NoError(t, db.Create(ctx, newer))
+	require.NoError(t, db.Create(ctx, older))
+	require.Error(t, db.Create(ctx, older))
+
+	// Pending requests are listed oldest first
+	pending, err := db.GetByStatus(ctx, model.RoleRequestPending)
+	require.NoError(t, err)
+	position := make(map[uuid.UUID]int)
+	for i, request := range pending {
+		position[request.ID] = i
+	}
+	require.Contains(t, position, newer.ID)
+	require.Contains(t, position, older.ID)
+	require.Less(t, position[older.ID], position[newer.ID])
+
+	// A request is decided once
+	admin := uuid.New()
+	approved, err := db.Decide(ctx, older.ID, model.RoleRequestApproved, admin, now)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleRequestApproved, approved.Status)
+	require.Equal(t, older.UserID, approved.UserID)
+	require.NotNil(t, approved.DecidedBy)
+	require.Equal(t, admin, *approved.DecidedBy)
+	_, err = db.Decide(ctx, older.ID, model.RoleRequestRejected, admin, now)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = db.Decide(ctx, uuid.New(), model.RoleRequestRejected, admin, now)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	pending, err = db.GetByStatus(ctx, model.RoleRequestPending)
+	require.NoError(t, err)
+	for _, request := range pending {
+		require.NotEqual(t, older.ID, request.ID)
+	}
+	decided, err := db.GetByStatus(ctx, model.RoleRequestApproved)
+	require.NoError(t, err)
+	require.NotEmpty(t, decided)
+}
+
+func TestRoleRequestMemory(t *testing.T) {
+	checkRoleRequests(t, NewRoleRequestMemoryConnection())
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RoleRequestPsqlConnection struct represents a connection to the role request table
+type RoleRequestPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewRoleRequestPsqlConnection is a constructor for RoleRequestPsqlConnection
+func NewRoleRequestPsqlConnection(pool *pgxpool.Pool) *RoleRequestPsqlConnection {
+	return &RoleRequestPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to insert a new role request
+func (db *RoleRequestPsqlConnection) Create(ctx context.Context, request *model.RoleRequest) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.role_request (id, user_id, role, status, created_at)
+	VALUES ($1, $2, $3, $4, $5)`, request.ID, request.UserID, request.Role, request.Status, request.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// GetByStatus function executes SQL request to select the role requests with the given status, oldest first
+func (db *RoleRequestPsqlConnection) GetByStatus(ctx context.Context, status string) ([]*model.RoleRequest, error) {
+	rows, err := db.pool.Query(ctx, `SELECT id, user_id, role, status, created_at, decided_by, decided_at
+	FROM goschema.role_request WHERE status=$1 ORDER BY created_at, id`, status)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+	var requests []*model.RoleRequest
+	for rows.Next() {
+		request, err := scanRoleRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		requests = append(requests, request)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+	return requests, nil
+}
+
+// Decide function executes SQL request approving or rejecting a role request, only a pending request can be decided
+func (db *RoleRequestPsqlConnection) Decide(ctx context.Context, id uuid.UUID, status string, by uuid.UUID, at time.Time) (*model.RoleRequest, error) {
+	request, err := scanRoleRequest(db.pool.QueryRow(ctx, `UPDATE goschema.role_request SET status=$2, decided_by=$3, decided_at=$4
+	WHERE id=$1 AND status='pending'
+	RETURNING id, user_id, role, status, created_at, decided_by, decided_at`, id, status, by, at))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return request, nil
+}
+
+// scanRoleRequest scans a role request row
+func scanRoleRequest(row pgx.Row) (*model.RoleRequest, error) {
+	request := &model.RoleRequest{}
+	err := row.Scan(&request.ID, &request.UserID, &request.Role, &request.Status, &request.CreatedAt, &request.DecidedBy, &request.DecidedAt)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
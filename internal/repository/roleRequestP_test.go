@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var roleRequestP *RoleRequestPsqlConnection
+
+func TestPgxRoleRequest(t *testing.T) {
+	checkRoleRequests(t, roleRequestP)
+}
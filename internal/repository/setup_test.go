@@ -1,7 +1,10 @@
+//go:build integration
+
 package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,9 +14,9 @@ import (
 	"github.com/ory/dockertest"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -68,19 +71,23 @@ func SetupTestPgx() (*pgxpool.Pool, func(), error) {
 	return dbpool, cleanup, nil
 }
 
+// SetupTestMongoDB starts MongoDB as a single member replica set, since the Mongo repositories need transactions
 func SetupTestMongoDB() (*mongo.Client, func(), error) {
 	pool, err := dockertest.NewPool("")
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not construct pool: %w", err)
 	}
-	resource, err := pool.Run("mongo", "6.0.6", []string{
-		"MONGO_INITDB_ROOT_USERNAME=eugenshima",
-		"MONGO_INITDB_ROOT_PASSWORD=ur2qly1ini",
-		"MONGO_INITDB_DATABASE=my_mongo_base"})
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "mongo",
+		Tag:        "6.0.6",
+		Env:        []string{"MONGO_INITDB_DATABASE=my_mongo_base"},
+		Cmd:        []string{"--replSet", "rs0", "--bind_ip_all"},
+	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not start resource: %w", err)
 	}
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017/"))
+	uri := fmt.Sprintf("mongodb://localhost:%s/?directConnection=true", resource.GetPort("27017/tcp"))
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect mongoDB: %w", err)
 	}
@@ -88,6 +95,31 @@ func SetupTestMongoDB() (*mongo.Client, func(), error) {
 		client.Disconnect(context.Background())
 		pool.Purge(resource)
 	}
+	admin := client.Database("admin")
+	initiate := bson.D{{Key: "replSetInitiate", Value: bson.M{
+		"_id":     "rs0",
+		"members": bson.A{bson.M{"_id": 0, "host": "localhost:27017"}},
+	}}}
+	err = pool.Retry(func() error {
+		return admin.RunCommand(context.Background(), initiate).Err()
+	})
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("could not initiate the replica set: %w", err)
+	}
+	err = pool.Retry(func() error {
+		var hello bson.M
+		err := admin.RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			return err
+		}
+		if hello["isWritablePrimary"] != true {
+			return errors.New("no primary yet")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("replica set has no primary: %w", err)
+	}
 	return client, cleanup, nil
 }
 
@@ -114,6 +146,9 @@ func SetupTestRedis() (*redis.Client, func(), error) {
 	return rdb, cleanup, nil
 }
 
+// TestMain starts PostgreSQL, MongoDB and Redis containers for the backend tests, which are built with the
+// integration tag: go test -tags integration ./internal/repository. The memory backend tests need no containers
+// and run without the tag
 func TestMain(m *testing.M) {
 	dbpool, cleanupPgx, err := SetupTestPgx()
 	if err != nil {
@@ -123,6 +158,15 @@ func TestMain(m *testing.M) {
 	}
 	rps = NewPsqlConnection(dbpool)
 	urps = NewUserPsqlConnection(dbpool)
+	auditP = NewAuditPsqlConnection(dbpool)
+	familyP = NewTokenFamilyPsqlConnection(dbpool)
+	signingKeyP = NewSigningKeyPsqlConnection(dbpool)
+	roleP = NewRolePsqlConnection(dbpool)
+	invitationP = NewInvitationPsqlConnection(dbpool)
+	roleRequestP = NewRoleRequestPsqlConnection(dbpool)
+	mfaP = NewMFAPsqlConnection(dbpool)
+	apiKeyP = NewAPIKeyPsqlConnection(dbpool)
+	passwordResetP = NewPasswordResetPsqlConnection(dbpool)
 
 	client, cleanupMongo, err := SetupTestMongoDB()
 	if err != nil {
@@ -132,6 +176,15 @@ func TestMain(m *testing.M) {
 	}
 	rpsM = NewMongoDBConnection(client)
 	urpsM = NewUserMongoDBConnection(client)
+	auditM = NewAuditMongoDBConnection(client)
+	familyM = NewTokenFamilyMongoDBConnection(client)
+	signingKeyM = NewSigningKeyMongoDBConnection(client)
+	roleM = NewRoleMongoDBConnection(client)
+	invitationM = NewInvitationMongoDBConnection(client)
+	roleRequestM = NewRoleRequestMongoDBConnection(client)
+	mfaM = NewMFAMongoDBConnection(client)
+	apiKeyM = NewAPIKeyMongoDBConnection(client)
+	passwordResetM = NewPasswordResetMongoDBConnection(client)
 
 	rdb, cleanupRedis, err := SetupTestRedis()
 	if err != nil {
@@ -146,11 +199,3 @@ func TestMain(m *testing.M) {
 	cleanupMongo()
 	os.Exit(exitVal)
 }
-
-func hashPassword(password []byte) []byte {
-	hashedPassword, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
-	if err != nil {
-		return nil
-	}
-	return hashedPassword
-}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SigningKeyMongoDBConnection is a struct, which contains *mongo.Client variable
+type SigningKeyMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewSigningKeyMongoDBConnection func is a constructor of SigningKeyMongoDBConnection struct
+func NewSigningKeyMongoDBConnection(client *mongo.Client) *SigningKeyMongoDBConnection {
+	return &SigningKeyMongoDBConnection{client: client}
+}
+
+// Create function executes "db.signing_key.insertOne()" command
+func (db *SigningKeyMongoDBConnection) Create(ctx context.Context, key *model.SigningKey) error {
+	collection := db.client.Database("my_mongo_base").Collection("signing_key")
+	_, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// GetValid function executes "db.signing_key.find()" command selecting the keys which have not expired
+// at the given time, ordered by the time they become active
+func (db *SigningKeyMongoDBConnection) GetValid(ctx context.Context, at time.Time) ([]*model.SigningKey, error) {
+	collection := db.client.Database("my_mongo_base").Collection("signing_key")
+	opts := options.Find().SetSort(bson.D{{Key: "active_from", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"expires_at": bson.M{"$gt": at}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find: %w", err)
+	}
+	var keys []*model.SigningKey
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("All: %w", err)
+	}
+	return keys, nil
+}
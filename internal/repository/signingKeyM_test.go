@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var signingKeyM *SigningKeyMongoDBConnection
+
+func TestMongoSigningKey(t *testing.T) {
+	checkSigningKeys(t, signingKeyM)
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+)
+
+// SigningKeyMemoryConnection is an in-memory signing key storage, safe for concurrent use
+type SigningKeyMemoryConnection struct {
+	mu   sync.Mutex
+	keys map[string]model.SigningKey
+}
+
+// NewSigningKeyMemoryConnection is a constructor for SigningKeyMemoryConnection
+func NewSigningKeyMemoryConnection() *SigningKeyMemoryConnection {
+	return &SigningKeyMemoryConnection{keys: make(map[string]model.SigningKey)}
+}
+
+// Create stores a copy of a new key
+func (db *SigningKeyMemoryConnection) Create(_ context.Context, key *model.SigningKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.keys[key.ID]; ok {
+		return fmt.Errorf("Create: signing key %s already exists", key.ID)
+	}
+	stored := *key
+	stored.PrivateKey = cloneBytes(key.PrivateKey)
+	db.keys[key.ID] = stored
+	return nil
+}
+
+// GetValid returns copies of the keys which have not expired at the given time, ordered by the time they become active
+func (db *SigningKeyMemoryConnection) GetValid(_ context.Context, at time.Time) ([]*model.SigningKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var keys []*model.SigningKey
+	for _, key := range db.keys {
+		if !key.ExpiresAt.After(at) {
+			continue
+		}
+		cp := key
+		cp.PrivateKey = cloneBytes(key.PrivateKey)
+		keys = append(keys, &cp)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ActiveFrom.Equal(keys[j].ActiveFrom) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].ActiveFrom.Before(keys[j].ActiveFrom)
+	})
+	return keys, nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// signingKeyRepository is implemented by every signing key backend
+type signingKeyRepository interface {
+	Create(ctx context.Context, key *model.SigningKey) error
+	GetValid(ctx context.Context, at time.Time) ([]*model.SigningKey, error)
+}
+
+// checkSigningKeys stores an expired, an active and a future key and reads the valid ones back
+func checkSigningKeys(t *testing.T, db signingKeyRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	keys := []*model.SigningKey{
+		{ID: uuid.NewString(), PrivateKey: []byte("next"), ActiveFrom: now.Add(time.Hour), ExpiresAt: now.Add(3 * time.Hour)},
+		{ID: uuid.NewString(), PrivateKey: []byte("expired"), ActiveFrom: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{ID: uuid.NewString(), PrivateKey: []byte("active"), ActiveFrom: now.Add(-time.Hour), ExpiresAt: now.Add(2 * time.Hour)},
+	}
+	for _, key := range keys {
+		key.CreatedAt = now
+		require.NoError(t, db.Create(ctx, key))
+	}
+	require.Error(t, db.Create(ctx, keys[0]))
+
+	// Keys are ordered by the time they become active, expired ones are left out
+	valid, err := db.GetValid(ctx, now)
+	require.NoError(t, err)
+	position := make(map[string]int)
+	for i, key := range valid {
+		position[key.ID] = i
+	}
+	require.NotContains(t, position, keys[1].ID)
+	require.Contains(t, position, keys[0].ID)
+	require.Contains(t, position, keys[2].ID)
+	require.Less(t, position[keys[2].ID], position[keys[0].ID])
+	active := valid[position[keys[2].ID]]
+	require.Equal(t, []byte("active"), active.PrivateKey)
+	require.True(t, keys[2].ActiveFrom.Equal(active.ActiveFrom))
+}
+
+func TestSigningKeyMemory(t *testing.T) {
+	checkSigningKeys(t, NewSigningKeyMemoryConnection())
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SigningKeyPsqlConnection struct represents a connection to the signing key table
+type SigningKeyPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewSigningKeyPsqlConnection is a constructor for SigningKeyPsqlConnection
+func NewSigningKeyPsqlConnection(pool *pgxpool.Pool) *SigningKeyPsqlConnection {
+	return &SigningKeyPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to insert a new signing key
+func (db *SigningKeyPsqlConnection) Create(ctx context.Context, key *model.SigningKey) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.signing_key (id, private_key, active_from, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5)`, key.ID, key.PrivateKey, key.ActiveFrom, key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// GetValid function executes SQL request to select the keys which have not expired at the given time,
+// ordered by the time they become active
+func (db *SigningKeyPsqlConnection) GetValid(ctx context.Context, at time.Time) ([]*model.SigningKey, error) {
+	rows, err := db.pool.Query(ctx, `SELECT id, private_key, active_from, expires_at, created_at
+	FROM goschema.signing_key WHERE expires_at > $1 ORDER BY active_from, id`, at)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+	var keys []*model.SigningKey
+	for rows.Next() {
+		key := &model.SigningKey{}
+		err = rows.Scan(&key.ID, &key.PrivateKey, &key.ActiveFrom, &key.ExpiresAt, &key.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+	return keys, nil
+}
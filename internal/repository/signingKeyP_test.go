@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var signingKeyP *SigningKeyPsqlConnection
+
+func TestPgxSigningKey(t *testing.T) {
+	checkSigningKeys(t, signingKeyP)
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Cache tiers
+const (
+	LocalTier = "local"
+	RedisTier = "redis"
+)
+
+// tierStats counts hits, misses and evictions of the in-process and the Redis tier of a cache
+type tierStats struct {
+	cache           string
+	localHits       uint64
+	localMisses     uint64
+	remoteHits      uint64
+	remoteMisses    uint64
+	remoteEvictions uint64
+}
+
+// local counts a lookup in the in-process tier
+func (s *tierStats) local(hit bool) {
+	if hit {
+		atomic.AddUint64(&s.localHits, 1)
+	} else {
+		atomic.AddUint64(&s.localMisses, 1)
+	}
+}
+
+// remote counts a lookup in the Redis tier
+func (s *tierStats) remote(hit bool) {
+	if hit {
+		atomic.AddUint64(&s.remoteHits, 1)
+	} else {
+		atomic.AddUint64(&s.remoteMisses, 1)
+	}
+}
+
+// evicted counts entries dropped from the Redis tier
+func (s *tierStats) evicted(n uint64) {
+	atomic.AddUint64(&s.remoteEvictions, n)
+}
+
+// stats returns the counters of both tiers, the in-process evictions are counted by its lru
+func (s *tierStats) stats(localEvictions uint64) []*model.CacheTierStats {
+	return []*model.CacheTierStats{
+		newTierStats(s.cache, LocalTier, atomic.LoadUint64(&s.localHits), atomic.LoadUint64(&s.localMisses), localEvictions),
+		newTierStats(s.cache, RedisTier, atomic.LoadUint64(&s.remoteHits), atomic.LoadUint64(&s.remoteMisses),
+			atomic.LoadUint64(&s.remoteEvictions)),
+	}
+}
+
+// newTierStats fills the hit ratio of a tier
+func newTierStats(cache, tier string, hits, misses, evictions uint64) *model.CacheTierStats {
+	stats := &model.CacheTierStats{Cache: cache, Tier: tier, Hits: hits, Misses: misses, Evictions: evictions}
+	if hits+misses > 0 {
+		stats.HitRatio = float64(hits) / float64(hits+misses)
+	}
+	return stats
+}
+
+// localPerson is a person in the in-process tier along with its Redis expiration time
+type localPerson struct {
+	person    model.Person
+	expiresAt time.Time
+}
+
+// TieredCacheConnection keeps recently read persons in a bounded in-process LRU in front of
+// the Redis cache. Writes go to Redis and are broadcast over the cache bus, so other instances
+// drop their copies, and in-process copies never outlive the local TTL.
+type TieredCacheConnection struct {
+	*RedisConnection
+	local *lru[uuid.UUID, localPerson]
+	ttl   time.Duration
+	bus   *CacheBus
+	stats tierStats
+}
+
+// NewTieredCacheConnection creates a new TieredCacheConnection holding up to size persons for ttl,
+// a size of zero disables the in-process tier
+func NewTieredCacheConnection(remote *RedisConnection, bus *CacheBus, size int, ttl time.Duration) *TieredCacheConnection {
+	c := &TieredCacheConnection{RedisConnection: remote, ttl: ttl, bus: bus, stats: tierStats{cache: PersonCacheEntity}}
+	if size > 0 {
+		c.local = newLRU[uuid.UUID, localPerson](size)
+		bus.subscribe(PersonCacheEntity, c.local.remove, c.local.clear)
+	}
+	return c
+}
+
+// RedisGetByID func returns a cached person
+func (c *TieredCacheConnection) RedisGetByID(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	person, _, err := c.RedisGetWithTTL(ctx, id)
+	return person, err
+}
+
+// RedisGetWithTTL func returns a cached person along with the time left until it expires in Redis,
+// it asks Redis only if the person is not in process
+func (c *TieredCacheConnection) RedisGetWithTTL(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	if c.local != nil {
+		cached, ok := c.local.get(id)
+		c.stats.local(ok)
+		if ok {
+			person := cached.person
+			return &person, time.Until(cached.expiresAt), nil
+		}
+	}
+	person, ttl, err := c.RedisConnection.RedisGetWithTTL(ctx, id)
+	c.stats.remote(err == nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.local != nil {
+		c.local.add(id, localPerson{person: *person, expiresAt: time.Now().Add(ttl)}, minDuration(c.ttl, ttl))
+	}
+	return person, ttl, nil
+}
+
+// RedisSetByID func caches the person in Redis and invalidates the in-process copies
+func (c *TieredCacheConnection) RedisSetByID(ctx context.Context, entity *model.Person) error {
+	err := c.RedisConnection.RedisSetByID(ctx, entity)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, entity.ID)
+}
+
+// RedisDeleteByID func deletes the person from Redis and invalidates the in-process copies
+func (c *TieredCacheConnection) RedisDeleteByID(ctx context.Context, id uuid.UUID) error {
+	err := c.RedisConnection.RedisDeleteByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	c.stats.evicted(1)
+	return c.invalidate(ctx, id)
+}
+
+// RedisInspect func returns the person as cached in Redis and whether this instance holds it in process too
+func (c *TieredCacheConnection) RedisInspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error) {
+	entry, err := c.RedisConnection.RedisInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entry.Local = c.local != nil && c.local.peek(id)
+	return entry, nil
+}
+
+// RedisFlush func deletes every cached person from Redis and clears the in-process tiers of all instances
+func (c *TieredCacheConnection) RedisFlush(ctx context.Context) (int64, error) {
+	deleted, err := c.RedisConnection.RedisFlush(ctx)
+	c.stats.evicted(uint64(deleted))
+	if c.local == nil {
+		return deleted, err
+	}
+	c.local.clear()
+	if publishErr := c.bus.PublishFlush(ctx, PersonCacheEntity); err == nil && publishErr != nil {
+		err = fmt.Errorf("PublishFlush(): %w", publishErr)
+	}
+	return deleted, err
+}
+
+// Stats func returns the counters of both tiers
+func (c *TieredCacheConnection) Stats() []*model.CacheTierStats {
+	return c.stats.stats(localEvictions(c.local))
+}
+
+// invalidate drops the in-process copy of a person here and on the other instances
+func (c *TieredCacheConnection) invalidate(ctx context.Context, id uuid.UUID) error {
+	if c.local == nil {
+		return nil
+	}
+	c.local.remove(id)
+	err := c.bus.Publish(ctx, PersonCacheEntity, id)
+	if err != nil {
+		return fmt.Errorf("Publish(): %w", err)
+	}
+	return nil
+}
+
+// UserTieredCacheConnection keeps recently read users in a bounded in-process LRU in front of
+// the Redis cache, the same way TieredCacheConnection does for persons
+type UserTieredCacheConnection struct {
+	*UserRedisConnection
+	local *lru[uuid.UUID, *model.User]
+	ttl   time.Duration
+	bus   *CacheBus
+	stats tierStats
+}
+
+// NewUserTieredCacheConnection creates a new UserTieredCacheConnection holding up to size users for ttl,
+// a size of zero disables the in-process tier
+func NewUserTieredCacheConnection(remote *UserRedisConnection, bus *CacheBus, size int, ttl time.Duration) *UserTieredCacheConnection {
+	c := &UserTieredCacheConnection{UserRedisConnection: remote, ttl: ttl, bus: bus, stats: tierStats{cache: UserCacheEntity}}
+	if size > 0 {
+		c.local = newLRU[uuid.UUID, *model.User](size)
+		bus.subscribe(UserCacheEntity, c.local.remove, c.local.clear)
+	}
+	return c
+}
+
+// Get func returns a cached user, it asks Redis only if the user is not in process
+func (c *UserTieredCacheConnection) Get(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	if c.local != nil {
+		user, ok := c.local.get(id)
+		c.stats.local(ok)
+		if ok {
+			return copyUser(user), nil
+		}
+	}
+	user, err := c.UserRedisConnection.Get(ctx, id)
+	c.stats.remote(err == nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.local != nil {
+		c.local.add(id, copyUser(user), c.ttl)
+	}
+	return user, nil
+}
+
+// Set func caches the user in Redis and invalidates the in-process copies
+func (c *UserTieredCacheConnection) Set(ctx context.Context, user *model.User) error {
+	err := c.UserRedisConnection.Set(ctx, user)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, user.ID)
+}
+
+// Delete func deletes the user from Redis and invalidates the in-process copies,
+// which may outlive the Redis entry
+func (c *UserTieredCacheConnection) Delete(ctx context.Context, id uuid.UUID) error {
+	err := c.UserRedisConnection.Delete(ctx, id)
+	if err == nil {
+		c.stats.evicted(1)
+	}
+	if invalidateErr := c.invalidate(ctx, id); err == nil {
+		err = invalidateErr
+	}
+	return err
+}
+
+// Inspect func returns the user as cached in Redis and whether this instance holds it in process too
+func (c *UserTieredCacheConnection) Inspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error) {
+	entry, err := c.UserRedisConnection.Inspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entry.Local = c.local != nil && c.local.peek(id)
+	return entry, nil
+}
+
+// Flush func deletes every cached user from Redis and clears the in-process tiers of all instances
+func (c *UserTieredCacheConnection) Flush(ctx context.Context) (int64, error) {
+	deleted, err := c.UserRedisConnection.Flush(ctx)
+	c.stats.evicted(uint64(deleted))
+	if c.local == nil {
+		return deleted, err
+	}
+	c.local.clear()
+	if publishErr := c.bus.PublishFlush(ctx, UserCacheEntity); err == nil && publishErr != nil {
+		err = fmt.Errorf("PublishFlush(): %w", publishErr)
+	}
+	return deleted, err
+}
+
+// GetRefreshToken func returns the refresh token of a cached user
+func (c *UserTieredCacheConnection) GetRefreshToken(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	user, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return user.RefreshToken, nil
+}
+
+// SetRefreshToken func sets the refresh token of a cached user and invalidates the in-process copies
+func (c *UserTieredCacheConnection) SetRefreshToken(ctx context.Context, id uuid.UUID, token []byte) error {
+	err := c.UserRedisConnection.SetRefreshToken(ctx, id, token)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, id)
+}
+
+// Stats func returns the counters of both tiers
+func (c *UserTieredCacheConnection) Stats() []*model.CacheTierStats {
+	return c.stats.stats(localEvictions(c.local))
+}
+
+// invalidate drops the in-process copy of a user here and on the other instances
+func (c *UserTieredCacheConnection) invalidate(ctx context.Context, id uuid.UUID) error {
+	if c.local == nil {
+		return nil
+	}
+	c.local.remove(id)
+	err := c.bus.Publish(ctx, UserCacheEntity, id)
+	if err != nil {
+		return fmt.Errorf("Publish(): %w", err)
+	}
+	return nil
+}
+
+// localEvictions returns the evictions of an in-process tier, which may be disabled
+func localEvictions[V any](local *lru[uuid.UUID, V]) uint64 {
+	if local == nil {
+		return 0
+	}
+	return local.evicted()
+}
+
+// minDuration returns the shorter duration
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newInstance returns the person cache of another app instance sharing the test Redis
+func newInstance(ctx context.Context) *TieredCacheConnection {
+	bus := NewCacheBus(redisConnPerson.rdb)
+	go bus.Run(ctx)
+	cache := NewTieredCacheConnection(redisConnPerson, bus, 10, time.Minute)
+	// wait for the subscription, it clears the local tier
+	time.Sleep(100 * time.Millisecond)
+	return cache
+}
+
+func TestTieredCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, second := newInstance(ctx), newInstance(ctx)
+	person := &model.Person{ID: uuid.New(), Name: "Eugen", Age: 20, Version: 1}
+
+	// Step 1: the first read goes to Redis, the second one is served in process
+	require.NoError(t, first.RedisSetByID(ctx, person))
+	for i := 0; i < 2; i++ {
+		cached, err := second.RedisGetByID(ctx, person.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), cached.Version)
+	}
+	stats := second.Stats()
+	require.Equal(t, uint64(1), stats[0].Hits)
+	require.Equal(t, uint64(1), stats[0].Misses)
+	require.Equal(t, uint64(1), stats[1].Hits)
+
+	// Step 2: a change on the first instance drops the copy of the second one
+	person.Version = 2
+	require.NoError(t, first.RedisSetByID(ctx, person))
+	require.Eventually(t, func() bool {
+		cached, err := second.RedisGetByID(ctx, person.ID)
+		return err == nil && cached.Version == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// Step 3: deleting drops every copy
+	require.NoError(t, first.RedisDeleteByID(ctx, person.ID))
+	require.Eventually(t, func() bool {
+		_, err := second.RedisGetByID(ctx, person.ID)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCacheAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, second := newInstance(ctx), newInstance(ctx)
+	person := &model.Person{ID: uuid.New(), Name: "Eugen", Age: 20, Version: 1}
+	require.NoError(t, first.RedisSetByID(ctx, person))
+	_, err := second.RedisGetByID(ctx, person.ID)
+	require.NoError(t, err)
+
+	// Step 1: inspecting shows the payload and the in-process copy
+	entry, err := second.RedisInspect(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, CacheKey(PersonCacheEntity, person.ID), entry.Key)
+	require.Equal(t, CachePayloadVersion, entry.SchemaVersion)
+	require.True(t, entry.Local)
+	require.Positive(t, entry.TTLMillis)
+	require.Equal(t, "Eugen", entry.Value.(*model.Person).Name)
+
+	// Step 2: flushing empties Redis and the in-process tier of every instance
+	deleted, err := first.RedisFlush(ctx)
+	require.NoError(t, err)
+	require.Positive(t, deleted)
+	require.Equal(t, uint64(deleted), first.Stats()[1].Evictions)
+	_, err = first.RedisInspect(ctx, person.ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+	require.Eventually(t, func() bool {
+		_, err := second.RedisGetByID(ctx, person.ID)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
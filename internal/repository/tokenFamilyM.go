@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenFamilyMongoDBConnection is a struct, which contains *mongo.Client variable
+type TokenFamilyMongoDBConnection struct {
+	client *mongo.Client
+}
+
+// NewTokenFamilyMongoDBConnection func is a constructor of TokenFamilyMongoDBConnection struct
+func NewTokenFamilyMongoDBConnection(client *mongo.Client) *TokenFamilyMongoDBConnection {
+	return &TokenFamilyMongoDBConnection{client: client}
+}
+
+// collection returns the token family collection
+func (db *TokenFamilyMongoDBConnection) collection() *mongo.Collection {
+	return db.client.Database("my_mongo_base").Collection("token_family")
+}
+
+// Create function executes "db.token_family.insertOne()" command
+func (db *TokenFamilyMongoDBConnection) Create(ctx context.Context, family *model.TokenFamily) error {
+	_, err := db.collection().InsertOne(ctx, family)
+	if err != nil {
+		return fmt.Errorf("InsertOne: %w", err)
+	}
+	return nil
+}
+
+// Get function executes "db.token_family.findOne()" command
+func (db *TokenFamilyMongoDBConnection) Get(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error) {
+	family := &model.TokenFamily{}
+	err := db.collection().FindOne(ctx, bson.M{"_id": id}).Decode(family)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOne: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOne: %w", err)
+	}
+	return family, nil
+}
+
+// Rotate function executes "db.token_family.findOneAndUpdate()" replacing the token hash only if oldHash
+// is still the latest one, so one token can't be rotated twice, even concurrently
+func (db *TokenFamilyMongoDBConnection) Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash []byte, expiresAt time.Time) (*model.TokenFamily, error) {
+	filter := bson.M{"_id": id, "token_hash": oldHash, "revoked_at": nil}
+	update := bson.M{
+		"$set": bson.M{"token_hash": newHash, "rotated_at": time.Now(), "expires_at": expiresAt},
+		"$inc": bson.M{"generation": 1},
+	}
+	family := &model.TokenFamily{}
+	err := db.collection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(family)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		current, err := db.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Rotate: %w", rotateFailure(current))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FindOneAndUpdate: %w", err)
+	}
+	return family, nil
+}
+
+// Revoke function executes "db.token_family.updateOne()" revoking a token family,
+// revoking it again keeps the first revocation time
+func (db *TokenFamilyMongoDBConnection) Revoke(ctx context.Context, id uuid.UUID) error {
+	res, err := db.collection().UpdateOne(ctx, bson.M{"_id": id, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		_, err = db.Get(ctx, id)
+		return err
+	}
+	return nil
+}
+
+// RevokeUser function executes "db.token_family.updateMany()" revoking every token family of a user
+// and returns the number of revoked families
+func (db *TokenFamilyMongoDBConnection) RevokeUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	res, err := db.collection().UpdateMany(ctx, bson.M{"user_id": userID, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return 0, fmt.Errorf("UpdateMany: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
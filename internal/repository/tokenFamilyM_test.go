@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var familyM *TokenFamilyMongoDBConnection
+
+func TestMongoTokenFamily(t *testing.T) {
+	checkTokenFamilies(t, familyM)
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// TokenFamilyMemoryConnection is an in-memory token family storage, safe for concurrent use
+type TokenFamilyMemoryConnection struct {
+	mu       sync.Mutex
+	families map[uuid.UUID]model.TokenFamily
+}
+
+// NewTokenFamilyMemoryConnection is a constructor for TokenFamilyMemoryConnection
+func NewTokenFamilyMemoryConnection() *TokenFamilyMemoryConnection {
+	return &TokenFamilyMemoryConnection{families: make(map[uuid.UUID]model.TokenFamily)}
+}
+
+// copyTokenFamily returns a deep copy of the family
+func copyTokenFamily(family *model.TokenFamily) *model.TokenFamily {
+	cp := *family
+	cp.TokenHash = cloneBytes(family.TokenHash)
+	if family.RevokedAt != nil {
+		revokedAt := *family.RevokedAt
+		cp.RevokedAt = &revokedAt
+	}
+	return &cp
+}
+
+// Create stores a copy of a new family
+func (db *TokenFamilyMemoryConnection) Create(_ context.Context, family *model.TokenFamily) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.families[family.ID]; ok {
+		return fmt.Errorf("Create: token family %v already exists", family.ID)
+	}
+	db.families[family.ID] = *copyTokenFamily(family)
+	return nil
+}
+
+// Get returns a copy of the family
+func (db *TokenFamilyMemoryConnection) Get(_ context.Context, id uuid.UUID) (*model.TokenFamily, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	family, ok := db.families[id]
+	if !ok {
+		return nil, fmt.Errorf("Get: token family %v: %w", id, model.ErrNotFound)
+	}
+	return copyTokenFamily(&family), nil
+}
+
+// Rotate replaces the token hash of a family if oldHash is still the latest one
+func (db *TokenFamilyMemoryConnection) Rotate(_ context.Context, id uuid.UUID, oldHash, newHash []byte, expiresAt time.Time) (*model.TokenFamily, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	family, ok := db.families[id]
+	if !ok {
+		return nil, fmt.Errorf("Rotate: token family %v: %w", id, model.ErrNotFound)
+	}
+	if family.RevokedAt != nil || !bytes.Equal(family.TokenHash, oldHash) {
+		return nil, fmt.Errorf("Rotate: %w", rotateFailure(&family))
+	}
+	family.TokenHash = cloneBytes(newHash)
+	family.Generation++
+	family.RotatedAt = time.Now()
+	family.ExpiresAt = expiresAt
+	db.families[id] = family
+	return copyTokenFamily(&family), nil
+}
+
+// Revoke revokes a family, revoking it again keeps the first revocation time
+func (db *TokenFamilyMemoryConnection) Revoke(_ context.Context, id uuid.UUID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	family, ok := db.families[id]
+	if !ok {
+		return fmt.Errorf("Revoke: token family %v: %w", id, model.ErrNotFound)
+	}
+	if family.RevokedAt == nil {
+		revokedAt := time.Now()
+		family.RevokedAt = &revokedAt
+		db.families[id] = family
+	}
+	return nil
+}
+
+// RevokeUser revokes every family of a user and returns the number of revoked families
+func (db *TokenFamilyMemoryConnection) RevokeUser(_ context.Context, userID uuid.UUID) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var revoked int64
+	revokedAt := time.Now()
+	for id, family := range db.families {
+		if family.UserID != userID || family.RevokedAt != nil {
+			continue
+		}
+		family.RevokedAt = &revokedAt
+		db.families[id] = family
+		revoked++
+	}
+	return revoked, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenFamilyRepository is implemented by every token family backend
+type tokenFamilyRepository interface {
+	Create(ctx context.Context, family *model.TokenFamily) error
+	Get(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error)
+	Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash []byte, expiresAt time.Time) (*model.TokenFamily, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+	RevokeUser(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// checkTokenFamilies rotates a family, replays an old token and revokes the family
+func checkTokenFamilies(t *testing.T, db tokenFamilyRepository) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	family := &model.TokenFamily{ID: uuid.New(), UserID: uuid.New(), TokenHash: []byte("first"),
+		CreatedAt: now, RotatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, db.Create(ctx, family))
+
+	// Step 1: The latest token rotates the family
+	expiresAt := now.Add(2 * time.Hour)
+	rotated, err := db.Rotate(ctx, family.ID, []byte("first"), []byte("second"), expiresAt)
+	require.NoError(t, err)
+	require.Equal(t, 1, rotated.Generation)
+	require.Equal(t, []byte("second"), rotated.TokenHash)
+	require.Equal(t, family.UserID, rotated.UserID)
+	require.True(t, expiresAt.Equal(rotated.ExpiresAt))
+	require.Nil(t, rotated.RevokedAt)
+
+	// Step 2: Only one of concurrent rotations of the same token wins
+	var wg sync.WaitGroup
+	var won, reused int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.Rotate(ctx, family.ID, []byte("second"), []byte{byte('a' + i)}, expiresAt)
+			if err == nil {
+				atomic.AddInt32(&won, 1)
+			} else if errors.Is(err, model.ErrTokenReuse) {
+				atomic.AddInt32(&reused, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), won)
+	require.Equal(t, int32(4), reused)
+
+	// Step 3: An old token is reported as reused
+	_, err = db.Rotate(ctx, family.ID, []byte("first"), []byte("third"), expiresAt)
+	require.ErrorIs(t, err, model.ErrTokenReuse)
+
+	// Step 4: A revoked family can't be rotated
+	latest, err := db.Get(ctx, family.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, latest.Generation)
+	require.NoError(t, db.Revoke(ctx, family.ID))
+	require.NoError(t, db.Revoke(ctx, family.ID))
+	_, err = db.Rotate(ctx, family.ID, latest.TokenHash, []byte("third"), expiresAt)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+	revoked, err := db.Get(ctx, family.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+
+	// Step 5: Revoking a user revokes the families it has left
+	other := &model.TokenFamily{ID: uuid.New(), UserID: family.UserID, TokenHash: []byte("other"),
+		CreatedAt: now, RotatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, db.Create(ctx, other))
+	revokedCount, err := db.RevokeUser(ctx, family.UserID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), revokedCount)
+	_, err = db.Rotate(ctx, other.ID, []byte("other"), []byte("third"), expiresAt)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+	revokedCount, err = db.RevokeUser(ctx, uuid.New())
+	require.NoError(t, err)
+	require.Zero(t, revokedCount)
+
+	// Step 6: Unknown families
+	_, err = db.Get(ctx, uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = db.Rotate(ctx, uuid.New(), []byte("first"), []byte("second"), expiresAt)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.ErrorIs(t, db.Revoke(ctx, uuid.New()), model.ErrNotFound)
+}
+
+func TestTokenFamilyMemory(t *testing.T) {
+	checkTokenFamilies(t, NewTokenFamilyMemoryConnection())
+}
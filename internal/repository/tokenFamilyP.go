@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// TokenFamilyPsqlConnection struct represents a connection to the refresh token family table
+type TokenFamilyPsqlConnection struct {
+	pool *pgxpool.Pool
+}
+
+// NewTokenFamilyPsqlConnection is a constructor for TokenFamilyPsqlConnection
+func NewTokenFamilyPsqlConnection(pool *pgxpool.Pool) *TokenFamilyPsqlConnection {
+	return &TokenFamilyPsqlConnection{pool: pool}
+}
+
+// Create function executes SQL request to insert a new token family
+func (db *TokenFamilyPsqlConnection) Create(ctx context.Context, family *model.TokenFamily) error {
+	_, err := db.pool.Exec(ctx, `INSERT INTO goschema.token_family (id, user_id, token_hash, generation, created_at, rotated_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		family.ID, family.UserID, family.TokenHash, family.Generation, family.CreatedAt, family.RotatedAt, family.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	return nil
+}
+
+// Get function executes SQL request to select a token family
+func (db *TokenFamilyPsqlConnection) Get(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error) {
+	family, err := scanTokenFamily(db.pool.QueryRow(ctx, `SELECT id, user_id, token_hash, generation, created_at, rotated_at, expires_at, revoked_at
+	FROM goschema.token_family WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return family, nil
+}
+
+// Rotate function executes SQL request replacing the token hash of a family only if oldHash is still the latest one,
+// so one token can't be rotated twice, even concurrently
+func (db *TokenFamilyPsqlConnection) Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash []byte, expiresAt time.Time) (*model.TokenFamily, error) {
+	family, err := scanTokenFamily(db.pool.QueryRow(ctx, `UPDATE goschema.token_family
+	SET token_hash=$3, generation=generation+1, rotated_at=now(), expires_at=$4
+	WHERE id=$1 AND token_hash=$2 AND revoked_at IS NULL
+	RETURNING id, user_id, token_hash, generation, created_at, rotated_at, expires_at, revoked_at`, id, oldHash, newHash, expiresAt))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, db.rotateFailure(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", err)
+	}
+	return family, nil
+}
+
+// Revoke function executes SQL request revoking a token family, revoking it again keeps the first revocation time
+func (db *TokenFamilyPsqlConnection) Revoke(ctx context.Context, id uuid.UUID) error {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.token_family SET revoked_at=COALESCE(revoked_at, now()) WHERE id=$1`, id)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("Revoke: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// RevokeUser function executes SQL request revoking every token family of a user and returns the number of revoked families
+func (db *TokenFamilyPsqlConnection) RevokeUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `UPDATE goschema.token_family SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("Exec(): %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// rotateFailure tells why a family was not rotated
+func (db *TokenFamilyPsqlConnection) rotateFailure(ctx context.Context, id uuid.UUID) error {
+	family, err := db.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Rotate: %w", rotateFailure(family))
+}
+
+// scanTokenFamily scans a token family row
+func scanTokenFamily(row pgx.Row) (*model.TokenFamily, error) {
+	family := &model.TokenFamily{}
+	err := row.Scan(&family.ID, &family.UserID, &family.TokenHash, &family.Generation,
+		&family.CreatedAt, &family.RotatedAt, &family.ExpiresAt, &family.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
+// rotateFailure returns the reason a family which exists was not rotated
+func rotateFailure(family *model.TokenFamily) error {
+	if family.RevokedAt != nil {
+		return model.ErrTokenRevoked
+	}
+	return model.ErrTokenReuse
+}
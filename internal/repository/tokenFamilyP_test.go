@@ -0,0 +1,11 @@
+//go:build integration
+
+package repository
+
+import "testing"
+
+var familyP *TokenFamilyPsqlConnection
+
+func TestPgxTokenFamily(t *testing.T) {
+	checkTokenFamilies(t, familyP)
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pgxConn is implemented by both pgxpool.Pool and pgx.Tx
+type pgxConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// txKey is the context key of the running PostgreSQL transaction
+type txKey struct{}
+
+// conn returns the transaction started by PsqlTxManager or the pool outside of it
+func conn(ctx context.Context, pool *pgxpool.Pool) pgxConn {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return pool
+}
+
+// PsqlTxManager runs functions in a PostgreSQL transaction shared by the Psql repositories
+type PsqlTxManager struct {
+	pool *pgxpool.Pool
+}
+
+// NewPsqlTxManager is a constructor for PsqlTxManager
+func NewPsqlTxManager(pool *pgxpool.Pool) *PsqlTxManager {
+	return &PsqlTxManager{pool: pool}
+}
+
+// WithinTx runs fn in a transaction, commits it if fn succeeds and rolls it back otherwise,
+// fn joins the transaction of ctx if there is one
+func (m *PsqlTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin(): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("Commit(): %w", err)
+	}
+	return nil
+}
+
+// MongoTxManager runs functions in a MongoDB transaction shared by the Mongo repositories
+type MongoTxManager struct {
+	client *mongo.Client
+}
+
+// NewMongoTxManager is a constructor for MongoTxManager, it fails unless the server is a replica set member
+// or mongos, as standalone servers have no transactions
+func NewMongoTxManager(ctx context.Context, client *mongo.Client) (*MongoTxManager, error) {
+	var hello bson.M
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return nil, fmt.Errorf("RunCommand(): %w", err)
+	}
+	if _, replicaSet := hello["setName"]; !replicaSet && hello["msg"] != "isdbgrid" {
+		return nil, errors.New("MongoDB is a standalone server, transactions need a replica set")
+	}
+	return &MongoTxManager{client: client}, nil
+}
+
+// WithinTx runs fn in a transaction, commits it if fn succeeds and aborts it otherwise,
+// fn joins the transaction of ctx if there is one
+func (m *MongoTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("StartSession(): %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// MemoryTxManager runs functions as is, the memory repositories have no transactions
+type MemoryTxManager struct{}
+
+// NewMemoryTxManager is a constructor for MemoryTxManager
+func NewMemoryTxManager() *MemoryTxManager {
+	return &MemoryTxManager{}
+}
+
+// WithinTx runs fn
+func (m *MemoryTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
@@ -2,12 +2,14 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/eugenshima/myapp/internal/model"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // import "go.mongodb.org/mongo-driver/mongo"
@@ -28,6 +30,9 @@ func (db *UserMongoDBConnection) GetUser(ctx context.Context, login string) (*mo
 	collection := db.client.Database("my_mongo_base").Collection("user")
 	filter := bson.M{"login": login}
 	err := collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("FindOne: %w", model.ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error: %v", err)
 	}
@@ -103,12 +108,55 @@ func (db *UserMongoDBConnection) GetRoleByID(ctx context.Context, ID uuid.UUID)
 	filter := bson.M{"_id": ID}
 	var user model.User
 	err := collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("FindOne(): %w", model.ErrNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("FindOne(): %w", err)
 	}
 	return user.Role, nil
 }
 
+// GetLoginByID returns the login of the given user ID
+func (db *UserMongoDBConnection) GetLoginByID(ctx context.Context, ID uuid.UUID) (string, error) {
+	collection := db.client.Database("my_mongo_base").Collection("user")
+	var user model.User
+	err := collection.FindOne(ctx, bson.M{"_id": ID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("FindOne(): %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("FindOne(): %w", err)
+	}
+	return user.Login, nil
+}
+
+// SetRole func assigns the given role to a user
+func (db *UserMongoDBConnection) SetRole(ctx context.Context, ID uuid.UUID, role string) error {
+	collection := db.client.Database("my_mongo_base").Collection("user")
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": ID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne(): %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne(): %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// SetPassword func replaces the password hash of a user
+func (db *UserMongoDBConnection) SetPassword(ctx context.Context, ID uuid.UUID, password []byte) error {
+	collection := db.client.Database("my_mongo_base").Collection("user")
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": ID}, bson.M{"$set": bson.M{"password": password}})
+	if err != nil {
+		return fmt.Errorf("UpdateOne(): %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("UpdateOne(): %w", model.ErrNotFound)
+	}
+	return nil
+}
+
 // Delete func deletes user from the database
 func (db *UserMongoDBConnection) Delete(ctx context.Context, ID uuid.UUID) error {
 	collection := db.client.Database("my_mongo_base").Collection("user")
@@ -122,3 +170,39 @@ func (db *UserMongoDBConnection) Delete(ctx context.Context, ID uuid.UUID) error
 	}
 	return nil
 }
+
+// GetBatch func executes a query, which returns up to limit users with IDs greater than after in ID order
+func (db *UserMongoDBConnection) GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.User, error) {
+	collection := db.client.Database("my_mongo_base").Collection("user")
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find(): %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]*model.User, 0, limit)
+	for cursor.Next(ctx) {
+		var user *model.User
+		err = cursor.Decode(&user)
+		if err != nil {
+			return nil, fmt.Errorf("Decode(): %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, cursor.Err()
+}
+
+// Import func replaces the users with the given ones as they are, importing the same users twice is harmless
+func (db *UserMongoDBConnection) Import(ctx context.Context, users []*model.User) error {
+	collection := db.client.Database("my_mongo_base").Collection("user")
+	models := make([]mongo.WriteModel, len(users))
+	for i, user := range users {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": user.ID}).SetReplacement(user).SetUpsert(true)
+	}
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("BulkWrite(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/google/uuid"
+)
+
+// UserMemoryConnection is an in-memory user storage, safe for concurrent use
+type UserMemoryConnection struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]*model.User
+}
+
+// NewUserMemoryConnection is a constructor for UserMemoryConnection
+func NewUserMemoryConnection() *UserMemoryConnection {
+	return &UserMemoryConnection{users: make(map[uuid.UUID]*model.User)}
+}
+
+// copyUser returns a deep copy of the user, so callers never share byte slices with the storage
+func copyUser(user *model.User) *model.User {
+	cp := *user
+	cp.Password = cloneBytes(user.Password)
+	cp.RefreshToken = cloneBytes(user.RefreshToken)
+	return &cp
+}
+
+// cloneBytes copies b, keeping nil as nil
+func cloneBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// GetUser returns the user with the given login
+func (db *UserMemoryConnection) GetUser(_ context.Context, login string) (*model.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, user := range db.users {
+		if user.Login == login {
+			return copyUser(user), nil
+		}
+	}
+	return nil, fmt.Errorf("GetUser: user %q: %w", login, model.ErrNotFound)
+}
+
+// Signup stores a new user
+func (db *UserMemoryConnection) Signup(_ context.Context, entity *model.User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range db.users {
+		if user.ID == entity.ID || user.Login == entity.Login {
+			return fmt.Errorf("Signup: user %q already exists", entity.Login)
+		}
+	}
+	db.users[entity.ID] = copyUser(entity)
+	return nil
+}
+
+// GetAll returns all stored users
+func (db *UserMemoryConnection) GetAll(_ context.Context) ([]*model.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	users := make([]*model.User, 0, len(db.users))
+	for _, user := range db.users {
+		users = append(users, copyUser(user))
+	}
+	return users, nil
+}
+
+// SaveRefreshToken saves the refresh token to a specific user
+func (db *UserMemoryConnection) SaveRefreshToken(_ context.Context, ID uuid.UUID, token []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return fmt.Errorf("SaveRefreshToken: user %v not found", ID)
+	}
+	user.RefreshToken = cloneBytes(token)
+	return nil
+}
+
+// GetRefreshToken returns a refresh token for the given user
+func (db *UserMemoryConnection) GetRefreshToken(_ context.Context, ID uuid.UUID) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return nil, fmt.Errorf("GetRefreshToken: user %v not found", ID)
+	}
+	return cloneBytes(user.RefreshToken), nil
+}
+
+// GetRoleByID returns a role for the given user ID
+func (db *UserMemoryConnection) GetRoleByID(_ context.Context, ID uuid.UUID) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return "", fmt.Errorf("GetRoleByID: user %v: %w", ID, model.ErrNotFound)
+	}
+	return user.Role, nil
+}
+
+// GetLoginByID returns the login of the given user ID
+func (db *UserMemoryConnection) GetLoginByID(_ context.Context, ID uuid.UUID) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return "", fmt.Errorf("GetLoginByID: user %v: %w", ID, model.ErrNotFound)
+	}
+	return user.Login, nil
+}
+
+// SetRole assigns the given role to a user
+func (db *UserMemoryConnection) SetRole(_ context.Context, ID uuid.UUID, role string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return fmt.Errorf("SetRole: user %v: %w", ID, model.ErrNotFound)
+	}
+	user.Role = role
+	return nil
+}
+
+// SetPassword replaces the password hash of a user with a copy of the given one
+func (db *UserMemoryConnection) SetPassword(_ context.Context, ID uuid.UUID, password []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	user, ok := db.users[ID]
+	if !ok {
+		return fmt.Errorf("SetPassword: user %v: %w", ID, model.ErrNotFound)
+	}
+	user.Password = cloneBytes(password)
+	return nil
+}
+
+// Delete removes the given user
+func (db *UserMemoryConnection) Delete(_ context.Context, ID uuid.UUID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[ID]; !ok {
+		return fmt.Errorf("Delete: user %v not found", ID)
+	}
+	delete(db.users, ID)
+	return nil
+}
+
+// GetBatch returns copies of up to limit users with IDs greater than after in ID order
+func (db *UserMemoryConnection) GetBatch(_ context.Context, after uuid.UUID, limit int) ([]*model.User, error) {
+	db.mu.RLock()
+	users := make([]*model.User, 0, len(db.users))
+	for id, user := range db.users {
+		if bytes.Compare(id[:], after[:]) > 0 {
+			users = append(users, copyUser(user))
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return bytes.Compare(users[i].ID[:], users[j].ID[:]) < 0 })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// Import stores copies of the users as they are, replacing the stored ones
+func (db *UserMemoryConnection) Import(_ context.Context, users []*model.User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range users {
+		db.users[user.ID] = copyUser(user)
+	}
+	return nil
+}
+
+// UserMemoryCacheConnection is an in-memory stand-in for the user Redis cache
+type UserMemoryCacheConnection struct {
+	mu       sync.Mutex
+	entries  map[uuid.UUID]memoryEntry[*model.User]
+	families map[uuid.UUID]model.TokenFamily
+	tokens   map[string]time.Time
+	users    map[uuid.UUID]memoryEntry[time.Time]
+	failures map[string]memoryEntry[model.LoginFailures]
+}
+
+// NewUserMemoryCacheConnection is a constructor for UserMemoryCacheConnection
+func NewUserMemoryCacheConnection() *UserMemoryCacheConnection {
+	return &UserMemoryCacheConnection{
+		entries:  make(map[uuid.UUID]memoryEntry[*model.User]),
+		families: make(map[uuid.UUID]model.TokenFamily),
+		tokens:   make(map[string]time.Time),
+		users:    make(map[uuid.UUID]memoryEntry[time.Time]),
+		failures: make(map[string]memoryEntry[model.LoginFailures]),
+	}
+}
+
+// get returns a live entry and prolongs its TTL, the caller must hold the lock
+func (rdb *UserMemoryCacheConnection) get(id uuid.UUID) (*model.User, error) {
+	entry, ok := rdb.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(rdb.entries, id)
+		return nil, fmt.Errorf(" Get: user %v: %w", id, model.ErrCacheMiss)
+	}
+	entry.expiresAt = time.Now().Add(TTL)
+	rdb.entries[id] = entry
+	return entry.value, nil
+}
+
+// Set caches a copy of the user
+func (rdb *UserMemoryCacheConnection) Set(_ context.Context, user *model.User) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	rdb.entries[user.ID] = memoryEntry[*model.User]{value: copyUser(user), expiresAt: time.Now().Add(TTL)}
+	return nil
+}
+
+// Get returns the cached user
+func (rdb *UserMemoryCacheConnection) Get(_ context.Context, id uuid.UUID) (*model.User, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	user, err := rdb.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// Delete removes the user from the cache
+func (rdb *UserMemoryCacheConnection) Delete(_ context.Context, id uuid.UUID) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	if _, ok := rdb.entries[id]; !ok {
+		return fmt.Errorf(" Del: user %v: %w", id, model.ErrCacheMiss)
+	}
+	delete(rdb.entries, id)
+	return nil
+}
+
+// GetRefreshToken returns the cached refresh token of the given user
+func (rdb *UserMemoryCacheConnection) GetRefreshToken(_ context.Context, id uuid.UUID) ([]byte, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	user, err := rdb.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return cloneBytes(user.RefreshToken), nil
+}
+
+// SetRefreshToken sets the refresh token of an already cached user
+func (rdb *UserMemoryCacheConnection) SetRefreshToken(_ context.Context, id uuid.UUID, token []byte) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	user, err := rdb.get(id)
+	if err != nil {
+		return err
+	}
+	user.RefreshToken = cloneBytes(token)
+	return nil
+}
+
+// SetTokenFamily caches a copy of the family until its latest token expires
+func (rdb *UserMemoryCacheConnection) SetTokenFamily(_ context.Context, family *model.TokenFamily) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	rdb.families[family.ID] = *copyTokenFamily(family)
+	return nil
+}
+
+// GetTokenFamily returns a cached family which has not expired
+func (rdb *UserMemoryCacheConnection) GetTokenFamily(_ context.Context, id uuid.UUID) (*model.TokenFamily, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	family, ok := rdb.families[id]
+	if !ok || time.Now().After(family.ExpiresAt) {
+		delete(rdb.families, id)
+		return nil, fmt.Errorf(" Get: token family %v: %w", id, model.ErrCacheMiss)
+	}
+	return copyTokenFamily(&family), nil
+}
+
+// DenyToken puts an access token on the denylist for ttl
+func (rdb *UserMemoryCacheConnection) DenyToken(_ context.Context, tokenID string, ttl time.Duration) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	rdb.tokens[tokenID] = time.Now().Add(ttl)
+	return nil
+}
+
+// DenyUser puts every access token of the user issued before revokedAt on the denylist for ttl
+func (rdb *UserMemoryCacheConnection) DenyUser(_ context.Context, id uuid.UUID, revokedAt time.Time, ttl time.Duration) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	rdb.users[id] = memoryEntry[time.Time]{value: revokedAt, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// IsDenied reports whether an access token is on the denylist, by itself or by its user
+func (rdb *UserMemoryCacheConnection) IsDenied(_ context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := rdb.tokens[tokenID]; ok {
+		if now.Before(expiresAt) {
+			return true, nil
+		}
+		delete(rdb.tokens, tokenID)
+	}
+	user, ok := rdb.users[userID]
+	if !ok {
+		return false, nil
+	}
+	if !now.Before(user.expiresAt) {
+		delete(rdb.users, userID)
+		return false, nil
+	}
+	return issuedAt.UnixMilli() < user.value.UnixMilli(), nil
+}
+
+// ReserveLogin counts a login attempt of the scope as failed until it is released and returns
+// the failures counted before it, the counter expires window after the last attempt
+func (rdb *UserMemoryCacheConnection) ReserveLogin(_ context.Context, scope, subject string, at time.Time, window time.Duration) (*model.LoginFailures, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	key := loginFailureKey(scope, subject)
+	entry, ok := rdb.failures[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		entry = memoryEntry[model.LoginFailures]{}
+	}
+	previous := entry.value
+	entry.value.Count++
+	entry.value.LastAt = at
+	entry.expiresAt = time.Now().Add(window)
+	rdb.failures[key] = entry
+	return &previous, nil
+}
+
+// ReleaseLogin takes back the login attempt reserved at, lastAt is the last attempt before it
+func (rdb *UserMemoryCacheConnection) ReleaseLogin(_ context.Context, scope, subject string, at, lastAt time.Time, _ time.Duration) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	key := loginFailureKey(scope, subject)
+	entry, ok := rdb.failures[key]
+	if !ok || !time.Now().Before(entry.expiresAt) || entry.value.Count <= 1 {
+		delete(rdb.failures, key)
+		return nil
+	}
+	entry.value.Count--
+	if entry.value.LastAt.Equal(at) {
+		entry.value.LastAt = lastAt
+	}
+	rdb.failures[key] = entry
+	return nil
+}
+
+// GetLoginFailures returns the failed logins counted for the scope
+func (rdb *UserMemoryCacheConnection) GetLoginFailures(_ context.Context, scope, subject string) (*model.LoginFailures, error) {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	key := loginFailureKey(scope, subject)
+	entry, ok := rdb.failures[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		delete(rdb.failures, key)
+		return &model.LoginFailures{}, nil
+	}
+	failures := entry.value
+	return &failures, nil
+}
+
+// ResetLoginFailures forgets the failed logins of the scope
+func (rdb *UserMemoryCacheConnection) ResetLoginFailures(_ context.Context, scope, subject string) error {
+	rdb.mu.Lock()
+	defer rdb.mu.Unlock()
+	delete(rdb.failures, loginFailureKey(scope, subject))
+	return nil
+}
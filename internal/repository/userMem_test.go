@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testUserRedis is the user the cache tests of every backend store
+var testUserRedis = model.User{
+	ID:       uuid.New(),
+	Login:    "test",
+	Password: []byte("test"),
+	Role:     "user",
+}
+
+func TestUserMemorySignup(t *testing.T) {
+	urpsMem := NewUserMemoryConnection()
+	user := model.User{ID: uuid.New(), Login: "memory", Password: hashPassword([]byte("memory")), Role: "user"}
+	err := urpsMem.Signup(context.Background(), &user)
+	require.NoError(t, err)
+	err = urpsMem.Signup(context.Background(), &model.User{ID: uuid.New(), Login: "memory"})
+	require.Error(t, err)
+	testUser, err := urpsMem.GetUser(context.Background(), "memory")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, testUser.ID)
+	require.Equal(t, user.Password, testUser.Password)
+	role, err := urpsMem.GetRoleByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "user", role)
+}
+
+func TestUserMemoryRefreshToken(t *testing.T) {
+	urpsMem := NewUserMemoryConnection()
+	user := model.User{ID: uuid.New(), Login: "memory", Role: "user"}
+	require.NoError(t, urpsMem.Signup(context.Background(), &user))
+	token := []byte("token")
+	require.NoError(t, urpsMem.SaveRefreshToken(context.Background(), user.ID, token))
+	token[0] = 'T'
+	saved, err := urpsMem.GetRefreshToken(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), saved)
+	require.Error(t, urpsMem.SaveRefreshToken(context.Background(), uuid.New(), token))
+}
+
+func TestUserMemoryDelete(t *testing.T) {
+	urpsMem := NewUserMemoryConnection()
+	user := model.User{ID: uuid.New(), Login: "memory", Role: "user"}
+	require.NoError(t, urpsMem.Signup(context.Background(), &user))
+	require.NoError(t, urpsMem.Delete(context.Background(), user.ID))
+	require.Error(t, urpsMem.Delete(context.Background(), user.ID))
+	users, err := urpsMem.GetAll(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, users)
+}
+
+func TestUserMemoryCache(t *testing.T) {
+	urdbMem := NewUserMemoryCacheConnection()
+	err := urdbMem.SetRefreshToken(context.Background(), testUserRedis.ID, []byte("token"))
+	require.Error(t, err)
+	require.NoError(t, urdbMem.Set(context.Background(), &testUserRedis))
+	require.NoError(t, urdbMem.SetRefreshToken(context.Background(), testUserRedis.ID, []byte("token")))
+	token, err := urdbMem.GetRefreshToken(context.Background(), testUserRedis.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("token"), token)
+	user, err := urdbMem.Get(context.Background(), testUserRedis.ID)
+	require.NoError(t, err)
+	require.Equal(t, testUserRedis.Login, user.Login)
+	require.NoError(t, urdbMem.Delete(context.Background(), testUserRedis.ID))
+	require.Error(t, urdbMem.Delete(context.Background(), testUserRedis.ID))
+}
+
+func TestUserMemoryCacheTokenFamily(t *testing.T) {
+	urdbMem := NewUserMemoryCacheConnection()
+	family := model.TokenFamily{ID: uuid.New(), TokenHash: []byte("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	_, err := urdbMem.GetTokenFamily(context.Background(), family.ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+	require.NoError(t, urdbMem.SetTokenFamily(context.Background(), &family))
+	family.TokenHash[0] = 'H'
+	cached, err := urdbMem.GetTokenFamily(context.Background(), family.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hash"), cached.TokenHash)
+	expired := model.TokenFamily{ID: uuid.New(), ExpiresAt: time.Now().Add(-time.Second)}
+	require.NoError(t, urdbMem.SetTokenFamily(context.Background(), &expired))
+	_, err = urdbMem.GetTokenFamily(context.Background(), expired.ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+}
+
+func TestUserMemoryCacheDenylist(t *testing.T) {
+	checkDenylist(t, NewUserMemoryCacheConnection())
+}
+
+// denylist is implemented by every user cache
+type denylist interface {
+	DenyToken(ctx context.Context, tokenID string, ttl time.Duration) error
+	DenyUser(ctx context.Context, id uuid.UUID, revokedAt time.Time, ttl time.Duration) error
+	IsDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+}
+
+// checkDenylist denies a single token, then every token of a user
+func checkDenylist(t *testing.T, db denylist) {
+	ctx := context.Background()
+	userID, tokenID := uuid.New(), uuid.New().String()
+	issuedAt := time.Now().Add(-time.Minute)
+
+	// Step 1: Only the denied token is denied
+	denied, err := db.IsDenied(ctx, tokenID, userID, issuedAt)
+	require.NoError(t, err)
+	require.False(t, denied)
+	require.NoError(t, db.DenyToken(ctx, tokenID, time.Minute))
+	denied, err = db.IsDenied(ctx, tokenID, userID, issuedAt)
+	require.NoError(t, err)
+	require.True(t, denied)
+	denied, err = db.IsDenied(ctx, uuid.New().String(), userID, issuedAt)
+	require.NoError(t, err)
+	require.False(t, denied)
+
+	// Step 2: Denying the user denies the tokens issued before, to the millisecond
+	revokedAt := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	require.NoError(t, db.DenyUser(ctx, userID, revokedAt, time.Minute))
+	denied, err = db.IsDenied(ctx, uuid.New().String(), userID, issuedAt)
+	require.NoError(t, err)
+	require.True(t, denied)
+	denied, err = db.IsDenied(ctx, uuid.New().String(), userID, revokedAt.Add(-time.Millisecond))
+	require.NoError(t, err)
+	require.True(t, denied)
+	denied, err = db.IsDenied(ctx, uuid.New().String(), userID, revokedAt)
+	require.NoError(t, err)
+	require.False(t, denied)
+	denied, err = db.IsDenied(ctx, uuid.New().String(), userID, revokedAt.Add(time.Millisecond))
+	require.NoError(t, err)
+	require.False(t, denied)
+
+	// Step 3: Denials expire
+	require.NoError(t, db.DenyToken(ctx, tokenID, time.Millisecond))
+	require.NoError(t, db.DenyUser(ctx, userID, time.Now(), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	denied, err = db.IsDenied(ctx, tokenID, userID, issuedAt)
+	require.NoError(t, err)
+	require.False(t, denied)
+}
+
+func TestUserMemoryCacheLoginFailures(t *testing.T) {
+	checkLoginFailures(t, NewUserMemoryCacheConnection())
+}
+
+// loginFailures is implemented by every user cache
+type loginFailures interface {
+	ReserveLogin(ctx context.Context, scope, subject string, at time.Time, window time.Duration) (*model.LoginFailures, error)
+	ReleaseLogin(ctx context.Context, scope, subject string, at, lastAt time.Time, window time.Duration) error
+	GetLoginFailures(ctx context.Context, scope, subject string) (*model.LoginFailures, error)
+	ResetLoginFailures(ctx context.Context, scope, subject string) error
+}
+
+// checkLoginFailures counts failures of a login, releases and resets them and lets them expire
+func checkLoginFailures(t *testing.T, db loginFailures) {
+	ctx := context.Background()
+	login := uuid.NewString()
+	at := time.Now().Truncate(time.Millisecond)
+
+	// Step 1: Failures are counted per scope
+	failures, err := db.GetLoginFailures(ctx, model.LoginScopeLogin, login)
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+	for i := 0; i < 3; i++ {
+		failures, err = db.ReserveLogin(ctx, model.LoginScopeLogin, login, at, time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, i, failures.Count)
+	}
+	failures, err = db.GetLoginFailures(ctx, model.LoginScopeLogin, login)
+	require.NoError(t, err)
+	require.Equal(t, 3, failures.Count)
+	require.True(t, at.Equal(failures.LastAt))
+	failures, err = db.GetLoginFailures(ctx, model.LoginScopeIP, login)
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+
+	// Step 2: A released attempt is taken back along with its time
+	later := at.Add(time.Second)
+	_, err = db.ReserveLogin(ctx, model.LoginScopeLogin, login, later, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, db.ReleaseLogin(ctx, model.LoginScopeLogin, login, later, at, time.Minute))
+	failures, err = db.GetLoginFailures(ctx, model.LoginScopeLogin, login)
+	require.NoError(t, err)
+	require.Equal(t, 3, failures.Count)
+	require.True(t, at.Equal(failures.LastAt))
+
+	// Step 3: A reset forgets them
+	require.NoError(t, db.ResetLoginFailures(ctx, model.LoginScopeLogin, login))
+	failures, err = db.GetLoginFailures(ctx, model.LoginScopeLogin, login)
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+
+	// Step 4: Failures expire
+	_, err = db.ReserveLogin(ctx, model.LoginScopeLogin, login, at, 5*time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	failures, err = db.GetLoginFailures(ctx, model.LoginScopeLogin, login)
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+}
+
+func TestUserMemorySetRole(t *testing.T) {
+	urpsMem := NewUserMemoryConnection()
+	user := model.User{ID: uuid.New(), Login: "memory", Role: model.RoleUser}
+	require.NoError(t, urpsMem.Signup(context.Background(), &user))
+	require.NoError(t, urpsMem.SetRole(context.Background(), user.ID, model.RoleAdmin))
+	role, err := urpsMem.GetRoleByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, role)
+	login, err := urpsMem.GetLoginByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "memory", login)
+	require.ErrorIs(t, urpsMem.SetRole(context.Background(), uuid.New(), model.RoleAdmin), model.ErrNotFound)
+	_, err = urpsMem.GetLoginByID(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = urpsMem.GetRoleByID(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestUserMemorySetPassword(t *testing.T) {
+	urpsMem := NewUserMemoryConnection()
+	user := model.User{ID: uuid.New(), Login: "memory", Password: []byte("old"), Role: model.RoleUser}
+	require.NoError(t, urpsMem.Signup(context.Background(), &user))
+	password := []byte("new")
+	require.NoError(t, urpsMem.SetPassword(context.Background(), user.ID, password))
+	password[0] = 'N'
+	saved, err := urpsMem.GetUser(context.Background(), "memory")
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), saved.Password)
+	require.ErrorIs(t, urpsMem.SetPassword(context.Background(), uuid.New(), password), model.ErrNotFound)
+}
+
+// hashPassword returns the bcrypt hash of the password
+func hashPassword(password []byte) []byte {
+	hashedPassword, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil
+	}
+	return hashedPassword
+}
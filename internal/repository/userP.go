@@ -5,11 +5,13 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/eugenshima/myapp/internal/model"
 	"github.com/google/uuid"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -27,6 +29,9 @@ func NewUserPsqlConnection(pool *pgxpool.Pool) *UserPsqlConnection {
 func (db *UserPsqlConnection) GetUser(ctx context.Context, login string) (*model.User, error) {
 	var user model.User
 	err := db.pool.QueryRow(ctx, "SELECT id, password, role FROM goschema.user WHERE login = $1", login).Scan(&user.ID, &user.Password, &user.Role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("QueryRow: %w", model.ErrNotFound)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("QueryRow: %w", err)
 	}
@@ -99,6 +104,43 @@ func (db *UserPsqlConnection) GetRoleByID(ctx context.Context, ID uuid.UUID) (st
 	return user.Role, nil
 }
 
+// GetLoginByID returns the login of the given user ID
+func (db *UserPsqlConnection) GetLoginByID(ctx context.Context, ID uuid.UUID) (string, error) {
+	var login string
+	err := db.pool.QueryRow(ctx, "SELECT login FROM goschema.user WHERE id=$1", ID).Scan(&login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("QueryRow: %w", model.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("QueryRow: %w", err)
+	}
+	return login, nil
+}
+
+// SetRole function executes a query, which assigns the given role to a user
+func (db *UserPsqlConnection) SetRole(ctx context.Context, ID uuid.UUID, role string) error {
+	tag, err := db.pool.Exec(ctx, "UPDATE goschema.user SET role=$1 WHERE id=$2", role, ID)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("SetRole: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
+// SetPassword function executes a query, which replaces the password hash of a user, within the transaction of ctx if there is one
+func (db *UserPsqlConnection) SetPassword(ctx context.Context, ID uuid.UUID, password []byte) error {
+	tag, err := conn(ctx, db.pool).Exec(ctx, "UPDATE goschema.user SET password=$1 WHERE id=$2", password, ID)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("SetPassword: %w", model.ErrNotFound)
+	}
+	return nil
+}
+
 // Delete user deletes the given user from the database
 func (db *UserPsqlConnection) Delete(ctx context.Context, ID uuid.UUID) error {
 	bd, err := db.pool.Exec(ctx, "DELETE FROM goschema.user WHERE id=$1", ID)
@@ -107,3 +149,54 @@ func (db *UserPsqlConnection) Delete(ctx context.Context, ID uuid.UUID) error {
 	}
 	return nil
 }
+
+// GetBatch func executes a query, which returns up to limit users with IDs greater than after in ID order
+func (db *UserPsqlConnection) GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.User, error) {
+	rows, err := db.pool.Query(ctx, "SELECT id, login, password, role, refresh_token FROM goschema.user WHERE id > $1 ORDER BY id LIMIT $2", after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]*model.User, 0, limit)
+	for rows.Next() {
+		var user model.User
+		err := rows.Scan(&user.ID, &user.Login, &user.Password, &user.Role, &user.RefreshToken)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", err)
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// Import func replaces the users with the given ones as they are, importing the same users twice is harmless
+func (db *UserPsqlConnection) Import(ctx context.Context, users []*model.User) error {
+	ids := make([]uuid.UUID, len(users))
+	rows := make([][]interface{}, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+		rows[i] = []interface{}{user.ID, user.Login, user.Password, user.Role, user.RefreshToken}
+	}
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("Begin(): %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	_, err = tx.Exec(ctx, "DELETE FROM goschema.user WHERE id = ANY($1::uuid[])", uuidStrings(ids))
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"goschema", "user"},
+		[]string{"id", "login", "password", "role", "refresh_token"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("CopyFrom(): %w", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("Commit(): %w", err)
+	}
+	return nil
+}
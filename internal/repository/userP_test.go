@@ -1,3 +1,5 @@
+//go:build integration
+
 package repository
 
 import (
@@ -21,7 +23,6 @@ var testLoginUser = model.Login{
 var testSignupUser = model.Signup{
 	Login:    "test",
 	Password: "test",
-	Role:     "user",
 }
 
 var testUser = model.User{
@@ -170,3 +171,42 @@ func TestDeleteWrongID(t *testing.T) {
 	err = urps.Delete(context.Background(), testUser.ID)
 	require.NoError(t, err)
 }
+
+func TestSetRole(t *testing.T) {
+	hashedPassword := hashPassword(testUser.Password)
+	testUser.Password = hashedPassword
+	err := urps.Signup(context.Background(), &testUser)
+	require.NoError(t, err)
+	err = urps.SetRole(context.Background(), testUser.ID, model.RoleAdmin)
+	require.NoError(t, err)
+	role, err := urps.GetRoleByID(context.Background(), testUser.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, role)
+	login, err := urps.GetLoginByID(context.Background(), testUser.ID)
+	require.NoError(t, err)
+	require.Equal(t, testUser.Login, login)
+	err = urps.SetRole(context.Background(), uuid.New(), model.RoleAdmin)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = urps.GetLoginByID(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = urps.GetRoleByID(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+	err = urps.Delete(context.Background(), testUser.ID)
+	require.NoError(t, err)
+}
+
+func TestSetPassword(t *testing.T) {
+	testUser.Password = hashPassword([]byte("old"))
+	err := urps.Signup(context.Background(), &testUser)
+	require.NoError(t, err)
+	password := hashPassword([]byte("new"))
+	err = urps.SetPassword(context.Background(), testUser.ID, password)
+	require.NoError(t, err)
+	user, err := urps.GetUser(context.Background(), testUser.Login)
+	require.NoError(t, err)
+	require.Equal(t, password, user.Password)
+	err = urps.SetPassword(context.Background(), uuid.New(), password)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	err = urps.Delete(context.Background(), testUser.ID)
+	require.NoError(t, err)
+}
@@ -3,7 +3,10 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/eugenshima/myapp/internal/model"
 	"github.com/google/uuid"
@@ -22,90 +25,283 @@ func NewUserRedisConnection(rdb *redis.Client) *UserRedisConnection {
 
 // Set func inserting entity to redis database
 func (rdb *UserRedisConnection) Set(ctx context.Context, user *model.User) error {
-	val, err := json.Marshal(model.UserRedis{
+	return rdb.set(ctx, user.ID, &model.UserRedis{
 		Login:        user.Login,
 		Password:     user.Password,
 		Role:         user.Role,
 		RefreshToken: user.RefreshToken,
 	})
+}
+
+// Get func getting entity from redis database
+func (rdb *UserRedisConnection) Get(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	cached, err := rdb.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.User{
+		ID:           id,
+		Login:        cached.Login,
+		Password:     cached.Password,
+		Role:         cached.Role,
+		RefreshToken: cached.RefreshToken,
+	}, nil
+}
+
+// Delete deletes the user from the cache
+func (rdb *UserRedisConnection) Delete(ctx context.Context, id uuid.UUID) error {
+	res, err := rdb.rdb.Del(ctx, CacheKey(UserCacheEntity, id)).Result()
+	if err != nil {
+		return fmt.Errorf(" Del: %w", err)
+	}
+	if res == 0 {
+		return fmt.Errorf(" Del: %w", model.ErrCacheMiss)
+	}
+	return nil
+}
+
+// GetRefreshToken func gets a refresh token from given user
+func (rdb *UserRedisConnection) GetRefreshToken(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	cached, err := rdb.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return cached.RefreshToken, nil
+}
+
+// SetRefreshToken sets the refresh token for the user
+func (rdb *UserRedisConnection) SetRefreshToken(ctx context.Context, id uuid.UUID, token []byte) error {
+	val, err := rdb.rdb.Get(ctx, CacheKey(UserCacheEntity, id)).Result()
+	if err != nil {
+		return fmt.Errorf(" Get: %w", cacheMiss(err))
+	}
+	cached, err := decodeUser(val)
+	if err != nil {
+		return err
+	}
+	cached.RefreshToken = token
+	return rdb.set(ctx, id, cached)
+}
+
+// SetTokenFamily func caches a refresh token family until its latest token expires
+func (rdb *UserRedisConnection) SetTokenFamily(ctx context.Context, family *model.TokenFamily) error {
+	ttl := time.Until(family.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	val, err := json.Marshal(family)
 	if err != nil {
 		return fmt.Errorf(" Marshal: %w", err)
 	}
-	_, err = rdb.rdb.Set(ctx, user.ID.String(), val, TTL).Result()
+	_, err = rdb.rdb.Set(ctx, CacheKey(TokenFamilyCacheEntity, family.ID), val, ttl).Result()
 	if err != nil {
 		return fmt.Errorf(" Set: %w", err)
 	}
 	return nil
 }
 
-// Get func getting entity from redis database
-func (rdb *UserRedisConnection) Get(ctx context.Context, id uuid.UUID) (*model.User, error) {
-	val, err := rdb.rdb.Get(ctx, id.String()).Result()
+// GetTokenFamily func returns a cached refresh token family
+func (rdb *UserRedisConnection) GetTokenFamily(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error) {
+	val, err := rdb.rdb.Get(ctx, CacheKey(TokenFamilyCacheEntity, id)).Result()
 	if err != nil {
-		return nil, fmt.Errorf(" Get: %w", err)
+		return nil, fmt.Errorf(" Get: %w", cacheMiss(err))
 	}
-	err = rdb.rdb.Expire(ctx, id.String(), TTL).Err()
+	family := &model.TokenFamily{}
+	err = json.Unmarshal([]byte(val), family)
 	if err != nil {
-		return nil, fmt.Errorf(" Expire: %w", err)
+		return nil, fmt.Errorf(" Unmarshal: %w", err)
 	}
-	user := &model.User{}
-	err = json.Unmarshal([]byte(val), &user)
+	return family, nil
+}
+
+// DenyToken func puts an access token on the denylist for ttl, the rest of its lifetime
+func (rdb *UserRedisConnection) DenyToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	err := rdb.rdb.Set(ctx, deniedTokenKey(tokenID), 1, ttl).Err()
 	if err != nil {
-		return nil, fmt.Errorf(" Unmarshal: %w", err)
+		return fmt.Errorf(" Set: %w", err)
 	}
-	return user, nil
+	return nil
 }
 
-// Delete deletes the user from the cache
-func (rdb *UserRedisConnection) Delete(ctx context.Context, id uuid.UUID) error {
-	res, err := rdb.rdb.Del(ctx, id.String()).Result()
-	if err != nil || res == 0 {
-		return fmt.Errorf(" Del: %w", err)
+// DenyUser func puts every access token of the user issued before revokedAt on the denylist for ttl,
+// the lifetime of an access token. The time is kept in milliseconds
+func (rdb *UserRedisConnection) DenyUser(ctx context.Context, id uuid.UUID, revokedAt time.Time, ttl time.Duration) error {
+	err := rdb.rdb.Set(ctx, CacheKey(DeniedUserEntity, id), revokedAt.UnixMilli(), ttl).Err()
+	if err != nil {
+		return fmt.Errorf(" Set: %w", err)
 	}
 	return nil
 }
 
-// GetRefreshToken func gets a refresh token from given user
-func (rdb *UserRedisConnection) GetRefreshToken(ctx context.Context, id uuid.UUID) ([]byte, error) {
-	val, err := rdb.rdb.Get(ctx, id.String()).Result()
+// IsDenied func reports whether an access token is on the denylist, by itself or by its user.
+// Tokens issued before the user was denied are denied, compared in milliseconds
+func (rdb *UserRedisConnection) IsDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	pipe := rdb.rdb.Pipeline()
+	token := pipe.Exists(ctx, deniedTokenKey(tokenID))
+	user := pipe.Get(ctx, CacheKey(DeniedUserEntity, userID))
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, fmt.Errorf(" Exec: %w", err)
+	}
+	if token.Val() > 0 {
+		return true, nil
+	}
+	revokedAt, err := user.Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf(" Get: %w", err)
+		return false, fmt.Errorf(" Get: %w", err)
 	}
-	err = rdb.rdb.Expire(ctx, id.String(), TTL).Err()
+	return issuedAt.UnixMilli() < revokedAt, nil
+}
+
+// releaseLoginScript takes a reserved login attempt back, the last attempt is restored
+// unless a later attempt has been reserved since
+const releaseLoginScript = `local count = redis.call("HINCRBY", KEYS[1], "count", -1)
+if count <= 0 then
+	redis.call("DEL", KEYS[1])
+elseif redis.call("HGET", KEYS[1], "last_at") == ARGV[1] then
+	redis.call("HSET", KEYS[1], "last_at", ARGV[2])
+	redis.call("PEXPIREAT", KEYS[1], ARGV[3])
+end
+return count`
+
+// ReserveLogin func counts a login attempt of the scope as failed until it is released and returns
+// the failures counted before it, the counter expires window after the last attempt
+func (rdb *UserRedisConnection) ReserveLogin(ctx context.Context, scope, subject string, at time.Time, window time.Duration) (*model.LoginFailures, error) {
+	key := loginFailureKey(scope, subject)
+	pipe := rdb.rdb.TxPipeline()
+	previous := pipe.HMGet(ctx, key, "count", "last_at")
+	pipe.HIncrBy(ctx, key, "count", 1)
+	pipe.HSet(ctx, key, "last_at", at.UnixMilli())
+	pipe.PExpire(ctx, key, window)
+	_, err := pipe.Exec(ctx)
 	if err != nil {
-		return nil, fmt.Errorf(" Expire: %w", err)
+		return nil, fmt.Errorf(" Exec: %w", err)
 	}
-	user := &model.User{}
-	err = json.Unmarshal([]byte(val), &user)
+	count, _ := previous.Val()[0].(string)
+	lastAt, _ := previous.Val()[1].(string)
+	return parseLoginFailures(count, lastAt)
+}
+
+// ReleaseLogin func takes back the login attempt reserved at, lastAt is the last attempt before it
+func (rdb *UserRedisConnection) ReleaseLogin(ctx context.Context, scope, subject string, at, lastAt time.Time, window time.Duration) error {
+	err := redis.NewScript(releaseLoginScript).Run(ctx, rdb.rdb, []string{loginFailureKey(scope, subject)},
+		at.UnixMilli(), lastAt.UnixMilli(), lastAt.Add(window).UnixMilli()).Err()
+	if err != nil {
+		return fmt.Errorf(" Run: %w", err)
+	}
+	return nil
+}
+
+// GetLoginFailures func returns the failed logins counted for the scope, none are counted for an unknown scope
+func (rdb *UserRedisConnection) GetLoginFailures(ctx context.Context, scope, subject string) (*model.LoginFailures, error) {
+	val, err := rdb.rdb.HGetAll(ctx, loginFailureKey(scope, subject)).Result()
+	if err != nil {
+		return nil, fmt.Errorf(" HGetAll: %w", err)
+	}
+	return parseLoginFailures(val["count"], val["last_at"])
+}
+
+// parseLoginFailures parses the fields of a login failure counter, an empty count is no failure
+func parseLoginFailures(count, lastAt string) (*model.LoginFailures, error) {
+	failures := &model.LoginFailures{}
+	if count == "" {
+		return failures, nil
+	}
+	var err error
+	failures.Count, err = strconv.Atoi(count)
+	if err != nil {
+		return nil, fmt.Errorf(" Atoi: %w", err)
+	}
+	ms, err := strconv.ParseInt(lastAt, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf(" ParseInt: %w", err)
+	}
+	failures.LastAt = time.UnixMilli(ms)
+	return failures, nil
+}
+
+// ResetLoginFailures func forgets the failed logins of the scope
+func (rdb *UserRedisConnection) ResetLoginFailures(ctx context.Context, scope, subject string) error {
+	err := rdb.rdb.Del(ctx, loginFailureKey(scope, subject)).Err()
+	if err != nil {
+		return fmt.Errorf(" Del: %w", err)
+	}
+	return nil
+}
+
+// Inspect func returns the cached user without its secrets, along with its key and the time left until it expires
+func (rdb *UserRedisConnection) Inspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error) {
+	key := CacheKey(UserCacheEntity, id)
+	val, ttl, err := inspectKey(ctx, rdb.rdb, key)
+	if err != nil {
+		return nil, err
+	}
+	cached := &model.UserRedis{}
+	err = json.Unmarshal([]byte(val), cached)
 	if err != nil {
 		return nil, fmt.Errorf(" Unmarshal: %w", err)
 	}
-	return user.RefreshToken, nil
+	return &model.CacheEntry{
+		Entity:        UserCacheEntity,
+		ID:            id,
+		Key:           key,
+		SchemaVersion: payloadVersion(cached.SchemaVersion),
+		TTLMillis:     ttl.Milliseconds(),
+		Value: &model.CachedUser{
+			Login:           cached.Login,
+			Role:            cached.Role,
+			HasRefreshToken: len(cached.RefreshToken) > 0,
+		},
+	}, nil
 }
 
-// SetRefreshToken sets the refresh token for the user
-func (rdb *UserRedisConnection) SetRefreshToken(ctx context.Context, id uuid.UUID, token []byte) error {
-	val, err := rdb.rdb.Get(ctx, id.String()).Result()
+// Flush func deletes every cached user and returns the number of deleted keys
+func (rdb *UserRedisConnection) Flush(ctx context.Context) (int64, error) {
+	return flushEntity(ctx, rdb.rdb, UserCacheEntity)
+}
+
+// get reads the cached user and prolongs its TTL
+func (rdb *UserRedisConnection) get(ctx context.Context, id uuid.UUID) (*model.UserRedis, error) {
+	key := CacheKey(UserCacheEntity, id)
+	val, err := rdb.rdb.Get(ctx, key).Result()
 	if err != nil {
-		return fmt.Errorf(" Get: %w", err)
+		return nil, fmt.Errorf(" Get: %w", cacheMiss(err))
 	}
-	user := &model.User{}
-	err = json.Unmarshal([]byte(val), &user)
+	err = rdb.rdb.Expire(ctx, key, TTL).Err()
 	if err != nil {
-		return fmt.Errorf(" Unmarshal: %w", err)
+		return nil, fmt.Errorf(" Expire: %w", err)
 	}
-	res, err := json.Marshal(model.UserRedis{
-		Login:        user.Login,
-		Password:     user.Password,
-		Role:         user.Role,
-		RefreshToken: token,
-	})
+	return decodeUser(val)
+}
+
+// set writes the user payload with the current schema version
+func (rdb *UserRedisConnection) set(ctx context.Context, id uuid.UUID, cached *model.UserRedis) error {
+	cached.SchemaVersion = CachePayloadVersion
+	cached.ID = id
+	val, err := json.Marshal(cached)
 	if err != nil {
 		return fmt.Errorf(" Marshal: %w", err)
 	}
-	_, err = rdb.rdb.Set(ctx, id.String(), res, TTL).Result()
+	_, err = rdb.rdb.Set(ctx, CacheKey(UserCacheEntity, id), val, jitteredTTL()).Result()
 	if err != nil {
 		return fmt.Errorf(" Set: %w", err)
 	}
 	return nil
 }
+
+// decodeUser decodes a cached user payload of any supported schema version
+func decodeUser(val string) (*model.UserRedis, error) {
+	cached := &model.UserRedis{}
+	err := json.Unmarshal([]byte(val), cached)
+	if err != nil {
+		return nil, fmt.Errorf(" Unmarshal: %w", err)
+	}
+	err = checkPayloadVersion(cached.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
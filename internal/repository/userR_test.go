@@ -1,8 +1,11 @@
+//go:build integration
+
 package repository
 
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/eugenshima/myapp/internal/model"
 	"github.com/google/uuid"
@@ -11,13 +14,6 @@ import (
 
 var redisConnUser *UserRedisConnection
 
-var testUserRedis = model.User{
-	ID:       uuid.New(),
-	Login:    "test",
-	Password: []byte("test"),
-	Role:     "user",
-}
-
 func TestUserRedisGet(t *testing.T) {
 	err := redisConnUser.Set(context.Background(), &testUserRedis)
 	require.NoError(t, err)
@@ -110,3 +106,27 @@ func TestUserRedisDeleteNil(t *testing.T) {
 	err = redisConnUser.Delete(context.Background(), testUserRedis.ID)
 	require.NoError(t, err)
 }
+
+func TestUserRedisTokenFamily(t *testing.T) {
+	family := model.TokenFamily{ID: uuid.New(), UserID: testUserRedis.ID, TokenHash: []byte("hash"), Generation: 2,
+		ExpiresAt: time.Now().Add(time.Hour)}
+	_, err := redisConnUser.GetTokenFamily(context.Background(), family.ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+	require.NoError(t, redisConnUser.SetTokenFamily(context.Background(), &family))
+	cached, err := redisConnUser.GetTokenFamily(context.Background(), family.ID)
+	require.NoError(t, err)
+	require.Equal(t, family.TokenHash, cached.TokenHash)
+	require.Equal(t, 2, cached.Generation)
+	require.Nil(t, cached.RevokedAt)
+	ttl, err := redisConnUser.rdb.TTL(context.Background(), CacheKey(TokenFamilyCacheEntity, family.ID)).Result()
+	require.NoError(t, err)
+	require.LessOrEqual(t, ttl, time.Hour)
+}
+
+func TestUserRedisDenylist(t *testing.T) {
+	checkDenylist(t, redisConnUser)
+}
+
+func TestUserRedisLoginFailures(t *testing.T) {
+	checkLoginFailures(t, redisConnUser)
+}
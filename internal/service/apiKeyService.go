@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// const for API keys
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to recognize
+	apiKeyPrefix = "ak_"
+	// apiKeyBytes is the entropy of an API key
+	apiKeyBytes = 32
+	// apiKeyShownLen is the number of characters of a key kept as its prefix after apiKeyPrefix
+	apiKeyShownLen = 8
+	// apiKeyTouchInterval is how stale the last use of a key may get, so not every request writes to the store
+	apiKeyTouchInterval = time.Minute
+)
+
+// apiKeyEncoding writes API keys in lower case without padding
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKeyRepository interface, which contains psql/mongo API key methods
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	GetByHash(ctx context.Context, hash []byte) (*model.APIKey, error)
+	GetByUser(ctx context.Context, userID uuid.UUID) ([]*model.APIKey, error)
+	Rotate(ctx context.Context, id, userID uuid.UUID, prefix string, hash []byte, at time.Time) (*model.APIKey, error)
+	Revoke(ctx context.Context, id, userID uuid.UUID, at time.Time) error
+	RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error)
+	Touch(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+// RoleFinder finds the current role of a user
+type RoleFinder interface {
+	GetRoleByID(ctx context.Context, id uuid.UUID) (string, error)
+}
+
+// APIKeyService manages the API keys of users. A key acts for its user with the permissions of its scopes
+// the current role of the user grants, until it expires or is revoked
+type APIKeyService struct {
+	rps    APIKeyRepository
+	users  RoleFinder
+	roles  mdlwr.PermissionChecker
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// NewAPIKeyService is a constructor for APIKeyService, keys live at most maxTTL
+func NewAPIKeyService(rps APIKeyRepository, users RoleFinder, roles mdlwr.PermissionChecker, maxTTL time.Duration) *APIKeyService {
+	return &APIKeyService{rps: rps, users: users, roles: roles, maxTTL: maxTTL, now: time.Now}
+}
+
+// Create creates an API key of the actor with scopes the role of the actor grants, the key is returned only here
+func (ks *APIKeyService) Create(ctx context.Context, request *model.APIKeyRequest) (*model.APIKeySecret, error) {
+	actor, ok := mdlwr.ActorFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("Create: no actor")
+	}
+	for _, scope := range request.Scopes {
+		if !knownPermission(scope) {
+			return nil, fmt.Errorf("Create: %q: %w", scope, model.ErrUnknownPermission)
+		}
+		if !ks.roles.HasPermission(ctx, actor.Role, scope) {
+			return nil, fmt.Errorf("Create: %q: %w", scope, model.ErrAPIKeyScope)
+		}
+	}
+	now := ks.now()
+	expiresAt := now.Add(ks.maxTTL)
+	if request.ExpiresAt != nil {
+		if !request.ExpiresAt.After(now) || request.ExpiresAt.After(expiresAt) {
+			return nil, fmt.Errorf("Create: expiry must be within %v: %w", ks.maxTTL, model.ErrAPIKeyExpiry)
+		}
+		expiresAt = *request.ExpiresAt
+	}
+	secret, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	key := &model.APIKey{
+		ID:        uuid.New(),
+		UserID:    actor.ID,
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	err = ks.rps.Create(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "api_key_created")).WithFields(logrus.Fields{"api_key_id": key.ID, "scopes": key.Scopes}).
+		Info("API key created")
+	return &model.APIKeySecret{APIKey: key, Key: secret}, nil
+}
+
+// GetAll returns the API keys of the actor, oldest first
+func (ks *APIKeyService) GetAll(ctx context.Context) ([]*model.APIKey, error) {
+	actor, ok := mdlwr.ActorFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("GetAll: no actor")
+	}
+	keys, err := ks.rps.GetByUser(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("GetByUser: %w", err)
+	}
+	return keys, nil
+}
+
+// Rotate replaces an active API key of the actor with a new key, which is returned only here.
+// The previous key stops working at once
+func (ks *APIKeyService) Rotate(ctx context.Context, id uuid.UUID) (*model.APIKeySecret, error) {
+	actor, ok := mdlwr.ActorFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("Rotate: no actor")
+	}
+	secret, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ks.rps.Rotate(ctx, id, actor.ID, prefix, hash, ks.now())
+	if err != nil {
+		return nil, fmt.Errorf("Rotate: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "api_key_rotated")).WithField("api_key_id", id).Info("API key rotated")
+	return &model.APIKeySecret{APIKey: key, Key: secret}, nil
+}
+
+// Revoke revokes an API key of the actor
+func (ks *APIKeyService) Revoke(ctx context.Context, id uuid.UUID) error {
+	actor, ok := mdlwr.ActorFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("Revoke: no actor")
+	}
+	err := ks.rps.Revoke(ctx, id, actor.ID, ks.now())
+	if err != nil {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "api_key_revoked")).WithField("api_key_id", id).Info("API key revoked")
+	return nil
+}
+
+// RevokeUser revokes every API key of the user, so the keys don't outlive a password reset
+func (ks *APIKeyService) RevokeUser(ctx context.Context, userID uuid.UUID) error {
+	revoked, err := ks.rps.RevokeUser(ctx, userID, ks.now())
+	if err != nil {
+		return fmt.Errorf("RevokeUser: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "api_keys_revoked")).WithFields(logrus.Fields{"user_id": userID, "api_keys": revoked}).
+		Info("API keys of the user revoked")
+	return nil
+}
+
+// Authenticate returns the actor an API key acts for, with the current role of its user and the scopes of the key.
+// Unknown, expired and revoked keys, and keys of deleted users, are reported as model.ErrInvalidAPIKey
+func (ks *APIKeyService) Authenticate(ctx context.Context, secret string) (*model.Actor, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, fmt.Errorf("Authenticate: %w", model.ErrInvalidAPIKey)
+	}
+	key, err := ks.rps.GetByHash(ctx, hashAPIKey(secret))
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, fmt.Errorf("GetByHash: %w", model.ErrInvalidAPIKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetByHash: %w", err)
+	}
+	now := ks.now()
+	if key.RevokedAt != nil || !key.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("Authenticate: key %s: %w", key.ID, model.ErrInvalidAPIKey)
+	}
+	role, err := ks.users.GetRoleByID(ctx, key.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, fmt.Errorf("GetRoleByID: %w", model.ErrInvalidAPIKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetRoleByID: %w", err)
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err = ks.rps.Touch(ctx, key.ID, now); err != nil {
+			logrus.WithFields(logrus.Fields{"api_key_id": key.ID}).Errorf("Touch: %v", err)
+		}
+	}
+	return &model.Actor{ID: key.UserID, Role: role, APIKeyID: &key.ID, Scopes: key.Scopes}, nil
+}
+
+// newAPIKey returns a new API key along with its prefix and its hash
+func newAPIKey() (secret, prefix string, hash []byte, err error) {
+	raw := make([]byte, apiKeyBytes)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", nil, fmt.Errorf("Read(): %w", err)
+	}
+	secret = apiKeyPrefix + apiKeyEncoding.EncodeToString(raw)
+	return secret, secret[:len(apiKeyPrefix)+apiKeyShownLen], hashAPIKey(secret), nil
+}
+
+// hashAPIKey returns the hash an API key is stored as, keys are random enough for a fast hash
+func hashAPIKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
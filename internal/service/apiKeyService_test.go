@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAPIKeys returns an API key service on memory stores with a frozen clock along with a signed up basic user
+func newTestAPIKeys(t *testing.T) (*APIKeyService, *repository.UserMemoryConnection, *time.Time, model.Actor) {
+	roles, _, users, _ := newTestRoleService(t)
+	actor := model.Actor{ID: uuid.New(), Role: model.RoleUser}
+	require.NoError(t, users.Signup(context.Background(), &model.User{ID: actor.ID, Login: "eugen", Password: []byte("hash"), Role: actor.Role}))
+	ks := NewAPIKeyService(repository.NewAPIKeyMemoryConnection(), users, roles, 24*time.Hour)
+	now := time.Now()
+	ks.now = func() time.Time { return now }
+	return ks, users, &now, actor
+}
+
+func TestAPIKeyService(t *testing.T) {
+	ks, _, now, actor := newTestAPIKeys(t)
+	ctx := mdlwr.WithActor(context.Background(), actor)
+
+	// Step 1: a key gets scopes the role of the user grants and expires after the longest lifetime by default
+	_, err := ks.Create(ctx, &model.APIKeyRequest{Name: "batch", Scopes: []string{model.PermissionPersonWrite}})
+	require.ErrorIs(t, err, model.ErrAPIKeyScope)
+	_, err = ks.Create(ctx, &model.APIKeyRequest{Name: "batch", Scopes: []string{"person:everything"}})
+	require.ErrorIs(t, err, model.ErrUnknownPermission)
+	tooLate := now.Add(48 * time.Hour)
+	_, err = ks.Create(ctx, &model.APIKeyRequest{Name: "batch", Scopes: []string{model.PermissionPersonRead}, ExpiresAt: &tooLate})
+	require.ErrorIs(t, err, model.ErrAPIKeyExpiry)
+	created, err := ks.Create(ctx, &model.APIKeyRequest{Name: "batch", Scopes: []string{model.PermissionPersonRead}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(created.Key, created.Prefix))
+	require.Equal(t, now.Add(24*time.Hour), created.ExpiresAt)
+
+	// Step 2: the key acts for the user within its scopes, and its use is recorded
+	keyActor, err := ks.Authenticate(context.Background(), created.Key)
+	require.NoError(t, err)
+	require.Equal(t, actor.ID, keyActor.ID)
+	require.Equal(t, model.RoleUser, keyActor.Role)
+	require.Equal(t, created.ID, *keyActor.APIKeyID)
+	require.Equal(t, []string{model.PermissionPersonRead}, keyActor.Scopes)
+	keys, err := ks.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].LastUsedAt)
+	_, err = ks.Authenticate(context.Background(), "ak_unknown")
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+	_, err = ks.Authenticate(context.Background(), "unknown")
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+
+	// Step 3: a rotated key replaces the previous one
+	rotated, err := ks.Rotate(ctx, created.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, created.Key, rotated.Key)
+	_, err = ks.Authenticate(context.Background(), created.Key)
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+	_, err = ks.Authenticate(context.Background(), rotated.Key)
+	require.NoError(t, err)
+	_, err = ks.Rotate(mdlwr.WithActor(context.Background(), model.Actor{ID: uuid.New()}), created.ID)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 4: revoked and expired keys stop working
+	require.NoError(t, ks.Revoke(ctx, created.ID))
+	_, err = ks.Authenticate(context.Background(), rotated.Key)
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+	expiring, err := ks.Create(ctx, &model.APIKeyRequest{Name: "short", Scopes: []string{model.PermissionPersonRead}})
+	require.NoError(t, err)
+	*now = now.Add(24 * time.Hour)
+	_, err = ks.Authenticate(context.Background(), expiring.Key)
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+}
+
+func TestAPIKeyServiceDeletedUser(t *testing.T) {
+	ks, users, _, actor := newTestAPIKeys(t)
+	ctx := mdlwr.WithActor(context.Background(), actor)
+	created, err := ks.Create(ctx, &model.APIKeyRequest{Name: "batch", Scopes: []string{model.PermissionPersonRead}})
+	require.NoError(t, err)
+	require.NoError(t, users.Delete(context.Background(), actor.ID))
+	_, err = ks.Authenticate(context.Background(), created.Key)
+	require.ErrorIs(t, err, model.ErrInvalidAPIKey)
+}
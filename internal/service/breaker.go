@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// Breaker is a circuit breaker in front of a backend. It opens after threshold failures in a row
+// and rejects calls with model.ErrCircuitOpen for cooldown, then lets a single probe through:
+// a successful probe closes it, a failed one opens it again.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	onClose  []func()
+}
+
+// NewBreaker is a constructor for a closed Breaker
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now, state: BreakerClosed}
+}
+
+// OnClose registers fn to be called in the background whenever the breaker closes after being open
+func (b *Breaker) OnClose(fn func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onClose = append(b.onClose, fn)
+}
+
+// Do calls fn unless the breaker is open and records the result. Misses and canceled
+// requests say nothing about the backend and don't count as failures.
+func (b *Breaker) Do(fn func() error) error {
+	if !b.allow() {
+		return model.ErrCircuitOpen
+	}
+	err := fn()
+	if err == nil || errors.Is(err, model.ErrCacheMiss) || errors.Is(err, context.Canceled) {
+		b.success()
+	} else {
+		b.failure(err)
+	}
+	return err
+}
+
+// State returns a snapshot of the breaker
+func (b *Breaker) State() *model.BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := &model.BreakerState{Name: b.name, State: b.state, Failures: b.failures, Threshold: b.threshold}
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		state.State = BreakerHalfOpen
+	}
+	if b.state != BreakerClosed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.cooldown)
+		state.OpenedAt, state.RetryAt = &openedAt, &retryAt
+	}
+	return state
+}
+
+// allow reports whether a call may go through, after the cooldown it admits one probe at a time
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+	}
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success closes the breaker and resets the failure count
+func (b *Breaker) success() {
+	b.mu.Lock()
+	recovered := b.state != BreakerClosed
+	b.state, b.failures, b.probing = BreakerClosed, 0, false
+	var onClose []func()
+	if recovered {
+		onClose = append(onClose, b.onClose...)
+	}
+	b.mu.Unlock()
+	if recovered {
+		logrus.WithFields(logrus.Fields{"breaker": b.name}).Info("circuit breaker closed")
+	}
+	for _, fn := range onClose {
+		go fn()
+	}
+}
+
+// failure counts a failure and opens the breaker at the threshold or when a probe fails
+func (b *Breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	tripped := b.state == BreakerClosed && b.failures >= b.threshold
+	if !tripped && !(b.state == BreakerHalfOpen && b.probing) {
+		return
+	}
+	if tripped {
+		logrus.WithFields(logrus.Fields{"breaker": b.name, "failures": b.failures}).Errorf("circuit breaker opened: %v", err)
+	}
+	b.state, b.openedAt, b.probing = BreakerOpen, b.now(), false
+}
+
+// logCacheError logs a failed cache call, calls rejected by an open breaker are expected and only logged at debug level
+func logCacheError(fields logrus.Fields, op string, err error) {
+	if errors.Is(err, model.ErrCircuitOpen) {
+		logrus.WithFields(fields).Debugf("%s: %v", op, err)
+		return
+	}
+	logrus.WithFields(fields).Errorf("%s: %v", op, err)
+}
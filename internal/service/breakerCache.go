@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// const for the caches behind a circuit breaker
+const (
+	// maxMissedInvalidations bounds the invalidations kept for replay, entries beyond it expire with their TTL
+	maxMissedInvalidations = 10000
+	replayTimeout          = 30 * time.Second
+)
+
+// missedInvalidations remembers the cache entries and tags an outage kept us from invalidating.
+// They are treated as misses until they are invalidated after the breaker closes,
+// so entries that survived the outage are never served stale.
+type missedInvalidations struct {
+	mu   sync.Mutex
+	ids  map[uuid.UUID]struct{}
+	tags map[string]struct{}
+}
+
+func newMissedInvalidations() *missedInvalidations {
+	return &missedInvalidations{ids: make(map[uuid.UUID]struct{}), tags: make(map[string]struct{})}
+}
+
+// addID remembers an entry to invalidate
+func (m *missedInvalidations) addID(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.ids) >= maxMissedInvalidations {
+		logrus.WithFields(logrus.Fields{"id": id}).Warn("too many missed cache invalidations, the entry expires with its TTL")
+		return
+	}
+	m.ids[id] = struct{}{}
+}
+
+// addTags remembers tags to invalidate
+func (m *missedInvalidations) addTags(tags ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		m.tags[tag] = struct{}{}
+	}
+}
+
+// hasID reports whether the entry still has to be invalidated
+func (m *missedInvalidations) hasID(id uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.ids[id]
+	return ok
+}
+
+// hasTag reports whether any of the tags still has to be invalidated
+func (m *missedInvalidations) hasTag(tags ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		if _, ok := m.tags[tag]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pending returns the invalidations still to do
+func (m *missedInvalidations) pending() (ids []uuid.UUID, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	for tag := range m.tags {
+		tags = append(tags, tag)
+	}
+	return ids, tags
+}
+
+// doneID forgets an invalidated entry
+func (m *missedInvalidations) doneID(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ids, id)
+}
+
+// doneTags forgets invalidated tags
+func (m *missedInvalidations) doneTags(tags ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		delete(m.tags, tag)
+	}
+}
+
+// BreakerPersonRedis is the person cache behind a circuit breaker. While the breaker is open
+// every call fails fast with model.ErrCircuitOpen, so the service goes straight to the primary store.
+type BreakerPersonRedis struct {
+	rdb     PersonRepositoryRedis
+	breaker *Breaker
+	missed  *missedInvalidations
+}
+
+// NewBreakerPersonRedis puts rdb behind the breaker
+func NewBreakerPersonRedis(rdb PersonRepositoryRedis, breaker *Breaker) *BreakerPersonRedis {
+	c := &BreakerPersonRedis{rdb: rdb, breaker: breaker, missed: newMissedInvalidations()}
+	breaker.OnClose(c.replay)
+	return c
+}
+
+// RedisGetByID returns a cached person
+func (c *BreakerPersonRedis) RedisGetByID(ctx context.Context, id uuid.UUID) (person *model.Person, err error) {
+	if c.missed.hasID(id) {
+		return nil, model.ErrCacheMiss
+	}
+	err = c.breaker.Do(func() error {
+		person, err = c.rdb.RedisGetByID(ctx, id)
+		return err
+	})
+	return person, err
+}
+
+// RedisSetByID caches a person, the entry is invalidated after recovery if that fails
+func (c *BreakerPersonRedis) RedisSetByID(ctx context.Context, entity *model.Person) error {
+	err := c.breaker.Do(func() error {
+		return c.rdb.RedisSetByID(ctx, entity)
+	})
+	if err != nil {
+		c.missed.addID(entity.ID)
+	}
+	return err
+}
+
+// RedisDeleteByID drops a cached person, it is dropped after recovery if that fails
+func (c *BreakerPersonRedis) RedisDeleteByID(ctx context.Context, id uuid.UUID) error {
+	err := c.breaker.Do(func() error {
+		return c.rdb.RedisDeleteByID(ctx, id)
+	})
+	if err != nil {
+		c.missed.addID(id)
+	}
+	return err
+}
+
+// RedisGetWithTTL returns a cached person along with the time left until it expires
+func (c *BreakerPersonRedis) RedisGetWithTTL(ctx context.Context, id uuid.UUID) (person *model.Person, ttl time.Duration, err error) {
+	if c.missed.hasID(id) {
+		return nil, 0, model.ErrCacheMiss
+	}
+	err = c.breaker.Do(func() error {
+		person, ttl, err = c.rdb.RedisGetWithTTL(ctx, id)
+		return err
+	})
+	return person, ttl, err
+}
+
+// RedisLock takes the loading lock of a person
+func (c *BreakerPersonRedis) RedisLock(ctx context.Context, id uuid.UUID, ttl time.Duration) (token string, ok bool, err error) {
+	err = c.breaker.Do(func() error {
+		token, ok, err = c.rdb.RedisLock(ctx, id, ttl)
+		return err
+	})
+	return token, ok, err
+}
+
+// RedisUnlock releases the loading lock of a person
+func (c *BreakerPersonRedis) RedisUnlock(ctx context.Context, id uuid.UUID, token string) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.RedisUnlock(ctx, id, token)
+	})
+}
+
+// RedisQueryKey returns the key of a query result for the current versions of its tags,
+// queries depending on a tag which still has to be bumped are not cached
+func (c *BreakerPersonRedis) RedisQueryKey(ctx context.Context, hash string, tags ...string) (key string, err error) {
+	if c.missed.hasTag(tags...) {
+		return "", model.ErrCacheMiss
+	}
+	err = c.breaker.Do(func() error {
+		key, err = c.rdb.RedisQueryKey(ctx, hash, tags...)
+		return err
+	})
+	return key, err
+}
+
+// RedisGetQuery returns a cached query result
+func (c *BreakerPersonRedis) RedisGetQuery(ctx context.Context, key string) (val []byte, err error) {
+	err = c.breaker.Do(func() error {
+		val, err = c.rdb.RedisGetQuery(ctx, key)
+		return err
+	})
+	return val, err
+}
+
+// RedisSetQuery caches a query result
+func (c *BreakerPersonRedis) RedisSetQuery(ctx context.Context, key string, val []byte) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.RedisSetQuery(ctx, key, val)
+	})
+}
+
+// RedisInvalidateTags bumps the tags, they are bumped after recovery if that fails
+func (c *BreakerPersonRedis) RedisInvalidateTags(ctx context.Context, tags ...string) error {
+	err := c.breaker.Do(func() error {
+		return c.rdb.RedisInvalidateTags(ctx, tags...)
+	})
+	if err != nil {
+		c.missed.addTags(tags...)
+	}
+	return err
+}
+
+// replay applies the invalidations missed during the outage
+func (c *BreakerPersonRedis) replay() {
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	ids, tags := c.missed.pending()
+	for _, id := range ids {
+		err := c.RedisDeleteByID(ctx, id)
+		if err != nil {
+			logCacheError(logrus.Fields{"id": id}, "replay RedisDeleteByID", err)
+			continue
+		}
+		c.missed.doneID(id)
+	}
+	if len(tags) > 0 {
+		err := c.RedisInvalidateTags(ctx, tags...)
+		if err != nil {
+			logCacheError(logrus.Fields{"tags": tags}, "replay RedisInvalidateTags", err)
+			return
+		}
+		c.missed.doneTags(tags...)
+	}
+}
+
+// BreakerUserRedis is the user cache behind a circuit breaker
+type BreakerUserRedis struct {
+	rdb     UserRepositoryRedis
+	breaker *Breaker
+	missed  *missedInvalidations
+}
+
+// NewBreakerUserRedis puts rdb behind the breaker
+func NewBreakerUserRedis(rdb UserRepositoryRedis, breaker *Breaker) *BreakerUserRedis {
+	c := &BreakerUserRedis{rdb: rdb, breaker: breaker, missed: newMissedInvalidations()}
+	breaker.OnClose(c.replay)
+	return c
+}
+
+// Set caches a user, the entry is invalidated after recovery if that fails
+func (c *BreakerUserRedis) Set(ctx context.Context, user *model.User) error {
+	err := c.breaker.Do(func() error {
+		return c.rdb.Set(ctx, user)
+	})
+	if err != nil {
+		c.missed.addID(user.ID)
+	}
+	return err
+}
+
+// Get returns a cached user
+func (c *BreakerUserRedis) Get(ctx context.Context, id uuid.UUID) (user *model.User, err error) {
+	if c.missed.hasID(id) {
+		return nil, model.ErrCacheMiss
+	}
+	err = c.breaker.Do(func() error {
+		user, err = c.rdb.Get(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+// Delete drops a cached user, it is dropped after recovery if that fails
+func (c *BreakerUserRedis) Delete(ctx context.Context, id uuid.UUID) error {
+	err := c.breaker.Do(func() error {
+		return c.rdb.Delete(ctx, id)
+	})
+	if err != nil && !errors.Is(err, model.ErrCacheMiss) {
+		c.missed.addID(id)
+	}
+	return err
+}
+
+// GetTokenFamily returns a cached token family
+func (c *BreakerUserRedis) GetTokenFamily(ctx context.Context, id uuid.UUID) (family *model.TokenFamily, err error) {
+	err = c.breaker.Do(func() error {
+		family, err = c.rdb.GetTokenFamily(ctx, id)
+		return err
+	})
+	return family, err
+}
+
+// SetTokenFamily caches a token family. A failure needs no replay, the primary store
+// decides every rotation and the cache only short-cuts families that are revoked for good
+func (c *BreakerUserRedis) SetTokenFamily(ctx context.Context, family *model.TokenFamily) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.SetTokenFamily(ctx, family)
+	})
+}
+
+// DenyToken puts an access token on the denylist
+func (c *BreakerUserRedis) DenyToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.DenyToken(ctx, tokenID, ttl)
+	})
+}
+
+// DenyUser puts the access tokens of a user on the denylist
+func (c *BreakerUserRedis) DenyUser(ctx context.Context, id uuid.UUID, revokedAt time.Time, ttl time.Duration) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.DenyUser(ctx, id, revokedAt, ttl)
+	})
+}
+
+// IsDenied reports whether an access token is on the denylist
+func (c *BreakerUserRedis) IsDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (denied bool, err error) {
+	err = c.breaker.Do(func() error {
+		denied, err = c.rdb.IsDenied(ctx, tokenID, userID, issuedAt)
+		return err
+	})
+	return denied, err
+}
+
+// ReserveLogin counts a login attempt as failed until it is released
+func (c *BreakerUserRedis) ReserveLogin(ctx context.Context, scope, subject string, at time.Time, window time.Duration) (previous *model.LoginFailures, err error) {
+	err = c.breaker.Do(func() error {
+		previous, err = c.rdb.ReserveLogin(ctx, scope, subject, at, window)
+		return err
+	})
+	return previous, err
+}
+
+// ReleaseLogin takes back a reserved login attempt
+func (c *BreakerUserRedis) ReleaseLogin(ctx context.Context, scope, subject string, at, lastAt time.Time, window time.Duration) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.ReleaseLogin(ctx, scope, subject, at, lastAt, window)
+	})
+}
+
+// GetLoginFailures returns the failed logins counted for a scope
+func (c *BreakerUserRedis) GetLoginFailures(ctx context.Context, scope, subject string) (failures *model.LoginFailures, err error) {
+	err = c.breaker.Do(func() error {
+		failures, err = c.rdb.GetLoginFailures(ctx, scope, subject)
+		return err
+	})
+	return failures, err
+}
+
+// ResetLoginFailures forgets the failed logins of a scope
+func (c *BreakerUserRedis) ResetLoginFailures(ctx context.Context, scope, subject string) error {
+	return c.breaker.Do(func() error {
+		return c.rdb.ResetLoginFailures(ctx, scope, subject)
+	})
+}
+
+// replay drops the users whose invalidation was missed during the outage
+func (c *BreakerUserRedis) replay() {
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	ids, _ := c.missed.pending()
+	for _, id := range ids {
+		err := c.Delete(ctx, id)
+		if err != nil && !errors.Is(err, model.ErrCacheMiss) {
+			logCacheError(logrus.Fields{"id": id}, "replay Delete", err)
+			continue
+		}
+		c.missed.doneID(id)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBreaker returns a breaker with a clock the test moves forward
+func newTestBreaker(threshold int) (*Breaker, *time.Time) {
+	now := time.Now()
+	breaker := NewBreaker("test", threshold, time.Second)
+	breaker.now = func() time.Time { return now }
+	return breaker, &now
+}
+
+func TestBreaker(t *testing.T) {
+	breaker, now := newTestBreaker(2)
+	fail := func() error { return errCacheDown }
+	calls := 0
+	succeed := func() error {
+		calls++
+		return nil
+	}
+
+	// Step 1: misses don't count, the threshold opens the breaker
+	require.ErrorIs(t, breaker.Do(func() error { return model.ErrCacheMiss }), model.ErrCacheMiss)
+	require.ErrorIs(t, breaker.Do(fail), errCacheDown)
+	require.Equal(t, BreakerClosed, breaker.State().State)
+	require.ErrorIs(t, breaker.Do(fail), errCacheDown)
+	require.Equal(t, BreakerOpen, breaker.State().State)
+	require.ErrorIs(t, breaker.Do(succeed), model.ErrCircuitOpen)
+	require.Zero(t, calls)
+
+	// Step 2: a failed probe after the cooldown opens it again
+	*now = now.Add(time.Second)
+	require.Equal(t, BreakerHalfOpen, breaker.State().State)
+	require.ErrorIs(t, breaker.Do(fail), errCacheDown)
+	require.Equal(t, BreakerOpen, breaker.State().State)
+	require.NotNil(t, breaker.State().RetryAt)
+
+	// Step 3: a successful probe closes it
+	*now = now.Add(time.Second)
+	closed := make(chan struct{})
+	breaker.OnClose(func() { close(closed) })
+	require.NoError(t, breaker.Do(succeed))
+	require.Equal(t, 1, calls)
+	state := breaker.State()
+	require.Equal(t, BreakerClosed, state.State)
+	require.Zero(t, state.Failures)
+	require.Nil(t, state.OpenedAt)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose was not called")
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	breaker, now := newTestBreaker(1)
+	require.Error(t, breaker.Do(func() error { return errCacheDown }))
+	*now = now.Add(time.Second)
+	require.NoError(t, breaker.Do(func() error {
+		// a second caller is rejected while the probe is running
+		require.ErrorIs(t, breaker.Do(func() error { return nil }), model.ErrCircuitOpen)
+		return nil
+	}))
+	require.Equal(t, BreakerClosed, breaker.State().State)
+}
+
+func TestBreakerPersonRedis(t *testing.T) {
+	ctx := context.Background()
+	rdb := &flakyRedis{MemoryCacheConnection: repository.NewMemoryCacheConnection()}
+	breaker, now := newTestBreaker(1)
+	recovered := make(chan struct{})
+	cache, err := NewPersonCache(CacheAside, NewBreakerPersonRedis(rdb, breaker))
+	require.NoError(t, err)
+	breaker.OnClose(func() { close(recovered) })
+	srv := NewPersonService(repository.NewMemoryConnection(), cache, repository.NewAuditMemoryConnection(),
+		repository.NewOutboxMemoryConnection(), repository.NewMemoryTxManager())
+	person := createCached(t, srv)
+
+	// Step 1: writes succeed while the cache is down and the breaker opens
+	rdb.down = true
+	update := &model.Person{Name: "Updated", Age: 21, Version: person.Version}
+	_, err = srv.Update(ctx, person.ID, update)
+	require.NoError(t, err)
+	require.Equal(t, BreakerOpen, breaker.State().State)
+	require.Equal(t, "Eugen", cached(rdb, person.ID).Name)
+
+	// Step 2: reads go to the primary store
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", res.Name)
+
+	// Step 3: the missed invalidation is replayed once the cache is back
+	rdb.down = false
+	*now = now.Add(time.Second)
+	res, err = srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", res.Name)
+	<-recovered
+	require.Eventually(t, func() bool {
+		entry := cached(rdb, person.ID)
+		return entry == nil || entry.Name == "Updated"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, BreakerClosed, breaker.State().State)
+}
+
+// downUserCache is a user cache which always fails
+type downUserCache struct {
+	*repository.UserMemoryCacheConnection
+}
+
+func (downUserCache) Set(context.Context, *model.User) error { return errCacheDown }
+
+func (downUserCache) Delete(context.Context, uuid.UUID) error { return errCacheDown }
+
+func (downUserCache) IsDenied(context.Context, string, uuid.UUID, time.Time) (bool, error) {
+	return false, errCacheDown
+}
+
+func (downUserCache) ReserveLogin(context.Context, string, string, time.Time, time.Duration) (*model.LoginFailures, error) {
+	return nil, errCacheDown
+}
+
+func (downUserCache) GetLoginFailures(context.Context, string, string) (*model.LoginFailures, error) {
+	return nil, errCacheDown
+}
+
+func TestUserServiceCacheDown(t *testing.T) {
+	ctx := context.Background()
+	rps := repository.NewUserMemoryConnection()
+	rdb := downUserCache{repository.NewUserMemoryCacheConnection()}
+	srv := NewUserServiceImpl(rps, rdb, repository.NewTokenFamilyMemoryConnection(), newTestKeySet(t), nil,
+		testPolicy, NewLoginThrottle(rdb, testLimits, testLimits), newTestMFA(rps))
+	user := &model.User{ID: uuid.New(), Login: "eugen", Password: []byte(testPassword), Role: "user"}
+	require.NoError(t, srv.Signup(ctx, user, ""))
+	_, err := rps.GetUser(ctx, "eugen")
+	require.NoError(t, err)
+	require.NoError(t, srv.Delete(ctx, user.ID))
+	_, err = rps.GetUser(ctx, "eugen")
+	require.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Managed cache entities
+const (
+	PersonEntity = "person"
+	UserEntity   = "user"
+)
+
+// const for cache warming
+const (
+	// DefaultWarmLimit is the number of entities warmed when no limit is given
+	DefaultWarmLimit = 1000
+	warmBatch        = 500
+)
+
+// CacheStatsSource interface is implemented by caches counting their hits
+type CacheStatsSource interface {
+	Stats() []*model.CacheTierStats
+}
+
+// PersonCacheAdmin interface, which contains the person cache administration methods
+type PersonCacheAdmin interface {
+	RedisInspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error)
+	RedisFlush(ctx context.Context) (int64, error)
+	RedisSetByID(ctx context.Context, entity *model.Person) error
+}
+
+// UserCacheAdmin interface, which contains the user cache administration methods
+type UserCacheAdmin interface {
+	Inspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error)
+	Flush(ctx context.Context) (int64, error)
+	Set(ctx context.Context, user *model.User) error
+}
+
+// managedCache holds the administration functions of an entity cache
+type managedCache struct {
+	inspect func(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error)
+	flush   func(ctx context.Context) (int64, error)
+	warm    func(ctx context.Context, limit int) (int, error)
+}
+
+// CacheService is a struct which inspects and manages the person and user caches
+type CacheService struct {
+	breakers    []*Breaker
+	sources     []CacheStatsSource
+	caches      map[string]*managedCache
+	writeBehind *WriteBehindCache
+}
+
+// NewCacheService is a constructor for CacheService, call ManagePersons and ManageUsers to administer the caches
+func NewCacheService(breakers []*Breaker, sources ...CacheStatsSource) *CacheService {
+	return &CacheService{breakers: breakers, sources: sources, caches: make(map[string]*managedCache)}
+}
+
+// ManagePersons registers the person cache for administration, it is warmed from rps
+func (s *CacheService) ManagePersons(cache PersonCacheAdmin, rps PersonRepositoryPsql) {
+	s.caches[PersonEntity] = &managedCache{
+		inspect: cache.RedisInspect,
+		flush:   cache.RedisFlush,
+		warm: func(ctx context.Context, limit int) (int, error) {
+			return warm(ctx, limit, rps.GetBatch, func(person *model.Person) uuid.UUID { return person.ID },
+				func(ctx context.Context, person *model.Person) (bool, error) {
+					if person.DeletedAt != nil {
+						return false, nil
+					}
+					return true, cache.RedisSetByID(ctx, person)
+				})
+		},
+	}
+}
+
+// ManageWriteBehind registers the write-behind person cache, whose queue counters are reported by WriteBehind
+func (s *CacheService) ManageWriteBehind(cache *WriteBehindCache) {
+	s.writeBehind = cache
+}
+
+// ManageUsers registers the user cache for administration, it is warmed from rps
+func (s *CacheService) ManageUsers(cache UserCacheAdmin, rps UserRepository) {
+	s.caches[UserEntity] = &managedCache{
+		inspect: cache.Inspect,
+		flush:   cache.Flush,
+		warm: func(ctx context.Context, limit int) (int, error) {
+			return warm(ctx, limit, rps.GetBatch, func(user *model.User) uuid.UUID { return user.ID },
+				func(ctx context.Context, user *model.User) (bool, error) {
+					return true, cache.Set(ctx, user)
+				})
+		},
+	}
+}
+
+// Stats is a service function which returns the hit counters of every cache tier
+func (s *CacheService) Stats(_ context.Context) []*model.CacheTierStats {
+	stats := make([]*model.CacheTierStats, 0, 2*len(s.sources))
+	for _, source := range s.sources {
+		stats = append(stats, source.Stats()...)
+	}
+	return stats
+}
+
+// WriteBehind is a service function which returns the counters of the write-behind queue of the person cache
+func (s *CacheService) WriteBehind(_ context.Context) (*model.WriteBehindStats, error) {
+	if s.writeBehind == nil {
+		return nil, fmt.Errorf("write-behind queue: %w", model.ErrUnknownCache)
+	}
+	return s.writeBehind.Stats(), nil
+}
+
+// Breakers is a service function which returns the state of the circuit breakers in front of the caches
+func (s *CacheService) Breakers(_ context.Context) []*model.BreakerState {
+	states := make([]*model.BreakerState, 0, len(s.breakers))
+	for _, breaker := range s.breakers {
+		states = append(states, breaker.State())
+	}
+	return states
+}
+
+// Inspect is a service function which returns a cached person or user
+func (s *CacheService) Inspect(ctx context.Context, entity string, id uuid.UUID) (*model.CacheEntry, error) {
+	cache, err := s.cache(entity)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := cache.inspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Inspect(): %w", err)
+	}
+	return entry, nil
+}
+
+// Flush is a service function which deletes every cached entry and query result of an entity
+func (s *CacheService) Flush(ctx context.Context, entity string) (*model.CacheFlushResult, error) {
+	cache, err := s.cache(entity)
+	if err != nil {
+		return nil, err
+	}
+	flushed, err := cache.flush(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Flush(): %w", err)
+	}
+	return &model.CacheFlushResult{Entity: entity, Flushed: flushed}, nil
+}
+
+// Warm is a service function which loads up to limit entities from the primary store into their cache,
+// a limit of zero warms DefaultWarmLimit entities
+func (s *CacheService) Warm(ctx context.Context, entity string, limit int) (*model.CacheWarmResult, error) {
+	cache, err := s.cache(entity)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = DefaultWarmLimit
+	}
+	warmed, err := cache.warm(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Warm(): %w", err)
+	}
+	return &model.CacheWarmResult{Entity: entity, Warmed: warmed}, nil
+}
+
+// cache returns the managed cache of an entity
+func (s *CacheService) cache(entity string) (*managedCache, error) {
+	cache, ok := s.caches[entity]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", entity, model.ErrUnknownCache)
+	}
+	return cache, nil
+}
+
+// warm reads entities in ID order and stores them until limit entities are stored,
+// store reports false for entities it skips
+func warm[T any](ctx context.Context, limit int, getBatch func(context.Context, uuid.UUID, int) ([]T, error),
+	id func(T) uuid.UUID, store func(context.Context, T) (bool, error)) (int, error) {
+	warmed := 0
+	after := uuid.Nil
+	for warmed < limit {
+		items, err := getBatch(ctx, after, warmBatch)
+		if err != nil {
+			return warmed, fmt.Errorf("GetBatch(): %w", err)
+		}
+		for _, item := range items {
+			stored, err := store(ctx, item)
+			if err != nil {
+				return warmed, fmt.Errorf("store %v: %w", id(item), err)
+			}
+			if stored {
+				warmed++
+			}
+			if warmed == limit {
+				return warmed, nil
+			}
+		}
+		if len(items) < warmBatch {
+			break
+		}
+		after = id(items[len(items)-1])
+	}
+	return warmed, nil
+}
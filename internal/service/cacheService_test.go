@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// adminCache is a memory person cache with the administration methods of the Redis cache
+type adminCache struct {
+	*repository.MemoryCacheConnection
+	stored []uuid.UUID
+}
+
+func (c *adminCache) RedisSetByID(ctx context.Context, entity *model.Person) error {
+	c.stored = append(c.stored, entity.ID)
+	return c.MemoryCacheConnection.RedisSetByID(ctx, entity)
+}
+
+func (c *adminCache) RedisInspect(ctx context.Context, id uuid.UUID) (*model.CacheEntry, error) {
+	person, err := c.RedisGetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CacheEntry{Entity: PersonEntity, ID: id, Value: person}, nil
+}
+
+func (c *adminCache) RedisFlush(context.Context) (int64, error) {
+	flushed := int64(len(c.stored))
+	c.stored = nil
+	return flushed, nil
+}
+
+func TestCacheServiceWarm(t *testing.T) {
+	ctx := context.Background()
+	rps := repository.NewMemoryConnection()
+	for i := 0; i < 5; i++ {
+		_, err := rps.Create(ctx, &model.Person{ID: uuid.New(), Name: "Eugen", Age: 20 + i})
+		require.NoError(t, err)
+	}
+	persons, err := rps.GetAll(ctx)
+	require.NoError(t, err)
+	_, err = rps.Delete(ctx, persons[0].ID, persons[0].Version)
+	require.NoError(t, err)
+	cache := &adminCache{MemoryCacheConnection: repository.NewMemoryCacheConnection()}
+	srv := NewCacheService(nil)
+	srv.ManagePersons(cache, rps)
+
+	// Step 1: deleted persons are skipped and the limit is kept
+	res, err := srv.Warm(ctx, PersonEntity, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, res.Warmed)
+	res, err = srv.Warm(ctx, PersonEntity, 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, res.Warmed)
+	require.NotContains(t, cache.stored, persons[0].ID)
+
+	// Step 2: warmed persons can be inspected
+	entry, err := srv.Inspect(ctx, PersonEntity, persons[1].ID)
+	require.NoError(t, err)
+	require.Equal(t, persons[1].Name, entry.Value.(*model.Person).Name)
+	_, err = srv.Inspect(ctx, PersonEntity, persons[0].ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+
+	// Step 3: only managed caches can be administered
+	flushed, err := srv.Flush(ctx, PersonEntity)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), flushed.Flushed)
+	_, err = srv.Flush(ctx, UserEntity)
+	require.ErrorIs(t, err, model.ErrUnknownCache)
+}
+
+func TestCacheServiceWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	srv := NewCacheService(nil)
+	_, err := srv.WriteBehind(ctx)
+	require.ErrorIs(t, err, model.ErrUnknownCache)
+
+	cache := NewWriteBehindCache(repository.NewMemoryCacheConnection())
+	cache.Removed(ctx, uuid.New())
+	srv.ManageWriteBehind(cache)
+	stats, err := srv.WriteBehind(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Queued)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// invitationCodeBytes is the number of random bytes of an invitation code
+const invitationCodeBytes = 24
+
+// InvitationRepository interface, which contains psql/mongo invitation methods
+type InvitationRepository interface {
+	Create(ctx context.Context, invitation *model.Invitation) error
+	Redeem(ctx context.Context, codeHash []byte, userID uuid.UUID, at time.Time) (*model.Invitation, error)
+	Release(ctx context.Context, codeHash []byte, userID uuid.UUID) error
+}
+
+// RoleRequestRepository interface, which contains psql/mongo role request methods
+type RoleRequestRepository interface {
+	Create(ctx context.Context, request *model.RoleRequest) error
+	GetByStatus(ctx context.Context, status string) ([]*model.RoleRequest, error)
+	Decide(ctx context.Context, id uuid.UUID, status string, by uuid.UUID, at time.Time) (*model.RoleRequest, error)
+}
+
+// RoleAssigner checks roles and assigns them to users
+type RoleAssigner interface {
+	HasRole(ctx context.Context, name string) (bool, error)
+	AssignRole(ctx context.Context, id uuid.UUID, role string) error
+}
+
+// EnrollmentService hands out roles beyond the basic one every signup gets: through single-use invitations
+// created by admins, or through role requests of users which wait for an admin to approve them
+type EnrollmentService struct {
+	invitations InvitationRepository
+	requests    RoleRequestRepository
+	roles       RoleAssigner
+	ttl         time.Duration
+	now         func() time.Time
+}
+
+// NewEnrollmentService is a constructor for EnrollmentService, invitations expire after ttl
+func NewEnrollmentService(invitations InvitationRepository, requests RoleRequestRepository, roles RoleAssigner, ttl time.Duration) *EnrollmentService {
+	return &EnrollmentService{invitations: invitations, requests: requests, roles: roles, ttl: ttl, now: time.Now}
+}
+
+// CreateInvitation creates an invitation granting an existing role, the code is returned only here
+func (es *EnrollmentService) CreateInvitation(ctx context.Context, role string) (*model.InvitationCode, error) {
+	ok, err := es.roles.HasRole(ctx, role)
+	if err != nil {
+		return nil, fmt.Errorf("HasRole: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("CreateInvitation: role %q: %w", role, model.ErrNotFound)
+	}
+	random := make([]byte, invitationCodeBytes)
+	_, err = rand.Read(random)
+	if err != nil {
+		return nil, fmt.Errorf("Read: %w", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(random)
+	now := es.now()
+	actor, _ := mdlwr.ActorFromContext(ctx)
+	invitation := &model.Invitation{
+		ID:        uuid.New(),
+		CodeHash:  hashInvitationCode(code),
+		Role:      role,
+		CreatedBy: actor.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(es.ttl),
+	}
+	err = es.invitations.Create(ctx, invitation)
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"security_event": "invitation_created", "actor_id": actor.ID, "invitation": invitation.ID, "role": role}).
+		Info("invitation created")
+	return &model.InvitationCode{ID: invitation.ID, Code: code, Role: role, ExpiresAt: invitation.ExpiresAt}, nil
+}
+
+// RedeemInvitation uses up the invitation with the given code for a user and returns the role it grants
+func (es *EnrollmentService) RedeemInvitation(ctx context.Context, code string, userID uuid.UUID) (string, error) {
+	invitation, err := es.invitations.Redeem(ctx, hashInvitationCode(code), userID, es.now())
+	if err != nil {
+		return "", fmt.Errorf("Redeem: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"security_event": "invitation_redeemed", "user_id": userID, "invitation": invitation.ID, "role": invitation.Role}).
+		Info("invitation redeemed")
+	return invitation.Role, nil
+}
+
+// ReleaseInvitation makes an invitation redeemed by a user whose signup failed usable again
+func (es *EnrollmentService) ReleaseInvitation(ctx context.Context, code string, userID uuid.UUID) error {
+	err := es.invitations.Release(ctx, hashInvitationCode(code), userID)
+	if err != nil {
+		return fmt.Errorf("Release: %w", err)
+	}
+	return nil
+}
+
+// RequestRole queues a request of the actor for an existing role until an admin decides it
+func (es *EnrollmentService) RequestRole(ctx context.Context, role string) (*model.RoleRequest, error) {
+	actor, ok := mdlwr.ActorFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("RequestRole: no actor")
+	}
+	exists, err := es.roles.HasRole(ctx, role)
+	if err != nil {
+		return nil, fmt.Errorf("HasRole: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("RequestRole: role %q: %w", role, model.ErrNotFound)
+	}
+	request := &model.RoleRequest{ID: uuid.New(), UserID: actor.ID, Role: role, Status: model.RoleRequestPending, CreatedAt: es.now()}
+	err = es.requests.Create(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+	return request, nil
+}
+
+// GetRoleRequests returns the role requests with the given status, the pending ones by default
+func (es *EnrollmentService) GetRoleRequests(ctx context.Context, status string) ([]*model.RoleRequest, error) {
+	if status == "" {
+		status = model.RoleRequestPending
+	}
+	requests, err := es.requests.GetByStatus(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("GetByStatus: %w", err)
+	}
+	return requests, nil
+}
+
+// DecideRoleRequest approves or rejects a pending role request, approving it assigns the role to the user
+func (es *EnrollmentService) DecideRoleRequest(ctx context.Context, id uuid.UUID, approve bool) (*model.RoleRequest, error) {
+	status := model.RoleRequestRejected
+	if approve {
+		status = model.RoleRequestApproved
+	}
+	actor, _ := mdlwr.ActorFromContext(ctx)
+	request, err := es.requests.Decide(ctx, id, status, actor.ID, es.now())
+	if err != nil {
+		return nil, fmt.Errorf("Decide: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"security_event": "role_request_" + status, "actor_id": actor.ID, "user_id": request.UserID, "role": request.Role}).
+		Info("role request decided")
+	if approve {
+		err = es.roles.AssignRole(ctx, request.UserID, request.Role)
+		if err != nil {
+			return nil, fmt.Errorf("AssignRole: %w", err)
+		}
+	}
+	return request, nil
+}
+
+// hashInvitationCode returns the stored hash of an invitation code, the codes are random so a fast hash is enough
+func hashInvitationCode(code string) []byte {
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEnrollment returns an enrollment service and a user service signing up through it on memory stores
+func newTestEnrollment(t *testing.T) (*EnrollmentService, *UserService, *repository.UserMemoryConnection) {
+	roles, _, users, rdb := newTestRoleService(t)
+	enrollment := NewEnrollmentService(repository.NewInvitationMemoryConnection(), repository.NewRoleRequestMemoryConnection(), roles, time.Hour)
+	srv := NewUserServiceImpl(users, rdb, repository.NewTokenFamilyMemoryConnection(), newTestKeySet(t), enrollment,
+		testPolicy, NewLoginThrottle(rdb, testLimits, testLimits), newTestMFA(users))
+	return enrollment, srv, users
+}
+
+func TestSignupInvitation(t *testing.T) {
+	admin := mdlwr.WithActor(context.Background(), model.Actor{ID: uuid.New(), Role: model.RoleAdmin})
+	ctx := context.Background()
+	enrollment, srv, users := newTestEnrollment(t)
+
+	// Step 1: a signup can't pick its role
+	basic := &model.User{ID: uuid.New(), Login: "basic", Password: []byte(testPassword), Role: model.RoleAdmin}
+	require.NoError(t, srv.Signup(ctx, basic, ""))
+	role, err := users.GetRoleByID(ctx, basic.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleUser, role)
+
+	// Step 2: an invitation grants its role once
+	_, err = enrollment.CreateInvitation(admin, "unknown")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	invitation, err := enrollment.CreateInvitation(admin, model.RoleAdmin)
+	require.NoError(t, err)
+	invited := &model.User{ID: uuid.New(), Login: "invited", Password: []byte(testPassword)}
+	require.NoError(t, srv.Signup(ctx, invited, invitation.Code))
+	role, err = users.GetRoleByID(ctx, invited.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, role)
+	err = srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "again", Password: []byte(testPassword)}, invitation.Code)
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+	err = srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "guess", Password: []byte(testPassword)}, "guess")
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+
+	// Step 3: a failed signup leaves the invitation usable
+	invitation, err = enrollment.CreateInvitation(admin, model.RoleAdmin)
+	require.NoError(t, err)
+	require.Error(t, srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "basic", Password: []byte(testPassword)}, invitation.Code))
+	require.NoError(t, srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "second", Password: []byte(testPassword)}, invitation.Code))
+
+	// Step 4: an expired invitation is refused
+	invitation, err = enrollment.CreateInvitation(admin, model.RoleAdmin)
+	require.NoError(t, err)
+	enrollment.now = func() time.Time { return time.Now().Add(time.Hour) }
+	err = srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "late", Password: []byte(testPassword)}, invitation.Code)
+	require.ErrorIs(t, err, model.ErrInvalidInvitation)
+}
+
+func TestRoleRequests(t *testing.T) {
+	adminID := uuid.New()
+	admin := mdlwr.WithActor(context.Background(), model.Actor{ID: adminID, Role: model.RoleAdmin})
+	ctx := context.Background()
+	enrollment, srv, users := newTestEnrollment(t)
+	user := &model.User{ID: uuid.New(), Login: "eugen", Password: []byte(testPassword)}
+	require.NoError(t, srv.Signup(ctx, user, ""))
+	userCtx := mdlwr.WithActor(ctx, model.Actor{ID: user.ID, Role: model.RoleUser})
+
+	// Step 1: users queue requests for existing roles
+	_, err := enrollment.RequestRole(ctx, model.RoleAdmin)
+	require.Error(t, err)
+	_, err = enrollment.RequestRole(userCtx, "unknown")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	approved, err := enrollment.RequestRole(userCtx, model.RoleAdmin)
+	require.NoError(t, err)
+	rejected, err := enrollment.RequestRole(userCtx, model.RoleAdmin)
+	require.NoError(t, err)
+	pending, err := enrollment.GetRoleRequests(admin, "")
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	// Step 2: a rejected request changes nothing, an approved one assigns the role
+	decided, err := enrollment.DecideRoleRequest(admin, rejected.ID, false)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleRequestRejected, decided.Status)
+	role, err := users.GetRoleByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleUser, role)
+	decided, err = enrollment.DecideRoleRequest(admin, approved.ID, true)
+	require.NoError(t, err)
+	require.Equal(t, adminID, *decided.DecidedBy)
+	role, err = users.GetRoleByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, role)
+
+	// Step 3: a request is decided once
+	_, err = enrollment.DecideRoleRequest(admin, approved.ID, false)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	pending, err = enrollment.GetRoleRequests(admin, "")
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// const for token signing keys
+const (
+	signingKeyBits = 2048
+	// keyCheckInterval is how often the key set looks for keys created by other instances and rotates
+	keyCheckInterval = time.Minute
+	// keyReloadInterval bounds how often tokens signed by unknown keys reload the key set
+	keyReloadInterval = 10 * time.Second
+	keyReloadTimeout  = 5 * time.Second
+	// keyEncryptionKeyBytes is the size of the AES-256 key sealing the signing keys at rest
+	keyEncryptionKeyBytes = 32
+)
+
+// SigningKeyRepository interface, which contains psql/mongo signing key methods
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *model.SigningKey) error
+	GetValid(ctx context.Context, at time.Time) ([]*model.SigningKey, error)
+}
+
+// signingKey is a stored signing key with its private key parsed
+type signingKey struct {
+	id         string
+	private    *rsa.PrivateKey
+	activeFrom time.Time
+	expiresAt  time.Time
+}
+
+// KeySet signs tokens with RS256 and finds the key verifying a token by its kid header.
+// The keys live in the primary store and are shared by every instance, sealed with AES-GCM so the store
+// alone doesn't give them away. A new key is created every rotation period,
+// it is published lead before it starts signing, so verifiers caching the JWKS know it in time,
+// and it stays published until the tokens it signed have expired.
+type KeySet struct {
+	rps      SigningKeyRepository
+	sealer   cipher.AEAD
+	rotation time.Duration
+	lead     time.Duration
+	lifetime time.Duration
+	bits     int
+	now      func() time.Time
+
+	mu       sync.RWMutex
+	keys     []*signingKey
+	reloaded time.Time
+}
+
+// NewKeyCipher returns the cipher sealing the signing keys at rest, key is the base64 encoding of 32 random bytes
+func NewKeyCipher(key string) (cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeString(): %w", err)
+	}
+	if len(raw) != keyEncryptionKeyBytes {
+		return nil, fmt.Errorf("key encryption key has %d bytes, want %d", len(raw), keyEncryptionKeyBytes)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("NewCipher(): %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCM(): %w", err)
+	}
+	return gcm, nil
+}
+
+// NewKeySet is a constructor for an empty KeySet whose keys are sealed with sealer, call Rotate to load or create its keys
+func NewKeySet(rps SigningKeyRepository, sealer cipher.AEAD, rotation, lead time.Duration) *KeySet {
+	if lead >= rotation {
+		lead = rotation / 2
+	}
+	return &KeySet{rps: rps, sealer: sealer, rotation: rotation, lead: lead, lifetime: refreshTokenTTL, bits: signingKeyBits, now: time.Now}
+}
+
+// Load reads the keys which have not expired from the store, keys which can't be unsealed or parsed are skipped.
+// Keys stored unsealed by older builds are skipped too, the tokens they signed are no longer accepted
+func (ks *KeySet) Load(ctx context.Context) error {
+	stored, err := ks.rps.GetValid(ctx, ks.now())
+	if err != nil {
+		return fmt.Errorf("GetValid(): %w", err)
+	}
+	keys := make([]*signingKey, 0, len(stored))
+	for _, key := range stored {
+		pemKey, err := ks.open(key)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"kid": key.ID}).Errorf("open: %v", err)
+			continue
+		}
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"kid": key.ID}).Errorf("ParseRSAPrivateKeyFromPEM: %v", err)
+			continue
+		}
+		keys = append(keys, &signingKey{id: key.ID, private: private, activeFrom: key.ActiveFrom, expiresAt: key.ExpiresAt})
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.reloaded = ks.now()
+	return nil
+}
+
+// Rotate loads the keys and creates the next one once it is due, lead before the newest key has signed
+// for a rotation period. Instances rotating at once may both create a key, then the later one signs
+func (ks *KeySet) Rotate(ctx context.Context) error {
+	err := ks.Load(ctx)
+	if err != nil {
+		return err
+	}
+	now := ks.now()
+	activeFrom := now
+	ks.mu.RLock()
+	if len(ks.keys) > 0 {
+		activeFrom = ks.keys[len(ks.keys)-1].activeFrom.Add(ks.rotation)
+	}
+	ks.mu.RUnlock()
+	if now.Before(activeFrom.Add(-ks.lead)) {
+		return nil
+	}
+	if activeFrom.Before(now) {
+		activeFrom = now
+	}
+	key, err := ks.generate(activeFrom)
+	if err != nil {
+		return err
+	}
+	err = ks.rps.Create(ctx, key)
+	if err != nil {
+		return fmt.Errorf("Create(): %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"kid": key.ID, "active_from": key.ActiveFrom}).Info("created a signing key")
+	return ks.Load(ctx)
+}
+
+// Run rotates the keys every keyCheckInterval until ctx is done
+func (ks *KeySet) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := ks.Rotate(ctx)
+		if err != nil {
+			logrus.Errorf("Rotate: %v", err)
+		}
+	}
+}
+
+// Sign signs the claims with the active key and names the key in the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := ks.signing()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.id
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("SignedString(): %w", err)
+	}
+	return signed, nil
+}
+
+// Keyfunc returns the public key verifying the token, it is a jwt.Keyfunc.
+// A token signed by a key which is not known yet reloads the keys, at most every keyReloadInterval
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	key := ks.find(kid)
+	if key == nil && ks.reload() {
+		key = ks.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return &key.private.PublicKey, nil
+}
+
+// JWKS returns the public keys of the set, including the next key before it starts signing
+func (ks *KeySet) JWKS() *model.JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := ks.now()
+	jwks := &model.JWKS{Keys: make([]*model.JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		if !key.expiresAt.After(now) {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, &model.JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.id,
+			N:   base64.RawURLEncoding.EncodeToString(key.private.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.private.E)).Bytes()),
+		})
+	}
+	return jwks
+}
+
+// signing returns the newest active key. A key signs only while the tokens it signs expire before it does,
+// so when creating its successor keeps failing, logins fail rather than issuing tokens nobody can verify
+func (ks *KeySet) signing() (*signingKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := ks.now()
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		key := ks.keys[i]
+		if key.activeFrom.After(now) {
+			continue
+		}
+		if now.Add(ks.lifetime).After(key.expiresAt) {
+			break
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no active signing key")
+}
+
+// find returns the key with the given ID unless it has expired
+func (ks *KeySet) find(kid string) *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.id == kid && key.expiresAt.After(ks.now()) {
+			return key
+		}
+	}
+	return nil
+}
+
+// reload loads the keys unless they were loaded within keyReloadInterval and reports whether it did
+func (ks *KeySet) reload() bool {
+	ks.mu.Lock()
+	if ks.now().Sub(ks.reloaded) < keyReloadInterval {
+		ks.mu.Unlock()
+		return false
+	}
+	ks.reloaded = ks.now()
+	ks.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), keyReloadTimeout)
+	defer cancel()
+	err := ks.Load(ctx)
+	if err != nil {
+		logrus.Errorf("Load: %v", err)
+		return false
+	}
+	return true
+}
+
+// generate creates a key signing from activeFrom. It may sign for up to two rotation periods
+// if creating its successor fails, and is published until the last token it may have signed expires
+func (ks *KeySet) generate(activeFrom time.Time) (*model.SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, ks.bits)
+	if err != nil {
+		return nil, fmt.Errorf("GenerateKey(): %w", err)
+	}
+	key := &model.SigningKey{
+		ID:         uuid.NewString(),
+		ActiveFrom: activeFrom,
+		ExpiresAt:  activeFrom.Add(2*ks.rotation + ks.lifetime),
+		CreatedAt:  ks.now(),
+	}
+	key.PrivateKey, err = ks.seal(key.ID, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// seal encrypts a private key as nonce followed by ciphertext, the key ID is authenticated along with it
+// so a sealed key can't be moved to another ID
+func (ks *KeySet) seal(kid string, private []byte) ([]byte, error) {
+	nonce := make([]byte, ks.sealer.NonceSize(), ks.sealer.NonceSize()+len(private)+ks.sealer.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Read(): %w", err)
+	}
+	return ks.sealer.Seal(nonce, nonce, private, []byte(kid)), nil
+}
+
+// open decrypts a private key sealed by seal
+func (ks *KeySet) open(key *model.SigningKey) ([]byte, error) {
+	if len(key.PrivateKey) < ks.sealer.NonceSize() {
+		return nil, errors.New("sealed key is too short")
+	}
+	nonce, sealed := key.PrivateKey[:ks.sealer.NonceSize()], key.PrivateKey[ks.sealer.NonceSize():]
+	private, err := ks.sealer.Open(nil, nonce, sealed, []byte(key.ID))
+	if err != nil {
+		return nil, fmt.Errorf("Open(): %w", err)
+	}
+	return private, nil
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKeyCipher returns a cipher sealing signing keys with a random key
+func newTestKeyCipher(t *testing.T) cipher.AEAD {
+	key := make([]byte, keyEncryptionKeyBytes)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	sealer, err := NewKeyCipher(base64.StdEncoding.EncodeToString(key))
+	require.NoError(t, err)
+	return sealer
+}
+
+// newTestKeySet returns a key set with an active key, small keys keep the tests fast
+func newTestKeySet(t *testing.T) *KeySet {
+	keys := NewKeySet(repository.NewSigningKeyMemoryConnection(), newTestKeyCipher(t), time.Hour, time.Minute)
+	keys.bits = 1024
+	require.NoError(t, keys.Rotate(context.Background()))
+	return keys
+}
+
+// sign signs claims naming their subject with the key set
+func sign(t *testing.T, keys *KeySet, subject string) (string, string) {
+	signed, err := keys.Sign(&jwt.StandardClaims{Subject: subject, ExpiresAt: keys.now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+	token, err := jwt.Parse(signed, keys.Keyfunc)
+	require.NoError(t, err)
+	return signed, token.Header["kid"].(string)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	ctx := context.Background()
+	rps := repository.NewSigningKeyMemoryConnection()
+	keys := NewKeySet(rps, newTestKeyCipher(t), time.Hour, 10*time.Minute)
+	keys.bits = 1024
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+
+	// Step 1: the first key signs right away
+	require.NoError(t, keys.Rotate(ctx))
+	_, firstKid := sign(t, keys, "first")
+	require.Len(t, keys.JWKS().Keys, 1)
+
+	// Step 2: the next key is published lead before it signs
+	now = now.Add(49 * time.Minute)
+	require.NoError(t, keys.Rotate(ctx))
+	require.Len(t, keys.JWKS().Keys, 1)
+	now = now.Add(time.Minute)
+	require.NoError(t, keys.Rotate(ctx))
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "RS256", jwks.Keys[1].Alg)
+	require.Equal(t, "AQAB", jwks.Keys[1].E)
+	oldToken, kid := sign(t, keys, "old")
+	require.Equal(t, firstKid, kid)
+
+	// Step 3: then it takes over and the old key still verifies
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, keys.Rotate(ctx))
+	_, kid = sign(t, keys, "new")
+	require.Equal(t, jwks.Keys[1].Kid, kid)
+	_, err := jwt.Parse(oldToken, keys.Keyfunc)
+	require.NoError(t, err)
+
+	// Step 4: keys expire once the tokens they signed have
+	now = now.Add(2*time.Hour + refreshTokenTTL)
+	require.NoError(t, keys.Load(ctx))
+	_, err = keys.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": firstKid}})
+	require.Error(t, err)
+	_, err = keys.Sign(&jwt.StandardClaims{})
+	require.Error(t, err)
+}
+
+func TestKeySetSharedKeys(t *testing.T) {
+	ctx := context.Background()
+	rps := repository.NewSigningKeyMemoryConnection()
+	sealer := newTestKeyCipher(t)
+	first := NewKeySet(rps, sealer, time.Hour, time.Minute)
+	second := NewKeySet(rps, sealer, time.Hour, time.Minute)
+	first.bits = 1024
+
+	// Step 1: the second instance loads the key of the first one
+	require.NoError(t, first.Rotate(ctx))
+	require.NoError(t, second.Rotate(ctx))
+	require.Equal(t, first.JWKS(), second.JWKS())
+
+	// Step 2: a token signed by a key it doesn't know yet reloads the keys
+	now := time.Now().Add(time.Hour)
+	first.now = func() time.Time { return now }
+	require.NoError(t, first.Rotate(ctx))
+	signed, _ := sign(t, first, "user")
+	second.reloaded = time.Time{}
+	_, err := jwt.Parse(signed, second.Keyfunc)
+	require.NoError(t, err)
+
+	// Step 3: other signing methods are rejected
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = jwt.Parse(hmac, second.Keyfunc)
+	require.Error(t, err)
+}
+
+func TestKeySetSealedKeys(t *testing.T) {
+	ctx := context.Background()
+	rps := repository.NewSigningKeyMemoryConnection()
+	keys := NewKeySet(rps, newTestKeyCipher(t), time.Hour, time.Minute)
+	keys.bits = 1024
+	require.NoError(t, keys.Rotate(ctx))
+	signed, kid := sign(t, keys, "user")
+
+	// Step 1: the store holds the key sealed
+	stored, err := rps.GetValid(ctx, time.Now())
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.NotContains(t, string(stored[0].PrivateKey), "PRIVATE KEY")
+
+	// Step 2: without the key encryption key the stored key is skipped and its tokens are rejected
+	other := NewKeySet(rps, newTestKeyCipher(t), time.Hour, time.Minute)
+	require.NoError(t, other.Load(ctx))
+	require.Empty(t, other.JWKS().Keys)
+	_, err = jwt.Parse(signed, other.Keyfunc)
+	require.Error(t, err)
+
+	// Step 3: a sealed key moved to another ID or a key stored unsealed is skipped as well
+	moved := *stored[0]
+	moved.ID = "moved"
+	require.NoError(t, rps.Create(ctx, &moved))
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	require.NoError(t, rps.Create(ctx, &model.SigningKey{
+		ID:         "plain",
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}),
+		ActiveFrom: stored[0].ActiveFrom,
+		ExpiresAt:  stored[0].ExpiresAt,
+	}))
+	require.NoError(t, keys.Load(ctx))
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, kid, jwks.Keys[0].Kid)
+
+	// Step 4: the key encryption key has to be 32 bytes
+	_, err = NewKeyCipher(base64.StdEncoding.EncodeToString([]byte("short")))
+	require.Error(t, err)
+	_, err = NewKeyCipher("not base64")
+	require.Error(t, err)
+}
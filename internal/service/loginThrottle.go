@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LoginFailureRepository interface, which contains the redis methods counting failed logins
+type LoginFailureRepository interface {
+	ReserveLogin(ctx context.Context, scope, subject string, at time.Time, window time.Duration) (*model.LoginFailures, error)
+	ReleaseLogin(ctx context.Context, scope, subject string, at, lastAt time.Time, window time.Duration) error
+	GetLoginFailures(ctx context.Context, scope, subject string) (*model.LoginFailures, error)
+	ResetLoginFailures(ctx context.Context, scope, subject string) error
+}
+
+// LoginLimits struct contains the throttling rules of a login scope
+type LoginLimits struct {
+	// Free is the number of failures which don't delay the next attempt
+	Free int
+	// Lockout is the number of failures locking the scope out, 0 never locks it out
+	Lockout int
+	// BackoffBase is the delay after the first failure beyond Free, it doubles with every further failure
+	BackoffBase time.Duration
+	// BackoffMax caps the delay between attempts
+	BackoffMax time.Duration
+	// LockoutTTL is how long failures are remembered after the last one, which is how long a lockout lasts
+	LockoutTTL time.Duration
+}
+
+// retryAfter returns how long the next attempt has to wait after the failures and whether the scope is locked out
+func (limits LoginLimits) retryAfter(failures *model.LoginFailures, now time.Time) (time.Duration, bool) {
+	if limits.Lockout > 0 && failures.Count >= limits.Lockout {
+		return failures.LastAt.Add(limits.LockoutTTL).Sub(now), true
+	}
+	if failures.Count <= limits.Free || limits.BackoffBase <= 0 {
+		return 0, false
+	}
+	delay := limits.BackoffBase
+	for i := limits.Free + 1; i < failures.Count && delay < limits.BackoffMax; i++ {
+		delay *= 2
+	}
+	if limits.BackoffMax > 0 && delay > limits.BackoffMax {
+		delay = limits.BackoffMax
+	}
+	return failures.LastAt.Add(delay).Sub(now), false
+}
+
+// LoginThrottle counts failed logins per login and per client IP in the cache. After the free attempts
+// every attempt waits exponentially longer, and too many failures lock the login or the IP out for a while.
+// Every attempt is counted as failed before any password is compared, so concurrent attempts can't slip past
+// the limits. While the cache is unreachable attempts are rejected, as they can't be counted
+type LoginThrottle struct {
+	rdb        LoginFailureRepository
+	loginScope string
+	ipScope    string
+	limits     map[string]LoginLimits
+	now        func() time.Time
+}
+
+// NewLoginThrottle is a constructor for LoginThrottle with the limits of logins and of client IPs
+func NewLoginThrottle(rdb LoginFailureRepository, login, ip LoginLimits) *LoginThrottle {
+	return newThrottle(rdb, model.LoginScopeLogin, model.LoginScopeIP, login, ip)
+}
+
+// NewResetThrottle is a constructor for a LoginThrottle of password reset requests, counted apart from logins.
+// A login or an IP gets perLogin or perIP requests, further ones wait until window has passed since the last one
+func NewResetThrottle(rdb LoginFailureRepository, perLogin, perIP int, window time.Duration) *LoginThrottle {
+	return newThrottle(rdb, model.ResetScopeLogin, model.ResetScopeIP,
+		LoginLimits{Free: perLogin, Lockout: perLogin, LockoutTTL: window},
+		LoginLimits{Free: perIP, Lockout: perIP, LockoutTTL: window})
+}
+
+// newThrottle returns a LoginThrottle counting attempts in the scopes of logins and of client IPs
+func newThrottle(rdb LoginFailureRepository, loginScope, ipScope string, login, ip LoginLimits) *LoginThrottle {
+	return &LoginThrottle{
+		rdb:        rdb,
+		loginScope: loginScope,
+		ipScope:    ipScope,
+		limits:     map[string]LoginLimits{loginScope: login, ipScope: ip},
+		now:        time.Now,
+	}
+}
+
+// Reserve counts a login attempt of the login and the IP as failed before its password is compared.
+// It returns a *model.LoginThrottledError and takes the attempt back if the login or the IP has to wait
+func (lt *LoginThrottle) Reserve(ctx context.Context, login, ip string) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{lt: lt, at: lt.now()}
+	for _, s := range lt.scopes(login, ip) {
+		limits := lt.limits[s.scope]
+		previous, err := lt.rdb.ReserveLogin(ctx, s.scope, s.subject, attempt.at, limits.LockoutTTL)
+		if err != nil {
+			attempt.Release(ctx)
+			return nil, fmt.Errorf("ReserveLogin(): %w", err)
+		}
+		attempt.reserved = append(attempt.reserved, reservation{loginScope: s, previous: previous})
+		wait, locked := limits.retryAfter(previous, attempt.at)
+		if wait > 0 {
+			attempt.Release(ctx)
+			return nil, &model.LoginThrottledError{Scope: s.scope, Locked: locked, RetryAfter: wait}
+		}
+	}
+	return attempt, nil
+}
+
+// Forget forgets the failed logins of the login, the failures of the IP are kept
+func (lt *LoginThrottle) Forget(ctx context.Context, login string) {
+	err := lt.rdb.ResetLoginFailures(ctx, lt.loginScope, login)
+	if err != nil {
+		logCacheError(logrus.Fields{"login": login}, "ResetLoginFailures", err)
+	}
+}
+
+// Unlock forgets the failed logins of the login and/or the IP, lifting their backoff and lockout
+func (lt *LoginThrottle) Unlock(ctx context.Context, login, ip string) error {
+	for _, s := range lt.scopes(login, ip) {
+		err := lt.rdb.ResetLoginFailures(ctx, s.scope, s.subject)
+		if err != nil {
+			return fmt.Errorf("ResetLoginFailures(): %w", err)
+		}
+	}
+	logrus.WithFields(eventFields(ctx, "login_unlocked")).WithFields(logrus.Fields{"login": login, "ip": ip}).Info("unlocked logins")
+	return nil
+}
+
+// LoginAttempt is a login attempt reserved by LoginThrottle.Reserve. It stays counted as failed
+// once it fails, and is taken back once it succeeds or is released
+type LoginAttempt struct {
+	lt       *LoginThrottle
+	at       time.Time
+	reserved []reservation
+	done     bool
+}
+
+// reservation is a scope the attempt is counted in along with the failures counted before it
+type reservation struct {
+	loginScope
+	previous *model.LoginFailures
+}
+
+// Fail keeps the attempt counted as failed and reports lockouts as security events
+func (a *LoginAttempt) Fail() {
+	if a.done {
+		return
+	}
+	a.done = true
+	for _, r := range a.reserved {
+		limits := a.lt.limits[r.scope]
+		count := r.previous.Count + 1
+		fields := logrus.Fields{"security_event": "login_failed", "scope": r.scope, "subject": r.subject, "failures": count}
+		if limits.Lockout > 0 && count == limits.Lockout {
+			fields["security_event"] = "login_lockout"
+			logrus.WithFields(fields).Warnf("locked out for %v", limits.LockoutTTL)
+			continue
+		}
+		logrus.WithFields(fields).Info("failed login")
+	}
+}
+
+// Keep keeps the attempt counted without reporting it as a failed login, for requests counted whatever they lead to
+func (a *LoginAttempt) Keep() {
+	a.done = true
+}
+
+// Succeed forgets the failed logins of the login and takes the attempt back from the IP. The earlier
+// failures of the IP are kept, so an attacker holding one account can't use it to keep guessing the passwords of others
+func (a *LoginAttempt) Succeed(ctx context.Context) {
+	if a.done {
+		return
+	}
+	for _, r := range a.reserved {
+		if r.scope == a.lt.loginScope {
+			a.lt.Forget(ctx, r.subject)
+			continue
+		}
+		a.release(ctx, r)
+	}
+	a.done = true
+}
+
+// Release takes back an attempt which neither failed nor succeeded, it does nothing once the attempt is done
+func (a *LoginAttempt) Release(ctx context.Context) {
+	if a.done {
+		return
+	}
+	for _, r := range a.reserved {
+		a.release(ctx, r)
+	}
+	a.done = true
+}
+
+// release takes the attempt back from the scope
+func (a *LoginAttempt) release(ctx context.Context, r reservation) {
+	err := a.lt.rdb.ReleaseLogin(ctx, r.scope, r.subject, a.at, r.previous.LastAt, a.lt.limits[r.scope].LockoutTTL)
+	if err != nil {
+		logCacheError(logrus.Fields{"scope": r.scope, "subject": r.subject}, "ReleaseLogin", err)
+	}
+}
+
+// loginScope is a throttled scope of a login attempt along with its subject, the login or the IP
+type loginScope struct {
+	scope   string
+	subject string
+}
+
+// scopes returns the throttled scopes of a login attempt, an empty login or IP is not throttled
+func (lt *LoginThrottle) scopes(login, ip string) []loginScope {
+	res := make([]loginScope, 0, 2)
+	if login != "" {
+		res = append(res, loginScope{scope: lt.loginScope, subject: login})
+	}
+	if ip != "" {
+		res = append(res, loginScope{scope: lt.ipScope, subject: ip})
+	}
+	return res
+}
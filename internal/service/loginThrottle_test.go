@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestThrottle returns a throttle with a clock the test moves forward
+func newTestThrottle(rdb LoginFailureRepository, ip LoginLimits) (*LoginThrottle, *time.Time) {
+	now := time.Now()
+	throttle := NewLoginThrottle(rdb, testLimits, ip)
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+// requireThrottled checks that the attempt is throttled for wait
+func requireThrottled(t *testing.T, err error, scope string, locked bool, wait time.Duration) {
+	var throttled *model.LoginThrottledError
+	require.True(t, errors.As(err, &throttled), "not throttled: %v", err)
+	require.ErrorIs(t, err, model.ErrLoginThrottled)
+	require.Equal(t, scope, throttled.Scope)
+	require.Equal(t, locked, throttled.Locked)
+	require.Equal(t, wait, throttled.RetryAfter)
+}
+
+// fail reserves an attempt and fails it
+func fail(t *testing.T, throttle *LoginThrottle, login, ip string) {
+	attempt, err := throttle.Reserve(context.Background(), login, ip)
+	require.NoError(t, err)
+	attempt.Fail()
+}
+
+// check reserves an attempt and takes it back
+func check(throttle *LoginThrottle, login, ip string) error {
+	attempt, err := throttle.Reserve(context.Background(), login, ip)
+	if err != nil {
+		return err
+	}
+	attempt.Release(context.Background())
+	return nil
+}
+
+func TestLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	throttle, now := newTestThrottle(repository.NewUserMemoryCacheConnection(), LoginLimits{})
+
+	// Step 1: the free failures don't delay the next attempt
+	for i := 0; i < testLimits.Free; i++ {
+		fail(t, throttle, "eugen", "192.0.2.1")
+		require.NoError(t, check(throttle, "eugen", "192.0.2.1"))
+	}
+
+	// Step 2: the delay doubles with every further failure, other logins are not delayed
+	fail(t, throttle, "eugen", "192.0.2.1")
+	requireThrottled(t, check(throttle, "eugen", "192.0.2.1"), model.LoginScopeLogin, false, time.Second)
+	require.NoError(t, check(throttle, "other", "192.0.2.1"))
+	*now = now.Add(time.Second)
+	require.NoError(t, check(throttle, "eugen", "192.0.2.1"))
+	fail(t, throttle, "eugen", "192.0.2.1")
+	requireThrottled(t, check(throttle, "eugen", "192.0.2.1"), model.LoginScopeLogin, false, 2*time.Second)
+
+	// Step 3: the lockout threshold locks the login out until the failures expire
+	*now = now.Add(2 * time.Second)
+	fail(t, throttle, "eugen", "192.0.2.1")
+	requireThrottled(t, check(throttle, "eugen", "192.0.2.1"), model.LoginScopeLogin, true, testLimits.LockoutTTL)
+	*now = now.Add(time.Minute)
+	requireThrottled(t, check(throttle, "eugen", ""), model.LoginScopeLogin, true, testLimits.LockoutTTL-time.Minute)
+
+	// Step 4: an admin unlocks the login
+	require.NoError(t, throttle.Unlock(ctx, "eugen", ""))
+	require.NoError(t, check(throttle, "eugen", "192.0.2.1"))
+}
+
+func TestLoginThrottleIP(t *testing.T) {
+	ctx := context.Background()
+	ip := LoginLimits{Free: 3, Lockout: 4, BackoffBase: time.Second, BackoffMax: time.Minute, LockoutTTL: time.Hour}
+	throttle, _ := newTestThrottle(repository.NewUserMemoryCacheConnection(), ip)
+
+	// Step 1: failures of different logins add up per IP
+	for _, login := range []string{"a", "b", "c", "d"} {
+		fail(t, throttle, login, "192.0.2.1")
+	}
+	requireThrottled(t, check(throttle, "e", "192.0.2.1"), model.LoginScopeIP, true, time.Hour)
+	require.NoError(t, check(throttle, "e", "192.0.2.2"))
+
+	// Step 2: a successful login doesn't unlock its IP
+	attempt, err := throttle.Reserve(ctx, "a", "192.0.2.2")
+	require.NoError(t, err)
+	attempt.Succeed(ctx)
+	requireThrottled(t, check(throttle, "a", "192.0.2.1"), model.LoginScopeIP, true, time.Hour)
+	require.NoError(t, throttle.Unlock(ctx, "", "192.0.2.1"))
+	require.NoError(t, check(throttle, "a", "192.0.2.1"))
+}
+
+func TestLoginThrottleRelease(t *testing.T) {
+	ctx := context.Background()
+	rdb := repository.NewUserMemoryCacheConnection()
+	throttle, _ := newTestThrottle(rdb, testLimits)
+
+	// Step 1: a released attempt is not counted
+	fail(t, throttle, "eugen", "192.0.2.1")
+	require.NoError(t, check(throttle, "eugen", "192.0.2.1"))
+	failures, err := rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Equal(t, 1, failures.Count)
+
+	// Step 2: a successful attempt forgets the failures of the login and is taken back from the IP
+	attempt, err := throttle.Reserve(ctx, "eugen", "192.0.2.1")
+	require.NoError(t, err)
+	attempt.Succeed(ctx)
+	attempt.Release(ctx)
+	failures, err = rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+	failures, err = rdb.GetLoginFailures(ctx, model.LoginScopeIP, "192.0.2.1")
+	require.NoError(t, err)
+	require.Equal(t, 1, failures.Count)
+}
+
+func TestLoginThrottleCacheDown(t *testing.T) {
+	throttle, _ := newTestThrottle(downUserCache{repository.NewUserMemoryCacheConnection()}, testLimits)
+	require.ErrorIs(t, check(throttle, "eugen", "192.0.2.1"), errCacheDown)
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// const for recovery codes
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the entropy of a recovery code, 40 bits written as eight base32 characters
+	recoveryCodeBytes = 5
+)
+
+// recoveryEncoding writes recovery codes in lower case without padding
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// MFARepository interface, which contains psql/mongo second factor methods
+type MFARepository interface {
+	Create(ctx context.Context, mfa *model.MFA) error
+	Get(ctx context.Context, userID uuid.UUID) (*model.MFA, error)
+	Confirm(ctx context.Context, userID uuid.UUID, recoveryCodes []string, step int64, at time.Time) error
+	UseStep(ctx context.Context, userID uuid.UUID, step int64) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
+	Delete(ctx context.Context, userID uuid.UUID) error
+}
+
+// LoginFinder interface finds the login naming a user in authenticator apps
+type LoginFinder interface {
+	GetLoginByID(ctx context.Context, id uuid.UUID) (string, error)
+}
+
+// MFAService manages the TOTP second factors of users. A second factor is enrolled with a new secret,
+// enabled by confirming a code generated from it and from then on required at every login of the user.
+// Users of the required roles can't log in without one
+type MFAService struct {
+	rps      MFARepository
+	users    LoginFinder
+	issuer   string
+	required map[string]bool
+	now      func() time.Time
+}
+
+// NewMFAService is a constructor for MFAService, issuer names the service in authenticator apps
+func NewMFAService(rps MFARepository, users LoginFinder, issuer string, requiredRoles []string) *MFAService {
+	required := make(map[string]bool, len(requiredRoles))
+	for _, role := range requiredRoles {
+		required[role] = true
+	}
+	return &MFAService{rps: rps, users: users, issuer: issuer, required: required, now: time.Now}
+}
+
+// Required reports whether users of the role have to log in with a second factor
+func (ms *MFAService) Required(role string) bool {
+	return ms.required[role]
+}
+
+// Enabled reports whether the user has a confirmed second factor
+func (ms *MFAService) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	mfa, err := ms.rps.Get(ctx, userID)
+	if errors.Is(err, model.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Get(): %w", err)
+	}
+	return mfa.ConfirmedAt != nil, nil
+}
+
+// Enroll creates a new secret for the user, replacing one which was not confirmed yet
+func (ms *MFAService) Enroll(ctx context.Context, userID uuid.UUID) (*model.MFAEnrollment, error) {
+	login, err := ms.users.GetLoginByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("GetLoginByID(): %w", err)
+	}
+	secret := make([]byte, totpSecretBytes)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("Read(): %w", err)
+	}
+	err = ms.rps.Create(ctx, &model.MFA{UserID: userID, Secret: secret, CreatedAt: ms.now()})
+	if err != nil {
+		return nil, fmt.Errorf("Create(): %w", err)
+	}
+	return &model.MFAEnrollment{Secret: totpEncoding.EncodeToString(secret), URI: totpURI(ms.issuer, login, secret)}, nil
+}
+
+// Confirm enables the enrolled second factor with a code generated from its secret
+// and returns its recovery codes, they are not stored and can't be shown again
+func (ms *MFAService) Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	mfa, err := ms.rps.Get(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Get(): %w", err)
+	}
+	if mfa.ConfirmedAt != nil {
+		return nil, fmt.Errorf("Confirm(): %w", model.ErrMFAEnabled)
+	}
+	step, ok := validateTOTP(mfa.Secret, code, ms.now())
+	if !ok {
+		return nil, fmt.Errorf("validateTOTP(): %w", model.ErrInvalidMFACode)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = ms.rps.Confirm(ctx, userID, hashes, step, ms.now())
+	if err != nil {
+		return nil, fmt.Errorf("Confirm(): %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "mfa_enabled")).WithField("user_id", userID).Info("second factor enabled")
+	return codes, nil
+}
+
+// Verify accepts a TOTP code of the enabled second factor once, or one of its recovery codes
+func (ms *MFAService) Verify(ctx context.Context, userID uuid.UUID, code string) error {
+	mfa, err := ms.rps.Get(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Get(): %w", err)
+	}
+	if mfa.ConfirmedAt == nil {
+		return fmt.Errorf("Verify(): %w", model.ErrNotFound)
+	}
+	if step, ok := validateTOTP(mfa.Secret, code, ms.now()); ok {
+		err = ms.rps.UseStep(ctx, userID, step)
+		if err != nil {
+			return fmt.Errorf("UseStep(): %w", err)
+		}
+		return nil
+	}
+	err = ms.rps.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return fmt.Errorf("UseRecoveryCode(): %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "mfa_recovery_code_used")).WithField("user_id", userID).Warn("recovery code used")
+	return nil
+}
+
+// Disable removes the second factor of the user, a valid code proves it is the user's decision
+func (ms *MFAService) Disable(ctx context.Context, userID uuid.UUID, code string) error {
+	err := ms.Verify(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+	err = ms.rps.Delete(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Delete(): %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "mfa_disabled")).WithField("user_id", userID).Info("second factor disabled")
+	return nil
+}
+
+// Reset removes the second factor of a user who lost it, users of the required roles enroll a new one at their next login
+func (ms *MFAService) Reset(ctx context.Context, userID uuid.UUID) error {
+	err := ms.rps.Delete(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Delete(): %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "mfa_reset")).WithField("user_id", userID).Warn("second factor reset")
+	return nil
+}
+
+// newRecoveryCodes returns new recovery codes along with their hashes
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, recoveryCodeCount)
+	hashes = make([]string, recoveryCodeCount)
+	raw := make([]byte, recoveryCodeBytes)
+	for i := range codes {
+		if _, err = rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("Read(): %w", err)
+		}
+		encoded := recoveryEncoding.EncodeToString(raw)
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash a recovery code is stored as, ignoring case and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
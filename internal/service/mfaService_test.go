@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMFAUser returns a second factor service with a frozen clock along with a signed up user
+func newTestMFAUser(t *testing.T) (*MFAService, *time.Time, uuid.UUID) {
+	users := repository.NewUserMemoryConnection()
+	id := uuid.New()
+	require.NoError(t, users.Signup(context.Background(), &model.User{ID: id, Login: "eugen", Password: []byte("hash"), Role: model.RoleUser}))
+	ms := newTestMFA(users)
+	now := time.Unix(1700000000, 0)
+	ms.now = func() time.Time { return now }
+	return ms, &now, id
+}
+
+// enrollTestMFA enrolls and confirms a second factor of the user and returns its secret and recovery codes
+func enrollTestMFA(t *testing.T, ms *MFAService, id uuid.UUID) ([]byte, []string) {
+	ctx := context.Background()
+	enrollment, err := ms.Enroll(ctx, id)
+	require.NoError(t, err)
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+	codes, err := ms.Confirm(ctx, id, totpCode(secret, totpStep(ms.now())))
+	require.NoError(t, err)
+	return secret, codes
+}
+
+func TestMFAService(t *testing.T) {
+	ctx := context.Background()
+	ms, now, id := newTestMFAUser(t)
+	require.True(t, ms.Required(model.RoleAdmin))
+	require.False(t, ms.Required(model.RoleUser))
+
+	// Step 1: an enrollment is pending until it is confirmed with a valid code
+	enrollment, err := ms.Enroll(ctx, id)
+	require.NoError(t, err)
+	require.Contains(t, enrollment.URI, "otpauth://totp/myapp:eugen?")
+	enabled, err := ms.Enabled(ctx, id)
+	require.NoError(t, err)
+	require.False(t, enabled)
+	require.ErrorIs(t, ms.Verify(ctx, id, "000000"), model.ErrNotFound)
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+	_, err = ms.Confirm(ctx, id, "000000")
+	require.ErrorIs(t, err, model.ErrInvalidMFACode)
+	codes, err := ms.Confirm(ctx, id, totpCode(secret, totpStep(*now)))
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	enabled, err = ms.Enabled(ctx, id)
+	require.NoError(t, err)
+	require.True(t, enabled)
+	_, err = ms.Enroll(ctx, id)
+	require.ErrorIs(t, err, model.ErrMFAEnabled)
+
+	// Step 2: a code is accepted once, the code confirming the factor is used up
+	require.ErrorIs(t, ms.Verify(ctx, id, totpCode(secret, totpStep(*now))), model.ErrInvalidMFACode)
+	*now = now.Add(totpPeriod)
+	require.NoError(t, ms.Verify(ctx, id, totpCode(secret, totpStep(*now))))
+	require.ErrorIs(t, ms.Verify(ctx, id, totpCode(secret, totpStep(*now))), model.ErrInvalidMFACode)
+
+	// Step 3: recovery codes are single-use and ignore case and dashes
+	require.NoError(t, ms.Verify(ctx, id, codes[0]))
+	require.ErrorIs(t, ms.Verify(ctx, id, codes[0]), model.ErrInvalidMFACode)
+	require.NoError(t, ms.Verify(ctx, id, strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))))
+
+	// Step 4: disabling takes a valid code
+	require.ErrorIs(t, ms.Disable(ctx, id, "000000"), model.ErrInvalidMFACode)
+	require.NoError(t, ms.Disable(ctx, id, codes[2]))
+	enabled, err = ms.Enabled(ctx, id)
+	require.NoError(t, err)
+	require.False(t, enabled)
+}
+
+func TestMFAServiceReset(t *testing.T) {
+	ctx := context.Background()
+	ms, _, id := newTestMFAUser(t)
+	require.ErrorIs(t, ms.Reset(ctx, id), model.ErrNotFound)
+	enrollTestMFA(t, ms, id)
+	require.NoError(t, ms.Reset(ctx, id))
+	enabled, err := ms.Enabled(ctx, id)
+	require.NoError(t, err)
+	require.False(t, enabled)
+	_, err = ms.Enroll(ctx, uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
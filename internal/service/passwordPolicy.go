@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eugenshima/myapp/internal/model"
+)
+
+// maxPasswordBytes is the longest password bcrypt hashes, longer ones are rejected rather than truncated
+const maxPasswordBytes = 72
+
+// PasswordPolicy struct contains the strength rules of passwords, it is checked at signup and password change
+type PasswordPolicy struct {
+	// MinLength is the minimal number of characters
+	MinLength int
+	// MinClasses is the minimal number of character classes used out of lower case, upper case, digits and symbols
+	MinClasses int
+	// RejectLogin rejects passwords containing the login
+	RejectLogin bool
+}
+
+// Check returns model.ErrWeakPassword along with the broken rule if the password of the login doesn't satisfy the policy
+func (p *PasswordPolicy) Check(login, password string) error {
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: longer than %d bytes", model.ErrWeakPassword, maxPasswordBytes)
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: shorter than %d characters", model.ErrWeakPassword, p.MinLength)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		return fmt.Errorf("%w: uses %d of %d required character classes (lower case, upper case, digits, symbols)",
+			model.ErrWeakPassword, classes, p.MinClasses)
+	}
+	if p.RejectLogin && login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		return fmt.Errorf("%w: contains the login", model.ErrWeakPassword)
+	}
+	return nil
+}
+
+// characterClasses returns how many of lower case, upper case, digits and symbols the password uses
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
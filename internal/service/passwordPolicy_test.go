@@ -0,0 +1,25 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	require.NoError(t, testPolicy.Check("eugen", testPassword))
+	require.NoError(t, testPolicy.Check("eugen", "пароль-Надёжный"))
+	for _, weak := range []string{
+		"Short-1",
+		"alllowercaseletters",
+		"lowercase-only",
+		"My-Eugen-Password",
+		strings.Repeat("Aa1-", 19),
+	} {
+		require.ErrorIs(t, testPolicy.Check("eugen", weak), model.ErrWeakPassword, weak)
+	}
+	require.NoError(t, (&PasswordPolicy{}).Check("eugen", "eugen"))
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// resetTokenBytes is the number of random bytes of a password reset token
+	resetTokenBytes = 32
+	// resetMailTimeout bounds the sending of a password reset mail, which outlives the request asking for it
+	resetMailTimeout = 30 * time.Second
+)
+
+// PasswordResetRepository interface, which contains psql/mongo password reset methods
+type PasswordResetRepository interface {
+	Create(ctx context.Context, reset *model.PasswordReset) error
+	Get(ctx context.Context, tokenHash []byte) (*model.PasswordReset, error)
+	Redeem(ctx context.Context, tokenHash []byte, at time.Time) (*model.PasswordReset, error)
+	RevokeUser(ctx context.Context, userID uuid.UUID, at time.Time) (int64, error)
+}
+
+// PasswordUserRepository interface, which contains the user methods password changes need
+type PasswordUserRepository interface {
+	GetUser(ctx context.Context, login string) (*model.User, error)
+	GetLoginByID(ctx context.Context, id uuid.UUID) (string, error)
+	SetPassword(ctx context.Context, id uuid.UUID, password []byte) error
+}
+
+// Mailer interface sends mails, implemented by the senders of the mail package
+type Mailer interface {
+	Send(ctx context.Context, msg *model.Mail) error
+}
+
+// SessionRevoker revokes every session of a user
+type SessionRevoker interface {
+	RevokeSessions(ctx context.Context, id uuid.UUID) error
+}
+
+// APIKeyRevoker revokes every API key of a user
+type APIKeyRevoker interface {
+	RevokeUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// PasswordService changes the passwords of logged in users and resets forgotten ones. A forgotten password
+// is reset with a single-use token mailed to the login, which has to be a mail address, and the reset ends
+// every session and revokes every API key of the user. Whether a login exists is never revealed by asking for a reset
+type PasswordService struct {
+	users    PasswordUserRepository
+	resets   PasswordResetRepository
+	mailer   Mailer
+	sessions SessionRevoker
+	keys     APIKeyRevoker
+	txm      TxManager
+	policy   *PasswordPolicy
+	throttle *LoginThrottle
+	requests *LoginThrottle
+	ttl      time.Duration
+	resetURL string
+	now      func() time.Time
+	mails    sync.WaitGroup
+}
+
+// NewPasswordService is a constructor for PasswordService, reset tokens expire after ttl. Wrong current passwords
+// count against the login throttle, reset requests against the requests throttle. A reset is stored within a txm transaction.
+// The mailed link is resetURL with the token as query parameter, without resetURL only the token is mailed
+func NewPasswordService(users PasswordUserRepository, resets PasswordResetRepository, mailer Mailer, sessions SessionRevoker,
+	keys APIKeyRevoker, txm TxManager, policy *PasswordPolicy, throttle, requests *LoginThrottle, ttl time.Duration,
+	resetURL string) *PasswordService {
+	return &PasswordService{
+		users:    users,
+		resets:   resets,
+		mailer:   mailer,
+		sessions: sessions,
+		keys:     keys,
+		txm:      txm,
+		policy:   policy,
+		throttle: throttle,
+		requests: requests,
+		ttl:      ttl,
+		resetURL: resetURL,
+		now:      time.Now,
+	}
+}
+
+// ChangePassword replaces the password of the user, the current password has to be confirmed.
+// Every session of the user is revoked, the current one included, so the user logs in again with the new password.
+// Wrong current passwords are throttled like failed logins, from the client IP and for the login
+func (ps *PasswordService) ChangePassword(ctx context.Context, userID uuid.UUID, current, password, ip string) error {
+	login, err := ps.users.GetLoginByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("GetLoginByID: %w", err)
+	}
+	attempt, err := ps.throttle.Reserve(ctx, login, ip)
+	if err != nil {
+		return fmt.Errorf("Reserve: %w", err)
+	}
+	defer attempt.Release(ctx)
+	user, err := ps.users.GetUser(ctx, login)
+	if err != nil {
+		return fmt.Errorf("GetUser: %w", err)
+	}
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(current))
+	if err != nil {
+		attempt.Fail()
+		return fmt.Errorf("CompareHashAndPassword: %w", model.ErrInvalidCredentials)
+	}
+	attempt.Succeed(ctx)
+	if current == password {
+		return fmt.Errorf("%w: same as the current password", model.ErrWeakPassword)
+	}
+	err = ps.policy.Check(login, password)
+	if err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	err = ps.setPassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+	err = ps.sessions.RevokeSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("RevokeSessions: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "password_changed")).WithField("user_id", userID).Info("password changed")
+	return nil
+}
+
+// ForgotPassword mails a password reset token to the login. Unknown logins, logins which are no mail address
+// and requests beyond the limits of the login or the client IP are only logged. The mail is sent in the background
+// and a mail which can't be sent is only logged as well, so callers can't tell which logins exist,
+// neither by the answer nor by how long it takes
+func (ps *PasswordService) ForgotPassword(ctx context.Context, login, ip string) error {
+	fields := logrus.Fields{"security_event": "password_reset_requested", "login": login, "ip": ip}
+	attempt, err := ps.requests.Reserve(ctx, login, ip)
+	var throttled *model.LoginThrottledError
+	if errors.As(err, &throttled) {
+		fields["security_event"] = "password_reset_throttled"
+		logrus.WithFields(fields).Warnf("password reset requests of the %s throttled for %v", throttled.Scope, throttled.RetryAfter)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Reserve: %w", err)
+	}
+	attempt.Keep()
+	if addr, err := mail.ParseAddress(login); err != nil || addr.Address != login {
+		logrus.WithFields(fields).Info("password reset requested for a login which is no mail address")
+		return nil
+	}
+	user, err := ps.users.GetUser(ctx, login)
+	if errors.Is(err, model.ErrNotFound) {
+		logrus.WithFields(fields).Info("password reset requested for an unknown login")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("GetUser: %w", err)
+	}
+	random := make([]byte, resetTokenBytes)
+	_, err = rand.Read(random)
+	if err != nil {
+		return fmt.Errorf("Read: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	now := ps.now()
+	reset := &model.PasswordReset{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ps.ttl),
+	}
+	err = ps.resets.Create(ctx, reset)
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	fields["user_id"], fields["reset"] = user.ID, reset.ID
+	ps.mails.Add(1)
+	go ps.send(ps.resetMail(login, token), fields)
+	return nil
+}
+
+// send sends a mail apart from the request it was asked for, within resetMailTimeout
+func (ps *PasswordService) send(msg *model.Mail, fields logrus.Fields) {
+	defer ps.mails.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), resetMailTimeout)
+	defer cancel()
+	err := ps.mailer.Send(ctx, msg)
+	if err != nil {
+		logrus.WithFields(fields).Errorf("Send: %v", err)
+		return
+	}
+	logrus.WithFields(fields).Info("password reset mailed")
+}
+
+// ResetPassword sets a new password with a mailed reset token. The token and every other reset token of the user
+// are used up and every API key of the user is revoked in the transaction storing the password.
+// Every session of the user is revoked and the failed logins of the login are forgotten.
+// A new password which doesn't satisfy the password policy leaves the token usable
+func (ps *PasswordService) ResetPassword(ctx context.Context, token, password string) error {
+	hash := hashResetToken(token)
+	now := ps.now()
+	reset, err := ps.resets.Get(ctx, hash)
+	if errors.Is(err, model.ErrNotFound) || err == nil && (reset.UsedAt != nil || !reset.ExpiresAt.After(now)) {
+		return fmt.Errorf("Get: %w", model.ErrInvalidResetToken)
+	}
+	if err != nil {
+		return fmt.Errorf("Get: %w", err)
+	}
+	login, err := ps.users.GetLoginByID(ctx, reset.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		return fmt.Errorf("GetLoginByID: %w", model.ErrInvalidResetToken)
+	}
+	if err != nil {
+		return fmt.Errorf("GetLoginByID: %w", err)
+	}
+	err = ps.policy.Check(login, password)
+	if err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	err = ps.txm.WithinTx(ctx, func(ctx context.Context) error {
+		_, err := ps.resets.Redeem(ctx, hash, now)
+		if err != nil {
+			return fmt.Errorf("Redeem: %w", err)
+		}
+		err = ps.setPassword(ctx, reset.UserID, password)
+		if err != nil {
+			return err
+		}
+		err = ps.keys.RevokeUser(ctx, reset.UserID)
+		if err != nil {
+			return fmt.Errorf("RevokeUser: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = ps.sessions.RevokeSessions(ctx, reset.UserID)
+	if err != nil {
+		return fmt.Errorf("RevokeSessions: %w", err)
+	}
+	ps.throttle.Forget(ctx, login)
+	logrus.WithFields(logrus.Fields{"security_event": "password_reset", "user_id": reset.UserID, "reset": reset.ID}).Warn("password reset")
+	return nil
+}
+
+// setPassword stores the hash of the password and uses up the reset tokens of the user which are still outstanding
+func (ps *PasswordService) setPassword(ctx context.Context, userID uuid.UUID, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("GenerateFromPassword: %w", err)
+	}
+	err = ps.users.SetPassword(ctx, userID, hash)
+	if err != nil {
+		return fmt.Errorf("SetPassword: %w", err)
+	}
+	_, err = ps.resets.RevokeUser(ctx, userID, ps.now())
+	if err != nil {
+		return fmt.Errorf("RevokeUser: %w", err)
+	}
+	return nil
+}
+
+// resetMail returns the mail carrying a password reset token
+func (ps *PasswordService) resetMail(to, token string) *model.Mail {
+	link := token
+	if ps.resetURL != "" {
+		if u, err := url.Parse(ps.resetURL); err == nil {
+			query := u.Query()
+			query.Set("token", token)
+			u.RawQuery = query.Encode()
+			link = u.String()
+		}
+	}
+	return &model.Mail{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("A password reset was requested for your account.\n\n%s\n\n"+
+			"It can be used once within %v. If you did not request it, ignore this mail, your password stays unchanged.\n",
+			link, ps.ttl),
+	}
+}
+
+// hashResetToken returns the hash a password reset token is stored as
+func hashResetToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
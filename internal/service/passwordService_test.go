@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// testMailer keeps the mails it is asked to send, failing with err if it is set.
+// While hold is set, sending waits until it is closed
+type testMailer struct {
+	mails []*model.Mail
+	err   error
+	hold  chan struct{}
+}
+
+// Send implements the Mailer interface
+func (m *testMailer) Send(_ context.Context, msg *model.Mail) error {
+	if m.hold != nil {
+		<-m.hold
+	}
+	if m.err != nil {
+		return m.err
+	}
+	m.mails = append(m.mails, msg)
+	return nil
+}
+
+// forgotPassword asks for a password reset and waits until the mail is sent
+func forgotPassword(t *testing.T, ps *PasswordService, login string) {
+	require.NoError(t, ps.ForgotPassword(context.Background(), login, "192.0.2.1"))
+	ps.mails.Wait()
+}
+
+// resetToken returns the token of the link in the last mail
+func (m *testMailer) resetToken(t *testing.T) string {
+	require.NotEmpty(t, m.mails)
+	body := m.mails[len(m.mails)-1].Body
+	start := strings.Index(body, "https://")
+	require.GreaterOrEqual(t, start, 0)
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+// newTestPasswordService returns a password service along with the user service of a signed up user
+// whose login is a mail address
+func newTestPasswordService(t *testing.T) (*PasswordService, *UserService, *testMailer, *model.User) {
+	rps := repository.NewUserMemoryConnection()
+	rdb := repository.NewUserMemoryCacheConnection()
+	throttle := NewLoginThrottle(rdb, testLimits, testLimits)
+	users := NewUserServiceImpl(rps, rdb, repository.NewTokenFamilyMemoryConnection(), newTestKeySet(t), nil,
+		testPolicy, throttle, newTestMFA(rps))
+	user := &model.User{ID: uuid.New(), Login: "eugen@example.com", Password: []byte(testPassword)}
+	require.NoError(t, users.Signup(context.Background(), user, ""))
+	mailer := &testMailer{}
+	ps := NewPasswordService(rps, repository.NewPasswordResetMemoryConnection(), mailer, users,
+		NewAPIKeyService(repository.NewAPIKeyMemoryConnection(), rps, nil, time.Hour), repository.NewMemoryTxManager(),
+		testPolicy, throttle, NewResetThrottle(rdb, 5, 10, time.Hour), time.Hour, "https://example.com/reset")
+	return ps, users, mailer, user
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	ps, users, mailer, user := newTestPasswordService(t)
+	const newPassword = "Battery-Staple-7"
+
+	// Step 1: the current password has to be right and the new one has to satisfy the policy
+	err := ps.ChangePassword(ctx, user.ID, "wrong", newPassword, "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrInvalidCredentials)
+	err = ps.ChangePassword(ctx, user.ID, testPassword, testPassword, "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrWeakPassword)
+	err = ps.ChangePassword(ctx, user.ID, testPassword, "short", "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrWeakPassword)
+	err = ps.ChangePassword(ctx, uuid.New(), testPassword, newPassword, "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 2: the new password logs in, the old one doesn't, the sessions of the user are revoked
+	// and an outstanding reset token is used up
+	access, refresh, err := users.GenerateTokens(ctx, user.Login, testPassword, "")
+	require.NoError(t, err)
+	forgotPassword(t, ps, user.Login)
+	token := mailer.resetToken(t)
+	require.NoError(t, ps.ChangePassword(ctx, user.ID, testPassword, newPassword, "192.0.2.1"))
+	_, _, err = users.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+	_, _, err = users.GenerateTokens(ctx, user.Login, testPassword, "")
+	require.ErrorIs(t, err, model.ErrInvalidCredentials)
+	_, _, err = users.GenerateTokens(ctx, user.Login, newPassword, "")
+	require.NoError(t, err)
+	require.ErrorIs(t, ps.ResetPassword(ctx, token, "Another-Pass-5"), model.ErrInvalidResetToken)
+
+	// Step 3: wrong current passwords are throttled
+	for i := 0; i <= testLimits.Free; i++ {
+		err = ps.ChangePassword(ctx, user.ID, "wrong", "Another-Pass-5", "192.0.2.3")
+		require.ErrorIs(t, err, model.ErrInvalidCredentials)
+	}
+	err = ps.ChangePassword(ctx, user.ID, newPassword, "Another-Pass-5", "192.0.2.3")
+	require.ErrorIs(t, err, model.ErrLoginThrottled)
+}
+
+func TestForgotPassword(t *testing.T) {
+	ctx := context.Background()
+	ps, users, mailer, user := newTestPasswordService(t)
+	require.NoError(t, users.Signup(ctx, &model.User{ID: uuid.New(), Login: "eugen", Password: []byte(testPassword)}, ""))
+
+	// Step 1: unknown logins and logins which are no mail address succeed without a mail
+	forgotPassword(t, ps, "nobody@example.com")
+	forgotPassword(t, ps, "eugen")
+	require.Empty(t, mailer.mails)
+
+	// Step 2: the reset mail goes to the login and carries a link with the token
+	forgotPassword(t, ps, user.Login)
+	require.Len(t, mailer.mails, 1)
+	require.Equal(t, user.Login, mailer.mails[0].To)
+	require.NotEmpty(t, mailer.resetToken(t))
+
+	// Step 3: a mail which can't be sent is not reported
+	mailer.err = errors.New("mail server down")
+	forgotPassword(t, ps, user.Login)
+
+	// Step 4: the answer doesn't wait for the mail
+	mailer.err, mailer.hold = nil, make(chan struct{})
+	require.NoError(t, ps.ForgotPassword(ctx, user.Login, "192.0.2.1"))
+	require.Len(t, mailer.mails, 1)
+	close(mailer.hold)
+	ps.mails.Wait()
+	require.Len(t, mailer.mails, 2)
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+	ps, users, mailer, user := newTestPasswordService(t)
+
+	// Step 1: a login gets a limited number of mails, further requests get the same answer without a mail
+	for i := 0; i < 5; i++ {
+		forgotPassword(t, ps, user.Login)
+	}
+	forgotPassword(t, ps, user.Login)
+	require.Len(t, mailer.mails, 5)
+
+	// Step 2: requests from an IP are limited across logins
+	require.NoError(t, users.Signup(context.Background(), &model.User{ID: uuid.New(), Login: "other@example.com", Password: []byte(testPassword)}, ""))
+	for i := 0; i < 5; i++ {
+		forgotPassword(t, ps, "nobody@example.com")
+	}
+	forgotPassword(t, ps, "other@example.com")
+	require.Len(t, mailer.mails, 5)
+
+	// Step 3: failed logins and reset requests are counted apart
+	_, _, err := users.GenerateTokens(context.Background(), user.Login, testPassword, "192.0.2.1")
+	require.NoError(t, err)
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	ps, users, mailer, user := newTestPasswordService(t)
+	const newPassword = "Battery-Staple-7"
+	access, refresh, err := users.GenerateTokens(ctx, user.Login, testPassword, "")
+	require.NoError(t, err)
+	keys := ps.keys.(*APIKeyService).rps
+	key := &model.APIKey{ID: uuid.New(), UserID: user.ID, Name: "batch", Prefix: "ak_first", Hash: []byte(uuid.NewString()),
+		CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, keys.Create(ctx, key))
+	forgotPassword(t, ps, user.Login)
+	older := mailer.resetToken(t)
+	forgotPassword(t, ps, user.Login)
+	token := mailer.resetToken(t)
+
+	// Step 1: unknown tokens and weak passwords are rejected, the latter leaves the token usable
+	require.ErrorIs(t, ps.ResetPassword(ctx, "unknown", newPassword), model.ErrInvalidResetToken)
+	require.ErrorIs(t, ps.ResetPassword(ctx, token, "short"), model.ErrWeakPassword)
+
+	// Step 2: the reset sets the password and revokes the sessions and the API keys of the user
+	require.NoError(t, ps.ResetPassword(ctx, token, newPassword))
+	_, _, err = users.GenerateTokens(ctx, user.Login, newPassword, "")
+	require.NoError(t, err)
+	_, _, err = users.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+	key, err = keys.GetByHash(ctx, key.Hash)
+	require.NoError(t, err)
+	require.NotNil(t, key.RevokedAt)
+
+	// Step 3: the token and the other outstanding tokens of the user are used up
+	require.ErrorIs(t, ps.ResetPassword(ctx, token, "Another-Pass-5"), model.ErrInvalidResetToken)
+	require.ErrorIs(t, ps.ResetPassword(ctx, older, "Another-Pass-5"), model.ErrInvalidResetToken)
+
+	// Step 4: an expired token is rejected
+	forgotPassword(t, ps, user.Login)
+	token = mailer.resetToken(t)
+	ps.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	require.ErrorIs(t, ps.ResetPassword(ctx, token, "Another-Pass-5"), model.ErrInvalidResetToken)
+}
+
+func TestResetPasswordUnlocks(t *testing.T) {
+	ctx := context.Background()
+	ps, users, mailer, user := newTestPasswordService(t)
+	now := time.Now()
+	users.throttle.now = func() time.Time { return now }
+	for i := 0; i < testLimits.Lockout; i++ {
+		_, _, _ = users.GenerateTokens(ctx, user.Login, "wrong", "")
+		now = now.Add(testLimits.BackoffMax)
+	}
+	_, _, err := users.GenerateTokens(ctx, user.Login, testPassword, "")
+	require.ErrorIs(t, err, model.ErrLoginThrottled)
+
+	forgotPassword(t, ps, user.Login)
+	require.NoError(t, ps.ResetPassword(ctx, mailer.resetToken(t), "Battery-Staple-7"))
+	_, _, err = users.GenerateTokens(ctx, user.Login, "Battery-Staple-7", "")
+	require.NoError(t, err)
+}
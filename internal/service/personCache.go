@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Cache policies of the person cache
+const (
+	CacheAside    = "cache_aside"
+	WriteThrough  = "write_through"
+	WriteBehind   = "write_behind"
+	CacheDisabled = "disabled"
+)
+
+// const for the person cache
+const (
+	writeBehindInterval = time.Second
+	writeBehindAttempts = 5
+	// writeBehindDeleteTimeout bounds the delete replacing a change which is given up
+	writeBehindDeleteTimeout = 2 * time.Second
+	// loadLockTTL bounds how long a crashed loader keeps others waiting
+	loadLockTTL = 5 * time.Second
+	// refreshAhead is how long before expiry a read triggers a background refresh
+	refreshAhead = 2 * time.Minute
+)
+
+// PersonCache is the caching strategy of PersonService. The service calls Stored and Removed
+// only after the change is committed to the primary store, a failed write never reaches the cache.
+// Cache failures are logged and never fail the request, the primary store stays the source of truth.
+type PersonCache interface {
+	// Get returns the cached person or nil on a miss, refresh reports that the entry expires soon
+	Get(ctx context.Context, id uuid.UUID) (person *model.Person, refresh bool)
+	// Lock takes the loading lock of a person shared by all instances, it returns false
+	// if another loader holds it
+	Lock(ctx context.Context, id uuid.UUID) (unlock func(), ok bool)
+	// Loaded is called with a person read from the primary store after a miss
+	Loaded(ctx context.Context, person *model.Person)
+	// Stored is called with a created or updated person
+	Stored(ctx context.Context, person *model.Person)
+	// Removed is called with a deleted, restored or purged person ID
+	Removed(ctx context.Context, id uuid.UUID)
+	// Query decodes the cached result of a query depending on the tags into dst,
+	// on a miss it returns the key to store the result under with StoreQuery
+	Query(ctx context.Context, hash string, dst interface{}, tags ...string) (key string, hit bool)
+	// StoreQuery caches a query result under the key returned by Query
+	StoreQuery(ctx context.Context, key string, result interface{})
+	// Invalidate is called with the tags of the query results a committed change affects
+	Invalidate(ctx context.Context, tags ...string)
+}
+
+// NewPersonCache returns the person cache of the given policy in front of rdb
+func NewPersonCache(policy string, rdb PersonRepositoryRedis) (PersonCache, error) {
+	switch policy {
+	case CacheAside:
+		return &CacheAsideCache{rdb: rdb}, nil
+	case WriteThrough:
+		return &WriteThroughCache{CacheAsideCache{rdb: rdb}}, nil
+	case WriteBehind:
+		return NewWriteBehindCache(rdb), nil
+	case CacheDisabled:
+		return NoCache{}, nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q (expected %q, %q, %q or %q)", policy, CacheAside, WriteThrough, WriteBehind, CacheDisabled)
+}
+
+// CacheAsideCache fills the cache on read misses and drops changed persons,
+// the next read loads them from the primary store
+type CacheAsideCache struct {
+	rdb PersonRepositoryRedis
+}
+
+// Get returns the cached person or nil on a miss, refresh reports that the entry expires soon
+func (c *CacheAsideCache) Get(ctx context.Context, id uuid.UUID) (person *model.Person, refresh bool) {
+	person, ttl, err := c.rdb.RedisGetWithTTL(ctx, id)
+	if err != nil || person == nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Debugf("RedisGetWithTTL: %v", err)
+		return nil, false
+	}
+	person.ID = id
+	return person, ttl > 0 && ttl < refreshAhead
+}
+
+// Lock takes the loading lock of a person, a failing lock doesn't keep the caller from loading
+func (c *CacheAsideCache) Lock(ctx context.Context, id uuid.UUID) (unlock func(), ok bool) {
+	token, ok, err := c.rdb.RedisLock(ctx, id, loadLockTTL)
+	if err != nil {
+		logCacheError(logrus.Fields{"id": id}, "RedisLock", err)
+		return func() {}, true
+	}
+	if !ok {
+		return nil, false
+	}
+	return func() {
+		err := c.rdb.RedisUnlock(ctx, id, token)
+		if err != nil {
+			logCacheError(logrus.Fields{"id": id}, "RedisUnlock", err)
+		}
+	}, true
+}
+
+// Loaded caches a person read from the primary store
+func (c *CacheAsideCache) Loaded(ctx context.Context, person *model.Person) {
+	err := c.rdb.RedisSetByID(ctx, person)
+	if err != nil {
+		logCacheError(logrus.Fields{"id": person.ID}, "RedisSetByID", err)
+	}
+}
+
+// Stored drops the changed person from the cache
+func (c *CacheAsideCache) Stored(ctx context.Context, person *model.Person) {
+	c.Removed(ctx, person.ID)
+}
+
+// Removed drops the person from the cache
+func (c *CacheAsideCache) Removed(ctx context.Context, id uuid.UUID) {
+	err := c.rdb.RedisDeleteByID(ctx, id)
+	if err != nil {
+		logCacheError(logrus.Fields{"id": id}, "RedisDeleteByID", err)
+	}
+}
+
+// Query decodes the cached result of a query into dst, the key it returns pins the current
+// tag versions, so a result read before a change is stored where nobody reads it after the change
+func (c *CacheAsideCache) Query(ctx context.Context, hash string, dst interface{}, tags ...string) (key string, hit bool) {
+	key, err := c.rdb.RedisQueryKey(ctx, hash, tags...)
+	if err != nil {
+		logCacheError(logrus.Fields{"hash": hash}, "RedisQueryKey", err)
+		return "", false
+	}
+	val, err := c.rdb.RedisGetQuery(ctx, key)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"key": key}).Debugf("RedisGetQuery: %v", err)
+		return key, false
+	}
+	err = json.Unmarshal(val, dst)
+	if err != nil {
+		logCacheError(logrus.Fields{"key": key}, "Unmarshal", err)
+		return key, false
+	}
+	return key, true
+}
+
+// StoreQuery caches a query result, an empty key means the query can't be cached
+func (c *CacheAsideCache) StoreQuery(ctx context.Context, key string, result interface{}) {
+	if key == "" {
+		return
+	}
+	val, err := json.Marshal(result)
+	if err != nil {
+		logCacheError(logrus.Fields{"key": key}, "Marshal", err)
+		return
+	}
+	err = c.rdb.RedisSetQuery(ctx, key, val)
+	if err != nil {
+		logCacheError(logrus.Fields{"key": key}, "RedisSetQuery", err)
+	}
+}
+
+// Invalidate bumps the tags, query results depending on them expire within QueryTTL
+// if that fails
+func (c *CacheAsideCache) Invalidate(ctx context.Context, tags ...string) {
+	err := c.rdb.RedisInvalidateTags(ctx, tags...)
+	if err != nil {
+		logCacheError(logrus.Fields{"tags": tags}, "RedisInvalidateTags", err)
+	}
+}
+
+// WriteThroughCache caches changed persons right after the commit,
+// a person it fails to cache is dropped so the stale entry is not served
+type WriteThroughCache struct {
+	CacheAsideCache
+}
+
+// Stored caches the changed person, it drops the stale entry if that fails
+func (c *WriteThroughCache) Stored(ctx context.Context, person *model.Person) {
+	err := c.rdb.RedisSetByID(ctx, person)
+	if err != nil {
+		logCacheError(logrus.Fields{"id": person.ID}, "RedisSetByID", err)
+		c.Removed(ctx, person.ID)
+	}
+}
+
+// WriteBehindCache queues committed changes and writes them to the cache in the background.
+// Queued changes are served from memory until written, so this instance never reads a stale entry.
+// Changes of the same person are coalesced, a change failing writeBehindAttempts times is given up
+// and replaced by deleting the cached person, a delete which fails too stays queued until it succeeds.
+type WriteBehindCache struct {
+	CacheAsideCache
+	mu       sync.Mutex
+	pending  map[uuid.UUID]*model.Person
+	attempts map[uuid.UUID]int
+	failures uint64
+	gaveUp   uint64
+	wake     chan struct{}
+}
+
+// NewWriteBehindCache is a constructor for WriteBehindCache, call Run to start writing
+func NewWriteBehindCache(rdb PersonRepositoryRedis) *WriteBehindCache {
+	return &WriteBehindCache{
+		CacheAsideCache: CacheAsideCache{rdb: rdb},
+		pending:         make(map[uuid.UUID]*model.Person),
+		attempts:        make(map[uuid.UUID]int),
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// Get returns the queued or cached person or nil on a miss
+func (c *WriteBehindCache) Get(ctx context.Context, id uuid.UUID) (person *model.Person, refresh bool) {
+	c.mu.Lock()
+	person, ok := c.pending[id]
+	c.mu.Unlock()
+	if ok {
+		if person == nil {
+			return nil, false
+		}
+		res := *person
+		return &res, false
+	}
+	return c.CacheAsideCache.Get(ctx, id)
+}
+
+// Loaded caches a person read from the primary store unless a change of it is queued
+func (c *WriteBehindCache) Loaded(ctx context.Context, person *model.Person) {
+	c.mu.Lock()
+	_, ok := c.pending[person.ID]
+	c.mu.Unlock()
+	if !ok {
+		c.CacheAsideCache.Loaded(ctx, person)
+	}
+}
+
+// Stored queues the changed person
+func (c *WriteBehindCache) Stored(_ context.Context, person *model.Person) {
+	res := *person
+	c.enqueue(person.ID, &res)
+}
+
+// Removed queues dropping the person
+func (c *WriteBehindCache) Removed(_ context.Context, id uuid.UUID) {
+	c.enqueue(id, nil)
+}
+
+// Run writes queued changes until ctx is done, then flushes what is left
+func (c *WriteBehindCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(writeBehindInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.Flush(context.Background())
+			return
+		case <-c.wake:
+		case <-ticker.C:
+		}
+		c.Flush(ctx)
+	}
+}
+
+// Flush writes the queued changes and returns the number of changes still queued
+func (c *WriteBehindCache) Flush(ctx context.Context) int {
+	c.mu.Lock()
+	batch := make(map[uuid.UUID]*model.Person, len(c.pending))
+	for id, person := range c.pending {
+		batch[id] = person
+	}
+	c.mu.Unlock()
+
+	for id, person := range batch {
+		var err error
+		if person == nil {
+			err = c.rdb.RedisDeleteByID(ctx, id)
+		} else {
+			err = c.rdb.RedisSetByID(ctx, person)
+		}
+		c.written(id, person, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// enqueue replaces the queued change of the person and wakes the writer up
+func (c *WriteBehindCache) enqueue(id uuid.UUID, person *model.Person) {
+	c.mu.Lock()
+	c.pending[id] = person
+	delete(c.attempts, id)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns the counters of the write-behind queue
+func (c *WriteBehindCache) Stats() *model.WriteBehindStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &model.WriteBehindStats{Queued: len(c.pending), Failures: c.failures, GaveUp: c.gaveUp}
+	for _, attempt := range c.attempts {
+		if attempt > writeBehindAttempts {
+			stats.Stuck++
+		}
+	}
+	return stats
+}
+
+// written dequeues a written change unless a newer one was queued meanwhile. A change failing
+// writeBehindAttempts times is given up: a delete of the cached person is queued in its place
+// and tried right away, so the stale entry is neither kept nor served by this instance
+func (c *WriteBehindCache) written(id uuid.UUID, person *model.Person, err error) {
+	c.mu.Lock()
+	if c.pending[id] != person {
+		c.mu.Unlock()
+		return
+	}
+	if err == nil {
+		delete(c.pending, id)
+		delete(c.attempts, id)
+		c.mu.Unlock()
+		return
+	}
+	c.failures++
+	c.attempts[id]++
+	attempt := c.attempts[id]
+	if attempt != writeBehindAttempts {
+		c.mu.Unlock()
+		if attempt < writeBehindAttempts {
+			logrus.WithFields(logrus.Fields{"id": id, "attempt": attempt}).Errorf("write-behind: %v", err)
+		}
+		return
+	}
+	c.gaveUp++
+	c.pending[id] = nil
+	c.mu.Unlock()
+	logrus.WithFields(logrus.Fields{"id": id}).Errorf("write-behind gave up, deleting the cached person: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), writeBehindDeleteTimeout)
+	defer cancel()
+	err = c.rdb.RedisDeleteByID(ctx, id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"id": id}).Errorf("write-behind: the delete stays queued: %v", err)
+	}
+	c.written(id, nil, err)
+}
+
+// NoCache is the disabled cache, every read goes to the primary store
+type NoCache struct{}
+
+// Get always misses
+func (NoCache) Get(context.Context, uuid.UUID) (person *model.Person, refresh bool) {
+	return nil, false
+}
+
+// Lock always succeeds, there is nothing to protect
+func (NoCache) Lock(context.Context, uuid.UUID) (unlock func(), ok bool) { return func() {}, true }
+
+// Loaded does nothing
+func (NoCache) Loaded(context.Context, *model.Person) {}
+
+// Stored does nothing
+func (NoCache) Stored(context.Context, *model.Person) {}
+
+// Removed does nothing
+func (NoCache) Removed(context.Context, uuid.UUID) {}
+
+// Query always misses
+func (NoCache) Query(context.Context, string, interface{}, ...string) (key string, hit bool) {
+	return "", false
+}
+
+// StoreQuery does nothing
+func (NoCache) StoreQuery(context.Context, string, interface{}) {}
+
+// Invalidate does nothing
+func (NoCache) Invalidate(context.Context, ...string) {}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var errCacheDown = errors.New("cache is down")
+
+// flakyRedis is a memory cache whose writes fail while down is set, setFails fails only sets
+type flakyRedis struct {
+	*repository.MemoryCacheConnection
+	down     bool
+	setFails bool
+}
+
+func (rdb *flakyRedis) RedisSetByID(ctx context.Context, entity *model.Person) error {
+	if rdb.down || rdb.setFails {
+		return errCacheDown
+	}
+	return rdb.MemoryCacheConnection.RedisSetByID(ctx, entity)
+}
+
+func (rdb *flakyRedis) RedisDeleteByID(ctx context.Context, id uuid.UUID) error {
+	if rdb.down {
+		return errCacheDown
+	}
+	return rdb.MemoryCacheConnection.RedisDeleteByID(ctx, id)
+}
+
+// newCachedService returns a service over memory repositories with the given cache policy
+func newCachedService(t *testing.T, policy string) (*PersonService, PersonCache, *flakyRedis) {
+	rdb := &flakyRedis{MemoryCacheConnection: repository.NewMemoryCacheConnection()}
+	cache, err := NewPersonCache(policy, rdb)
+	require.NoError(t, err)
+	srv := NewPersonService(repository.NewMemoryConnection(), cache, repository.NewAuditMemoryConnection(),
+		repository.NewOutboxMemoryConnection(), repository.NewMemoryTxManager())
+	return srv, cache, rdb
+}
+
+// cached returns the person stored in the cache backend or nil
+func cached(rdb *flakyRedis, id uuid.UUID) *model.Person {
+	person, err := rdb.MemoryCacheConnection.RedisGetByID(context.Background(), id)
+	if err != nil {
+		return nil
+	}
+	return person
+}
+
+// createCached creates a person and reads it once so it is cached
+func createCached(t *testing.T, srv *PersonService) *model.Person {
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err := srv.Create(context.Background(), person)
+	require.NoError(t, err)
+	_, err = srv.GetByID(context.Background(), person.ID)
+	require.NoError(t, err)
+	return person
+}
+
+func TestNewPersonCacheUnknown(t *testing.T) {
+	_, err := NewPersonCache("write_around", repository.NewMemoryCacheConnection())
+	require.Error(t, err)
+}
+
+func TestCacheAside(t *testing.T) {
+	ctx := context.Background()
+	srv, _, rdb := newCachedService(t, CacheAside)
+	person := createCached(t, srv)
+	require.NotNil(t, cached(rdb, person.ID))
+
+	// Step 1: an update drops the entry after the commit
+	update := &model.Person{Name: "Updated", Age: 21, Version: person.Version}
+	_, err := srv.Update(ctx, person.ID, update)
+	require.NoError(t, err)
+	require.Nil(t, cached(rdb, person.ID))
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", res.Name)
+
+	// Step 2: a failed update leaves the entry alone
+	_, err = srv.Update(ctx, person.ID, &model.Person{Name: "Stale", Age: 22, Version: person.Version})
+	require.ErrorIs(t, err, model.ErrVersionConflict)
+	require.Equal(t, "Updated", cached(rdb, person.ID).Name)
+
+	// Step 3: deleting works whether the person is cached or not
+	require.NoError(t, rdb.MemoryCacheConnection.RedisDeleteByID(ctx, person.ID))
+	_, err = srv.Delete(ctx, person.ID, update.Version)
+	require.NoError(t, err)
+	_, err = srv.GetByID(ctx, person.ID)
+	require.Error(t, err)
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	srv, cache, rdb := newCachedService(t, WriteThrough)
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err := srv.Create(ctx, person)
+	require.NoError(t, err)
+	require.Equal(t, person.Version, cached(rdb, person.ID).Version)
+
+	// Step 1: the committed version is written to the cache
+	update := &model.Person{Name: "Updated", Age: 21, Version: person.Version}
+	_, err = srv.Update(ctx, person.ID, update)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", cached(rdb, person.ID).Name)
+
+	// Step 2: a failed update leaves the cache alone
+	_, err = srv.Update(ctx, person.ID, &model.Person{Name: "Stale", Age: 22, Version: person.Version})
+	require.Error(t, err)
+	require.Equal(t, update.Version, cached(rdb, person.ID).Version)
+
+	// Step 3: a change reaching the cache after a newer one doesn't replace it
+	older := *update
+	older.Name, older.Version = "Older", update.Version-1
+	cache.Stored(ctx, &older)
+	require.Equal(t, "Updated", cached(rdb, person.ID).Name)
+
+	// Step 4: a cache outage doesn't fail the write
+	rdb.down = true
+	_, err = srv.Delete(ctx, person.ID, update.Version)
+	require.NoError(t, err)
+}
+
+func TestWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	srv, cache, rdb := newCachedService(t, WriteBehind)
+	writeBehind := cache.(*WriteBehindCache)
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err := srv.Create(ctx, person)
+	require.NoError(t, err)
+
+	// Step 1: a queued change is served before it is written
+	require.Nil(t, cached(rdb, person.ID))
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, person.Version, res.Version)
+	require.Zero(t, writeBehind.Flush(ctx))
+	require.Equal(t, person.Version, cached(rdb, person.ID).Version)
+
+	// Step 2: a failing change is retried and then given up, the cached person is deleted instead
+	update := &model.Person{Name: "Updated", Age: 21, Version: person.Version}
+	_, err = srv.Update(ctx, person.ID, update)
+	require.NoError(t, err)
+	rdb.setFails = true
+	for i := 1; i < writeBehindAttempts; i++ {
+		require.Equal(t, 1, writeBehind.Flush(ctx))
+	}
+	require.NotNil(t, cached(rdb, person.ID))
+	require.Zero(t, writeBehind.Flush(ctx))
+	require.Nil(t, cached(rdb, person.ID))
+	require.Equal(t, &model.WriteBehindStats{Failures: writeBehindAttempts, GaveUp: 1}, writeBehind.Stats())
+	rdb.setFails = false
+	res, err = srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", res.Name)
+
+	// Step 3: a removal hides the cached entry until it is written
+	_, err = srv.Delete(ctx, person.ID, update.Version)
+	require.NoError(t, err)
+	require.NotNil(t, cached(rdb, person.ID))
+	_, err = srv.GetByID(ctx, person.ID)
+	require.Error(t, err)
+	require.Zero(t, writeBehind.Flush(ctx))
+	require.Nil(t, cached(rdb, person.ID))
+}
+
+func TestWriteBehindStuckDelete(t *testing.T) {
+	ctx := context.Background()
+	srv, cache, rdb := newCachedService(t, WriteBehind)
+	writeBehind := cache.(*WriteBehindCache)
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err := srv.Create(ctx, person)
+	require.NoError(t, err)
+	require.Zero(t, writeBehind.Flush(ctx))
+
+	// Step 1: a delete which keeps failing is not given up, it stays queued and hides the stale entry
+	_, err = srv.Delete(ctx, person.ID, person.Version)
+	require.NoError(t, err)
+	rdb.down = true
+	for i := 0; i < 2*writeBehindAttempts; i++ {
+		require.Equal(t, 1, writeBehind.Flush(ctx))
+	}
+	require.NotNil(t, cached(rdb, person.ID))
+	stats := writeBehind.Stats()
+	require.Equal(t, 1, stats.Queued)
+	require.Equal(t, 1, stats.Stuck)
+	require.Equal(t, uint64(1), stats.GaveUp)
+	_, err = srv.GetByID(ctx, person.ID)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// Step 2: the delete goes through once the cache is back
+	rdb.down = false
+	require.Zero(t, writeBehind.Flush(ctx))
+	require.Nil(t, cached(rdb, person.ID))
+	require.Equal(t, 0, writeBehind.Stats().Stuck)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	srv, _, rdb := newCachedService(t, CacheDisabled)
+	person := createCached(t, srv)
+	require.Nil(t, cached(rdb, person.ID))
+	_, err := srv.Update(ctx, person.ID, &model.Person{Name: "Updated", Age: 21, Version: person.Version})
+	require.NoError(t, err)
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", res.Name)
+}
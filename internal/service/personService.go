@@ -3,11 +3,35 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
 	"github.com/eugenshima/myapp/internal/model"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// const for loading persons missing from the cache
+const (
+	loadWait       = 500 * time.Millisecond
+	loadPoll       = 25 * time.Millisecond
+	loadTimeout    = 5 * time.Second
+	refreshTimeout = 5 * time.Second
+)
+
+// Cache tags of person query results
+const (
+	// personListTag is the tag of the lists of live persons
+	personListTag = "person:list"
+	// personTrashTag is the tag of the list of soft deleted persons
+	personTrashTag = "person:trash"
 )
 
 //go:generate mockgen -source=personService.go -destination=mocks/mock.go
@@ -16,9 +40,18 @@ import (
 type PersonRepositoryPsql interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*model.Person, error)
 	GetAll(ctx context.Context) ([]*model.Person, error)
-	Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	GetPage(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error)
+	Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error)
 	Create(ctx context.Context, entity *model.Person) (uuid.UUID, error)
 	Update(ctx context.Context, uuidString uuid.UUID, entity *model.Person) (uuid.UUID, error)
+	GetDeleted(ctx context.Context) ([]*model.Person, error)
+	Restore(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	Purge(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error)
+	GetByIDs(ctx context.Context, ids []uuid.UUID) ([]*model.Person, error)
+	CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error)
+	DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error)
+	GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.Person, error)
 }
 
 // PersonRepositoryRedis interface, which contains repository methods
@@ -26,78 +59,534 @@ type PersonRepositoryRedis interface {
 	RedisGetByID(ctx context.Context, id uuid.UUID) (*model.Person, error)
 	RedisSetByID(ctx context.Context, entity *model.Person) error
 	RedisDeleteByID(ctx context.Context, id uuid.UUID) error
+	RedisGetWithTTL(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error)
+	RedisLock(ctx context.Context, id uuid.UUID, ttl time.Duration) (string, bool, error)
+	RedisUnlock(ctx context.Context, id uuid.UUID, token string) error
+	RedisQueryKey(ctx context.Context, hash string, tags ...string) (string, error)
+	RedisGetQuery(ctx context.Context, key string) ([]byte, error)
+	RedisSetQuery(ctx context.Context, key string, val []byte) error
+	RedisInvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// AuditRepository interface, which contains audit trail repository methods
+type AuditRepository interface {
+	Add(ctx context.Context, record *model.AuditRecord) error
+	Find(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error)
+}
+
+// OutboxRepository interface, which contains the outbox method used by the service
+type OutboxRepository interface {
+	Add(ctx context.Context, events ...*model.Event) error
+}
+
+// TxManager interface runs functions in a transaction of the primary store,
+// the repositories join it through the context
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // PersonService is a struct that contains a reference to the repository interface
 type PersonService struct {
-	rps PersonRepositoryPsql
-	rdb PersonRepositoryRedis
+	rps    PersonRepositoryPsql
+	cache  PersonCache
+	audit  AuditRepository
+	outbox OutboxRepository
+	txm    TxManager
+	loads  singleflight.Group
 }
 
 // NewPersonService is a constructor for the PersonServiceImpl struct
-func NewPersonService(rps PersonRepositoryPsql, rdb PersonRepositoryRedis) *PersonService {
+func NewPersonService(rps PersonRepositoryPsql, cache PersonCache, audit AuditRepository, outbox OutboxRepository, txm TxManager) *PersonService {
 	return &PersonService{
-		rps: rps,
-		rdb: rdb,
+		rps:    rps,
+		cache:  cache,
+		audit:  audit,
+		outbox: outbox,
+		txm:    txm,
 	}
 }
 
 // GetByID is a service function which interacts with PostgreSQL in repository level
 func (db *PersonService) GetByID(ctx context.Context, id uuid.UUID) (*model.Person, error) {
-	res, err := db.rdb.RedisGetByID(ctx, id)
-	str := fmt.Errorf("RedisGetByID: %w", err)
+	res, refresh := db.cache.Get(ctx, id)
 	if res != nil {
+		if refresh {
+			go db.refresh(id)
+		}
 		return res, nil
 	}
-	res, err = db.rps.GetByID(ctx, id)
+	return db.load(ctx, id)
+}
+
+// load reads a person missing from the cache and caches it. Concurrent loads of a person
+// share a single read in this process, and only the holder of the cache lock reads it
+// across processes while the others wait for it to show up in the cache.
+// The shared read runs apart from the cancellation of the caller who started it, within loadTimeout,
+// while every caller stops waiting for it when its own context is done
+func (db *PersonService) load(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	loaded := db.loads.DoChan(id.String(), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, loadTimeout)
+		defer cancel()
+		unlock, ok := db.cache.Lock(ctx, id)
+		if ok {
+			defer unlock()
+		} else if person := db.awaitLoad(ctx, id); person != nil {
+			return person, nil
+		}
+		person, err := db.rps.GetByID(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("GetByID: %w", err)
+		}
+		db.cache.Loaded(ctx, person)
+		return person, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("GetByID: %w", ctx.Err())
+	case res := <-loaded:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		person := *res.Val.(*model.Person)
+		return &person, nil
+	}
+}
+
+// detachedContext keeps the values of its parent but neither its deadline nor its cancellation
+type detachedContext struct {
+	context.Context
+}
+
+// Deadline implements context.Context
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context
+func (detachedContext) Err() error {
+	return nil
+}
+
+// awaitLoad polls the cache while another process loads the person,
+// it returns nil if the person doesn't show up in time
+func (db *PersonService) awaitLoad(ctx context.Context, id uuid.UUID) *model.Person {
+	ticker := time.NewTicker(loadPoll)
+	defer ticker.Stop()
+	timeout := time.NewTimer(loadWait)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout.C:
+			return nil
+		case <-ticker.C:
+			if person, _ := db.cache.Get(ctx, id); person != nil {
+				return person
+			}
+		}
+	}
+}
+
+// refresh reloads a person whose cache entry expires soon, so readers don't miss it,
+// it does nothing while another refresh or load of the person holds the cache lock
+func (db *PersonService) refresh(id uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	_, _, _ = db.loads.Do("refresh:"+id.String(), func() (interface{}, error) {
+		unlock, ok := db.cache.Lock(ctx, id)
+		if !ok {
+			return nil, nil
+		}
+		defer unlock()
+		person, err := db.rps.GetByID(ctx, id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"id": id}).Errorf("refresh: GetByID: %v", err)
+			return nil, nil
+		}
+		db.cache.Loaded(ctx, person)
+		return nil, nil
+	})
+}
+
+// GetAll is a service function which returns a filtered and sorted page of persons,
+// pages are cached per query until a person changes
+func (db *PersonService) GetAll(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	hash, err := queryHash(query)
 	if err != nil {
-		return nil, fmt.Errorf("error getting person: %v + %v", err, str)
+		return nil, err
 	}
-	err = db.rdb.RedisSetByID(ctx, res)
+	page := &model.PersonPage{}
+	key, hit := db.cache.Query(ctx, hash, page, personListTag)
+	if hit {
+		return page, nil
+	}
+	page, err = db.rps.GetPage(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("RedisSetByID: %w", err)
+		return nil, err
 	}
-	return res, nil
+	db.cache.StoreQuery(ctx, key, page)
+	return page, nil
 }
 
-// GetAll is a service function which interacts with repository level
-func (db *PersonService) GetAll(ctx context.Context) ([]*model.Person, error) {
-	return db.rps.GetAll(ctx)
+// queryHash returns the hash of a query, which identifies its cached result
+func queryHash(query interface{}) (string, error) {
+	raw, err := json.Marshal(query)
+	if err != nil {
+		return "", fmt.Errorf("Marshal: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-// Delete is a service function which interacts with repository level
-func (db *PersonService) Delete(ctx context.Context, uuidString uuid.UUID) (uuid.UUID, error) {
-	err := db.rdb.RedisDeleteByID(ctx, uuidString)
+// Delete is a service function which soft deletes the given version of a person
+func (db *PersonService) Delete(ctx context.Context, uuidString uuid.UUID, version int64) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		before, err := db.rps.GetByID(ctx, uuidString)
+		if err != nil {
+			return fmt.Errorf("GetByID: %w", err)
+		}
+		id, err = db.rps.Delete(ctx, uuidString, version)
+		if err != nil {
+			return fmt.Errorf("Delete: %w", err)
+		}
+		return db.changed(ctx, model.AuditDelete, id, before, nil)
+	})
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("RedisDeleteByID: %w", err)
+		return uuid.Nil, err
 	}
-	return db.rps.Delete(ctx, uuidString)
+	db.cache.Removed(ctx, id)
+	db.cache.Invalidate(ctx, personListTag, personTrashTag)
+	return id, nil
 }
 
 // Create is a service function which interacts with repository level
 func (db *PersonService) Create(ctx context.Context, entity *model.Person) (uuid.UUID, error) {
-	id, err := db.rps.Create(ctx, entity)
+	var id uuid.UUID
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = db.rps.Create(ctx, entity)
+		if err != nil {
+			return fmt.Errorf("Create: %w", err)
+		}
+		after := *entity
+		return db.changed(ctx, model.AuditCreate, id, nil, &after)
+	})
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("Create: %w", err)
+		return uuid.Nil, err
 	}
-	// Creating cache
-	err = db.rdb.RedisSetByID(ctx, entity)
+	db.cache.Stored(ctx, entity)
+	db.cache.Invalidate(ctx, personListTag)
+	return id, nil
+}
+
+// Update is a service function which updates the person if entity.Version is still current
+func (db *PersonService) Update(ctx context.Context, id uuid.UUID, entity *model.Person) (uuid.UUID, error) {
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		before, err := db.rps.GetByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("GetByID: %w", err)
+		}
+		entity.ID = id
+		id, err = db.rps.Update(ctx, id, entity)
+		if err != nil {
+			return fmt.Errorf("Update: %w", err)
+		}
+		after := *entity
+		return db.changed(ctx, model.AuditUpdate, id, before, &after)
+	})
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("RedisSetByID: %w", err)
+		return uuid.Nil, err
 	}
-	return id, err
+	db.cache.Stored(ctx, entity)
+	db.cache.Invalidate(ctx, personListTag)
+	return id, nil
 }
 
-// Update is a service function which interacts with repository level
-func (db *PersonService) Update(ctx context.Context, id uuid.UUID, entity *model.Person) (uuid.UUID, error) {
-	// Overwriting cache
-	err := db.rdb.RedisDeleteByID(ctx, id)
+// GetDeleted is a service function which returns soft deleted persons
+func (db *PersonService) GetDeleted(ctx context.Context) ([]*model.Person, error) {
+	var persons []*model.Person
+	key, hit := db.cache.Query(ctx, "deleted", &persons, personTrashTag)
+	if hit {
+		return persons, nil
+	}
+	persons, err := db.rps.GetDeleted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.StoreQuery(ctx, key, persons)
+	return persons, nil
+}
+
+// Restore is a service function which brings a soft deleted person back
+func (db *PersonService) Restore(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = db.rps.Restore(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Restore: %w", err)
+		}
+		after, err := db.rps.GetByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("GetByID: %w", err)
+		}
+		return db.changed(ctx, model.AuditRestore, id, nil, after)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	db.cache.Removed(ctx, id)
+	db.cache.Invalidate(ctx, personListTag, personTrashTag)
+	return id, nil
+}
+
+// Purge is a service function which permanently deletes a soft deleted person
+func (db *PersonService) Purge(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = db.rps.Purge(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Purge: %w", err)
+		}
+		return db.changed(ctx, model.AuditPurge, id, nil, nil)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	db.cache.Removed(ctx, id)
+	db.cache.Invalidate(ctx, personTrashTag)
+	return id, nil
+}
+
+// CreateMany is a service function which creates persons in bulk and returns an error per person,
+// in atomic mode either all persons are created or none
+func (db *PersonService) CreateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	errs, err := db.bulk(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		errs, err := db.rps.CreateMany(ctx, persons, atomic)
+		if err != nil {
+			return nil, fmt.Errorf("CreateMany: %w", err)
+		}
+		return errs, db.bulkChanged(ctx, errs, atomic, func(i int) error {
+			after := *persons[i]
+			return db.changed(ctx, model.AuditCreate, persons[i].ID, nil, &after)
+		})
+	})
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("RedisDeleteByID: %w", err)
+		return nil, err
 	}
-	err = db.rdb.RedisSetByID(ctx, entity)
+	for i, person := range persons {
+		if errs[i] == nil {
+			db.cache.Stored(ctx, person)
+		}
+	}
+	db.cache.Invalidate(ctx, personListTag)
+	return errs, nil
+}
+
+// UpdateMany is a service function which updates persons in bulk and returns an error per person,
+// in atomic mode either all persons are updated or none
+func (db *PersonService) UpdateMany(ctx context.Context, persons []*model.Person, atomic bool) ([]error, error) {
+	ids := make([]uuid.UUID, len(persons))
+	for i, person := range persons {
+		ids[i] = person.ID
+	}
+	errs, err := db.bulk(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		before, err := db.snapshots(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		errs, err := db.rps.UpdateMany(ctx, persons, atomic)
+		if err != nil {
+			return nil, fmt.Errorf("UpdateMany: %w", err)
+		}
+		return errs, db.bulkChanged(ctx, errs, atomic, func(i int) error {
+			after := *persons[i]
+			return db.changed(ctx, model.AuditUpdate, persons[i].ID, before[persons[i].ID], &after)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, person := range persons {
+		if errs[i] == nil {
+			db.cache.Stored(ctx, person)
+		}
+	}
+	db.cache.Invalidate(ctx, personListTag)
+	return errs, nil
+}
+
+// DeleteMany is a service function which soft deletes persons in bulk and returns an error per person,
+// in atomic mode either all persons are deleted or none
+func (db *PersonService) DeleteMany(ctx context.Context, items []model.PersonVersion, atomic bool) ([]error, error) {
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	errs, err := db.bulk(ctx, atomic, func(ctx context.Context) ([]error, error) {
+		before, err := db.snapshots(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		errs, err := db.rps.DeleteMany(ctx, items, atomic)
+		if err != nil {
+			return nil, fmt.Errorf("DeleteMany: %w", err)
+		}
+		return errs, db.bulkChanged(ctx, errs, atomic, func(i int) error {
+			return db.changed(ctx, model.AuditDelete, items[i].ID, before[items[i].ID], nil)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if errs[i] == nil {
+			db.cache.Removed(ctx, item.ID)
+		}
+	}
+	db.cache.Invalidate(ctx, personListTag, personTrashTag)
+	return errs, nil
+}
+
+// bulk runs a bulk write in a transaction and returns an error per item, a failed atomic
+// write rolls the transaction back and every item gets an error
+func (db *PersonService) bulk(ctx context.Context, atomic bool, write func(ctx context.Context) ([]error, error)) ([]error, error) {
+	var errs []error
+	err := db.txm.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		errs, err = write(ctx)
+		return err
+	})
+	if err != nil && !errors.Is(err, model.ErrBulkAborted) {
+		return nil, err
+	}
+	return bulkErrors(errs, atomic), nil
+}
+
+// bulkChanged records the changes of the applied items, it returns ErrBulkAborted
+// for a failed atomic write to roll its transaction back
+func (db *PersonService) bulkChanged(ctx context.Context, errs []error, atomic bool, changed func(i int) error) error {
+	for _, err := range errs {
+		if err != nil && atomic {
+			return model.ErrBulkAborted
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+		err = changed(i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshots returns the stored persons with the given IDs by ID
+func (db *PersonService) snapshots(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*model.Person, error) {
+	persons, err := db.rps.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("GetByIDs: %w", err)
+	}
+	res := make(map[uuid.UUID]*model.Person, len(persons))
+	for _, person := range persons {
+		res[person.ID] = person
+	}
+	return res, nil
+}
+
+// bulkErrors marks every item of a failed atomic request, the applied ones get ErrBulkAborted
+func bulkErrors(errs []error, atomic bool) []error {
+	if !atomic {
+		return errs
+	}
+	aborted := false
+	for _, err := range errs {
+		aborted = aborted || err != nil
+	}
+	for i := range errs {
+		if aborted && errs[i] == nil {
+			errs[i] = model.ErrBulkAborted
+		}
+	}
+	return errs
+}
+
+// GetAudit is a service function which returns the audit trail matching the query
+func (db *PersonService) GetAudit(ctx context.Context, query *model.AuditQuery) ([]*model.AuditRecord, error) {
+	return db.audit.Find(ctx, query)
+}
+
+// changed writes the audit record and the outbox event of a change,
+// called inside the transaction of the change
+func (db *PersonService) changed(ctx context.Context, action string, id uuid.UUID, before, after *model.Person) error {
+	err := db.record(ctx, action, id, before, after)
+	if err != nil {
+		return err
+	}
+	event, err := personEvent(action, id, before, after)
+	if err != nil || event == nil {
+		return err
+	}
+	err = db.outbox.Add(ctx, event)
+	if err != nil {
+		return fmt.Errorf("Add: %w", err)
+	}
+	return nil
+}
+
+// personEvent returns the outbox event of a change, purging a deleted person publishes nothing
+func personEvent(action string, id uuid.UUID, before, after *model.Person) (*model.Event, error) {
+	var eventType string
+	switch action {
+	case model.AuditCreate:
+		eventType = model.EventPersonCreated
+	case model.AuditUpdate, model.AuditRestore:
+		eventType = model.EventPersonUpdated
+	case model.AuditDelete:
+		eventType = model.EventPersonDeleted
+	default:
+		return nil, nil
+	}
+	state := after
+	if state == nil {
+		state = before
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	return &model.Event{
+		ID:          uuid.New(),
+		Type:        eventType,
+		AggregateID: id,
+		Payload:     payload,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// record writes an audit record of the change on behalf of the request actor
+func (db *PersonService) record(ctx context.Context, action string, id uuid.UUID, before, after *model.Person) error {
+	actor, _ := mdlwr.ActorFromContext(ctx)
+	err := db.audit.Add(ctx, &model.AuditRecord{
+		ID:        uuid.New(),
+		PersonID:  id,
+		ActorID:   actor.ID,
+		ActorRole: actor.Role,
+		Action:    action,
+		Before:    before,
+		After:     after,
+		CreatedAt: time.Now().UTC(),
+	})
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("RedisSetByID: %w", err)
+		return fmt.Errorf("Add: %w", err)
 	}
-	return db.rps.Update(ctx, id, entity)
+	return nil
 }
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts and slows down reads of single persons, a read fails when its context is done
+type countingRepository struct {
+	*repository.MemoryConnection
+	reads int32
+	delay time.Duration
+}
+
+func (rps *countingRepository) GetByID(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	atomic.AddInt32(&rps.reads, 1)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(rps.delay):
+	}
+	return rps.MemoryConnection.GetByID(ctx, id)
+}
+
+// expiringRedis is a memory cache reporting every entry as expiring in ttl
+type expiringRedis struct {
+	*repository.MemoryCacheConnection
+	ttl time.Duration
+}
+
+func (rdb *expiringRedis) RedisGetWithTTL(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	person, _, err := rdb.MemoryCacheConnection.RedisGetWithTTL(ctx, id)
+	return person, rdb.ttl, err
+}
+
+// newLoadingService returns a cache-aside service over a counting repository with a stored person
+func newLoadingService(t *testing.T, rdb PersonRepositoryRedis) (*PersonService, *countingRepository, *model.Person) {
+	rps := &countingRepository{MemoryConnection: repository.NewMemoryConnection(), delay: 50 * time.Millisecond}
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err := rps.Create(context.Background(), person)
+	require.NoError(t, err)
+	cache, err := NewPersonCache(CacheAside, rdb)
+	require.NoError(t, err)
+	srv := NewPersonService(rps, cache, repository.NewAuditMemoryConnection(),
+		repository.NewOutboxMemoryConnection(), repository.NewMemoryTxManager())
+	return srv, rps, person
+}
+
+func TestGetByIDCoalescesMisses(t *testing.T) {
+	srv, rps, person := newLoadingService(t, repository.NewMemoryCacheConnection())
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := srv.GetByID(context.Background(), person.ID)
+			require.NoError(t, err)
+			require.Equal(t, person.Name, res.Name)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&rps.reads))
+}
+
+func TestGetByIDFirstCallerCanceled(t *testing.T) {
+	srv, rps, person := newLoadingService(t, repository.NewMemoryCacheConnection())
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := srv.GetByID(ctx, person.ID)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// Step 1: the caller who started the read gives up, the others keep waiting for it
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := srv.GetByID(context.Background(), person.ID)
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-first, context.Canceled)
+	// Step 2: the shared read completes for the callers still waiting
+	require.NoError(t, <-waiting)
+	require.Equal(t, int32(1), atomic.LoadInt32(&rps.reads))
+}
+
+func TestGetByIDWaitsForLockHolder(t *testing.T) {
+	ctx := context.Background()
+	rdb := repository.NewMemoryCacheConnection()
+	srv, rps, person := newLoadingService(t, rdb)
+	// Step 1: another instance holds the lock and caches the person a bit later
+	token, ok, err := rdb.RedisLock(ctx, person.ID, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+	go func() {
+		time.Sleep(3 * loadPoll)
+		require.NoError(t, rdb.RedisSetByID(ctx, person))
+		require.NoError(t, rdb.RedisUnlock(ctx, person.ID, token))
+	}()
+	// Step 2: the read waits for the cache instead of the store
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, person.ID, res.ID)
+	require.Zero(t, atomic.LoadInt32(&rps.reads))
+}
+
+func TestGetByIDLockHolderGone(t *testing.T) {
+	ctx := context.Background()
+	rdb := repository.NewMemoryCacheConnection()
+	srv, rps, person := newLoadingService(t, rdb)
+	_, ok, err := rdb.RedisLock(ctx, person.ID, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, person.ID, res.ID)
+	require.Equal(t, int32(1), atomic.LoadInt32(&rps.reads))
+}
+
+func TestGetByIDRefreshAhead(t *testing.T) {
+	ctx := context.Background()
+	rdb := &expiringRedis{MemoryCacheConnection: repository.NewMemoryCacheConnection(), ttl: time.Second}
+	srv, rps, person := newLoadingService(t, rdb)
+	require.NoError(t, rdb.RedisSetByID(ctx, person))
+	res, err := srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	require.Equal(t, person.ID, res.ID)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&rps.reads) == 1 }, time.Second, 10*time.Millisecond)
+
+	// entries far from expiry are not refreshed
+	rdb.ttl = time.Hour
+	_, err = srv.GetByID(ctx, person.ID)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&rps.reads))
+}
+
+// pagingRepository counts page reads
+type pagingRepository struct {
+	*repository.MemoryConnection
+	pages int32
+}
+
+func (rps *pagingRepository) GetPage(ctx context.Context, query *model.PersonQuery) (*model.PersonPage, error) {
+	atomic.AddInt32(&rps.pages, 1)
+	return rps.MemoryConnection.GetPage(ctx, query)
+}
+
+func TestGetAllCached(t *testing.T) {
+	ctx := context.Background()
+	rps := &pagingRepository{MemoryConnection: repository.NewMemoryConnection()}
+	cache, err := NewPersonCache(CacheAside, repository.NewMemoryCacheConnection())
+	require.NoError(t, err)
+	srv := NewPersonService(rps, cache, repository.NewAuditMemoryConnection(),
+		repository.NewOutboxMemoryConnection(), repository.NewMemoryTxManager())
+	person := &model.Person{Name: "Eugen", Age: 20}
+	_, err = srv.Create(ctx, person)
+	require.NoError(t, err)
+
+	// Step 1: the same query is read once, another query is read separately
+	query := &model.PersonQuery{Limit: 10}
+	for i := 0; i < 3; i++ {
+		page, err := srv.GetAll(ctx, query)
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&rps.pages))
+	_, err = srv.GetAll(ctx, &model.PersonQuery{Limit: 10, NamePrefix: "E"})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&rps.pages))
+
+	// Step 2: a change invalidates every cached page
+	_, err = srv.Update(ctx, person.ID, &model.Person{Name: "Updated", Age: 21, Version: person.Version})
+	require.NoError(t, err)
+	page, err := srv.GetAll(ctx, query)
+	require.NoError(t, err)
+	require.Equal(t, "Updated", page.Items[0].Name)
+	require.Equal(t, int32(3), atomic.LoadInt32(&rps.pages))
+
+	// Step 3: deleting moves the person from the cached list to the cached trash
+	deleted, err := srv.GetDeleted(ctx)
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+	_, err = srv.Delete(ctx, person.ID, page.Items[0].Version)
+	require.NoError(t, err)
+	page, err = srv.GetAll(ctx, query)
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	deleted, err = srv.GetDeleted(ctx)
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	mdlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// roleReloadInterval bounds how long a role changed by another instance keeps its old permissions here
+const roleReloadInterval = 30 * time.Second
+
+// defaultRoles are created on startup unless they exist, the admin role is granted every permission anyway
+var defaultRoles = []*model.Role{
+	{Name: model.RoleAdmin, Permissions: model.Permissions},
+	{Name: model.RoleUser, Permissions: []string{model.PermissionPersonRead, model.PermissionImageRead}},
+}
+
+// RoleRepository interface, which contains psql/mongo role methods
+type RoleRepository interface {
+	GetAll(ctx context.Context) ([]*model.Role, error)
+	Save(ctx context.Context, role *model.Role) error
+	Add(ctx context.Context, role *model.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+// RoleService maps the role of a user to the permissions it grants and manages roles and their assignment.
+// The roles are kept in memory and reloaded from the store every roleReloadInterval
+type RoleService struct {
+	rps   RoleRepository
+	users UserRepository
+	rdb   UserRepositoryRedis
+	now   func() time.Time
+
+	mu     sync.RWMutex
+	roles  map[string]map[string]bool
+	loaded time.Time
+}
+
+// NewRoleService is a constructor for RoleService, call EnsureDefaults to create the built-in roles and load them
+func NewRoleService(rps RoleRepository, users UserRepository, rdb UserRepositoryRedis) *RoleService {
+	return &RoleService{rps: rps, users: users, rdb: rdb, now: time.Now, roles: make(map[string]map[string]bool)}
+}
+
+// EnsureDefaults creates the built-in roles which do not exist yet, keeping the permissions of existing ones, and loads the roles
+func (rs *RoleService) EnsureDefaults(ctx context.Context) error {
+	for _, role := range defaultRoles {
+		err := rs.rps.Add(ctx, role)
+		if err != nil {
+			return fmt.Errorf("Add: %w", err)
+		}
+	}
+	return rs.Load(ctx)
+}
+
+// Load reads every role from the store
+func (rs *RoleService) Load(ctx context.Context) error {
+	stored, err := rs.rps.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("GetAll: %w", err)
+	}
+	roles := make(map[string]map[string]bool, len(stored))
+	for _, role := range stored {
+		permissions := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+		roles[role.Name] = permissions
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.roles = roles
+	rs.loaded = rs.now()
+	return nil
+}
+
+// HasPermission reports whether the role grants the permission, the admin role grants every permission.
+// When reloading the roles fails, the roles loaded last keep being used
+func (rs *RoleService) HasPermission(ctx context.Context, role, permission string) bool {
+	if role == model.RoleAdmin {
+		return true
+	}
+	rs.reload(ctx)
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.roles[role][permission]
+}
+
+// GetAll returns every role
+func (rs *RoleService) GetAll(ctx context.Context) ([]*model.Role, error) {
+	roles, err := rs.rps.GetAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll: %w", err)
+	}
+	return roles, nil
+}
+
+// Save creates a role or replaces its permissions, the admin role always grants every permission and can't be changed
+func (rs *RoleService) Save(ctx context.Context, role *model.Role) error {
+	if role.Name == model.RoleAdmin {
+		return fmt.Errorf("Save: role %q: %w", role.Name, model.ErrBuiltinRole)
+	}
+	for _, permission := range role.Permissions {
+		if !knownPermission(permission) {
+			return fmt.Errorf("Save: %q: %w", permission, model.ErrUnknownPermission)
+		}
+	}
+	err := rs.rps.Save(ctx, role)
+	if err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "role_saved")).WithField("role", role.Name).Infof("role permissions: %v", role.Permissions)
+	return rs.Load(ctx)
+}
+
+// Delete deletes a role which is not built in, users holding it are left without permissions
+func (rs *RoleService) Delete(ctx context.Context, name string) error {
+	for _, role := range defaultRoles {
+		if role.Name == name {
+			return fmt.Errorf("Delete: role %q: %w", name, model.ErrBuiltinRole)
+		}
+	}
+	err := rs.rps.Delete(ctx, name)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "role_deleted")).WithField("role", name).Info("role deleted")
+	return rs.Load(ctx)
+}
+
+// HasRole loads the roles and reports whether the role exists
+func (rs *RoleService) HasRole(ctx context.Context, name string) (bool, error) {
+	err := rs.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	_, ok := rs.roles[name]
+	return ok, nil
+}
+
+// AssignRole assigns an existing role to a user. The access tokens issued so far carry the old role,
+// so they are revoked and the user gets the new role with the next token refresh
+func (rs *RoleService) AssignRole(ctx context.Context, id uuid.UUID, role string) error {
+	ok, err := rs.HasRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("AssignRole: role %q: %w", role, model.ErrNotFound)
+	}
+	err = rs.users.SetRole(ctx, id, role)
+	if err != nil {
+		return fmt.Errorf("SetRole: %w", err)
+	}
+	err = rs.rdb.Delete(ctx, id)
+	if err != nil && !errors.Is(err, model.ErrCacheMiss) {
+		logCacheError(logrus.Fields{"id": id}, "Delete", err)
+	}
+	err = rs.rdb.DenyUser(ctx, id, rs.now(), accessTokenTTL)
+	if err != nil {
+		return fmt.Errorf("DenyUser: %w", err)
+	}
+	logrus.WithFields(eventFields(ctx, "role_assigned")).WithFields(logrus.Fields{"user_id": id, "role": role}).Info("role assigned")
+	return nil
+}
+
+// reload loads the roles once they are older than roleReloadInterval, a failure is only logged
+func (rs *RoleService) reload(ctx context.Context) {
+	rs.mu.Lock()
+	if rs.now().Sub(rs.loaded) < roleReloadInterval {
+		rs.mu.Unlock()
+		return
+	}
+	rs.loaded = rs.now()
+	rs.mu.Unlock()
+	err := rs.Load(ctx)
+	if err != nil {
+		logrus.Errorf("Load: %v", err)
+	}
+}
+
+// eventFields returns the log fields of a security event caused by the actor of ctx
+func eventFields(ctx context.Context, event string) logrus.Fields {
+	fields := logrus.Fields{"security_event": event}
+	if actor, ok := mdlwr.ActorFromContext(ctx); ok {
+		fields["actor_id"] = actor.ID
+	}
+	return fields
+}
+
+// knownPermission reports whether the permission is one of model.Permissions
+func knownPermission(permission string) bool {
+	for _, known := range model.Permissions {
+		if known == permission {
+			return true
+		}
+	}
+	return false
+}
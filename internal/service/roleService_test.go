@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRoleService returns a role service with the default roles on memory stores
+func newTestRoleService(t *testing.T) (*RoleService, *repository.RoleMemoryConnection, *repository.UserMemoryConnection, *repository.UserMemoryCacheConnection) {
+	rps := repository.NewRoleMemoryConnection()
+	users := repository.NewUserMemoryConnection()
+	rdb := repository.NewUserMemoryCacheConnection()
+	srv := NewRoleService(rps, users, rdb)
+	require.NoError(t, srv.EnsureDefaults(context.Background()))
+	return srv, rps, users, rdb
+}
+
+func TestRolePermissions(t *testing.T) {
+	ctx := context.Background()
+	srv, rps, _, _ := newTestRoleService(t)
+
+	// Step 1: the admin role grants everything, the user role only reads
+	for _, permission := range model.Permissions {
+		require.True(t, srv.HasPermission(ctx, model.RoleAdmin, permission), permission)
+	}
+	require.True(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonRead))
+	require.False(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonWrite))
+	require.False(t, srv.HasPermission(ctx, "unknown", model.PermissionPersonRead))
+
+	// Step 2: a saved role grants its permissions right away, unknown permissions are refused
+	require.NoError(t, srv.Save(ctx, &model.Role{Name: "editor", Permissions: []string{model.PermissionPersonRead, model.PermissionPersonWrite}}))
+	require.True(t, srv.HasPermission(ctx, "editor", model.PermissionPersonWrite))
+	require.ErrorIs(t, srv.Save(ctx, &model.Role{Name: "editor", Permissions: []string{"person:fly"}}), model.ErrUnknownPermission)
+	require.ErrorIs(t, srv.Save(ctx, &model.Role{Name: model.RoleAdmin}), model.ErrBuiltinRole)
+
+	// Step 3: built-in roles can't be deleted, others lose their permissions
+	require.ErrorIs(t, srv.Delete(ctx, model.RoleUser), model.ErrBuiltinRole)
+	require.NoError(t, srv.Delete(ctx, "editor"))
+	require.False(t, srv.HasPermission(ctx, "editor", model.PermissionPersonRead))
+	require.ErrorIs(t, srv.Delete(ctx, "editor"), model.ErrNotFound)
+
+	// Step 4: changes made by another instance show up once the roles are reloaded
+	require.NoError(t, rps.Save(ctx, &model.Role{Name: model.RoleUser, Permissions: []string{model.PermissionPersonWrite}}))
+	require.True(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonRead))
+	now := time.Now()
+	srv.now = func() time.Time { return now.Add(roleReloadInterval) }
+	require.False(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonRead))
+	require.True(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonWrite))
+
+	// Step 5: restarting keeps the edited built-in roles
+	require.NoError(t, srv.EnsureDefaults(ctx))
+	require.True(t, srv.HasPermission(ctx, model.RoleUser, model.PermissionPersonWrite))
+}
+
+func TestAssignRole(t *testing.T) {
+	ctx := context.Background()
+	srv, _, users, rdb := newTestRoleService(t)
+	user := &model.User{ID: uuid.New(), Login: "eugen", Role: model.RoleUser}
+	require.NoError(t, users.Signup(ctx, user))
+	require.NoError(t, rdb.Set(ctx, user))
+	issuedAt := time.Now().Add(-time.Second)
+
+	require.NoError(t, srv.AssignRole(ctx, user.ID, model.RoleAdmin))
+	role, err := users.GetRoleByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleAdmin, role)
+	// the cached user and the access tokens carrying the old role are gone
+	_, err = rdb.Get(ctx, user.ID)
+	require.ErrorIs(t, err, model.ErrCacheMiss)
+	denied, err := rdb.IsDenied(ctx, uuid.NewString(), user.ID, issuedAt)
+	require.NoError(t, err)
+	require.True(t, denied)
+
+	require.ErrorIs(t, srv.AssignRole(ctx, user.ID, "unknown"), model.ErrNotFound)
+	require.ErrorIs(t, srv.AssignRole(ctx, uuid.New(), model.RoleUser), model.ErrNotFound)
+}
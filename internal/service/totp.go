@@ -0,0 +1,72 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 is the default TOTP algorithm every authenticator app supports
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// const for TOTP codes (RFC 6238) as authenticator apps generate them by default
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew is the number of time steps a code may be off, to allow for clock drift
+	totpSkew = 1
+	// totpSecretBytes is the length of a secret, RFC 4226 recommends 160 bits
+	totpSecretBytes = 20
+)
+
+// totpEncoding encodes secrets for authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpStep returns the time step of t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode returns the code of the secret for the time step, the HOTP value (RFC 4226) of the step
+func totpCode(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// validateTOTP returns the time step the code is valid for at now, within totpSkew steps
+func validateTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the provisioning URI authenticator apps read from a QR code, e.g.
+// otpauth://totp/myapp:eugen?secret=...&issuer=myapp
+func totpURI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", totpEncoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return (&url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + account, RawQuery: query.Encode()}).String()
+}
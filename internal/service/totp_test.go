@@ -0,0 +1,57 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238, truncated to six digits
+	secret := []byte("12345678901234567890")
+	for unix, code := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		require.Equal(t, code, totpCode(secret, totpStep(time.Unix(unix, 0))), unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	code := totpCode(secret, totpStep(now))
+
+	// Step 1: a code is valid within one step of clock drift
+	for _, at := range []time.Time{now, now.Add(-totpPeriod), now.Add(totpPeriod)} {
+		step, ok := validateTOTP(secret, code, at)
+		require.True(t, ok, at)
+		require.Equal(t, totpStep(now), step)
+	}
+
+	// Step 2: older codes, other secrets and malformed codes are not
+	for _, at := range []time.Time{now.Add(-2 * totpPeriod), now.Add(2 * totpPeriod)} {
+		_, ok := validateTOTP(secret, code, at)
+		require.False(t, ok, at)
+	}
+	_, ok := validateTOTP([]byte("09876543210987654321"), code, now)
+	require.False(t, ok)
+	_, ok = validateTOTP(secret, code[:5], now)
+	require.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("myapp", "eugen", []byte("12345678901234567890")))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/myapp:eugen", uri.Path)
+	require.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uri.Query().Get("secret"))
+	require.Equal(t, "myapp", uri.Query().Get("issuer"))
+	require.Equal(t, "30", uri.Query().Get("period"))
+}
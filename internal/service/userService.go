@@ -5,52 +5,111 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
-	"github.com/eugenshima/myapp/internal/config"
 	mdlwr "github.com/eugenshima/myapp/internal/middleware"
 	"github.com/eugenshima/myapp/internal/model"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	accessTokenTTL  = 24 * time.Hour
 	refreshTokenTTL = 72 * time.Hour
+	// mfaChallengeTTL is how long a login with the right password may take to pass the second factor
+	mfaChallengeTTL = 5 * time.Minute
 )
 
-// tokenClaims struct contains information about the claims associated with the given token
+// tokenClaims struct contains information about the claims associated with the given token,
+// the ID of an access token is unique and its subject is the user. IssuedAtMs is the issue time in milliseconds,
+// so a token issued right after the sessions of its user were revoked is told apart from the revoked ones
 type tokenClaims struct {
-	Role string `json:"role"`
+	Role       string `json:"role"`
+	Family     string `json:"fam,omitempty"`
+	IssuedAtMs int64  `json:"iat_ms,omitempty"`
+	jwt.StandardClaims
+}
+
+// refreshClaims struct contains the claims of a refresh token, every refresh token has its own ID
+// and belongs to the token family started by a login. Its audience keeps it from passing as an access token
+type refreshClaims struct {
+	Family string `json:"fam"`
+	jwt.StandardClaims
+}
+
+// challengeClaims struct contains the claims of an MFA challenge token, its subject is the user,
+// Enroll is set when the user has to enroll a second factor during the login
+type challengeClaims struct {
+	Login  string `json:"login"`
+	Enroll bool   `json:"enroll,omitempty"`
 	jwt.StandardClaims
 }
 
 // UserService is a struct that contains a reference to the repository interface
 type UserService struct {
-	rps UserRepository
-	rdb UserRepositoryRedis
+	rps         UserRepository
+	rdb         UserRepositoryRedis
+	families    TokenFamilyRepository
+	keys        TokenSigner
+	invitations InvitationRedeemer
+	policy      *PasswordPolicy
+	throttle    *LoginThrottle
+	mfa         SecondFactor
 }
 
 // NewUserServiceImpl creates a new service
-func NewUserServiceImpl(rps UserRepository, rdb UserRepositoryRedis) *UserService {
+func NewUserServiceImpl(rps UserRepository, rdb UserRepositoryRedis, families TokenFamilyRepository, keys TokenSigner,
+	invitations InvitationRedeemer, policy *PasswordPolicy, throttle *LoginThrottle, mfa SecondFactor) *UserService {
 	return &UserService{
-		rps: rps,
-		rdb: rdb,
+		rps:         rps,
+		rdb:         rdb,
+		families:    families,
+		keys:        keys,
+		invitations: invitations,
+		policy:      policy,
+		throttle:    throttle,
+		mfa:         mfa,
 	}
 }
 
+// InvitationRedeemer redeems the invitations granting a role at signup
+type InvitationRedeemer interface {
+	RedeemInvitation(ctx context.Context, code string, userID uuid.UUID) (string, error)
+	ReleaseInvitation(ctx context.Context, code string, userID uuid.UUID) error
+}
+
+// SecondFactor interface, which contains the second factor methods of logins
+type SecondFactor interface {
+	Required(role string) bool
+	Enabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	Enroll(ctx context.Context, userID uuid.UUID) (*model.MFAEnrollment, error)
+	Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Verify(ctx context.Context, userID uuid.UUID, code string) error
+}
+
+// TokenSigner interface, which signs tokens and finds the keys verifying them
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+	Keyfunc(token *jwt.Token) (interface{}, error)
+}
+
 // UserRepository interface, which contains psql/mongo repository methods
 type UserRepository interface {
 	GetUser(ctx context.Context, login string) (*model.User, error)
 	Signup(context.Context, *model.User) error
 	GetAll(context.Context) ([]*model.User, error)
-	SaveRefreshToken(ctx context.Context, id uuid.UUID, token []byte) error
-	GetRefreshToken(ctx context.Context, id uuid.UUID) ([]byte, error)
 	GetRoleByID(ctx context.Context, id uuid.UUID) (string, error)
+	GetLoginByID(ctx context.Context, id uuid.UUID) (string, error)
+	SetRole(ctx context.Context, id uuid.UUID, role string) error
+	SetPassword(ctx context.Context, id uuid.UUID, password []byte) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	GetBatch(ctx context.Context, after uuid.UUID, limit int) ([]*model.User, error)
 }
 
 // UserRepositoryRedis interface, which contains redis repository methods
@@ -58,19 +117,41 @@ type UserRepositoryRedis interface {
 	Set(ctx context.Context, user *model.User) error
 	Get(ctx context.Context, id uuid.UUID) (*model.User, error)
 	Delete(ctx context.Context, id uuid.UUID) error
-	GetRefreshToken(ctx context.Context, id uuid.UUID) ([]byte, error)
-	SetRefreshToken(ctx context.Context, id uuid.UUID, token []byte) error
+	GetTokenFamily(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error)
+	SetTokenFamily(ctx context.Context, family *model.TokenFamily) error
+	DenyToken(ctx context.Context, tokenID string, ttl time.Duration) error
+	DenyUser(ctx context.Context, id uuid.UUID, revokedAt time.Time, ttl time.Duration) error
+	IsDenied(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+	LoginFailureRepository
 }
 
-// GenerateTokens implements the UserServicePsql interface
-func (db *UserService) GenerateTokens(ctx context.Context, login, password string) (accessToken, refreshToken string, err error) {
-	cfg, err := config.NewConfig()
+// TokenFamilyRepository interface, which contains psql/mongo refresh token family methods
+type TokenFamilyRepository interface {
+	Create(ctx context.Context, family *model.TokenFamily) error
+	Get(ctx context.Context, id uuid.UUID) (*model.TokenFamily, error)
+	Rotate(ctx context.Context, id uuid.UUID, oldHash, newHash []byte, expiresAt time.Time) (*model.TokenFamily, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+	RevokeUser(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// GenerateTokens logs the user in from the client IP and returns a new token pair.
+// Every attempt is counted against the limits per login and per IP before any password is compared.
+// A user with a second factor, or whose role requires one, gets a *model.MFARequiredError
+// with the challenge token LoginMFA exchanges for the token pair instead
+func (db *UserService) GenerateTokens(ctx context.Context, login, password, ip string) (accessToken, refreshToken string, err error) {
+	attempt, err := db.throttle.Reserve(ctx, login, ip)
 	if err != nil {
-		return "", "", fmt.Errorf("NewConfig: %w", err)
+		return "", "", fmt.Errorf("Reserve: %w", err)
 	}
-
+	defer attempt.Release(ctx)
 	// GetUser
 	user, err := db.rps.GetUser(ctx, login)
+	if errors.Is(err, model.ErrNotFound) {
+		// compare anyway, so unknown logins take as long as wrong passwords
+		_ = bcrypt.CompareHashAndPassword(unknownUserPassword(), []byte(password))
+		attempt.Fail()
+		return "", "", fmt.Errorf("GetUser: %w", model.ErrInvalidCredentials)
+	}
 	if err != nil {
 		return "", "", fmt.Errorf("GetUser: %w", err)
 	}
@@ -78,10 +159,109 @@ func (db *UserService) GenerateTokens(ctx context.Context, login, password strin
 	// CompareHashAndPassword
 	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
 	if err != nil {
-		return "", "", fmt.Errorf("CompareHashAndPassword: %w", err)
+		attempt.Fail()
+		return "", "", fmt.Errorf("CompareHashAndPassword: %w", model.ErrInvalidCredentials)
+	}
+	err = db.challenge(ctx, user, login)
+	if err != nil {
+		return "", "", fmt.Errorf("challenge: %w", err)
+	}
+	attempt.Succeed(ctx)
+	accessToken, refreshToken, err = db.issueTokens(ctx, user.ID, user.Role)
+	if err != nil {
+		return "", "", err
+	}
+	user.Login = login
+	db.cache(ctx, user)
+	return accessToken, refreshToken, nil
+}
+
+// LoginMFA passes the second factor of the login the challenge token stands for and returns a new token pair.
+// A login enrolling a second factor confirms it with the code and gets its recovery codes along with the tokens.
+// Wrong codes are throttled like wrong passwords
+func (db *UserService) LoginMFA(ctx context.Context, challenge, code, ip string) (*model.MFATokens, error) {
+	claims, id, err := parseChallenge(challenge, db.keys.Keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("parseChallenge: %w", err)
+	}
+	attempt, err := db.throttle.Reserve(ctx, claims.Login, ip)
+	if err != nil {
+		return nil, fmt.Errorf("Reserve: %w", err)
+	}
+	defer attempt.Release(ctx)
+	tokens := &model.MFATokens{}
+	if claims.Enroll {
+		tokens.RecoveryCodes, err = db.mfa.Confirm(ctx, id, code)
+	} else {
+		err = db.mfa.Verify(ctx, id, code)
+	}
+	if errors.Is(err, model.ErrInvalidMFACode) {
+		attempt.Fail()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("second factor: %w", err)
+	}
+	attempt.Succeed(ctx)
+	role, err := db.rps.GetRoleByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetRoleByID: %w", err)
+	}
+	tokens.AccessToken, tokens.RefreshToken, err = db.issueTokens(ctx, id, role)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// EnrollMFA enrolls a second factor for the login the challenge token stands for,
+// when the role of the user requires one the user has not enabled yet
+func (db *UserService) EnrollMFA(ctx context.Context, challenge string) (*model.MFAEnrollment, error) {
+	claims, id, err := parseChallenge(challenge, db.keys.Keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("parseChallenge: %w", err)
 	}
+	if !claims.Enroll {
+		return nil, fmt.Errorf("EnrollMFA: %w", model.ErrMFAEnabled)
+	}
+	enrollment, err := db.mfa.Enroll(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Enroll: %w", err)
+	}
+	return enrollment, nil
+}
+
+// challenge returns a *model.MFARequiredError if the user has to pass a second factor before tokens are issued
+func (db *UserService) challenge(ctx context.Context, user *model.User, login string) error {
+	enabled, err := db.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("Enabled: %w", err)
+	}
+	if !enabled && !db.mfa.Required(user.Role) {
+		return nil
+	}
+	now := time.Now()
+	challenge, err := db.keys.Sign(&challengeClaims{
+		Login:  login,
+		Enroll: !enabled,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  mdlwr.MFAChallengeAudience,
+			ExpiresAt: now.Add(mfaChallengeTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        uuid.New().String(),
+			Subject:   user.ID.String(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Sign: %w", err)
+	}
+	return &model.MFARequiredError{Challenge: challenge, Enroll: !enabled}
+}
+
+// issueTokens returns a new token pair of the user, the refresh token starts a new token family
+func (db *UserService) issueTokens(ctx context.Context, id uuid.UUID, role string) (accessToken, refreshToken string, err error) {
 	// GenerateAccessToken
-	accessToken, refreshToken, err = GenerateAccessAndRefreshTokens(cfg.SigningKey, user.Role, user.ID)
+	familyID := uuid.New()
+	accessToken, refreshToken, err = GenerateAccessAndRefreshTokens(db.keys, role, id, familyID)
 	if err != nil {
 		return "", "", fmt.Errorf("GenerateAccessAndRefreshTokens: %w", err)
 	}
@@ -90,95 +270,224 @@ func (db *UserService) GenerateTokens(ctx context.Context, login, password strin
 	if err != nil {
 		return "", "", fmt.Errorf("HashRefreshToken: %w", err)
 	}
-	// SaveRefreshToken
-	err = db.rps.SaveRefreshToken(ctx, user.ID, hashedRefreshToken)
+	// Create token family
+	now := time.Now()
+	family := &model.TokenFamily{
+		ID:        familyID,
+		UserID:    id,
+		TokenHash: hashedRefreshToken,
+		CreatedAt: now,
+		RotatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	err = db.families.Create(ctx, family)
 	if err != nil {
-		return "", "", fmt.Errorf("SaveRefreshToken: %w", err)
+		return "", "", fmt.Errorf("Create: %w", err)
 	}
 	// CompareTokenIDs
-	compID, err := CompareTokenIDs(accessToken, refreshToken, cfg.SigningKey)
+	compID, err := CompareTokenIDs(accessToken, refreshToken, db.keys.Keyfunc)
 	if err != nil {
 		return "", "", fmt.Errorf("CompareTokenIDs: %w", err)
 	}
 	if !compID {
 		return "", "", fmt.Errorf("invalid token(campare error): %w", err)
 	}
-	user.RefreshToken = hashedRefreshToken
-	user.Login = login
-	err = db.rdb.Set(ctx, user)
-	if err != nil {
-		return "", "", fmt.Errorf("set: %w", err)
-	}
+	db.cacheFamily(ctx, family)
 	return accessToken, refreshToken, nil
 }
 
-// RefreshTokenPair func returns a pair of refresh tokens
+// RefreshTokenPair func rotates the token family of the refresh token and returns a new token pair.
+// A refresh token which was already rotated revokes its whole family: either the user or an attacker
+// holds a stolen copy, so both of them have to log in again
 func (db *UserService) RefreshTokenPair(ctx context.Context, accessToken, refreshToken string, id uuid.UUID) (access, refresh string, err error) {
-	cfg, err := config.NewConfig()
+	claims, familyID, err := parseRefreshToken(refreshToken, db.keys.Keyfunc)
 	if err != nil {
-		return "", "", fmt.Errorf("NewConfig: %w", err)
+		return "", "", fmt.Errorf("parseRefreshToken: %w", err)
 	}
-	// Get RefreshToken
-	savedRefreshToken, err := db.rdb.GetRefreshToken(ctx, id) // from cache
-	if err != nil || savedRefreshToken == nil {
-		savedRefreshToken, err = db.rps.GetRefreshToken(ctx, id) // from database
-		if err != nil {
-			return "", "", fmt.Errorf("GetRefreshToken: %w", err)
-		}
-	}
-	// HashRefreshToken
-	hashedRefreshToken, err := HashRefreshToken(refreshToken)
-	if err != nil {
-		return "", "", fmt.Errorf("HashRefreshToken: %w", err)
-	}
-	// CompareHashedTokens
-	isEqual := CompareHashedTokens(savedRefreshToken, hashedRefreshToken)
-	if !isEqual {
-		return "", "", fmt.Errorf("CompareHashedTokens: %w", err)
-	}
-	tokenID, role, err := mdlwr.GetPayloadFromToken(accessToken)
-	if err != nil {
-		return "", "", fmt.Errorf("GetPayloadFromToken: %w", err)
+	if claims.Subject != id.String() {
+		return "", "", fmt.Errorf("refresh token of user %s used for user %s", claims.Subject, id)
 	}
 	// CompareTokenIDs
-	compID, err := CompareTokenIDs(accessToken, refreshToken, cfg.SigningKey)
+	compID, err := CompareTokenIDs(accessToken, refreshToken, db.keys.Keyfunc)
 	if err != nil {
 		return "", "", fmt.Errorf("CompareTokenIDs: %w", err)
 	}
 	if !compID {
 		return "", "", fmt.Errorf("invalid token(campare error): %w", err)
 	}
+	// a family cached as revoked stays revoked, others are checked by Rotate
+	if db.cachedRevoked(ctx, familyID) {
+		return "", "", fmt.Errorf("token family %s: %w", familyID, model.ErrTokenRevoked)
+	}
+	role, err := db.rps.GetRoleByID(ctx, id)
+	if err != nil {
+		return "", "", fmt.Errorf("GetRoleByID: %w", err)
+	}
 	// GenerateAccessAndRefreshTokens
-	access, refresh, err = GenerateAccessAndRefreshTokens(cfg.SigningKey, role, tokenID)
+	access, refresh, err = GenerateAccessAndRefreshTokens(db.keys, role, id, familyID)
 	if err != nil {
 		return "", "", fmt.Errorf("GenerateAccessAndRefreshTokens: %w", err)
 	}
 	// HashRefreshToken
-	hashedRefreshToken, err = HashRefreshToken(refresh)
+	hashedRefreshToken, err := HashRefreshToken(refreshToken)
 	if err != nil {
 		return "", "", fmt.Errorf("HashRefreshToken: %w", err)
 	}
-	// SaveRefreshToken
-	err = db.rps.SaveRefreshToken(ctx, id, hashedRefreshToken)
+	newHashedRefreshToken, err := HashRefreshToken(refresh)
 	if err != nil {
-		return "", "", fmt.Errorf("SaveRefreshToken: %w", err)
+		return "", "", fmt.Errorf("HashRefreshToken: %w", err)
+	}
+	// Rotate token family
+	family, err := db.families.Rotate(ctx, familyID, hashedRefreshToken, newHashedRefreshToken, time.Now().Add(refreshTokenTTL))
+	if errors.Is(err, model.ErrTokenReuse) {
+		db.revokeFamily(ctx, familyID, claims)
 	}
-	err = db.rdb.SetRefreshToken(ctx, id, hashedRefreshToken)
 	if err != nil {
-		return "", "", fmt.Errorf("SetRefreshToken: %w", err)
+		return "", "", fmt.Errorf("Rotate: %w", err)
 	}
+	db.cacheFamily(ctx, family)
 	return access, refresh, nil
 }
 
-// Signup implements the UserServicePsql interface
-func (db *UserService) Signup(ctx context.Context, user *model.User) error {
+// Logout revokes the access token for the rest of its lifetime along with the token family of its session
+func (db *UserService) Logout(ctx context.Context, accessToken string) error {
+	claims, err := parseAccessToken(accessToken, db.keys.Keyfunc)
+	if err != nil {
+		return fmt.Errorf("parseAccessToken: %w", err)
+	}
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if ttl > 0 {
+		err = db.rdb.DenyToken(ctx, claims.Id, ttl)
+		if err != nil {
+			return fmt.Errorf("DenyToken: %w", err)
+		}
+	}
+	if claims.Family == "" {
+		return nil
+	}
+	familyID, err := uuid.Parse(claims.Family)
+	if err != nil {
+		return fmt.Errorf("Parse: %w", err)
+	}
+	err = db.families.Revoke(ctx, familyID)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessions revokes every access token issued to the user so far and every token family of the user
+func (db *UserService) RevokeSessions(ctx context.Context, id uuid.UUID) error {
+	revoked, err := db.families.RevokeUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("RevokeUser: %w", err)
+	}
+	err = db.rdb.DenyUser(ctx, id, time.Now(), accessTokenTTL)
+	if err != nil {
+		return fmt.Errorf("DenyUser: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"security_event": "sessions_revoked", "user_id": id, "families": revoked}).Info("revoked every session of the user")
+	return nil
+}
+
+// IsRevoked reports whether an access token is on the denylist. The denylist lives in the cache,
+// while it is unreachable tokens are accepted until they expire rather than locking every user out
+func (db *UserService) IsRevoked(ctx context.Context, tokenID string, userID uuid.UUID, issuedAt time.Time) bool {
+	denied, err := db.rdb.IsDenied(ctx, tokenID, userID, issuedAt)
+	if err != nil {
+		logCacheError(logrus.Fields{"token_id": tokenID, "user_id": userID}, "IsDenied", err)
+		return false
+	}
+	return denied
+}
+
+// revokeFamily revokes the family of a reused refresh token and reports the reuse as a security event
+func (db *UserService) revokeFamily(ctx context.Context, familyID uuid.UUID, claims *refreshClaims) {
+	fields := logrus.Fields{"security_event": "refresh_token_reuse", "user_id": claims.Subject, "family": familyID, "token_id": claims.Id}
+	logrus.WithFields(fields).Warn("refresh token reused, revoking its token family")
+	err := db.families.Revoke(ctx, familyID)
+	if err != nil {
+		logrus.WithFields(fields).Errorf("Revoke: %v", err)
+		return
+	}
+	family, err := db.families.Get(ctx, familyID)
+	if err != nil {
+		logrus.WithFields(fields).Errorf("Get: %v", err)
+		return
+	}
+	db.cacheFamily(ctx, family)
+}
+
+// cachedRevoked reports whether the family is cached as revoked, the cache is optional and a failure is only logged
+func (db *UserService) cachedRevoked(ctx context.Context, id uuid.UUID) bool {
+	family, err := db.rdb.GetTokenFamily(ctx, id)
+	if err != nil {
+		if !errors.Is(err, model.ErrCacheMiss) {
+			logCacheError(logrus.Fields{"family": id}, "GetTokenFamily", err)
+		}
+		return false
+	}
+	return family.RevokedAt != nil
+}
+
+// cacheFamily caches the token family, the cache is optional and a failure is only logged
+func (db *UserService) cacheFamily(ctx context.Context, family *model.TokenFamily) {
+	err := db.rdb.SetTokenFamily(ctx, family)
+	if err != nil {
+		logCacheError(logrus.Fields{"family": family.ID}, "SetTokenFamily", err)
+	}
+}
+
+// Signup creates a basic user, or a user with the role of the given invitation which is used up by the signup.
+// The password has to satisfy the password policy
+func (db *UserService) Signup(ctx context.Context, user *model.User, invitation string) error {
+	err := db.policy.Check(user.Login, string(user.Password))
+	if err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	user.Role = model.RoleUser
+	if invitation != "" {
+		role, err := db.invitations.RedeemInvitation(ctx, invitation, user.ID)
+		if err != nil {
+			return fmt.Errorf("RedeemInvitation: %w", err)
+		}
+		user.Role = role
+	}
 	hashedPassword := hashPassword(user.Password)
 	user.Password = hashedPassword
+	err = db.rps.Signup(ctx, user)
+	if err != nil {
+		if invitation != "" {
+			releaseErr := db.invitations.ReleaseInvitation(ctx, invitation, user.ID)
+			if releaseErr != nil {
+				logrus.WithFields(logrus.Fields{"id": user.ID}).Errorf("ReleaseInvitation: %v", releaseErr)
+			}
+		}
+		return fmt.Errorf("Signup: %w", err)
+	}
+	db.cache(ctx, user)
+	return nil
+}
+
+// cache caches the user, the cache is optional and a failure is only logged
+func (db *UserService) cache(ctx context.Context, user *model.User) {
 	err := db.rdb.Set(ctx, user)
 	if err != nil {
-		return fmt.Errorf("set: %w", err)
+		logCacheError(logrus.Fields{"id": user.ID}, "Set", err)
+	}
+}
+
+// uncache drops the user from the cache, a user which is not cached is fine
+func (db *UserService) uncache(ctx context.Context, id uuid.UUID) {
+	err := db.rdb.Delete(ctx, id)
+	if err != nil && !errors.Is(err, model.ErrCacheMiss) {
+		logCacheError(logrus.Fields{"id": id}, "Delete", err)
 	}
-	return db.rps.Signup(ctx, user)
+}
+
+// Unlock forgets the failed logins of the login and/or the IP, lifting their backoff and lockout
+func (db *UserService) Unlock(ctx context.Context, login, ip string) error {
+	return db.throttle.Unlock(ctx, login, ip)
 }
 
 // GetAll implements the UserServicePsql interface
@@ -195,6 +504,20 @@ func hashPassword(password []byte) []byte {
 	return hashedPassword
 }
 
+// the hash logins of unknown users are compared with
+var (
+	unknownUserOnce sync.Once
+	unknownUserHash []byte
+)
+
+// unknownUserPassword returns the hash logins of unknown users are compared with, it is generated on the first call
+func unknownUserPassword() []byte {
+	unknownUserOnce.Do(func() {
+		unknownUserHash = hashPassword([]byte(uuid.NewString()))
+	})
+	return unknownUserHash
+}
+
 // HashRefreshToken func returns hashed refresh token using bcrypt algorithm
 func HashRefreshToken(refreshToken string) ([]byte, error) {
 	hash := sha256.New()
@@ -210,72 +533,116 @@ func CompareHashedTokens(token1, token2 []byte) bool {
 	return sha256.Sum256(token1) == sha256.Sum256(token2)
 }
 
-// CompareTokenIDs func compares token ids
-func CompareTokenIDs(accessToken, refreshToken, key string) (bool, error) {
-	accessID, err := ExtractIDFromToken(accessToken, key)
+// CompareTokenIDs func checks that both tokens were issued to the same user
+func CompareTokenIDs(accessToken, refreshToken string, keyfunc jwt.Keyfunc) (bool, error) {
+	accessID, err := ExtractIDFromToken(accessToken, keyfunc)
 	if err != nil {
 		return false, fmt.Errorf("ExtractIDFromToken: %w", err)
 	}
 
-	refreshID, err := ExtractIDFromToken(refreshToken, key)
+	claims, _, err := parseRefreshToken(refreshToken, keyfunc)
 	if err != nil {
-		return false, fmt.Errorf("ExtractIDFromToken: %w", err)
+		return false, fmt.Errorf("parseRefreshToken: %w", err)
 	}
-	return accessID == refreshID, nil
+	return accessID == claims.Subject, nil
 }
 
-// ExtractIDFromToken extracts the identifier (ID) from the payload (claims) of the token.
-func ExtractIDFromToken(tokenString, key string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(key), nil
-	})
+// parseRefreshToken verifies a refresh token and returns its claims along with its token family
+func parseRefreshToken(tokenString string, keyfunc jwt.Keyfunc) (*refreshClaims, uuid.UUID, error) {
+	claims := &refreshClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyfunc)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("ParseWithClaims(): %w", err)
+	}
+	if !claims.VerifyAudience(mdlwr.RefreshAudience, true) {
+		return nil, uuid.Nil, errors.New("not a refresh token")
+	}
+	familyID, err := uuid.Parse(claims.Family)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("Parse(): %w", err)
+	}
+	return claims, familyID, nil
+}
+
+// parseChallenge verifies an MFA challenge token and returns its claims along with its user,
+// a token which is not a valid challenge is reported as model.ErrInvalidCredentials
+func parseChallenge(tokenString string, keyfunc jwt.Keyfunc) (*challengeClaims, uuid.UUID, error) {
+	claims := &challengeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyfunc)
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("ParseWithClaims(): %v: %w", err, model.ErrInvalidCredentials)
+	}
+	if !claims.VerifyAudience(mdlwr.MFAChallengeAudience, true) {
+		return nil, uuid.Nil, fmt.Errorf("not an MFA challenge: %w", model.ErrInvalidCredentials)
+	}
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return "", fmt.Errorf("Parse(): %w", err)
+		return nil, uuid.Nil, fmt.Errorf("Parse(): %v: %w", err, model.ErrInvalidCredentials)
 	}
+	return claims, id, nil
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if id, ok := claims["jti"].(string); ok {
-			return id, nil
-		}
+// ExtractIDFromToken extracts the user identifier (ID) from the payload (claims) of the token.
+func ExtractIDFromToken(tokenString string, keyfunc jwt.Keyfunc) (string, error) {
+	claims, err := parseAccessToken(tokenString, keyfunc)
+	if err != nil {
+		return "", fmt.Errorf("parseAccessToken: %w", err)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("error extracting ID from token: no subject")
 	}
+	return claims.Subject, nil
+}
 
-	return "", fmt.Errorf("error extracting ID from token: %v", token)
+// parseAccessToken verifies an access token and returns its claims
+func parseAccessToken(tokenString string, keyfunc jwt.Keyfunc) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("ParseWithClaims(): %w", err)
+	}
+	return claims, nil
 }
 
-// GenerateAccessAndRefreshTokens func returns access & refresh tokens
-func GenerateAccessAndRefreshTokens(key, role string, id uuid.UUID) (access, refresh string, err error) {
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
-		Role: role,
+// GenerateAccessAndRefreshTokens func returns access & refresh tokens, the refresh token belongs to the given token family
+func GenerateAccessAndRefreshTokens(signer TokenSigner, role string, id, family uuid.UUID) (access, refresh string, err error) {
+	now := time.Now()
+	access, err = signer.Sign(&tokenClaims{
+		Role:       role,
+		Family:     family.String(),
+		IssuedAtMs: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(accessTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Id:        id.String(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        uuid.New().String(),
+			Subject:   id.String(),
 		},
 	})
-
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
+	if err != nil {
+		return "", "", fmt.Errorf("Sign(access): %w", err)
+	}
+	refresh, err = signer.Sign(&refreshClaims{
+		Family: family.String(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(refreshTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Id:        id.String(),
+			Audience:  mdlwr.RefreshAudience,
+			ExpiresAt: now.Add(refreshTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        uuid.New().String(),
+			Subject:   id.String(),
 		},
 	})
-	access, err = accessToken.SignedString([]byte(key))
-	if err != nil {
-		return "", "", fmt.Errorf("SignedString(access): %w", err)
-	}
-	refresh, err = refreshToken.SignedString([]byte(key))
 	if err != nil {
-		return "", "", fmt.Errorf("SignedString(refresh): %w", err)
+		return "", "", fmt.Errorf("Sign(refresh): %w", err)
 	}
-	return access, refresh, err
+	return access, refresh, nil
 }
 
-// Delete calls delete method from repository level
+// Delete calls delete method from repository level and drops the user from the cache
 func (db *UserService) Delete(ctx context.Context, id uuid.UUID) error {
-	err := db.rdb.Delete(ctx, id)
+	err := db.rps.Delete(ctx, id)
 	if err != nil {
-		return db.rps.Delete(ctx, id)
+		return fmt.Errorf("Delete: %w", err)
 	}
-	return err
+	db.uncache(ctx, id)
+	return nil
 }
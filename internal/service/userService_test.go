@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// testPassword satisfies testPolicy
+const testPassword = "Correct-Horse-9"
+
+// testPolicy is the password policy of the tested user services
+var testPolicy = &PasswordPolicy{MinLength: 10, MinClasses: 3, RejectLogin: true}
+
+// testLimits locks out after five failures, the two after the free ones wait 1s and 2s
+var testLimits = LoginLimits{Free: 2, Lockout: 5, BackoffBase: time.Second, BackoffMax: time.Minute, LockoutTTL: 15 * time.Minute}
+
+// newTestMFA returns a second factor service on a memory store, admins have to use it
+func newTestMFA(users LoginFinder) *MFAService {
+	return NewMFAService(repository.NewMFAMemoryConnection(), users, "myapp", []string{model.RoleAdmin})
+}
+
+// newTestUserService returns a user service on memory stores with a signed up user
+func newTestUserService(t *testing.T) (*UserService, *repository.UserMemoryCacheConnection, *model.User) {
+	rps := repository.NewUserMemoryConnection()
+	rdb := repository.NewUserMemoryCacheConnection()
+	srv := NewUserServiceImpl(rps, rdb, repository.NewTokenFamilyMemoryConnection(), newTestKeySet(t), nil,
+		testPolicy, NewLoginThrottle(rdb, testLimits, testLimits), newTestMFA(rps))
+	user := &model.User{ID: uuid.New(), Login: "eugen", Password: []byte(testPassword), Role: "user"}
+	require.NoError(t, srv.Signup(context.Background(), user, ""))
+	return srv, rdb, user
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	srv, _, user := newTestUserService(t)
+	access, refresh, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+
+	// Step 1: every refresh rotates the token within the family
+	for i := 1; i <= 3; i++ {
+		access, refresh, err = srv.RefreshTokenPair(ctx, access, refresh, user.ID)
+		require.NoError(t, err)
+	}
+	claims, familyID, err := parseRefreshToken(refresh, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	require.Equal(t, user.ID.String(), claims.Subject)
+	family, err := srv.families.Get(ctx, familyID)
+	require.NoError(t, err)
+	require.Equal(t, 3, family.Generation)
+
+	// Step 2: another user can't use the tokens and an access token is no refresh token
+	_, _, err = srv.RefreshTokenPair(ctx, access, refresh, uuid.New())
+	require.Error(t, err)
+	_, _, err = srv.RefreshTokenPair(ctx, access, access, user.ID)
+	require.Error(t, err)
+
+	// Step 3: a second login starts its own family
+	_, otherRefresh, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	_, otherFamilyID, err := parseRefreshToken(otherRefresh, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	require.NotEqual(t, familyID, otherFamilyID)
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+	srv, rdb, user := newTestUserService(t)
+	access, stolen, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	_, otherRefresh, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	access, refresh, err := srv.RefreshTokenPair(ctx, access, stolen, user.ID)
+	require.NoError(t, err)
+
+	// Step 1: the rotated token is presented again and revokes its family
+	_, _, err = srv.RefreshTokenPair(ctx, access, stolen, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenReuse)
+	_, familyID, err := parseRefreshToken(refresh, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	cached, err := rdb.GetTokenFamily(ctx, familyID)
+	require.NoError(t, err)
+	require.NotNil(t, cached.RevokedAt)
+
+	// Step 2: the latest token of the family is revoked as well
+	_, _, err = srv.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+
+	// Step 3: the revocation holds without the cache and spares other families
+	rdb = repository.NewUserMemoryCacheConnection()
+	srv.rdb = rdb
+	_, _, err = srv.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+	_, _, err = srv.RefreshTokenPair(ctx, access, otherRefresh, user.ID)
+	require.NoError(t, err)
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	srv, _, user := newTestUserService(t)
+	access, refresh, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	otherAccess, _, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	claims, err := parseAccessToken(access, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	issuedAt := time.UnixMilli(claims.IssuedAtMs)
+
+	// Step 1: the token of the session is revoked, others are not
+	require.NoError(t, srv.Logout(ctx, access))
+	require.True(t, srv.IsRevoked(ctx, claims.Id, user.ID, issuedAt))
+	otherClaims, err := parseAccessToken(otherAccess, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	require.False(t, srv.IsRevoked(ctx, otherClaims.Id, user.ID, issuedAt))
+
+	// Step 2: the refresh token of the session is revoked as well
+	_, _, err = srv.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	ctx := context.Background()
+	srv, _, user := newTestUserService(t)
+	access, refresh, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	claims, err := parseAccessToken(access, srv.keys.Keyfunc)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.RevokeSessions(ctx, user.ID))
+	require.True(t, srv.IsRevoked(ctx, claims.Id, user.ID, time.UnixMilli(claims.IssuedAtMs)))
+	require.False(t, srv.IsRevoked(ctx, claims.Id, uuid.New(), time.UnixMilli(claims.IssuedAtMs)))
+	_, _, err = srv.RefreshTokenPair(ctx, access, refresh, user.ID)
+	require.ErrorIs(t, err, model.ErrTokenRevoked)
+
+	// a login right after the revocation, within the same second, is not revoked
+	time.Sleep(2 * time.Millisecond)
+	fresh, _, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	require.NoError(t, err)
+	freshClaims, err := parseAccessToken(fresh, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	require.False(t, srv.IsRevoked(ctx, freshClaims.Id, user.ID, time.UnixMilli(freshClaims.IssuedAtMs)))
+}
+
+func TestIsRevokedCacheDown(t *testing.T) {
+	rps := repository.NewUserMemoryConnection()
+	rdb := downUserCache{repository.NewUserMemoryCacheConnection()}
+	srv := NewUserServiceImpl(rps, rdb, repository.NewTokenFamilyMemoryConnection(), newTestKeySet(t), nil,
+		testPolicy, NewLoginThrottle(rdb, testLimits, testLimits), newTestMFA(rps))
+	require.False(t, srv.IsRevoked(context.Background(), uuid.New().String(), uuid.New(), time.Now()))
+}
+
+func TestGenerateTokensThrottled(t *testing.T) {
+	ctx := context.Background()
+	srv, _, _ := newTestUserService(t)
+
+	// Step 1: unknown logins and wrong passwords fail alike and are counted
+	_, _, err := srv.GenerateTokens(ctx, "nobody", testPassword, "192.0.2.2")
+	require.ErrorIs(t, err, model.ErrInvalidCredentials)
+	for i := 0; i < testLimits.Free; i++ {
+		_, _, err = srv.GenerateTokens(ctx, "eugen", "wrong", "192.0.2.1")
+		require.ErrorIs(t, err, model.ErrInvalidCredentials)
+	}
+	_, _, err = srv.GenerateTokens(ctx, "eugen", "wrong", "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrInvalidCredentials)
+
+	// Step 2: the right password waits for the backoff as well
+	_, _, err = srv.GenerateTokens(ctx, "eugen", testPassword, "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrLoginThrottled)
+
+	// Step 3: once unlocked the login succeeds and forgets its failures
+	require.NoError(t, srv.Unlock(ctx, "eugen", "192.0.2.1"))
+	_, _, err = srv.GenerateTokens(ctx, "eugen", testPassword, "192.0.2.1")
+	require.NoError(t, err)
+	failures, err := srv.rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+}
+
+// comparingRepository counts the logins looked up, each of which has its password compared
+type comparingRepository struct {
+	UserRepository
+	compared int32
+}
+
+func (r *comparingRepository) GetUser(ctx context.Context, login string) (*model.User, error) {
+	atomic.AddInt32(&r.compared, 1)
+	return r.UserRepository.GetUser(ctx, login)
+}
+
+func TestGenerateTokensConcurrentFailures(t *testing.T) {
+	ctx := context.Background()
+	srv, _, _ := newTestUserService(t)
+	rps := &comparingRepository{UserRepository: srv.rps}
+	srv.rps = rps
+	now := time.Now()
+	srv.throttle.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := srv.GenerateTokens(ctx, "eugen", "wrong", "192.0.2.1")
+			require.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(testLimits.Free+1), atomic.LoadInt32(&rps.compared))
+	failures, err := srv.rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Equal(t, testLimits.Free+1, failures.Count)
+}
+
+func TestSignupPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	srv, _, _ := newTestUserService(t)
+	err := srv.Signup(ctx, &model.User{ID: uuid.New(), Login: "weak", Password: []byte("password")}, "")
+	require.ErrorIs(t, err, model.ErrWeakPassword)
+	_, err = srv.rps.GetUser(ctx, "weak")
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+// requireMFARequired requires err to be a *model.MFARequiredError and returns it
+func requireMFARequired(t *testing.T, err error, enroll bool) *model.MFARequiredError {
+	var required *model.MFARequiredError
+	require.ErrorAs(t, err, &required)
+	require.ErrorIs(t, err, model.ErrMFARequired)
+	require.Equal(t, enroll, required.Enroll)
+	require.NotEmpty(t, required.Challenge)
+	return required
+}
+
+func TestGenerateTokensMFA(t *testing.T) {
+	ctx := context.Background()
+	srv, _, user := newTestUserService(t)
+	mfa := srv.mfa.(*MFAService)
+	now := time.Now()
+	mfa.now = func() time.Time { return now }
+	secret, _ := enrollTestMFA(t, mfa, user.ID)
+	now = now.Add(totpPeriod)
+
+	// Step 1: the right password returns a challenge instead of tokens
+	_, _, err := srv.GenerateTokens(ctx, "eugen", testPassword, "192.0.2.1")
+	required := requireMFARequired(t, err, false)
+	_, err = srv.EnrollMFA(ctx, required.Challenge)
+	require.ErrorIs(t, err, model.ErrMFAEnabled)
+
+	// Step 2: wrong codes are counted as failed logins
+	_, err = srv.LoginMFA(ctx, required.Challenge, "000000", "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrInvalidMFACode)
+	failures, err := srv.rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Equal(t, 1, failures.Count)
+
+	// Step 3: the challenge and a valid code are exchanged for tokens
+	tokens, err := srv.LoginMFA(ctx, required.Challenge, totpCode(secret, totpStep(now)), "192.0.2.1")
+	require.NoError(t, err)
+	require.Empty(t, tokens.RecoveryCodes)
+	id, err := ExtractIDFromToken(tokens.AccessToken, srv.keys.Keyfunc)
+	require.NoError(t, err)
+	require.Equal(t, user.ID.String(), id)
+	_, _, err = srv.RefreshTokenPair(ctx, tokens.AccessToken, tokens.RefreshToken, user.ID)
+	require.NoError(t, err)
+	failures, err = srv.rdb.GetLoginFailures(ctx, model.LoginScopeLogin, "eugen")
+	require.NoError(t, err)
+	require.Zero(t, failures.Count)
+
+	// Step 4: tokens are not challenges
+	_, err = srv.LoginMFA(ctx, tokens.AccessToken, totpCode(secret, totpStep(now)), "192.0.2.1")
+	require.ErrorIs(t, err, model.ErrInvalidCredentials)
+}
+
+func TestGenerateTokensMFAEnroll(t *testing.T) {
+	ctx := context.Background()
+	srv, _, user := newTestUserService(t)
+	require.NoError(t, srv.rps.SetRole(ctx, user.ID, model.RoleAdmin))
+
+	// Step 1: the role requires a second factor, which is enrolled during the login
+	_, _, err := srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	required := requireMFARequired(t, err, true)
+	_, err = srv.LoginMFA(ctx, required.Challenge, "000000", "")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	enrollment, err := srv.EnrollMFA(ctx, required.Challenge)
+	require.NoError(t, err)
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+
+	// Step 2: the first code confirms it and the recovery codes come along with the tokens
+	tokens, err := srv.LoginMFA(ctx, required.Challenge, totpCode(secret, totpStep(time.Now())), "")
+	require.NoError(t, err)
+	require.Len(t, tokens.RecoveryCodes, recoveryCodeCount)
+	require.NotEmpty(t, tokens.AccessToken)
+
+	// Step 3: later logins verify it
+	_, _, err = srv.GenerateTokens(ctx, "eugen", testPassword, "")
+	requireMFARequired(t, err, false)
+}
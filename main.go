@@ -4,16 +4,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
-	"time"
+	"os"
+	"strings"
 
 	_ "github.com/eugenshima/myapp/docs"
 	cfgrtn "github.com/eugenshima/myapp/internal/config"
 	"github.com/eugenshima/myapp/internal/consumer"
 	"github.com/eugenshima/myapp/internal/handlers"
+	"github.com/eugenshima/myapp/internal/mail"
 	middlwr "github.com/eugenshima/myapp/internal/middleware"
+	"github.com/eugenshima/myapp/internal/model"
 	"github.com/eugenshima/myapp/internal/producer"
-	"github.com/eugenshima/myapp/internal/repository"
 	"github.com/eugenshima/myapp/internal/service"
 
 	"github.com/go-playground/validator"
@@ -40,11 +43,51 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 	return nil
 }
 
+// storage backends, selected through STORAGE_BACKEND and CACHE_BACKEND
 const (
-	pgx    = "pgx"
-	mongod = "mongo"
+	pgx        = "postgres"
+	mongod     = "mongo"
+	memory     = "memory"
+	redisCache = "redis"
 )
 
+// mail backends, selected through MAIL_BACKEND
+const (
+	smtpMail = "smtp"
+	fileMail = "file"
+	logMail  = "log"
+)
+
+// newMailer returns the mail sender of the configured mail backend
+func newMailer(cfg *cfgrtn.Config) (service.Mailer, error) {
+	switch cfg.MailBackend {
+	case smtpMail:
+		return mail.NewSMTPMailer(cfg.SMTPAddr, cfg.MailFrom, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	case fileMail:
+		return mail.NewFileMailer(cfg.MailFile, cfg.MailFrom), nil
+	case logMail:
+		return mail.NewLogMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown mail backend %q (expected %q, %q or %q)", cfg.MailBackend, smtpMail, fileMail, logMail)
+}
+
+// newIPExtractor returns how the client IP of a request is found. Only the configured proxies are trusted
+// with X-Forwarded-For, a client can't pick its IP and slip past the login throttle by setting the header
+func newIPExtractor(cfg *cfgrtn.Config) (echo.IPExtractor, error) {
+	if len(cfg.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	trust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range cfg.TrustedProxies {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(proxy))
+		if err != nil {
+			return nil, fmt.Errorf("ParseCIDR(): trusted proxy %q: %w", proxy, err)
+		}
+		trust = append(trust, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(trust...), nil
+}
+
 // NewMongo creates a connection to MongoDB server
 func NewMongo(env string) (*mongo.Client, error) {
 	clientOptions := options.Client().ApplyURI(env)
@@ -114,90 +157,240 @@ func main() {
 		fmt.Printf("Error extracting env variables: %v", err)
 		return
 	}
-
-	ch := mongod
-	// Initializing the Database Connector (MongoDB)
-	client, err := NewMongo(cfg.MongoDBAddr)
+	if len(os.Args) > 1 {
+		run := command(os.Args[1])
+		if run == nil {
+			logrus.Fatalf("unknown command %q", os.Args[1])
+		}
+		if err = run(cfg, os.Args[2:]); err != nil {
+			logrus.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+	e.IPExtractor, err = newIPExtractor(cfg)
 	if err != nil {
-		err := echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("error creating database connection with MongoDB: %w", err))
 		e.Logger.Fatal(err)
 	}
-	// Initializing the Database Connector (PostgreSQL)
-	pool, err := NewDBPsql(cfg.PgxDBAddr)
+
+	stores, err := newStorage(cfg)
 	if err != nil {
-		err := echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("error creating database connection with PostgreSQL: %w", err))
 		e.Logger.Fatal(err)
 	}
-	rdbClient, err := NewDBRedis(cfg.RedisDBAddr)
+	// Initializing the Database Connectors of the selected backends
+	rps, err := stores.personRepository()
 	if err != nil {
-		err := echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("error creating database connection with Redis: %w", err))
-		e.Logger.Fatal(err)
+		e.Logger.Fatal(fmt.Errorf("error creating person repository: %w", err))
+	}
+	audit, err := stores.auditRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating audit repository: %w", err))
+	}
+	events, err := stores.outboxRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating outbox: %w", err))
+	}
+	txm, err := stores.txManager()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating transaction manager: %w", err))
+	}
+	utxm, err := stores.userTxManager()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating user transaction manager: %w", err))
+	}
+	urps, err := stores.userRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating user repository: %w", err))
+	}
+	families, err := stores.tokenFamilyRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating token family repository: %w", err))
+	}
+	signingKeys, err := stores.signingKeyRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating signing key repository: %w", err))
+	}
+	roleStore, err := stores.roleRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating role repository: %w", err))
+	}
+	invitations, err := stores.invitationRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating invitation repository: %w", err))
+	}
+	roleRequests, err := stores.roleRequestRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating role request repository: %w", err))
+	}
+	mfaStore, err := stores.mfaRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating second factor repository: %w", err))
+	}
+	apiKeyStore, err := stores.apiKeyRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating API key repository: %w", err))
+	}
+	passwordResets, err := stores.passwordResetRepository()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating password reset repository: %w", err))
+	}
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating mailer: %w", err))
+	}
+	rdb, err := stores.personCache()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating person cache: %w", err))
+	}
+	urdb, err := stores.userCache()
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating user cache: %w", err))
 	}
 
-	var handlr *handlers.PersonHandler
-	var uhandlr *handlers.UserHandler
-	switch ch {
-	case mongod:
-
-		// Person db mongodb
-		rps := repository.NewMongoDBConnection(client)
-		rdb := repository.NewRedisConnection(rdbClient)
-		srv := service.NewPersonService(rps, rdb)
-		handlr = handlers.NewPersonHandler(srv, validator.New())
+	if stores.bus != nil {
+		go stores.bus.Run(context.Background())
+	}
+	cache, err := service.NewPersonCache(cfg.CachePolicy, rdb)
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error creating person cache policy: %w", err))
+	}
+	if writeBehind, ok := cache.(*service.WriteBehindCache); ok {
+		go writeBehind.Run(context.Background())
+	}
 
-		// User db mongodb
-		urps := repository.NewUserMongoDBConnection(client)
-		urdb := repository.NewUserRedisConnection(rdbClient)
-		usrv := service.NewUserServiceImpl(urps, urdb)
-		uhandlr = handlers.NewUserHandler(usrv, validator.New())
+	// Person
+	srv := service.NewPersonService(rps, cache, audit, events, txm)
+	handlr := handlers.NewPersonHandler(srv, validator.New())
 
-	case pgx:
-		// Person db pgx
-		rps := repository.NewPsqlConnection(pool)
-		rdb := repository.NewRedisConnection(rdbClient)
-		srv := service.NewPersonService(rps, rdb)
-		handlr = handlers.NewPersonHandler(srv, validator.New())
+	// Token signing keys
+	keyCipher, err := service.NewKeyCipher(cfg.JWTKeyEncryptionKey)
+	if err != nil {
+		e.Logger.Fatal(fmt.Errorf("error reading JWT_KEY_ENCRYPTION_KEY: %w", err))
+	}
+	keys := service.NewKeySet(signingKeys, keyCipher, cfg.JWTKeyRotation, cfg.JWTKeyPublishLead)
+	if err = keys.Rotate(context.Background()); err != nil {
+		e.Logger.Fatal(fmt.Errorf("error loading signing keys: %w", err))
+	}
+	go keys.Run(context.Background())
+	jhandlr := handlers.NewJWKSHandler(keys)
 
-		// User db pgx
-		urps := repository.NewUserPsqlConnection(pool)
-		urdb := repository.NewUserRedisConnection(rdbClient)
-		usrv := service.NewUserServiceImpl(urps, urdb)
-		uhandlr = handlers.NewUserHandler(usrv, validator.New())
+	// Roles, the permissions they grant and the invitations and requests handing them out
+	roles := service.NewRoleService(roleStore, urps, urdb)
+	if err = roles.EnsureDefaults(context.Background()); err != nil {
+		e.Logger.Fatal(fmt.Errorf("error loading roles: %w", err))
 	}
+	rhandlr := handlers.NewRoleHandler(roles, validator.New())
+	enrollment := service.NewEnrollmentService(invitations, roleRequests, roles, cfg.InvitationTTL)
+	ehandlr := handlers.NewEnrollmentHandler(enrollment, validator.New())
+
+	// Second factors, required for the users of the configured roles
+	mfa := service.NewMFAService(mfaStore, urps, cfg.MFAIssuer, cfg.MFARequiredRoles)
+	mhandlr := handlers.NewMFAHandler(mfa, validator.New())
 
+	// User, with the password policy, the throttling of failed logins and the second factor
+	policy := &service.PasswordPolicy{MinLength: cfg.PasswordMinLength, MinClasses: cfg.PasswordMinClasses, RejectLogin: cfg.PasswordRejectLogin}
+	throttle := service.NewLoginThrottle(urdb,
+		service.LoginLimits{Free: cfg.LoginFreeAttempts, Lockout: cfg.LoginLockoutThreshold,
+			BackoffBase: cfg.LoginBackoffBase, BackoffMax: cfg.LoginBackoffMax, LockoutTTL: cfg.LoginLockoutDuration},
+		service.LoginLimits{Free: cfg.LoginIPFreeAttempts, Lockout: cfg.LoginIPLockoutThreshold,
+			BackoffBase: cfg.LoginBackoffBase, BackoffMax: cfg.LoginBackoffMax, LockoutTTL: cfg.LoginLockoutDuration})
+	usrv := service.NewUserServiceImpl(urps, urdb, families, keys, enrollment, policy, throttle, mfa)
+	uhandlr := handlers.NewUserHandler(usrv, validator.New())
+
+	// API keys of services acting for users
+	apiKeys := service.NewAPIKeyService(apiKeyStore, urps, roles, cfg.APIKeyMaxTTL)
+	khandlr := handlers.NewAPIKeyHandler(apiKeys, validator.New())
+
+	// Password changes, and resets of forgotten passwords with tokens mailed to the login
+	resetRequests := service.NewResetThrottle(urdb, cfg.PasswordResetLoginRequests, cfg.PasswordResetIPRequests, cfg.PasswordResetWindow)
+	passwords := service.NewPasswordService(urps, passwordResets, mailer, usrv, apiKeys, utxm, policy, throttle,
+		resetRequests, cfg.PasswordResetTTL, cfg.PasswordResetURL)
+	phandlr := handlers.NewPasswordHandler(passwords, validator.New())
+
+	// Cache
+	chandlr := handlers.NewCacheHandler(stores.cacheService(rps, urps, cache), validator.New())
+
+	// every authenticated route requires the permissions it needs, routes without one require a session
+	auth := middlwr.UserIdentity(keys.Keyfunc, usrv, apiKeys)
+	session := middlwr.RequireSession()
+	can := func(permissions ...string) echo.MiddlewareFunc {
+		return middlwr.RequirePermission(roles, permissions...)
+	}
 	api := e.Group("/api")
 	{
 		// Person Api
-		person := api.Group("/person")
-		person.POST("/insert", handlr.Create, middlwr.AdminIdentity())
-		person.GET("/getAll", handlr.GetAll, middlwr.UserIdentity())
-		person.GET("/getById/:id", handlr.GetByID, middlwr.UserIdentity())
-		person.PATCH("/update/:id", handlr.Update, middlwr.AdminIdentity())
-		person.DELETE("/delete/:id", handlr.Delete, middlwr.AdminIdentity())
+		person := api.Group("/person", auth)
+		person.POST("/insert", handlr.Create, can(model.PermissionPersonWrite))
+		person.GET("/getAll", handlr.GetAll, can(model.PermissionPersonRead))
+		person.GET("/getById/:id", handlr.GetByID, can(model.PermissionPersonRead))
+		person.PATCH("/update/:id", handlr.Update, can(model.PermissionPersonWrite))
+		person.DELETE("/delete/:id", handlr.Delete, can(model.PermissionPersonWrite))
+		person.GET("/trash", handlr.GetDeleted, can(model.PermissionPersonAdmin))
+		person.POST("/restore/:id", handlr.Restore, can(model.PermissionPersonAdmin))
+		person.DELETE("/purge/:id", handlr.Purge, can(model.PermissionPersonAdmin))
+		person.POST("/bulk/insert", handlr.BulkCreate, can(model.PermissionPersonWrite))
+		person.PATCH("/bulk/update", handlr.BulkUpdate, can(model.PermissionPersonWrite))
+		person.POST("/bulk/delete", handlr.BulkDelete, can(model.PermissionPersonWrite))
 
 		// User Api
 		user := api.Group("/user")
 		user.POST("/login", uhandlr.Login)
+		user.POST("/login/mfa", uhandlr.LoginMFA)
+		user.POST("/login/mfa/enroll", uhandlr.EnrollMFA)
 		user.POST("/signup", uhandlr.Signup)
-		user.GET("/getAll", uhandlr.GetAll)
+		user.GET("/getAll", uhandlr.GetAll, auth, can(model.PermissionUserRead))
 		user.POST("/refresh/:id", uhandlr.RefreshTokenPair)
-		user.DELETE("/delete/:id", uhandlr.Delete)
+		user.POST("/password/forgot", phandlr.Forgot)
+		user.POST("/password/reset", phandlr.Reset)
+		user.POST("/password/change", phandlr.Change, auth, session)
+		user.POST("/logout", uhandlr.Logout, auth, session)
+		user.POST("/role-request", ehandlr.RequestRole, auth, session)
+		user.POST("/mfa/enroll", mhandlr.Enroll, auth, session)
+		user.POST("/mfa/confirm", mhandlr.Confirm, auth, session)
+		user.POST("/mfa/disable", mhandlr.Disable, auth, session)
+		user.POST("/apikeys", khandlr.Create, auth, session)
+		user.GET("/apikeys", khandlr.GetAll, auth, session)
+		user.POST("/apikeys/:id/rotate", khandlr.Rotate, auth, session)
+		user.DELETE("/apikeys/:id", khandlr.Revoke, auth, session)
+		user.DELETE("/delete/:id", uhandlr.Delete, auth, can(model.PermissionUserAdmin))
+
+		// Admin Api
+		admin := api.Group("/admin", auth)
+		admin.GET("/audit", handlr.GetAudit, can(model.PermissionPersonAdmin))
+		admin.DELETE("/user/:id/sessions", uhandlr.RevokeSessions, can(model.PermissionUserAdmin))
+		admin.PUT("/user/:id/role", rhandlr.AssignRole, can(model.PermissionUserAdmin))
+		admin.POST("/unlock", uhandlr.Unlock, can(model.PermissionUserAdmin))
+		admin.DELETE("/user/:id/mfa", mhandlr.Reset, can(model.PermissionUserAdmin))
+		admin.POST("/invitations", ehandlr.CreateInvitation, can(model.PermissionUserAdmin))
+		admin.GET("/role-requests", ehandlr.GetRoleRequests, can(model.PermissionUserAdmin))
+		admin.POST("/role-requests/:id/approve", ehandlr.ApproveRoleRequest, can(model.PermissionUserAdmin))
+		admin.POST("/role-requests/:id/reject", ehandlr.RejectRoleRequest, can(model.PermissionUserAdmin))
+		admin.GET("/roles", rhandlr.GetAll, can(model.PermissionUserAdmin))
+		admin.PUT("/roles/:name", rhandlr.Save, can(model.PermissionUserAdmin))
+		admin.DELETE("/roles/:name", rhandlr.Delete, can(model.PermissionUserAdmin))
+		admin.GET("/cache/stats", chandlr.Stats, can(model.PermissionCacheAdmin))
+		admin.GET("/cache/breakers", chandlr.Breakers, can(model.PermissionCacheAdmin))
+		admin.GET("/cache/write-behind", chandlr.WriteBehind, can(model.PermissionCacheAdmin))
+		admin.GET("/cache/:entity/:id", chandlr.Inspect, can(model.PermissionCacheAdmin))
+		admin.DELETE("/cache/:entity", chandlr.Flush, can(model.PermissionCacheAdmin))
+		admin.POST("/cache/:entity/warm", chandlr.Warm, can(model.PermissionCacheAdmin))
 
 		// Image requests
-		image := api.Group("/image")
-		image.Use(middlwr.AdminIdentity())
-		image.GET("/get/:name", uhandlr.GetImage)
-		image.POST("/set", uhandlr.SetImage)
+		image := api.Group("/image", auth)
+		image.GET("/get/:name", uhandlr.GetImage, can(model.PermissionImageRead))
+		image.POST("/set", uhandlr.SetImage, can(model.PermissionImageWrite))
 	}
 	e.GET("/swagger/*", swg.WrapHandler)
+	e.GET("/.well-known/jwks.json", jhandlr.JWKS)
 
-	redisProd := producer.NewProducer(rdbClient)
-	redisCons := consumer.NewConsumer(rdbClient)
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
-	defer cancel()
-	// Redis Stream
-	go redisProd.RedisProducer(ctx)
-	go redisCons.RedisConsumer(ctx)
+	// Redis Stream of person change events
+	if cfg.EventsStream != "" {
+		streamRdb, err := stores.redis()
+		if err != nil {
+			e.Logger.Fatal(fmt.Errorf("error creating events stream client: %w", err))
+		}
+		go producer.NewOutboxRelay(events, streamRdb, cfg.EventsStream).Run(context.Background())
+		go consumer.NewConsumer(streamRdb, cfg.EventsStream).RedisConsumer(context.Background())
+	}
 
 	e.Logger.Fatal(e.Start(cfg.HTTPAddr))
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	cfgrtn "github.com/eugenshima/myapp/internal/config"
+	"github.com/eugenshima/myapp/internal/migrate"
+	"github.com/eugenshima/myapp/internal/model"
+	"github.com/eugenshima/myapp/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migrateCommand is the subcommand copying persons and users between backends
+const migrateCommand = "migrate-data"
+
+// backendStores are the migration stores of a single backend
+type backendStores struct {
+	persons migrate.Store[*model.Person]
+	users   migrate.Store[*model.User]
+	// counter counts the records of the stores which are not copied
+	counter migrate.Counter
+}
+
+// runMigrate parses the migrate-data flags, copies persons and users and verifies the copy,
+// an interrupted run is resumed from the checkpoint file. The other stores are not copied, so it refuses
+// to run while the source holds records in them unless -leave names them
+func runMigrate(cfg *cfgrtn.Config, args []string) error {
+	flags := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	from := flags.String("from", pgx, "source backend (postgres or mongo)")
+	to := flags.String("to", mongod, "target backend (postgres or mongo)")
+	batch := flags.Int("batch", migrate.DefaultBatch, "records copied at once")
+	checkpoint := flags.String("checkpoint", "migrate-data.checkpoint.json", "checkpoint file used to resume an interrupted run")
+	restart := flags.Bool("restart", false, "ignore the checkpoint and copy everything again")
+	leave := flags.String("leave", "", "comma separated stores which are not copied and whose records are left behind ("+
+		strings.Join(migrate.Uncovered, ", ")+")")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == *to {
+		return fmt.Errorf("source and target backends are both %q", *from)
+	}
+
+	stores := &storage{cfg: cfg}
+	source, err := stores.migrationStores(*from)
+	if err != nil {
+		return err
+	}
+	target, err := stores.migrationStores(*to)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err = migrate.CheckUncovered(ctx, source.counter, splitList(*leave)); err != nil {
+		return fmt.Errorf("CheckUncovered(): %w", err)
+	}
+	if *restart {
+		if err = os.Remove(*checkpoint); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Remove(): %w", err)
+		}
+	}
+	m, err := migrate.NewMigrator(*checkpoint, *from, *to, *batch)
+	if err != nil {
+		return fmt.Errorf("NewMigrator(): %w", err)
+	}
+
+	persons := migrate.Persons(source.persons, target.persons)
+	users := migrate.Users(source.users, target.users)
+	if err = migrate.Copy(ctx, m, persons); err != nil {
+		return fmt.Errorf("copying persons: %w", err)
+	}
+	if err = migrate.Copy(ctx, m, users); err != nil {
+		return fmt.Errorf("copying users: %w", err)
+	}
+	if err = verify(ctx, m, persons); err != nil {
+		return err
+	}
+	if err = verify(ctx, m, users); err != nil {
+		return err
+	}
+	return m.Done()
+}
+
+// verify compares the entity in both backends and logs the result
+func verify[T any](ctx context.Context, m *migrate.Migrator, e migrate.Entity[T]) error {
+	source, target, err := migrate.Verify(ctx, m, e)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", e.Name, err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"entity":   e.Name,
+		"count":    source.Count,
+		"checksum": source.Checksum,
+	}).Infof("verified, target count %d", target.Count)
+	return nil
+}
+
+// migrationStores returns the person and user stores of a persistent backend
+func (s *storage) migrationStores(backend string) (*backendStores, error) {
+	switch backend {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return &backendStores{
+			persons: repository.NewPsqlConnection(pool),
+			users:   repository.NewUserPsqlConnection(pool),
+			counter: repository.NewRecordCountPsqlConnection(pool),
+		}, nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return &backendStores{
+			persons: repository.NewMongoDBConnection(client),
+			users:   repository.NewUserMongoDBConnection(client),
+			counter: repository.NewRecordCountMongoDBConnection(client),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown migration backend %q (expected %q or %q)", backend, pgx, mongod)
+}
+
+// splitList splits a comma separated flag value, ignoring blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS goschema.invitation (
+    id         uuid        PRIMARY KEY,
+    code_hash  bytea       NOT NULL UNIQUE,
+    role       text        NOT NULL,
+    created_by uuid        NOT NULL,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    used_by    uuid
+);
+
+CREATE TABLE IF NOT EXISTS goschema.role_request (
+    id         uuid        PRIMARY KEY,
+    user_id    uuid        NOT NULL,
+    role       text        NOT NULL,
+    status     text        NOT NULL,
+    created_at timestamptz NOT NULL,
+    decided_by uuid,
+    decided_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS role_request_status_idx ON goschema.role_request (status, created_at);
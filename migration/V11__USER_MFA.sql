@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS goschema.user_mfa (
+    user_id        uuid        PRIMARY KEY,
+    secret         bytea       NOT NULL,
+    recovery_codes text[]      NOT NULL DEFAULT '{}',
+    last_step      bigint      NOT NULL DEFAULT 0,
+    created_at     timestamptz NOT NULL,
+    confirmed_at   timestamptz
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS goschema.api_key (
+    id           uuid        PRIMARY KEY,
+    user_id      uuid        NOT NULL,
+    name         text        NOT NULL,
+    prefix       text        NOT NULL,
+    hash         bytea       NOT NULL UNIQUE,
+    scopes       text[]      NOT NULL DEFAULT '{}',
+    created_at   timestamptz NOT NULL,
+    rotated_at   timestamptz,
+    expires_at   timestamptz NOT NULL,
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS api_key_user_idx ON goschema.api_key (user_id, created_at);
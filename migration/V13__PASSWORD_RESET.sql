@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS goschema.password_reset (
+    id         uuid        PRIMARY KEY,
+    user_id    uuid        NOT NULL,
+    token_hash bytea       NOT NULL UNIQUE,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_user_idx ON goschema.password_reset (user_id) WHERE used_at IS NULL;
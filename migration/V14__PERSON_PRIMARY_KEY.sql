@@ -0,0 +1,2 @@
+ALTER TABLE goschema.person
+    ADD PRIMARY KEY (id);
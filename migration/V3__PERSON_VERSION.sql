@@ -0,0 +1,2 @@
+ALTER TABLE goschema.person
+    ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
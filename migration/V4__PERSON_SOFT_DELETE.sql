@@ -0,0 +1,2 @@
+ALTER TABLE goschema.person
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS goschema.person_audit (
+    id         uuid PRIMARY KEY,
+    person_id  uuid        NOT NULL,
+    actor_id   uuid        NOT NULL,
+    actor_role text        NOT NULL,
+    action     text        NOT NULL,
+    before     jsonb,
+    after      jsonb,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS person_audit_person_idx ON goschema.person_audit (person_id, created_at);
+CREATE INDEX IF NOT EXISTS person_audit_actor_idx ON goschema.person_audit (actor_id, created_at);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS goschema.outbox (
+    seq          bigserial PRIMARY KEY,
+    id           uuid        NOT NULL UNIQUE,
+    type         text        NOT NULL,
+    aggregate_id uuid        NOT NULL,
+    payload      jsonb       NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now()
+);
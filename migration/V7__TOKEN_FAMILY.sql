@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS goschema.token_family (
+    id         uuid        PRIMARY KEY,
+    user_id    uuid        NOT NULL,
+    token_hash bytea       NOT NULL,
+    generation int         NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL,
+    rotated_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS token_family_user_id_idx ON goschema.token_family (user_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS goschema.signing_key (
+    id          text        PRIMARY KEY,
+    private_key bytea       NOT NULL,
+    active_from timestamptz NOT NULL,
+    expires_at  timestamptz NOT NULL,
+    created_at  timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS signing_key_expires_at_idx ON goschema.signing_key (expires_at);
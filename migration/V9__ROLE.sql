@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS goschema.role (
+    name        text   PRIMARY KEY,
+    permissions text[] NOT NULL DEFAULT '{}'
+);
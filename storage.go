@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	cfgrtn "github.com/eugenshima/myapp/internal/config"
+	"github.com/eugenshima/myapp/internal/producer"
+	"github.com/eugenshima/myapp/internal/repository"
+	"github.com/eugenshima/myapp/internal/service"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// outbox is written by the person service and read by the relay
+type outbox interface {
+	service.OutboxRepository
+	producer.Outbox
+}
+
+// storage opens database connections on first use, so only the selected backends are dialed
+type storage struct {
+	cfg    *cfgrtn.Config
+	pool   *pgxpool.Pool
+	client *mongo.Client
+	rdb    *redis.Client
+	bus    *repository.CacheBus
+	// breaker guards every call to the Redis caches, it is shared as they share the server
+	breaker *service.Breaker
+	// persons and users are the Redis caches operators can manage
+	persons *repository.TieredCacheConnection
+	users   *repository.UserTieredCacheConnection
+}
+
+// newStorage checks the configured backends and returns a lazy connector
+func newStorage(cfg *cfgrtn.Config) (*storage, error) {
+	if err := checkBackend("person", cfg.PersonBackend()); err != nil {
+		return nil, err
+	}
+	if err := checkBackend("user", cfg.UserBackend()); err != nil {
+		return nil, err
+	}
+	switch cfg.CacheBackend {
+	case redisCache, memory:
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q (expected %q or %q)", cfg.CacheBackend, redisCache, memory)
+	}
+	return &storage{cfg: cfg}, nil
+}
+
+// checkBackend fails on storage backends we can't serve
+func checkBackend(entity, backend string) error {
+	switch backend {
+	case pgx, mongod, memory:
+		return nil
+	}
+	return fmt.Errorf("unknown %s storage backend %q (expected %q, %q or %q)", entity, backend, pgx, mongod, memory)
+}
+
+// psql returns the PostgreSQL pool, connecting on the first call
+func (s *storage) psql() (*pgxpool.Pool, error) {
+	if s.pool == nil {
+		pool, err := NewDBPsql(s.cfg.PgxDBAddr)
+		if err != nil {
+			return nil, fmt.Errorf("NewDBPsql: %w", err)
+		}
+		s.pool = pool
+	}
+	return s.pool, nil
+}
+
+// mongo returns the MongoDB client, connecting on the first call
+func (s *storage) mongo() (*mongo.Client, error) {
+	if s.client == nil {
+		client, err := NewMongo(s.cfg.MongoDBAddr)
+		if err != nil {
+			return nil, fmt.Errorf("NewMongo: %w", err)
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// redis returns the Redis client, connecting on the first call
+func (s *storage) redis() (*redis.Client, error) {
+	if s.rdb == nil {
+		rdb, err := NewDBRedis(s.cfg.RedisDBAddr)
+		if err != nil {
+			return nil, fmt.Errorf("NewDBRedis: %w", err)
+		}
+		s.rdb = rdb
+	}
+	return s.rdb, nil
+}
+
+// personRepository returns the person repository of the configured backend
+func (s *storage) personRepository() (service.PersonRepositoryPsql, error) {
+	switch s.cfg.PersonBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewMongoDBConnection(client), nil
+	case memory:
+		return repository.NewMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown person storage backend %q", s.cfg.PersonBackend())
+}
+
+// auditRepository returns the person audit trail repository, it lives next to the persons
+func (s *storage) auditRepository() (service.AuditRepository, error) {
+	switch s.cfg.PersonBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewAuditPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewAuditMongoDBConnection(client), nil
+	case memory:
+		return repository.NewAuditMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown person storage backend %q", s.cfg.PersonBackend())
+}
+
+// outboxRepository returns the outbox of person change events, it lives next to the persons
+func (s *storage) outboxRepository() (outbox, error) {
+	switch s.cfg.PersonBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewOutboxPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewOutboxMongoDBConnection(client), nil
+	case memory:
+		return repository.NewOutboxMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown person storage backend %q", s.cfg.PersonBackend())
+}
+
+// txManager returns the transaction manager of the person backend
+func (s *storage) txManager() (service.TxManager, error) {
+	txm, err := s.txManagerOf(s.cfg.PersonBackend())
+	if err != nil {
+		return nil, fmt.Errorf("person storage: %w", err)
+	}
+	return txm, nil
+}
+
+// userTxManager returns the transaction manager of the user backend
+func (s *storage) userTxManager() (service.TxManager, error) {
+	txm, err := s.txManagerOf(s.cfg.UserBackend())
+	if err != nil {
+		return nil, fmt.Errorf("user storage: %w", err)
+	}
+	return txm, nil
+}
+
+// txManagerOf returns the transaction manager of a backend
+func (s *storage) txManagerOf(backend string) (service.TxManager, error) {
+	switch backend {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewPsqlTxManager(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		txm, err := repository.NewMongoTxManager(context.Background(), client)
+		if err != nil {
+			return nil, fmt.Errorf("NewMongoTxManager: %w", err)
+		}
+		return txm, nil
+	case memory:
+		return repository.NewMemoryTxManager(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// userRepository returns the user repository of the configured backend
+func (s *storage) userRepository() (service.UserRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewUserPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewUserMongoDBConnection(client), nil
+	case memory:
+		return repository.NewUserMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// tokenFamilyRepository returns the refresh token family repository of the configured user backend
+func (s *storage) tokenFamilyRepository() (service.TokenFamilyRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewTokenFamilyPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewTokenFamilyMongoDBConnection(client), nil
+	case memory:
+		return repository.NewTokenFamilyMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// signingKeyRepository returns the token signing key repository of the configured user backend
+func (s *storage) signingKeyRepository() (service.SigningKeyRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewSigningKeyPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewSigningKeyMongoDBConnection(client), nil
+	case memory:
+		return repository.NewSigningKeyMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// roleRepository returns the role repository of the configured user backend
+func (s *storage) roleRepository() (service.RoleRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRolePsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRoleMongoDBConnection(client), nil
+	case memory:
+		return repository.NewRoleMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// invitationRepository returns the invitation repository of the configured user backend
+func (s *storage) invitationRepository() (service.InvitationRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewInvitationPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewInvitationMongoDBConnection(client), nil
+	case memory:
+		return repository.NewInvitationMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// roleRequestRepository returns the role request repository of the configured user backend
+func (s *storage) roleRequestRepository() (service.RoleRequestRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRoleRequestPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewRoleRequestMongoDBConnection(client), nil
+	case memory:
+		return repository.NewRoleRequestMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// mfaRepository returns the second factor repository of the configured user backend
+func (s *storage) mfaRepository() (service.MFARepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewMFAPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewMFAMongoDBConnection(client), nil
+	case memory:
+		return repository.NewMFAMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// apiKeyRepository returns the API key repository of the configured user backend
+func (s *storage) apiKeyRepository() (service.APIKeyRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewAPIKeyPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewAPIKeyMongoDBConnection(client), nil
+	case memory:
+		return repository.NewAPIKeyMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// passwordResetRepository returns the password reset repository of the configured user backend
+func (s *storage) passwordResetRepository() (service.PasswordResetRepository, error) {
+	switch s.cfg.UserBackend() {
+	case pgx:
+		pool, err := s.psql()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewPasswordResetPsqlConnection(pool), nil
+	case mongod:
+		client, err := s.mongo()
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewPasswordResetMongoDBConnection(client), nil
+	case memory:
+		return repository.NewPasswordResetMemoryConnection(), nil
+	}
+	return nil, fmt.Errorf("unknown user storage backend %q", s.cfg.UserBackend())
+}
+
+// personCache returns the person cache of the configured backend
+func (s *storage) personCache() (service.PersonRepositoryRedis, error) {
+	if s.cfg.CacheBackend == memory {
+		return repository.NewMemoryCacheConnection(), nil
+	}
+	rdb, err := s.redis()
+	if err != nil {
+		return nil, err
+	}
+	cache := repository.NewTieredCacheConnection(repository.NewRedisConnection(rdb), s.cacheBus(rdb), s.cfg.LocalCacheSize, s.cfg.LocalCacheTTL)
+	s.persons = cache
+	return service.NewBreakerPersonRedis(cache, s.cacheBreaker()), nil
+}
+
+// userCache returns the user cache of the configured backend
+func (s *storage) userCache() (service.UserRepositoryRedis, error) {
+	if s.cfg.CacheBackend == memory {
+		return repository.NewUserMemoryCacheConnection(), nil
+	}
+	rdb, err := s.redis()
+	if err != nil {
+		return nil, err
+	}
+	cache := repository.NewUserTieredCacheConnection(repository.NewUserRedisConnection(rdb), s.cacheBus(rdb), s.cfg.LocalCacheSize, s.cfg.LocalCacheTTL)
+	s.users = cache
+	return service.NewBreakerUserRedis(cache, s.cacheBreaker()), nil
+}
+
+// cacheBus returns the bus keeping the in-process cache tiers of all instances coherent
+func (s *storage) cacheBus(rdb *redis.Client) *repository.CacheBus {
+	if s.bus == nil {
+		s.bus = repository.NewCacheBus(rdb)
+	}
+	return s.bus
+}
+
+// cacheBreaker returns the circuit breaker of the Redis caches
+func (s *storage) cacheBreaker() *service.Breaker {
+	if s.breaker == nil {
+		s.breaker = service.NewBreaker(redisCache, s.cfg.RedisBreakerThreshold, s.cfg.RedisBreakerCooldown)
+	}
+	return s.breaker
+}
+
+// cacheService returns the administration of the Redis caches created so far, they are warmed from rps and urps,
+// the write-behind queue is reported when cache is a WriteBehindCache
+func (s *storage) cacheService(rps service.PersonRepositoryPsql, urps service.UserRepository, cache service.PersonCache) *service.CacheService {
+	var breakers []*service.Breaker
+	if s.breaker != nil {
+		breakers = append(breakers, s.breaker)
+	}
+	var sources []service.CacheStatsSource
+	if s.persons != nil {
+		sources = append(sources, s.persons)
+	}
+	if s.users != nil {
+		sources = append(sources, s.users)
+	}
+	srv := service.NewCacheService(breakers, sources...)
+	if s.persons != nil {
+		srv.ManagePersons(s.persons, rps)
+	}
+	if s.users != nil {
+		srv.ManageUsers(s.users, urps)
+	}
+	if writeBehind, ok := cache.(*service.WriteBehindCache); ok {
+		srv.ManageWriteBehind(writeBehind)
+	}
+	return srv
+}